@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func deleteRepo(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("delete-repo", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo, the local repo is left intact")
+	ostreeHubUrl := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to delete repo at")
+	factory := fs.String("factory", "", "A Factory to delete repo of")
+	creds := fs.String("creds", "", "A credential archive with auth material")
+	yes := fs.Bool("yes-i-mean-it", false, "Delete the repo without asking to type the factory name")
+	restore := fs.Bool("restore", false, "Restore the latest deleted repo of the factory instead of deleting it")
+	parseFlags(fs, args)
+
+	var pusher fiopush.Pusher
+	if *creds != "" {
+		pusher, err = fiopush.NewPusher(*repo, *creds)
+	} else {
+		pusher, err = fiopush.NewPusherNoAuth(*repo, *ostreeHubUrl, *factory)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create Fio Pusher: %s\n", err.Error())
+	}
+
+	if *restore {
+		report, err := pusher.RestoreRepo()
+		if err != nil {
+			log.Fatalf("Failed to restore repo: %s\n", err.Error())
+		}
+		log.Printf("Restored %d objects of the repo deleted at %s\n", report.Restored, report.DeletedAt.Local().Format(time.RFC1123))
+		for o, e := range report.Failed {
+			log.Printf("Failed to restore %s: %s\n", o, e)
+		}
+		if len(report.Failed) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if pusher.Factory() == "" {
+		log.Fatalln("Factory is not specified")
+	}
+	if !*yes && !confirmFactory(pusher.Factory(), pusher.HubUrl()) {
+		log.Println("Aborted")
+		return
+	}
+	report, err := pusher.DeleteRepo()
+	if err != nil {
+		log.Fatalf("Failed to delete repo: %s\n", err.Error())
+	}
+	log.Printf("Moved %d objects of %s to the trash, the repo can be restored with `fiopush delete-repo -restore` until %s\n",
+		report.Moved, pusher.Factory(), report.RestoreUntil.Local().Format(time.RFC1123))
+	for o, e := range report.Failed {
+		log.Printf("Failed to delete %s: %s\n", o, e)
+	}
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// confirmFactory asks to type a factory name to confirm deletion of its repo
+func confirmFactory(factory string, hubUrl string) bool {
+	fmt.Printf("The whole repo of %s at %s will be deleted, type the factory name to confirm: ", factory, hubUrl)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == factory
+}
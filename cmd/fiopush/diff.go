@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+)
+
+func diff(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	ostreeHubUrl := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to compare repo with")
+	factory := fs.String("factory", "", "A Factory to compare repo for")
+	creds := fs.String("creds", "", "A credential archive with auth material")
+	parseFlags(fs, args)
+
+	var pusher fiopush.Pusher
+	if *creds != "" {
+		pusher, err = fiopush.NewPusher(*repo, *creds)
+	} else {
+		pusher, err = fiopush.NewPusherNoAuth(*repo, *ostreeHubUrl, *factory)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create Fio Pusher: %s\n", err.Error())
+	}
+
+	d, err := pusher.Diff()
+	if err != nil {
+		log.Fatalf("Failed to compare repo: %s\n", err.Error())
+	}
+	for _, r := range d.Refs {
+		switch {
+		case r.Remote == "":
+			fmt.Printf("+ %s %s\n", r.Ref, r.Local)
+		case r.Local == "":
+			fmt.Printf("- %s %s\n", r.Ref, r.Remote)
+		default:
+			fmt.Printf("~ %s %s -> %s\n", r.Ref, r.Remote, r.Local)
+		}
+	}
+	for _, c := range d.NewCommits {
+		fmt.Printf("new commit: %s\n", c)
+	}
+	fmt.Printf("Checked %d files, %d objects (%d files, %d bytes) to transfer\n", d.Checked, d.Objects, d.Files, d.Bytes)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+)
+
+func doctor(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	ostreeHubUrl := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to upload repo to")
+	factory := fs.String("factory", "", "A Factory to upload repo for")
+	creds := fs.String("creds", "", "A credential archive with auth material")
+	parseFlags(fs, args)
+
+	if !printDiagnoses(fiopush.Doctor(fiopush.DoctorConfig{
+		Repo:      *repo,
+		CredFile:  *creds,
+		ServerURL: *ostreeHubUrl,
+		Factory:   *factory,
+	})) {
+		os.Exit(1)
+	}
+}
+
+// printDiagnoses prints diagnoses along with hints how to fix found issues, it returns false if any check has failed
+func printDiagnoses(diagnoses []fiopush.Diagnosis) bool {
+	ok := true
+	for _, d := range diagnoses {
+		fmt.Printf("[%-4s] %-12s %s\n", d.Status, d.Check, d.Detail)
+		if d.Fix != "" && d.Status != fiopush.DiagnosisOK {
+			fmt.Printf("       %-12s fix: %s\n", "", d.Fix)
+		}
+		if d.Status == fiopush.DiagnosisFail {
+			ok = false
+		}
+	}
+	return ok
+}
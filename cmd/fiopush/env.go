@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	envPrefix = "FIOPUSH_"
+)
+
+// parseFlags parses command line flags, values of flags not specified in the command line are taken
+// from FIOPUSH_<FLAG> environment variables if they are set, e.g. FIOPUSH_REPO or FIOPUSH_LIMIT_RATE
+func parseFlags(fs *flag.FlagSet, args []string) {
+	_ = fs.Parse(args)
+
+	specified := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		specified[f.Name] = true
+	})
+	fs.VisitAll(func(f *flag.Flag) {
+		if specified[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envVar(f.Name))
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			fmt.Fprintf(fs.Output(), "invalid value %q of %s: %s\n", value, envVar(f.Name), err.Error())
+			os.Exit(2)
+		}
+	})
+}
+
+func envVar(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// nopWriteCloser writes an uncompressed bundle
+	nopWriteCloser struct {
+		io.Writer
+	}
+)
+
+// export writes a bundle of a repo to a file, so the repo can be transferred offline and imported by the hub later
+func export(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	out := fs.String("out", "", "A file to write the bundle to, it's compressed by zstd if its name ends with .zst "+
+		"and by gzip if it ends with .gz or .tgz, - writes an uncompressed bundle to stdout")
+	withSHA256 := fs.Bool("sha256", false, "Add SHA-256 digests of files to the bundle along with their CRC32C")
+	parseFlags(fs, args)
+	if *out == "" {
+		log.Fatalf("A file to write the bundle to is not specified, use -out\n")
+	}
+
+	if *out == "-" {
+		if _, err := fiopush.ExportBundle(*repo, os.Stdout, *withSHA256); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	manifest, err := writeBundle(*repo, *out, *withSHA256)
+	if err != nil {
+		log.Fatalf("Failed to export %s: %s\n", *repo, err.Error())
+	}
+	fmt.Printf("Exported %d files and %d refs of %s to %s\n", len(manifest.Files), len(manifest.Refs), *repo, *out)
+}
+
+// writeBundle writes a bundle of a repo to a temporary file first and renames it once it's complete,
+// so a failed export doesn't leave a truncated bundle behind
+func writeBundle(repo string, out string, withSHA256 bool) (*oshub.BundleManifest, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(out), filepath.Base(out)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w, err := compressBundle(tmp, out)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := fiopush.ExportBundle(repo, w, withSHA256)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, os.Rename(tmp.Name(), out)
+}
+
+// compressBundle returns a writer compressing a bundle according to its file name
+func compressBundle(w io.Writer, name string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".zst"):
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %s", err.Error())
+		}
+		return zw, nil
+	case strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz"):
+		return gzip.NewWriter(w), nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/ostree"
+	"log"
+	"os"
+	"runtime"
+)
+
+// fsck verifies checksums of repo objects and refs, so a corrupted repo is caught before it's pushed
+func fsck(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	workers := fs.Int("workers", runtime.NumCPU(), "A number of objects verified concurrently")
+	parseFlags(fs, args)
+
+	if err := fsckRepo(*repo, *workers, false); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fsckRepo verifies a repo and prints found issues, only a summary is printed if the repo is fine and quiet is set
+func fsckRepo(repo string, workers int, quiet bool) error {
+	r, err := ostree.OpenRepo(repo)
+	if err != nil {
+		return err
+	}
+	report, err := r.Fsck(workers)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %s", repo, err.Error())
+	}
+	for _, e := range report.Corrupted {
+		fmt.Printf("corrupted object %s: %s\n", e.Path, e.Err)
+	}
+	for _, e := range report.BrokenRefs {
+		fmt.Printf("broken ref %s: %s\n", e.Path, e.Err)
+	}
+	if !quiet || !report.OK() {
+		fmt.Printf("%s: %d objects verified, %d not verifiable in %s mode, %d corrupted, %d broken refs\n",
+			repo, report.Objects, report.Unverified, r.Mode, len(report.Corrupted), len(report.BrokenRefs))
+	}
+	if !report.OK() {
+		return fmt.Errorf("%s is corrupted", repo)
+	}
+	return nil
+}
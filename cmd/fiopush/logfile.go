@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	// a number of rotated log files kept along with the current one, e.g. fiopush.log.1 ... fiopush.log.3
+	logFileBackups = 3
+)
+
+type (
+	// rotatingFile is a log file which is renamed to <path>.1 once it grows over maxSize bytes,
+	// older ones are shifted to <path>.2 and so on, the oldest one is removed
+	rotatingFile struct {
+		mu      sync.Mutex
+		path    string
+		maxSize int64
+		size    int64
+		f       *os.File
+	}
+)
+
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open a log file: %s", err.Error())
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open a log file: %s", err.Error())
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	for ii := logFileBackups - 1; ii > 0; ii-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, ii), fmt.Sprintf("%s.%d", r.path, ii+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate a log file: %s", err.Error())
+	}
+	return r.open()
+}
+
+// mirrorLog makes the log output be written to a given file along with stderr
+func mirrorLog(path string, maxSize int64) error {
+	f, err := openRotatingFile(path, maxSize)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	return nil
+}
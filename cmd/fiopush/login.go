@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+)
+
+// login obtains a refresh token by means of the OAuth device authorization flow, so a developer can push
+// from a workstation without a credential archive
+func login(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	authServer := fs.String("auth-server", fiopush.DefaultAuthServer, "An URL of the OAuth server to log in at")
+	clientID := fs.String("client-id", fiopush.DefaultLoginClientID, "An OAuth client ID of fiopush registered at the auth server")
+	server := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to push to by default")
+	factory := fs.String("factory", "", "A Factory to push to by default")
+	logout := fs.Bool("logout", false, "Remove the stored login instead")
+	keyring := fs.Bool("use-keyring", false, "Store the login in the OS keyring instead of a plaintext file")
+	parseFlags(fs, args)
+
+	if *logout {
+		remove := fiopush.RemoveLogin
+		if *keyring {
+			remove = fiopush.RemoveKeyringLogin
+		}
+		if err := remove(); err != nil {
+			log.Fatalf("Failed to remove the login: %s\n", err.Error())
+		}
+		log.Println("Logged out")
+		return
+	}
+
+	dc, err := fiopush.RequestDeviceCode(*authServer, *clientID)
+	if err != nil {
+		log.Fatalf("Failed to start the login: %s", err.Error())
+	}
+	if dc.VerificationURIComplete != "" {
+		fmt.Printf("Open %s in a browser to approve the login, the code is %s\n", dc.VerificationURIComplete, dc.UserCode)
+	} else {
+		fmt.Printf("Open %s in a browser and enter the code %s\n", dc.VerificationURI, dc.UserCode)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	tok, err := fiopush.PollDeviceToken(ctx, *authServer, *clientID, dc)
+	if err != nil {
+		log.Fatalf("Failed to log in: %s", err.Error())
+	}
+	if tok.RefreshToken == "" {
+		log.Fatalf("The auth server hasn't issued a refresh token, the login can't be stored\n")
+	}
+	l := &fiopush.Login{AuthServer: *authServer, ClientID: *clientID, RefreshToken: tok.RefreshToken, Server: *server, Factory: *factory}
+	save := fiopush.SaveLogin
+	if *keyring {
+		save = fiopush.SaveKeyringLogin
+	}
+	if err := save(l); err != nil {
+		log.Fatalf("Failed to store the login: %s", err.Error())
+	}
+	log.Println("Logged in, pushes without a credential archive use the login from now on")
+}
+
+// applyLogin makes pushes without a credential archive, a token, a basic auth user or a Vault secret use
+// the stored login if any, the login's hub and factory are used unless others are specified
+func (pf *pushFlags) applyLogin(opts []fiopush.Option) ([]fiopush.Option, error) {
+	if len(pf.creds) > 0 || *pf.token != "" || *pf.user != "" || *pf.vaultPath != "" {
+		return opts, nil
+	}
+	load := fiopush.LoadLogin
+	if *pf.keyring {
+		load = fiopush.LoadKeyringLogin
+	}
+	l, err := load()
+	if err != nil || l == nil {
+		return opts, err
+	}
+	token, err := l.Token()
+	if err != nil {
+		return nil, err
+	}
+	if *pf.server == DefaultServerUrl && l.Server != "" {
+		*pf.server = l.Server
+	}
+	if len(pf.factories) == 0 && l.Factory != "" {
+		pf.factories = listFlag{l.Factory}
+	}
+	return append(opts, fiopush.WithToken(token)), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+)
+
+func lsRemote(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("ls-remote", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	ostreeHubUrl := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to list objects at")
+	factory := fs.String("factory", "", "A Factory to list objects of")
+	creds := fs.String("creds", "", "A credential archive with auth material")
+	long := fs.Bool("l", false, "Print CRC32C and size of each object along with its path")
+	pageSize := fs.Int("page-size", 0, "A number of objects to request from OSTree Hub at once, the hub default if not specified")
+	parseFlags(fs, args)
+
+	var pusher fiopush.Pusher
+	if *creds != "" {
+		pusher, err = fiopush.NewPusher(*repo, *creds)
+	} else {
+		pusher, err = fiopush.NewPusherNoAuth(*repo, *ostreeHubUrl, *factory)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create Fio Pusher: %s\n", err.Error())
+	}
+
+	var total int
+	var totalSize int64
+	pageToken := ""
+	for {
+		page, err := pusher.ListRemoteObjects(pageToken, *pageSize)
+		if err != nil {
+			log.Fatalf("Failed to list remote objects: %s\n", err.Error())
+		}
+		for _, o := range page.Objects {
+			if *long {
+				fmt.Printf("%10d %12d %s\n", o.CRC32, o.Size, o.Path)
+			} else {
+				fmt.Println(o.Path)
+			}
+			total += 1
+			totalSize += o.Size
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	if *long {
+		fmt.Printf("total %d objects, %d bytes\n", total, totalSize)
+	}
+}
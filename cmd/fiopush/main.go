@@ -1,51 +1,830 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"foundriesio/ostreehub/pkg/fiopush"
+	"foundriesio/ostreehub/pkg/oshub"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// an exit code of a process terminated by SIGINT
+	exitInterrupted = 130
+	// an exit code of a push the hub has rejected, e.g. since its credentials aren't valid
+	exitRejected = 3
+	// a repo path making fiopush read a TAR stream of a repo from stdin
+	stdinRepo = "-"
 )
 
 var (
 	DefaultServerUrl = "https://api.foundries.io/ota/ostreehub"
+
+	// subcommands, fiopush pushes a repo if none of them is specified
+	commands = map[string]func(args []string){
+		"diff":           diff,
+		"delete-repo":    deleteRepo,
+		"doctor":         doctor,
+		"export":         export,
+		"fsck":           fsck,
+		"login":          login,
+		"ls-remote":      lsRemote,
+		"prune":          prune,
+		"refs":           refs,
+		"validate-creds": validateCreds,
+		"verify-receipt": verifyReceipt,
+		"watch":          watch,
+		"whoami":         whoami,
+	}
 )
 
-func main() {
-	cwd, err := os.Getwd()
+type (
+	metaFlag map[string]string
+
+	// listFlag is a flag that can be specified several times
+	listFlag []string
+
+	// pushFlags are command line flags of commands pushing a repo
+	pushFlags struct {
+		// a context cancelling pushes, e.g. on SIGINT
+		ctx       context.Context
+		cwd       string
+		repos     listFlag
+		parallel  *bool
+		server    *string
+		factories listFlag
+		creds     listFlag
+		compress  *bool
+		sha256    *bool
+		receipt   *string
+		limitRate *string
+		token     *string
+		user      *string
+		password  *string
+		keyring   *bool
+		vaultPath *string
+		retries   *int
+		notifyUrl *string
+		meta      metaFlag
+		waitLock  *bool
+		stealLock *bool
+		batchSize *string
+		maxMemory *string
+		streams   *int
+		workers   *int
+		skip      listFlag
+		include   listFlag
+		exclude   listFlag
+		skipRefs  *string
+		txnMode   *string
+		deltas    *bool
+		forceRefs *bool
+		force     *bool
+		verify    *bool
+		toArchive *bool
+		quiesce   *time.Duration
+		quiet     *bool
+		debug     *bool
+		logFile   *string
+		logSize   *string
+		progress  *string
+		ckptDir   *string
+		ckptEvery *time.Duration
+
+		// credentials read from Vault once and used by pushes of all repos
+		vaultCreds *fiopush.VaultCreds
+	}
+
+	pushResult struct {
+		repo   string
+		pusher fiopush.Pusher
+		report *fiopush.Report
+		err    error
+		// a stage the push has failed at, one of fiopush.Failure* categories
+		failure string
+	}
+)
+
+func (m metaFlag) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metaFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("expected key=value, got %s", value)
+	}
+	m[kv[0]] = kv[1]
+	return nil
+}
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func addPushFlags(fs *flag.FlagSet, cwd string) *pushFlags {
+	pf := &pushFlags{cwd: cwd, meta: metaFlag{}}
+	fs.Var(&pf.repos, "repo", "A path to an ostree repo, can be repeated or repo paths can be specified as positional arguments, "+
+		"the current directory by default, - reads a TAR stream of a repo, optionally gzip compressed, from stdin")
+	pf.parallel = fs.Bool("parallel", false, "Push several repos concurrently rather than one by one")
+	pf.server = fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to upload repo to")
+	fs.Var(&pf.factories, "factory", "A Factory to upload repo for, can be repeated to push the repo to several factories")
+	fs.Var(&pf.creds, "creds", "A credential archive with auth material, or a bare treehub.json file or its content, "+
+		"can be repeated to push the repo to several factories")
+	pf.compress = fs.Bool("compress", false, "Compress TAR streams pushed to OSTree Hub, already compressed objects are stored as is")
+	pf.sha256 = fs.Bool("sha256", false, "Send SHA-256 digest of each file along with CRC32C if OSTree Hub supports it")
+	pf.receipt = fs.String("receipt", "", "A file to store a receipt signed by OSTree Hub at once the push completes, "+
+		"a factory name is appended to the file name if the repo is pushed to several factories")
+	pf.limitRate = fs.String("limit-rate", "", "Maximum upload bandwidth in bytes per second, K, M and G suffixes are supported, e.g. 10M")
+	pf.retries = fs.Int("retries", 2, "A number of passes retrying objects that failed to sync once the push has completed")
+	pf.token = fs.String("token", "", "An OAuth token to use instead of obtaining one by means of the credential archive")
+	pf.user = fs.String("user", "", "A user to authenticate to OSTree Hub fronted by a reverse proxy with basic auth")
+	pf.password = fs.String("password", "", "A password of the basic auth user, "+
+		"FIOPUSH_PASSWORD environment variable keeps it out of the process list")
+	pf.keyring = fs.Bool("use-keyring", false, "Keep secrets in the OS keyring instead of plaintext files, "+
+		"secrets of a credential archive specified by -creds are stored in it, so next pushes need only -factory")
+	pf.vaultPath = fs.String("vault-path", "", "An API path of a HashiCorp Vault secret with a token or an OAuth2 client secret "+
+		"to push with, e.g. secret/data/fiopush/<factory>, Vault is accessed as configured by VAULT_ADDR, VAULT_TOKEN "+
+		"or VAULT_ROLE_ID and VAULT_SECRET_ID environment variables")
+	pf.notifyUrl = fs.String("notify-url", "", "A webhook URL to post the final push report to")
+	fs.Var(pf.meta, "meta", "A key=value build metadata to attach to the push session, e.g. git_sha=<sha>, can be repeated")
+	pf.waitLock = fs.Bool("wait-lock", false, "Wait until another push of the factory releases its lock instead of failing")
+	pf.stealLock = fs.Bool("steal-lock", false, "Take over a lock held by another push of the factory, e.g. a stuck CI job")
+	pf.maxMemory = fs.String("max-memory", "0", "Maximum memory taken by queues, transport buffers and batches in flight "+
+		"of a push of a repo, e.g. 512M for a memory constrained container, pushes to several factories share it, "+
+		"K, M and G suffixes are supported, 0 doesn't bound it")
+	pf.batchSize = fs.String("batch-size", "256M", "Maximum cumulative size of files pushed in a single batch, "+
+		"K, M and G suffixes are supported, 0 bounds batches only by a number of files")
+	pf.workers = fs.Int("workers", 0, fmt.Sprintf("A number of batches pushed concurrently, 0 tunes it, up to %d, along with "+
+		"the batch size by failures and latency of the hub", fiopush.DefaultMaxAdaptiveWorkers))
+	pf.streams = fs.Int("streams", 1, "A number of TAR streams each batch is split into and pushed in parallel, "+
+		"e.g. to saturate a high-bandwidth link with a batch of large objects")
+	fs.Var(&pf.skip, "skip", "A path prefix of repo files not to push, e.g. ./refs/remotes/, can be repeated, "+
+		"ostree transaction and staging files like ./tmp/ and ./transaction are skipped anyway")
+	fs.Var(&pf.include, "include", "A glob pattern of repo files to push in addition to objects, refs and config, "+
+		"e.g. delta-indexes/, ** matches any number of path segments, can be repeated")
+	fs.Var(&pf.exclude, "exclude", "A glob pattern of repo files not to push even if they are included, "+
+		"e.g. refs/remotes/ or refs/heads/tmp-*, can be repeated")
+	pf.skipRefs = fs.String("skip-ref-namespaces", strings.Join(fiopush.DefaultSkippedRefNamespaces(), ","),
+		"Comma separated namespaces of refs not to push, e.g. remotes and mirrors tracking other repos, an empty value pushes all refs")
+	pf.txnMode = fs.String("transaction", "fail", "What to do if an ostree transaction is in progress in the repo, "+
+		"either fail, wait until the repo stays unchanged for -quiescence, or ignore it")
+	pf.quiesce = fs.Duration("quiescence", 10*time.Second, "For how long the repo has to stay unchanged before it's pushed with -transaction wait")
+	pf.deltas = fs.Bool("static-deltas", false, "Generate static deltas from commits of refs published by the hub to the new ones "+
+		"by means of ostree found in PATH and push them along with refs, so devices get efficient upgrades")
+	pf.forceRefs = fs.Bool("force-refs", false, "Make the hub accept refs that move its branches backwards or sideways, "+
+		"e.g. to roll a branch back on purpose, otherwise such refs are rejected")
+	pf.force = fs.Bool("force", false, "Push and upload all repo files regardless of what the hub has, "+
+		"e.g. to recover from a suspected bucket corruption or to apply new metadata of objects")
+	pf.verify = fs.Bool("verify-objects", true, "Verify that checksums of objects match their names before sending them, "+
+		"mismatched objects are not pushed and neither are refs then")
+	pf.toArchive = fs.Bool("to-archive", false, "Convert content objects of a bare or bare-user repo to archive-z2 ones "+
+		"while pushing them, so the repo can be pushed to a hub serving archive-z2 repos")
+	pf.ckptDir = fs.String("checkpoint-dir", "", "A directory to write a checkpoint of the push to, <factory>.checkpoint.json, "+
+		"telling objects synced, bytes sent and refs pushed, a re-run push interrupted before skips objects it has synced")
+	pf.ckptEvery = fs.Duration("checkpoint-interval", fiopush.DefaultCheckpointInterval, "How often the checkpoint is written")
+	pf.quiet = fs.Bool("quiet", false, "Print only warnings, errors and the final report")
+	pf.debug = fs.Bool("debug", false, "Print debug messages including metadata of HTTP requests to OSTree Hub and their responses")
+	pf.progress = fs.String("progress-format", "text", "A format of the push progress, either text log messages or ndjson records on stdout")
+	pf.logFile = fs.String("log-file", "", "A file to write log messages to along with stderr")
+	pf.logSize = fs.String("log-file-max-size", "10M", fmt.Sprintf("A size the log file is rotated at, %d rotated files are kept, "+
+		"K, M and G suffixes are supported, 0 disables rotation", logFileBackups))
+	return pf
+}
+
+// openLogFile makes log messages be mirrored to the log file if it's specified
+func (pf *pushFlags) openLogFile() error {
+	if *pf.logFile == "" {
+		return nil
+	}
+	maxSize, err := fiopush.ParseSize(*pf.logSize)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid value of the log file size: %s", err.Error())
 	}
+	return mirrorLog(*pf.logFile, maxSize)
+}
 
-	repo := flag.String("repo", cwd, "A path to an ostree repo")
-	ostreeHubUrl := flag.String("server", DefaultServerUrl, "An URL to OSTree Hub to upload repo to")
-	factory := flag.String("factory", "", "A Factory to upload repo for")
-	creds := flag.String("creds", "", "A credential archive with auth material")
-	flag.Parse()
+func (pf *pushFlags) options() ([]fiopush.Option, error) {
+	var opts []fiopush.Option
+	if pf.ctx != nil {
+		opts = append(opts, fiopush.WithContext(pf.ctx))
+	}
+	if len(pf.meta) > 0 {
+		opts = append(opts, fiopush.WithMetadata(pf.meta))
+	}
+	if *pf.notifyUrl != "" {
+		opts = append(opts, fiopush.WithNotifyURL(*pf.notifyUrl))
+	}
+	opts = append(opts, fiopush.WithRetries(*pf.retries))
+	switch *pf.progress {
+	case "text":
+	case "ndjson":
+		opts = append(opts, fiopush.WithNDJSONProgress(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unsupported progress format: %s", *pf.progress)
+	}
+	if *pf.quiet && *pf.debug {
+		return nil, fmt.Errorf("-quiet and -debug are mutually exclusive")
+	}
+	if *pf.quiet {
+		opts = append(opts, fiopush.WithLogger(oshub.NewStdLogger(oshub.LevelWarn)))
+	}
+	if *pf.debug {
+		opts = append(opts, fiopush.WithLogger(oshub.NewStdLogger(oshub.LevelDebug)), fiopush.WithHTTPTrace())
+	}
+	if len(pf.creds) > 0 && len(pf.factories) > 0 {
+		// a credential archive specifies its factory, so -factory would be silently ignored
+		return nil, fmt.Errorf("-creds and -factory are mutually exclusive")
+	}
+	if *pf.waitLock && *pf.stealLock {
+		return nil, fmt.Errorf("-wait-lock and -steal-lock are mutually exclusive")
+	}
+	if *pf.waitLock {
+		opts = append(opts, fiopush.WithLockMode(fiopush.LockWait))
+	}
+	if *pf.stealLock {
+		opts = append(opts, fiopush.WithLockMode(fiopush.LockSteal))
+	}
+	if *pf.token != "" {
+		opts = append(opts, fiopush.WithToken(*pf.token))
+	}
+	if *pf.user != "" {
+		if *pf.token != "" {
+			return nil, fmt.Errorf("-user and -token are mutually exclusive")
+		}
+		opts = append(opts, fiopush.WithBasicAuth(*pf.user, *pf.password))
+	} else if *pf.password != "" {
+		return nil, fmt.Errorf("-password requires -user")
+	}
+	if *pf.vaultPath != "" {
+		if *pf.token != "" || *pf.user != "" {
+			return nil, fmt.Errorf("-vault-path is mutually exclusive with -token and -user")
+		}
+		if pf.vaultCreds == nil {
+			ctx := pf.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			creds, err := fiopush.FetchVaultCreds(ctx, fiopush.VaultConfigFromEnv(*pf.vaultPath))
+			if err != nil {
+				return nil, err
+			}
+			pf.vaultCreds = creds
+		}
+		opts = append(opts, fiopush.WithVaultCreds(pf.vaultCreds))
+	}
+	if *pf.compress {
+		opts = append(opts, fiopush.WithCompression())
+	}
+	if *pf.sha256 {
+		opts = append(opts, fiopush.WithSHA256())
+	}
+	if *pf.limitRate != "" {
+		rate, err := fiopush.ParseSize(*pf.limitRate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of the rate limit: %s", err.Error())
+		}
+		opts = append(opts, fiopush.WithRateLimit(rate))
+	}
+	batchBytes, err := fiopush.ParseSize(*pf.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of the batch size: %s", err.Error())
+	}
+	opts = append(opts, fiopush.WithBatchBytes(batchBytes))
+	maxMemory, err := fiopush.ParseSize(*pf.maxMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of the memory limit: %s", err.Error())
+	}
+	if maxMemory > 0 {
+		opts = append(opts, fiopush.WithMaxMemory(maxMemory))
+	}
+	if *pf.streams < 1 {
+		return nil, fmt.Errorf("invalid number of streams: %d", *pf.streams)
+	}
+	opts = append(opts, fiopush.WithStreams(*pf.streams))
+	switch {
+	case *pf.workers < 0:
+		return nil, fmt.Errorf("invalid number of workers: %d", *pf.workers)
+	case *pf.workers == 0:
+		opts = append(opts, fiopush.WithAdaptiveConcurrency(0))
+	default:
+		opts = append(opts, fiopush.WithWorkers(*pf.workers))
+	}
+	if len(pf.skip) > 0 {
+		opts = append(opts, fiopush.WithSkippedFiles(append(fiopush.DefaultSkippedFiles(), pf.skip...)...))
+	}
+	if err := pf.pathFilter().Validate(); err != nil {
+		return nil, err
+	}
+	opts = append(opts, fiopush.WithPathFilter(pf.pathFilter()))
+	mode, err := pf.transactionMode()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, fiopush.WithTransactionMode(mode, *pf.quiesce))
+	if *pf.deltas {
+		opts = append(opts, fiopush.WithStaticDeltas(""))
+	}
+	if *pf.forceRefs {
+		opts = append(opts, fiopush.WithForceRefs())
+	}
+	if *pf.force {
+		opts = append(opts, fiopush.WithForceUpload())
+	}
+	opts = append(opts, fiopush.WithObjectVerification(*pf.verify))
+	if *pf.toArchive {
+		opts = append(opts, fiopush.WithArchiveConversion())
+	}
+	if *pf.ckptDir != "" {
+		if *pf.ckptEvery <= 0 {
+			return nil, fmt.Errorf("invalid checkpoint interval: %s", *pf.ckptEvery)
+		}
+		opts = append(opts, fiopush.WithCheckpoint(*pf.ckptDir, *pf.ckptEvery))
+	}
+	return opts, nil
+}
 
-	var pusher fiopush.Pusher
-	if *creds != "" {
-		pusher, err = fiopush.NewPusher(*repo, *creds)
-	} else {
-		pusher, err = fiopush.NewPusherNoAuth(*repo, *ostreeHubUrl, *factory)
+func (pf *pushFlags) transactionMode() (fiopush.TransactionMode, error) {
+	switch *pf.txnMode {
+	case "fail":
+		return fiopush.TransactionFail, nil
+	case "wait":
+		return fiopush.TransactionWait, nil
+	case "ignore":
+		return fiopush.TransactionIgnore, nil
+	}
+	return 0, fmt.Errorf("unsupported transaction mode: %s", *pf.txnMode)
+}
+
+// repoPaths returns repos specified by -repo flags and positional arguments
+func (pf *pushFlags) repoPaths(args []string) []string {
+	repos := append(append([]string{}, pf.repos...), args...)
+	if len(repos) == 0 {
+		repos = []string{pf.cwd}
 	}
+	return repos
+}
+
+// newPushers returns a pusher of a given repo per each specified credential archive, or per each factory
+// if no archive is specified. If there are several of them the repo is walked and hashed only once.
+// Given files are pushed instead of walking through the repo, e.g. the ones of a spooled repo stream.
+func (pf *pushFlags) newPushers(repo string, files []*oshub.RepoFile) ([]fiopush.Pusher, error) {
+	opts, err := pf.options()
 	if err != nil {
-		log.Fatalf("Failed to create Fio Pusher: %s\n", err.Error())
+		return nil, err
+	}
+	targets := len(pf.creds)
+	if targets == 0 {
+		targets = len(pf.factories)
+	}
+	if files == nil && targets > 1 {
+		// the repo is walked once for all targets right away, so wait for it here
+		if *pf.txnMode == "wait" {
+			if err := fiopush.WaitRepoQuiescent(pf.ctx, repo, *pf.quiesce); err != nil {
+				return nil, err
+			}
+		}
+		if files, err = fiopush.ScanRepo(repo, *pf.sha256, pf.pathFilter()); err != nil {
+			return nil, err
+		}
 	}
+	if files != nil {
+		opts = append(opts, fiopush.WithRepoFiles(files))
+	}
+	if maxMemory, _ := fiopush.ParseSize(*pf.maxMemory); maxMemory > 0 && targets > 1 {
+		// the repo is pushed to all targets at once
+		opts = append(opts, fiopush.WithMaxMemory(maxMemory/int64(targets)))
+	}
+	// factories whose credentials are stored in the keyring don't use the login
+	keyringOpts := opts
+	if opts, err = pf.applyLogin(opts); err != nil {
+		return nil, err
+	}
+
+	var pushers []fiopush.Pusher
+	if len(pf.creds) > 0 {
+		for _, creds := range pf.creds {
+			var pusher fiopush.Pusher
+			if *pf.keyring {
+				pusher, err = storeCreds(repo, creds, keyringOpts)
+			} else {
+				pusher, err = fiopush.NewPusher(repo, creds, opts...)
+			}
+			if err != nil {
+				return nil, err
+			}
+			pushers = append(pushers, pusher)
+		}
+		return pushers, nil
+	}
+	factories := pf.factories
+	if len(factories) == 0 {
+		// let the constructor report the missing factory
+		factories = listFlag{""}
+	}
+	for _, factory := range factories {
+		if *pf.keyring {
+			pusher, err := fiopush.NewPusherFromKeyring(repo, factory, keyringOpts...)
+			if err == nil {
+				pushers = append(pushers, pusher)
+				continue
+			}
+			if !errors.Is(err, fiopush.ErrKeyringNotFound) {
+				return nil, err
+			}
+		}
+		pusher, err := fiopush.NewPusherNoAuth(repo, *pf.server, factory, opts...)
+		if err != nil {
+			return nil, err
+		}
+		pushers = append(pushers, pusher)
+	}
+	return pushers, nil
+}
+
+// pathFilter returns patterns of repo files to include and exclude given by -include and -exclude,
+// namespaces of refs given by -skip-ref-namespaces are turned into patterns as well
+func (pf *pushFlags) pathFilter() fiopush.PathFilter {
+	f := fiopush.PathFilter{Include: append([]string{}, pf.include...), Exclude: append([]string{}, pf.exclude...)}
+	skip := map[string]bool{}
+	for _, ns := range strings.Split(*pf.skipRefs, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			skip[ns] = true
+		}
+	}
+	for _, ns := range fiopush.DefaultSkippedRefNamespaces() {
+		if !skip[ns] {
+			f.Include = append(f.Include, "refs/"+ns+"/")
+		}
+		delete(skip, ns)
+	}
+	for ns := range skip {
+		f.Exclude = append(f.Exclude, "refs/"+ns+"/")
+	}
+	return f
+}
 
-	if err := pusher.Run(); err != nil {
-		log.Fatalf("Failed to run Fio Pusher: %s\n", err.Error())
+// spoolStdin extracts a TAR stream of a repo read from stdin to a temporary directory in a given one,
+// the caller removes the returned directory once the repo is pushed
+func spoolStdin(spoolDir string, withSHA256 bool, filter fiopush.PathFilter) (string, []*oshub.RepoFile, error) {
+	dir, err := ioutil.TempDir(spoolDir, "fiopush-stdin-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create a directory to extract the repo stream to: %s", err.Error())
 	}
+	log.Printf("Reading a repo stream from stdin to %s ...\n", dir)
+	files, err := fiopush.SpoolRepoStream(os.Stdin, dir, withSHA256, filter)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, files, nil
+}
 
-	log.Printf("Pushing %s to %s, factory: %s ...\n", *repo, pusher.HubUrl(), pusher.Factory())
-	report, err := pusher.Wait()
+// storeCreds stores secrets of a credential archive in the OS keyring and returns a pusher authenticating by them,
+// so the archive can be removed from a developer workstation
+func storeCreds(repo string, creds string, opts []fiopush.Option) (fiopush.Pusher, error) {
+	hub, err := fiopush.StoreCredsInKeyring(creds)
 	if err != nil {
-		log.Fatalf("Failed to push repo: %s\n", err.Error())
+		return nil, err
+	}
+	log.Printf("Credentials of %s factory have been stored in the OS keyring, the credentials file can be removed, "+
+		"pass -use-keyring -factory %s to push to the factory from now on\n", hub.Factory, hub.Factory)
+	return fiopush.NewPusherFromKeyring(repo, hub.Factory, opts...)
+}
+
+// receiptFile returns a file to store a receipt of a given push at, a factory and a repo names are appended
+// to the file name if several factories or repos are pushed to
+func (pf *pushFlags) receiptFile(r *pushResult, pusherNumb int, repoNumb int) string {
+	ext := filepath.Ext(*pf.receipt)
+	file := strings.TrimSuffix(*pf.receipt, ext)
+	if pusherNumb > 1 {
+		file += "." + r.pusher.Factory()
+	}
+	if repoNumb > 1 {
+		file += "." + filepath.Base(filepath.Clean(r.repo))
+	}
+	return file + ext
+}
+
+// finalize stores a push receipt if requested, or just finalizes the push session so the hub records its metadata
+func (pf *pushFlags) finalize(r *pushResult, pusherNumb int, repoNumb int) error {
+	pusher := r.pusher
+	if *pf.receipt != "" {
+		file := pf.receiptFile(r, pusherNumb, repoNumb)
+		if err := storeReceipt(pusher, file); err != nil {
+			return err
+		}
+		log.Printf("Push receipt has been stored at %s\n", file)
+	} else if len(pf.meta) > 0 {
+		if _, err := receipt(pusher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushAll runs given pushers concurrently and waits for all of them, quiet suppresses progress messages
+func pushAll(repo string, pushers []fiopush.Pusher, quiet bool) []pushResult {
+	results := make([]pushResult, len(pushers))
+	var wg sync.WaitGroup
+	for ii, pusher := range pushers {
+		results[ii].repo = repo
+		results[ii].pusher = pusher
+		wg.Add(1)
+		go func(r *pushResult) {
+			defer wg.Done()
+			if r.err = r.pusher.Run(); r.err != nil {
+				r.failure = fiopush.FailureRun
+				return
+			}
+			if !quiet {
+				log.Printf("Pushing %s to %s, factory: %s ...\n", repo, r.pusher.HubUrl(), r.pusher.Factory())
+			}
+			if r.report, r.err = r.pusher.Wait(); r.err != nil {
+				r.failure = fiopush.FailurePush
+			}
+		}(&results[ii])
 	}
+	wg.Wait()
+	return results
+}
+
+// confirmPush prints what a push of a repo would transfer to each factory and asks whether to proceed,
+// it doesn't ask if yes is set
+func confirmPush(repo string, pushers []fiopush.Pusher, yes bool) (bool, error) {
+	var files uint
+	var bytes int64
+	for _, pusher := range pushers {
+		d, err := pusher.Diff()
+		if err != nil {
+			return false, err
+		}
+		log.Printf("%s to %s, factory: %s: %d objects (%d files, %d bytes) to transfer\n",
+			repo, pusher.HubUrl(), pusher.Factory(), d.Objects, d.Files, d.Bytes)
+		files += d.Files
+		bytes += d.Bytes
+	}
+	if yes || files == 0 {
+		return true, nil
+	}
+	fmt.Printf("Push %d files, %d bytes? [y/N]: ", files, bytes)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
 
+// signalContext returns a context cancelled on SIGINT or SIGTERM, a second signal terminates the process at once
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Got %s, stopping, send it again to terminate at once ...\n", sig)
+		cancel()
+		<-sigs
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, cancel
+}
+
+func printReport(report *fiopush.Report) {
 	log.Printf("Checked: %d\n", report.Checked)
+	if report.Resumed > 0 {
+		log.Printf("Skipped %d objects synced by the previous push\n", report.Resumed)
+	}
 	log.Printf("Sent %d files, %d objects, %d bytes\n", report.Sent.FileNumb, report.Sent.ObjNumb, report.Sent.Bytes)
+	if report.Sent.DedupNumb > 0 {
+		log.Printf("Deduplicated %d files of identical content, %d bytes\n", report.Sent.DedupNumb, report.Sent.DedupBytes)
+	}
 	log.Printf("Uploaded %d files, synced %d objects, uploaded to GCS %d objects\n",
 		report.Synced.UploadedFileNumb, report.Synced.SyncedFileNumb, report.Synced.UploadSyncedFileNumb)
 	log.Printf("Failed to sync %d objects", report.Synced.SyncFailedNumb)
+	printPathReasons(report.Failures)
+	if report.BatchErrors > 0 {
+		log.Printf("Failed to check or send %d batches\n", report.BatchErrors)
+	}
+	if cm := report.CommitMeta; cm.Checked > 0 {
+		log.Printf("Detached commit metadata: checked %d, sent %d, %d bytes, synced %d, failed %d\n",
+			cm.Checked, cm.Sent, cm.Bytes, cm.Synced, cm.Failed)
+	}
+	if len(report.Corrupted) > 0 {
+		log.Printf("Corrupted %d objects, they haven't been pushed\n", len(report.Corrupted))
+		printPathReasons(report.Corrupted)
+	}
+	if report.Timing != nil {
+		printTiming(report.Timing)
+	}
+}
+
+// printPathReasons prints repo paths sorted along with reasons, e.g. of failures to sync them
+func printPathReasons(reasons map[string]string) {
+	paths := make([]string, 0, len(reasons))
+	for path := range reasons {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		log.Printf("  %s: %s\n", path, reasons[path])
+	}
+}
+
+// printTiming prints a table of push timing, check and send times are summed up over concurrent batches
+func printTiming(t *fiopush.Timing) {
+	tw := tabwriter.NewWriter(log.Writer(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Duration\t%.1fs\n", t.Duration)
+	fmt.Fprintf(tw, "Check (all batches)\t%.1fs\n", t.Check)
+	fmt.Fprintf(tw, "Send (all batches)\t%.1fs\n", t.Send)
+	fmt.Fprintf(tw, "Throughput\t%.2f MiB/s\n", t.Throughput/(1<<20))
+	fmt.Fprintf(tw, "Batches\t%d\n", t.Batches)
+	if t.Batches > 0 {
+		fmt.Fprintf(tw, "Batch latency min/avg/p95/max\t%.2fs/%.2fs/%.2fs/%.2fs\n", t.BatchMin, t.BatchAvg, t.BatchP95, t.BatchMax)
+	}
+	tw.Flush()
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pf := addPushFlags(flag.CommandLine, cwd)
+	prescan := flag.Bool("prescan", false, "Check what has to be transferred before pushing and ask for confirmation to proceed")
+	yes := flag.Bool("yes", false, "Proceed without asking for confirmation after the pre-scan")
+	fsckFirst := flag.Bool("fsck", false, "Verify checksums of repo objects and refs before pushing, a corrupted repo is not pushed")
+	spoolDir := flag.String("spool-dir", "", "A directory a repo stream read from stdin is extracted to while it's pushed, "+
+		"the system temporary directory by default")
+	parseFlags(flag.CommandLine, os.Args[1:])
+	if err := pf.openLogFile(); err != nil {
+		log.Fatal(err)
+	}
+	repos := pf.repoPaths(flag.Args())
+	stdinRepos := 0
+	for _, repo := range repos {
+		if repo == stdinRepo {
+			stdinRepos++
+		}
+	}
+	if stdinRepos > 1 {
+		log.Fatalf("Only one repo can be read from stdin\n")
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	pf.ctx = ctx
+
+	var mu sync.Mutex
+	// serializes pre-scan reports and prompts of repos pushed in parallel
+	var promptMu sync.Mutex
+	var results []pushResult
+	var pusherNumb int
+	failed := false
+	pushRepo := func(repo string) {
+		var files []*oshub.RepoFile
+		if repo == stdinRepo {
+			dir, spooled, err := spoolStdin(*spoolDir, *pf.sha256, pf.pathFilter())
+			if err != nil {
+				log.Printf("Failed to read the repo from stdin: %s\n", err.Error())
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				return
+			}
+			defer os.RemoveAll(dir)
+			repo, files = dir, spooled
+		}
+		if *fsckFirst {
+			if err := fsckRepo(repo, runtime.NumCPU(), true); err != nil {
+				log.Printf("Failed to verify %s: %s\n", repo, err.Error())
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				return
+			}
+		}
+		pushers, err := pf.newPushers(repo, files)
+		if err != nil {
+			log.Printf("Failed to create Fio Pusher of %s: %s\n", repo, err.Error())
+			mu.Lock()
+			failed = true
+			mu.Unlock()
+			return
+		}
+		if *prescan {
+			promptMu.Lock()
+			ok, err := confirmPush(repo, pushers, *yes)
+			promptMu.Unlock()
+			if err != nil {
+				log.Printf("Failed to pre-scan %s: %s\n", repo, err.Error())
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				return
+			}
+			if !ok {
+				log.Printf("Push of %s has been cancelled\n", repo)
+				return
+			}
+		}
+		repoResults := pushAll(repo, pushers, *pf.quiet)
+		mu.Lock()
+		results = append(results, repoResults...)
+		pusherNumb = len(pushers)
+		mu.Unlock()
+	}
+	if *pf.parallel {
+		var wg sync.WaitGroup
+		for _, repo := range repos {
+			wg.Add(1)
+			go func(repo string) {
+				defer wg.Done()
+				pushRepo(repo)
+			}(repo)
+		}
+		wg.Wait()
+	} else {
+		for _, repo := range repos {
+			pushRepo(repo)
+		}
+	}
+
+	var reports []*fiopush.Report
+	var rejected bool
+	for ii := range results {
+		r := &results[ii]
+		if r.err != nil {
+			log.Printf("Failed to push %s to %s: %s\n", r.repo, r.pusher.Factory(), r.err.Error())
+			failed = true
+			continue
+		}
+		if len(results) > 1 {
+			log.Printf("Repo: %s, factory: %s\n", r.repo, r.pusher.Factory())
+		}
+		printReport(r.report)
+		reports = append(reports, r.report)
+		if r.report.Rejected != nil {
+			log.Printf("OSTree Hub rejected the push of %s to %s: HTTP %d: %s\n",
+				r.repo, r.pusher.Factory(), r.report.Rejected.Status, r.report.Rejected.Reason)
+			rejected = true
+			continue
+		}
+		if r.report.RefsSkipped {
+			log.Printf("Refs of %s haven't been pushed to %s since not all objects have been synced\n", r.repo, r.pusher.Factory())
+			failed = true
+		}
+		if r.report.Interrupted {
+			// files synced so far are skipped by the next push, so re-running the command resumes the push
+			log.Printf("Push of %s to %s has been interrupted, session: %s, re-run the command to resume it\n",
+				r.repo, r.pusher.Factory(), r.report.Session)
+			failed = true
+			continue
+		}
+		if err := pf.finalize(r, pusherNumb, len(repos)); err != nil {
+			log.Printf("Failed to finalize the push of %s to %s: %s\n", r.repo, r.pusher.Factory(), err.Error())
+			failed = true
+		}
+	}
+	if len(reports) > 1 {
+		log.Printf("Total:\n")
+		printReport(fiopush.MergeReports(reports...))
+	}
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+	if rejected {
+		os.Exit(exitRejected)
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
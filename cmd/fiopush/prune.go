@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+	"strings"
+)
+
+func prune(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	ostreeHubUrl := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to prune repo at")
+	factory := fs.String("factory", "", "A Factory to prune repo for")
+	creds := fs.String("creds", "", "A credential archive with auth material")
+	dryRun := fs.Bool("dry-run", false, "Only print objects that would be deleted")
+	yes := fs.Bool("yes", false, "Delete objects without asking for confirmation")
+	parseFlags(fs, args)
+
+	var pusher fiopush.Pusher
+	if *creds != "" {
+		pusher, err = fiopush.NewPusher(*repo, *creds)
+	} else {
+		pusher, err = fiopush.NewPusherNoAuth(*repo, *ostreeHubUrl, *factory)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create Fio Pusher: %s\n", err.Error())
+	}
+
+	remote, err := pusher.RemoteObjects()
+	if err != nil {
+		log.Fatalf("Failed to get remote objects: %s\n", err.Error())
+	}
+	candidates, err := fiopush.PruneCandidates(*repo, remote)
+	if err != nil {
+		log.Fatalf("Failed to find objects to prune: %s\n", err.Error())
+	}
+	for _, o := range candidates {
+		fmt.Println(o)
+	}
+	log.Printf("%d of %d remote objects are absent or unreachable locally\n", len(candidates), len(remote))
+	if *dryRun || len(candidates) == 0 {
+		return
+	}
+	if !*yes && !confirm(fmt.Sprintf("Delete %d objects of %s at %s?", len(candidates), pusher.Factory(), pusher.HubUrl())) {
+		log.Println("Aborted")
+		return
+	}
+
+	report, err := pusher.Prune(candidates)
+	if err != nil {
+		log.Fatalf("Failed to prune repo: %s\n", err.Error())
+	}
+	log.Printf("Deleted %d objects\n", report.Deleted)
+	for o, e := range report.Failed {
+		log.Printf("Failed to delete %s: %s\n", o, e)
+	}
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"foundriesio/ostreehub/pkg/oshub"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// receipt finalizes a push and returns its receipt if the pusher supports that
+func receipt(pusher fiopush.Pusher) (*oshub.SignedReceipt, error) {
+	f, ok := pusher.(fiopush.Finalizer)
+	if !ok {
+		return nil, fmt.Errorf("the pusher of %s factory can't finalize the push", pusher.Factory())
+	}
+	return f.Receipt()
+}
+
+func storeReceipt(pusher fiopush.Pusher, receiptFile string) error {
+	signed, err := receipt(pusher)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(receiptFile, data, 0644)
+}
+
+func verifyReceipt(args []string) {
+	fs := flag.NewFlagSet("verify-receipt", flag.ExitOnError)
+	receiptFile := fs.String("receipt", "", "A receipt file stored by fiopush")
+	keyFile := fs.String("pubkey", "", "A PEM file with the public key of OSTree Hub")
+	parseFlags(fs, args)
+	if *receiptFile == "" || *keyFile == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	key, err := oshub.LoadPublicKey(*keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load the hub public key: %s\n", err.Error())
+	}
+	data, err := ioutil.ReadFile(*receiptFile)
+	if err != nil {
+		log.Fatalf("Failed to read the receipt: %s\n", err.Error())
+	}
+	var signed oshub.SignedReceipt
+	if err := json.Unmarshal(data, &signed); err != nil {
+		log.Fatalf("Failed to parse the receipt: %s\n", err.Error())
+	}
+	receipt, err := oshub.VerifyReceipt(&signed, key)
+	if err != nil {
+		log.Fatalf("Receipt verification failed: %s\n", err.Error())
+	}
+
+	log.Printf("Receipt is valid, signed by %s at %s\n", receipt.Hub, receipt.Timestamp)
+	log.Printf("Factory: %s, objects: %d\n", receipt.Factory, receipt.Objects)
+	for ref, commit := range receipt.Refs {
+		log.Printf("  %s -> %s\n", ref, commit)
+	}
+}
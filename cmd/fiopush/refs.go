@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+	"sort"
+)
+
+func refs(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("refs", flag.ExitOnError)
+	repo := fs.String("repo", cwd, "A path to an ostree repo")
+	ostreeHubUrl := fs.String("server", DefaultServerUrl, "An URL to OSTree Hub to list refs at")
+	factory := fs.String("factory", "", "A Factory to list refs of")
+	creds := fs.String("creds", "", "A credential archive with auth material")
+	parseFlags(fs, args)
+
+	var pusher fiopush.Pusher
+	if *creds != "" {
+		pusher, err = fiopush.NewPusher(*repo, *creds)
+	} else {
+		pusher, err = fiopush.NewPusherNoAuth(*repo, *ostreeHubUrl, *factory)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create Fio Pusher: %s\n", err.Error())
+	}
+
+	remoteRefs, err := pusher.RemoteRefs()
+	if err != nil {
+		log.Fatalf("Failed to get remote refs: %s\n", err.Error())
+	}
+	names := make([]string, 0, len(remoteRefs))
+	for ref := range remoteRefs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	for _, ref := range names {
+		fmt.Printf("%s %s\n", remoteRefs[ref], ref)
+	}
+}
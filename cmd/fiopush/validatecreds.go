@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+)
+
+// validateCreds checks credentials step by step and reports the step that fails, e.g. when pushes get 401
+func validateCreds(args []string) {
+	fs := flag.NewFlagSet("validate-creds", flag.ExitOnError)
+	creds := fs.String("creds", "", "A credential archive with auth material, or a bare treehub.json file or its content")
+	parseFlags(fs, args)
+	if *creds == "" {
+		log.Fatalf("-creds is not specified\n")
+	}
+	if !printDiagnoses(fiopush.ValidateCreds(*creds)) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDebounce = 30 * time.Second
+)
+
+func watch(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	pf := addPushFlags(fs, cwd)
+	debounce := fs.Duration("debounce", defaultDebounce, "A period refs have to stay unchanged for before the repo is pushed")
+	stampFile := fs.String("stamp", "", "A stamp file a build system touches once it has updated the repo, the repo is pushed on its changes instead of changes of refs")
+	summaryWindow := fs.Duration("summary-window", 24*time.Hour, "A time window pushes are aggregated over in the summary")
+	summaryFile := fs.String("summary-file", "", "A file to store a JSON summary of pushes at after each push")
+	apiAddr := fs.String("api", "", "An address to serve the summary of pushes at, e.g. :8080, GET /summary")
+	parseFlags(fs, args)
+	if err := pf.openLogFile(); err != nil {
+		log.Fatal(err)
+	}
+
+	var opts []fiopush.WatchOption
+	if *stampFile != "" {
+		opts = append(opts, fiopush.WithStampFile(*stampFile))
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	pf.ctx = ctx
+
+	summary := fiopush.NewSummaryCollector(*summaryWindow)
+	if *apiAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/summary", summary.Handler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*apiAddr, mux))
+		}()
+	}
+
+	repos := pf.repoPaths(fs.Args())
+	var summaryLock sync.Mutex
+	var wg sync.WaitGroup
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			log.Printf("Watching %s for new commits ...\n", repo)
+			err := fiopush.WatchRepo(ctx, repo, *debounce, func() {
+				// a failed push is retried on the next commit, the hub skips objects that have already been synced
+				for _, record := range pushOnce(pf, repo, len(repos)) {
+					if record.Err != "" {
+						log.Printf("Failed to push %s to %s: %s\n", repo, record.Factory, record.Err)
+					}
+					summary.Add(record)
+				}
+				if *summaryFile != "" {
+					summaryLock.Lock()
+					defer summaryLock.Unlock()
+					if err := summary.WriteFile(*summaryFile); err != nil {
+						log.Printf("Failed to store the push summary: %s\n", err.Error())
+					}
+				}
+			}, opts...)
+			if err != nil {
+				log.Fatalf("Failed to watch repo %s: %s\n", repo, err.Error())
+			}
+		}(repo)
+	}
+	wg.Wait()
+}
+
+func pushOnce(pf *pushFlags, repo string, repoNumb int) []fiopush.PushRecord {
+	start := time.Now()
+	pushers, err := pf.newPushers(repo, nil)
+	if err != nil {
+		return []fiopush.PushRecord{{Start: start, Duration: time.Since(start), Failure: fiopush.FailureRun, Err: err.Error()}}
+	}
+	var records []fiopush.PushRecord
+	results := pushAll(repo, pushers, *pf.quiet)
+	for ii := range results {
+		r := &results[ii]
+		record := fiopush.PushRecord{Factory: r.pusher.Factory(), Start: start, Report: r.report}
+		if r.err != nil {
+			record.Failure = r.failure
+			record.Err = r.err.Error()
+		} else {
+			printReport(r.report)
+			if err := pf.finalize(r, len(pushers), repoNumb); err != nil {
+				record.Failure = fiopush.FailureFinalize
+				record.Err = err.Error()
+			}
+		}
+		record.Duration = time.Since(start)
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"log"
+	"os"
+)
+
+// whoami prints the identity pushes made with the same flags would use, e.g. to make sure the right credentials are picked
+func whoami(args []string) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet("whoami", flag.ExitOnError)
+	pf := addPushFlags(fs, cwd)
+	parseFlags(fs, args)
+
+	opts, err := pf.options()
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyringOpts := opts
+	if opts, err = pf.applyLogin(opts); err != nil {
+		log.Fatal(err)
+	}
+
+	var ids []*fiopush.Identity
+	resolve := func(hub *fiopush.OSTreeHub, opts []fiopush.Option) {
+		id, err := fiopush.Whoami(hub, opts...)
+		if err != nil {
+			log.Fatalf("Failed to resolve the identity of %s factory: %s", hub.Factory, err.Error())
+		}
+		ids = append(ids, id)
+	}
+	if *pf.keyring && len(pf.creds) > 0 {
+		opts = append(opts, fiopush.WithKeyring())
+	}
+	for _, creds := range pf.creds {
+		hub, err := fiopush.ExtractUrlAndFactory(creds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		resolve(hub, opts)
+	}
+	if len(pf.creds) == 0 {
+		factories := pf.factories
+		if len(factories) == 0 {
+			log.Fatalf("Neither -creds nor -factory is specified\n")
+		}
+		for _, factory := range factories {
+			if *pf.keyring {
+				hub, err := fiopush.LoadCredsFromKeyring(factory)
+				if err == nil {
+					resolve(hub, append([]fiopush.Option{fiopush.WithKeyring()}, keyringOpts...))
+					continue
+				}
+				if !errors.Is(err, fiopush.ErrKeyringNotFound) {
+					log.Fatal(err)
+				}
+			}
+			resolve(&fiopush.OSTreeHub{URL: *pf.server, Factory: factory}, opts)
+		}
+	}
+	for ii, id := range ids {
+		if ii > 0 {
+			fmt.Println()
+		}
+		fmt.Print(id.String())
+	}
+}
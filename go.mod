@@ -3,7 +3,14 @@ module foundriesio/ostreehub
 go 1.15
 
 require (
-	cloud.google.com/go/storage v1.14.0
+	cloud.google.com/go/storage v1.19.0
+	github.com/fsnotify/fsnotify v1.5.1
+	github.com/googleapis/gax-go/v2 v2.1.1
+	github.com/klauspost/compress v1.14.4
 	github.com/labstack/echo/v4 v4.2.1
-	golang.org/x/sys v0.0.0-20210326220804-49726bf1d181
+	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e
+	google.golang.org/api v0.65.0
 )
@@ -0,0 +1,219 @@
+//go:build faults
+// +build faults
+
+package e2e
+
+import (
+	"foundriesio/ostreehub/internal/faults"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"foundriesio/ostreehub/pkg/oshub"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// injector injects given faults deterministically, each of them once
+type injector struct {
+	mu sync.Mutex
+	// numbers of batches to drop
+	drop map[uint32]bool
+	// paths of files whose CRC is corrupted
+	corrupt map[string]bool
+	// a delay of each PUT request
+	delay time.Duration
+	// a number of streams the hub responds with 503 to
+	unavailable int
+}
+
+func (i *injector) DropBatch(batch uint32) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	hit := i.drop[batch]
+	delete(i.drop, batch)
+	return hit
+}
+
+func (i *injector) CorruptCRC(path string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	hit := i.corrupt[path]
+	delete(i.corrupt, path)
+	return hit
+}
+
+func (i *injector) DelayPut() time.Duration {
+	return i.delay
+}
+
+func (i *injector) HubUnavailable() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.unavailable == 0 {
+		return false
+	}
+	i.unavailable--
+	return true
+}
+
+// injectFaults makes the push pipeline and the hub consult a given injector until the test ends
+func injectFaults(t *testing.T, i *injector) {
+	faults.Set(i)
+	t.Cleanup(func() { faults.Set(nil) })
+}
+
+// pushOptions makes a push send a batch per object, so a fault of a batch affects a single object,
+// and checkpoint objects the hub confirms synced to a given directory
+func pushOptions(checkpointDir string, retries int) []fiopush.Option {
+	return []fiopush.Option{fiopush.WithWorkers(1), fiopush.WithBatchBytes(1), fiopush.WithCheckpoint(checkpointDir, time.Hour),
+		fiopush.WithRetryPolicy(fiopush.RetryPolicy{Passes: retries, Delay: 10 * time.Millisecond, MaxThrottledPeriod: time.Minute})}
+}
+
+// checkIncomplete checks a report of a push that has left some objects unsynced and hasn't published refs
+func checkIncomplete(t *testing.T, hub *testHub, report *fiopush.Report, checkpointDir string) {
+	if !report.RefsSkipped || len(report.Refs) > 0 {
+		t.Fatalf("refs have been pushed along with unsynced objects: %+v", report)
+	}
+	for name := range hub.gcs.content() {
+		if strings.HasPrefix(name, testFactory+"/refs/") {
+			t.Fatalf("the bucket has a ref pushed along with unsynced objects: %s", name)
+		}
+	}
+	c, err := fiopush.ReadCheckpoint(fiopush.CheckpointFile(checkpointDir, testFactory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done {
+		t.Fatalf("the checkpoint of an incomplete push is done")
+	}
+}
+
+// checkResumed checks that a push resumed after a given one has pushed only objects the latter hasn't synced
+func checkResumed(t *testing.T, hub *testHub, repo *testRepo, checkpointDir string, synced int) {
+	report := push(t, repo.dir, hub.url, pushOptions(checkpointDir, 0)...)
+	if report.BatchErrors > 0 || report.RefsSkipped || report.Synced.SyncFailedNumb > 0 || report.Interrupted {
+		t.Fatalf("the resumed push hasn't completed: %+v", report)
+	}
+	if report.Resumed != uint(synced) {
+		t.Errorf("the resumed push has skipped %d objects, expected %d", report.Resumed, synced)
+	}
+	if report.Sent.ObjNumb != uint(len(repo.objects)-synced) {
+		t.Errorf("the resumed push has sent %d objects, expected %d", report.Sent.ObjNumb, len(repo.objects)-synced)
+	}
+	checkPublished(t, hub, repo)
+}
+
+func TestDropBatch(t *testing.T) {
+	hub := newTestHub(t)
+	repo := makeTestRepo(t, 3)
+	checkpointDir := tempDir(t, "fiopush-checkpoint")
+	injectFaults(t, &injector{drop: map[uint32]bool{2: true}})
+
+	report := push(t, repo.dir, hub.url, pushOptions(checkpointDir, 1)...)
+	if report.BatchErrors != 1 || report.Retried > 0 {
+		t.Fatalf("the dropped batch isn't reported: %+v", report)
+	}
+	checkIncomplete(t, hub, report, checkpointDir)
+	stored := hub.objects(testFactory)
+	if len(stored) != len(repo.objects)-1 {
+		t.Fatalf("the bucket has %d objects, expected all but the dropped one out of %d", len(stored), len(repo.objects))
+	}
+
+	checkResumed(t, hub, repo, checkpointDir, len(stored))
+}
+
+func TestCorruptCRC(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+	}{
+		{"retried", 1},
+		{"not retried", 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newTestHub(t)
+			repo := makeTestRepo(t, 3)
+			checkpointDir := tempDir(t, "fiopush-checkpoint")
+			var corrupted string
+			for path := range repo.objects {
+				corrupted = path
+				break
+			}
+			injectFaults(t, &injector{corrupt: map[string]bool{corrupted: true}})
+
+			report := push(t, repo.dir, hub.url, pushOptions(checkpointDir, tc.retries)...)
+			if tc.retries > 0 {
+				// a retry pass checksums the object once again, so it's pushed intact
+				if report.Retried != 1 || len(report.Failures) > 0 || report.Synced.SyncFailedNumb > 0 || report.RefsSkipped {
+					t.Fatalf("the object with corrupted CRC hasn't been retried: %+v", report)
+				}
+				checkPublished(t, hub, repo)
+				return
+			}
+			if report.Synced.SyncFailedNumb != 1 || !strings.Contains(report.Failures[corrupted], "CRC") {
+				t.Fatalf("the object with corrupted CRC isn't reported failed: %+v", report)
+			}
+			checkIncomplete(t, hub, report, checkpointDir)
+			if _, ok := hub.objects(testFactory)[corrupted]; ok {
+				t.Fatalf("the object with corrupted CRC has been stored")
+			}
+			checkResumed(t, hub, repo, checkpointDir, len(repo.objects)-1)
+		})
+	}
+}
+
+func TestHubUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		caps []string
+	}{
+		{"stream", nil},
+		{"resumable", []string{oshub.CapabilityResumable}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newTestHub(t, tc.caps...)
+			repo := makeTestRepo(t, 3)
+			checkpointDir := tempDir(t, "fiopush-checkpoint")
+			injectFaults(t, &injector{unavailable: 1})
+
+			report := push(t, repo.dir, hub.url, pushOptions(checkpointDir, 1)...)
+			if len(tc.caps) > 0 {
+				// a chunk of a resumable upload is sent again from the offset the hub reports
+				if report.BatchErrors > 0 || report.RefsSkipped || report.Synced.SyncFailedNumb > 0 {
+					t.Fatalf("the chunk failed with 503 hasn't been sent again: %+v", report)
+				}
+				checkPublished(t, hub, repo)
+				return
+			}
+			// the hub hasn't confirmed the stream, so its object is neither retried nor checkpointed
+			if report.BatchErrors != 1 || report.Retried > 0 {
+				t.Fatalf("the stream failed with 503 isn't reported: %+v", report)
+			}
+			checkIncomplete(t, hub, report, checkpointDir)
+			checkResumed(t, hub, repo, checkpointDir, len(repo.objects)-1)
+		})
+	}
+}
+
+func TestDelayPut(t *testing.T) {
+	hub := newTestHub(t)
+	repo := makeTestRepo(t, 3)
+	checkpointDir := tempDir(t, "fiopush-checkpoint")
+	injectFaults(t, &injector{delay: time.Second})
+
+	// the push times out while the first stream is delayed
+	opts := append(pushOptions(checkpointDir, 1), fiopush.WithTimeout(200*time.Millisecond))
+	report := push(t, repo.dir, hub.url, opts...)
+	if !report.Interrupted || report.Retried > 0 {
+		t.Fatalf("the push isn't reported interrupted: %+v", report)
+	}
+	checkIncomplete(t, hub, report, checkpointDir)
+	if n := len(hub.objects(testFactory)); n > 0 {
+		t.Fatalf("the bucket has %d objects of the push interrupted before any stream has been sent", n)
+	}
+
+	faults.Set(nil)
+	checkResumed(t, hub, repo, checkpointDir, 0)
+}
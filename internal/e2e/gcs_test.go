@@ -0,0 +1,187 @@
+package e2e
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// fakeGCS serves the subset of GCS JSON API the uploader uses, objects are kept in memory
+	fakeGCS struct {
+		bucket string
+
+		mu      sync.Mutex
+		objects map[string]*fakeObject
+		// number of objects uploaded so far, an object uploaded twice is counted twice
+		uploads int
+	}
+
+	fakeObject struct {
+		data     []byte
+		crc      uint32
+		metadata map[string]string
+	}
+
+	gcsObject struct {
+		Bucket     string            `json:"bucket"`
+		Name       string            `json:"name"`
+		Size       string            `json:"size"`
+		Crc32c     string            `json:"crc32c"`
+		Generation string            `json:"generation"`
+		Metadata   map[string]string `json:"metadata,omitempty"`
+	}
+)
+
+func newFakeGCS(bucket string) *fakeGCS {
+	return &fakeGCS{bucket: bucket, objects: make(map[string]*fakeObject)}
+}
+
+func (g *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketPath := "/storage/v1/b/" + g.bucket
+	objectPath := bucketPath + "/o/"
+	switch {
+	case r.Method == "GET" && r.URL.Path == bucketPath:
+		writeJSON(w, map[string]string{"kind": "storage#bucket", "name": g.bucket})
+	case r.Method == "GET" && r.URL.Path == bucketPath+"/o":
+		g.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, objectPath):
+		if o := g.object(strings.TrimPrefix(r.URL.Path, objectPath)); o != nil {
+			writeJSON(w, o)
+			return
+		}
+		gcsError(w, http.StatusNotFound, "No such object")
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, objectPath):
+		g.mu.Lock()
+		delete(g.objects, strings.TrimPrefix(r.URL.Path, objectPath))
+		g.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && r.URL.Path == "/upload"+bucketPath+"/o" && r.URL.Query().Get("uploadType") == "multipart":
+		g.upload(w, r)
+	default:
+		gcsError(w, http.StatusNotImplemented, r.Method+" "+r.URL.Path+" is not supported by the fake")
+	}
+}
+
+func (g *fakeGCS) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var attrs gcsObject
+	if err := json.NewDecoder(part).Decode(&attrs); err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	o := &fakeObject{data: data, crc: crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)), metadata: attrs.Metadata}
+	if attrs.Crc32c != "" && attrs.Crc32c != encodeCRC(o.crc) {
+		gcsError(w, http.StatusBadRequest, "Provided CRC32C doesn't match calculated CRC32C")
+		return
+	}
+	g.mu.Lock()
+	g.objects[attrs.Name] = o
+	g.uploads++
+	g.mu.Unlock()
+	writeJSON(w, g.attrs(attrs.Name, o))
+}
+
+func (g *fakeGCS) list(w http.ResponseWriter, prefix string) {
+	g.mu.Lock()
+	var names []string
+	for name := range g.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	items := make([]*gcsObject, 0, len(names))
+	for _, name := range names {
+		items = append(items, g.attrs(name, g.objects[name]))
+	}
+	g.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+func (g *fakeGCS) object(name string) *gcsObject {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o := g.objects[name]; o != nil {
+		return g.attrs(name, o)
+	}
+	return nil
+}
+
+func (g *fakeGCS) attrs(name string, o *fakeObject) *gcsObject {
+	return &gcsObject{Bucket: g.bucket, Name: name, Size: strconv.Itoa(len(o.data)), Crc32c: encodeCRC(o.crc),
+		Generation: "1", Metadata: o.metadata}
+}
+
+func (g *fakeGCS) metadata(name string) map[string]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o := g.objects[name]; o != nil {
+		return o.metadata
+	}
+	return nil
+}
+
+// content returns a copy of the bucket content, object names mapped to their data
+func (g *fakeGCS) content() map[string][]byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	res := make(map[string][]byte, len(g.objects))
+	for name, o := range g.objects {
+		res[name] = o.data
+	}
+	return res
+}
+
+func (g *fakeGCS) uploaded() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.uploads
+}
+
+func encodeCRC(crc uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, crc)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func gcsError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, `{"error":{"code":`+strconv.Itoa(status)+`,"message":`+strconv.Quote(msg)+`}}`)
+}
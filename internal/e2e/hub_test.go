@@ -0,0 +1,226 @@
+// Package e2e tests pushes end to end, fiopush.Pusher pushes a repo to a hub made of oshub and echohub handlers
+// served by httptest, the hub syncs the repo to a fake GCS server it talks to by means of oshub.WithEndpoint
+package e2e
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/oshub/echohub"
+	"github.com/labstack/echo/v4"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testBucket  = "ostreehub-e2e"
+	testFactory = "factory"
+)
+
+type testHub struct {
+	gcs      *fakeGCS
+	uploader *oshub.Uploader
+	url      string
+	repoDir  string
+
+	mu sync.Mutex
+	// numbers of requests the hub has received by method
+	requests map[string]int
+}
+
+// newTestHub starts a hub announcing given capabilities, the hub and its GCS server are stopped once the test ends
+func newTestHub(t *testing.T, caps ...string) *testHub {
+	// the uploader logs each object it uploads, only failures are of interest
+	oshub.SetLogger(oshub.NewStdLogger(oshub.LevelError))
+	gcs := newFakeGCS(testBucket)
+	gcsSrv := httptest.NewServer(gcs)
+	t.Cleanup(gcsSrv.Close)
+	u, err := oshub.NewUploader(context.Background(), oshub.UploaderConfig{Bucket: testBucket, Workers: 2},
+		oshub.WithEndpoint(gcsSrv.URL+"/storage/v1/"), oshub.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create an uploader: %s", err)
+	}
+	t.Cleanup(func() { u.Close() })
+	h := &testHub{gcs: gcs, uploader: u, repoDir: tempDir(t, "oshub-repos"), requests: make(map[string]int)}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.Logger.SetOutput(ioutil.Discard)
+	e.Use(h.countRequests, echohub.AnnounceCapabilities(caps...))
+	e.GET("/readyz", echohub.ReadyzHandler(u))
+	e.GET("/v1/repos/lmp", h.checkHandler)
+	// faults are injected into streams only, so the hub fails a stream after the client has checked its files
+	e.PUT("/v1/repos/lmp", func(c echo.Context) error { return h.sync(c, c.Request().Body) }, echohub.InjectFaults())
+	store := &oshub.UploadStore{Dir: tempDir(t, "oshub-uploads")}
+	e.HEAD("/v1/repos/lmp/uploads/:id", echohub.OffsetHandler(store))
+	e.PATCH("/v1/repos/lmp/uploads/:id", echohub.ChunkHandler(store, h.sync), echohub.InjectFaults())
+	e.GET("/v1/repos/lmp/refs", echohub.RefsHandler(h.factoryDir))
+	e.GET("/v1/repos/lmp/objects", echohub.ObjectsHandler(u, objectPrefix))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	h.url = srv.URL
+	return h
+}
+
+func (h *testHub) countRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h.mu.Lock()
+		h.requests[c.Request().Method]++
+		h.mu.Unlock()
+		return next(c)
+	}
+}
+
+func (h *testHub) received(method string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests[method]
+}
+
+func objectPrefix(factory string) string {
+	return factory
+}
+
+func (h *testHub) factoryDir(factory string) string {
+	return filepath.Join(h.repoDir, factory)
+}
+
+// checkHandler responds with files of a given list the hub lacks
+func (h *testHub) checkHandler(c echo.Context) error {
+	files := make(map[string]uint32)
+	if err := json.NewDecoder(c.Request().Body).Decode(&files); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	queue := make(chan *oshub.RepoFile, len(files))
+	for path, crc := range files {
+		queue <- &oshub.RepoFile{Path: path, CRC32: crc}
+	}
+	close(queue)
+	missing := make(map[string]uint32)
+	for f := range h.uploader.Check(c.Request().Context(), queue, objectPrefix(echohub.Factory(c))) {
+		missing[f.Path] = f.CRC32
+	}
+	return c.JSON(http.StatusOK, missing)
+}
+
+// sync extracts a TAR stream to the factory repo and syncs its files to the bucket, it responds with SyncReport
+func (h *testHub) sync(c echo.Context, body io.Reader) error {
+	factory := echohub.Factory(c)
+	tr, err := oshub.NewTarReader(body, c.Request().Header.Get("Content-Encoding"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+	dir := h.factoryDir(factory)
+	files := oshub.Untar(tr, dir, echohub.Logger(c.Logger()), oshub.WithContext(ctx))
+	counted, reportQueue := oshub.Filter(files, "")
+	prefix := objectPrefix(factory)
+	report := h.uploader.Wait(reportQueue, h.uploader.Sync(ctx, h.uploader.Check(ctx, counted, prefix), prefix, dir))
+	return c.JSON(http.StatusOK, report)
+}
+
+// objects returns the content of objects of a factory stored in the bucket mapped to their repo paths
+func (h *testHub) objects(factory string) map[string][]byte {
+	res := make(map[string][]byte)
+	for name, data := range h.gcs.content() {
+		// e.g. <prefix>/ab/cdef.commit, refs and config are stored as <prefix>/refs/heads/main and <prefix>/config
+		rel := strings.TrimPrefix(name, objectPrefix(factory)+"/")
+		if rel != name && len(rel) > 3 && rel[2] == '/' && !strings.Contains(rel[3:], "/") {
+			res["./objects/"+rel] = data
+		}
+	}
+	return res
+}
+
+type testRepo struct {
+	dir string
+	// repo paths of objects mapped to their content
+	objects map[string][]byte
+	refs    map[string]string
+	rnd     *rand.Rand
+}
+
+// makeTestRepo makes an archive repo of a given number of refs, each of them points to a commit made by addCommit
+func makeTestRepo(t *testing.T, commits int) *testRepo {
+	r := &testRepo{dir: tempDir(t, "fiopush-repo"), objects: make(map[string][]byte), refs: make(map[string]string),
+		rnd: rand.New(rand.NewSource(int64(commits)))}
+	writeFile(t, filepath.Join(r.dir, "config"), []byte("[core]\nrepo_version=1\nmode=archive-z2\n"))
+	for ii := 0; ii < commits; ii++ {
+		r.addCommit(t, fmt.Sprintf("heads/branch-%d", ii))
+	}
+	return r
+}
+
+// addCommit adds a commit which comes with a dirtree and a dirmeta, all of random content, so objects are named
+// by the checksum of their content the way ostree names them, given refs are set to the commit
+func (r *testRepo) addCommit(t *testing.T, refs ...string) string {
+	var commit string
+	for _, ext := range []string{"dirtree", "dirmeta", "commit"} {
+		data := make([]byte, 1024+r.rnd.Intn(4096))
+		r.rnd.Read(data)
+		sum := sha256.Sum256(data)
+		commit = hex.EncodeToString(sum[:])
+		path := fmt.Sprintf("./objects/%s/%s.%s", commit[:2], commit[2:], ext)
+		writeFile(t, filepath.Join(r.dir, filepath.FromSlash(path)), data)
+		r.objects[path] = data
+	}
+	for _, ref := range refs {
+		writeFile(t, filepath.Join(r.dir, "refs", filepath.FromSlash(ref)), []byte(commit+"\n"))
+		r.refs[ref] = commit
+	}
+	return commit
+}
+
+func push(t *testing.T, repo string, hubURL string, opts ...fiopush.Option) *fiopush.Report {
+	opts = append([]fiopush.Option{fiopush.WithLogger(oshub.NewStdLogger(oshub.LevelError))}, opts...)
+	p, err := fiopush.NewPusherNoAuth(repo, hubURL, testFactory, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("failed to run a push: %s", err)
+	}
+	report, err := p.Wait()
+	if err != nil {
+		t.Fatalf("push has failed: %s", err)
+	}
+	return report
+}
+
+func pusher(t *testing.T, repo string, hubURL string) fiopush.Pusher {
+	p, err := fiopush.NewPusherNoAuth(repo, hubURL, testFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func tempDir(t *testing.T, prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package e2e
+
+import (
+	"bytes"
+	"foundriesio/ostreehub/pkg/fiopush"
+	"foundriesio/ostreehub/pkg/oshub"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkPublished checks that the bucket has all objects of a repo and the hub publishes its refs
+func checkPublished(t *testing.T, hub *testHub, repo *testRepo) {
+	stored := hub.objects(testFactory)
+	for path, data := range repo.objects {
+		if !bytes.Equal(stored[path], data) {
+			t.Errorf("an object hasn't been stored intact: %s", path)
+		}
+	}
+	p := pusher(t, repo.dir, hub.url)
+	remote, err := p.RemoteObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[string]bool)
+	for _, path := range remote {
+		listed[path] = true
+	}
+	for path := range repo.objects {
+		if !listed[path] {
+			t.Errorf("the hub doesn't list a pushed object: %s", path)
+		}
+	}
+	refs, err := p.RemoteRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ref, commit := range repo.refs {
+		if refs[ref] != commit {
+			t.Errorf("the hub publishes %s at %q, expected %q", ref, refs[ref], commit)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name string
+		caps []string
+		// the method TAR streams are sent by
+		method string
+		// set if objects are stored along with their SHA-256 digests
+		sha256 bool
+	}{
+		{"stream", nil, http.MethodPut, false},
+		{"resumable", []string{oshub.CapabilityResumable}, http.MethodPatch, false},
+		{"sha256", []string{oshub.CapabilitySHA256}, http.MethodPut, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := newTestHub(t, tc.caps...)
+			resp, err := http.Get(hub.url + "/readyz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("the hub can't reach its bucket: %s", resp.Status)
+			}
+
+			repo := makeTestRepo(t, 3)
+			report := push(t, repo.dir, hub.url, fiopush.WithSHA256(), fiopush.WithBatchBytes(8*1024))
+			if report.BatchErrors > 0 || report.RefsSkipped || report.Synced.SyncFailedNumb > 0 || len(report.Failures) > 0 {
+				t.Fatalf("the push has failed: %+v", report)
+			}
+			if report.Sent.ObjNumb != uint(len(repo.objects)) {
+				t.Errorf("sent %d objects, expected %d", report.Sent.ObjNumb, len(repo.objects))
+			}
+			if len(report.Refs) != len(repo.refs) {
+				t.Errorf("the hub has updated refs %v, expected %v", report.Refs, repo.refs)
+			}
+			if hub.received(tc.method) == 0 {
+				t.Errorf("no stream has been sent by %s", tc.method)
+			}
+			for path := range repo.objects {
+				digest := hub.gcs.metadata(testFactory + strings.TrimPrefix(path, "./objects"))["fio-sha256"]
+				if tc.sha256 != (digest != "") {
+					t.Errorf("unexpected SHA-256 digest of %s: %q", path, digest)
+				}
+			}
+			checkPublished(t, hub, repo)
+
+			// the hub has all objects, so only refs and config are pushed again, the bucket has them intact too
+			uploads := hub.gcs.uploaded()
+			report = push(t, repo.dir, hub.url)
+			if report.BatchErrors > 0 || report.RefsSkipped || report.Sent.ObjNumb > 0 {
+				t.Fatalf("unexpected report of the repeated push: %+v", report)
+			}
+			if hub.gcs.uploaded() != uploads {
+				t.Errorf("the repeated push has uploaded %d objects again", hub.gcs.uploaded()-uploads)
+			}
+		})
+	}
+}
+
+func TestPushRefsOfOneCommit(t *testing.T) {
+	hub := newTestHub(t)
+	repo := makeTestRepo(t, 0)
+	refs := []string{"heads/branch-0", "heads/branch-1", "heads/branch-2"}
+	repo.addCommit(t, refs...)
+	for i := 0; i < 2; i++ {
+		report := push(t, repo.dir, hub.url)
+		if report.BatchErrors > 0 || report.RefsSkipped || report.Synced.SyncFailedNumb > 0 || len(report.Failures) > 0 {
+			t.Fatalf("push %d has failed: %+v", i, report)
+		}
+		if len(report.Refs) != len(refs) {
+			t.Errorf("push %d has updated refs %v, expected %v", i, report.Refs, repo.refs)
+		}
+		checkPublished(t, hub, repo)
+		// each ref is a file of its own on the hub, so an update of one ref doesn't change the others
+		for _, ref := range refs[1:] {
+			first, err := os.Stat(filepath.Join(hub.factoryDir(testFactory), "refs", filepath.FromSlash(refs[0])))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(filepath.Join(hub.factoryDir(testFactory), "refs", filepath.FromSlash(ref)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(first, fi) {
+				t.Errorf("%s is linked to %s on the hub", ref, refs[0])
+			}
+		}
+		repo.addCommit(t, refs...)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build faults
+// +build faults
+
+package faults
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Config specifies probabilities of faults and a delay of PUT requests
+	Config struct {
+		DropBatch      float64
+		CorruptCRC     float64
+		HubUnavailable float64
+		DelayPut       time.Duration
+	}
+
+	random struct {
+		cfg Config
+		mu  sync.Mutex
+		rnd *rand.Rand
+	}
+)
+
+const (
+	// EnvVar specifies faults to inject, e.g. FIO_FAULTS=drop-batch=0.1,corrupt-crc=0.01,delay-put=2s,hub-503=0.5
+	EnvVar = "FIO_FAULTS"
+)
+
+var (
+	active Injector = nop{}
+)
+
+func init() {
+	if spec := os.Getenv(EnvVar); spec != "" {
+		cfg, err := ParseConfig(spec)
+		if err != nil {
+			panic(err)
+		}
+		active = NewRandom(cfg, time.Now().UnixNano())
+	}
+}
+
+// Active returns the injector hooks of the pipeline consult
+func Active() Injector {
+	return active
+}
+
+// Set replaces the active injector, e.g. by a test specific one
+func Set(i Injector) {
+	if i == nil {
+		i = nop{}
+	}
+	active = i
+}
+
+// ParseConfig parses a comma separated list of fault=value pairs, see EnvVar
+func ParseConfig(spec string) (Config, error) {
+	var cfg Config
+	for _, kv := range strings.Split(spec, ",") {
+		pair := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(pair) != 2 {
+			return cfg, fmt.Errorf("invalid fault spec, expected fault=value: %s", kv)
+		}
+		var err error
+		switch pair[0] {
+		case "drop-batch":
+			cfg.DropBatch, err = strconv.ParseFloat(pair[1], 64)
+		case "corrupt-crc":
+			cfg.CorruptCRC, err = strconv.ParseFloat(pair[1], 64)
+		case "hub-503":
+			cfg.HubUnavailable, err = strconv.ParseFloat(pair[1], 64)
+		case "delay-put":
+			cfg.DelayPut, err = time.ParseDuration(pair[1])
+		default:
+			return cfg, fmt.Errorf("unknown fault: %s", pair[0])
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid value of %s fault: %s", pair[0], err.Error())
+		}
+	}
+	return cfg, nil
+}
+
+// NewRandom returns an injector injecting faults with probabilities specified in a given config
+func NewRandom(cfg Config, seed int64) Injector {
+	return &random{cfg: cfg, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (r *random) DropBatch(uint32) bool {
+	return r.hit(r.cfg.DropBatch)
+}
+
+func (r *random) CorruptCRC(string) bool {
+	return r.hit(r.cfg.CorruptCRC)
+}
+
+func (r *random) DelayPut() time.Duration {
+	return r.cfg.DelayPut
+}
+
+func (r *random) HubUnavailable() bool {
+	return r.hit(r.cfg.HubUnavailable)
+}
+
+func (r *random) hit(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Float64() < probability
+}
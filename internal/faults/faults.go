@@ -0,0 +1,27 @@
+// Package faults injects faults into the push pipeline to exercise its retry, resume and reporting behavior.
+// Faults are injected only by binaries built with the "faults" build tag, otherwise all hooks are no-op.
+package faults
+
+import (
+	"time"
+)
+
+type (
+	Injector interface {
+		// DropBatch returns true if a given batch of files should be skipped instead of being pushed
+		DropBatch(batch uint32) bool
+		// CorruptCRC returns true if CRC of a given file should be corrupted before it is sent to the hub
+		CorruptCRC(path string) bool
+		// DelayPut returns a delay to apply before a TAR stream is PUT to the hub
+		DelayPut() time.Duration
+		// HubUnavailable returns true if the hub should respond with 503 to a request
+		HubUnavailable() bool
+	}
+
+	nop struct{}
+)
+
+func (nop) DropBatch(uint32) bool   { return false }
+func (nop) CorruptCRC(string) bool  { return false }
+func (nop) DelayPut() time.Duration { return 0 }
+func (nop) HubUnavailable() bool    { return false }
@@ -0,0 +1,176 @@
+package fiopush
+
+import (
+	"context"
+	"foundriesio/ostreehub/pkg/oshub"
+)
+
+type (
+	AggOption func(*aggConfig)
+
+	aggConfig struct {
+		ctx      context.Context
+		logger   Logger
+		progress func(Report)
+		// called with each event along with the report updated by it
+		onEvent func(Event, Report)
+	}
+)
+
+// AggContext makes Aggregate stop consuming the status channels and return the report collected so far
+// once a given context is done
+func AggContext(ctx context.Context) AggOption {
+	return func(c *aggConfig) {
+		c.ctx = ctx
+	}
+}
+
+// AggLogger makes Aggregate log the push progress by means of a given logger
+func AggLogger(l Logger) AggOption {
+	return func(c *aggConfig) {
+		c.logger = l
+	}
+}
+
+// AggProgress makes Aggregate call a given function with the cumulative report each time it is updated
+func AggProgress(fn func(Report)) AggOption {
+	return func(c *aggConfig) {
+		c.progress = fn
+	}
+}
+
+// Aggregate consumes the events of a push pipeline, e.g. composed of oshub.Tar and custom stages,
+// and sums the reports up until the event channel is closed
+func Aggregate(status *Status, opts ...AggOption) *Report {
+	cfg := aggConfig{ctx: context.Background(), logger: oshub.NewStdLogger(oshub.LevelInfo)}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	var report Report
+	for {
+		var event Event
+		var ok bool
+		select {
+		case <-cfg.ctx.Done():
+			cfg.logger.Warn("Stopped aggregating the push status", "err", cfg.ctx.Err())
+			return &report
+		case event, ok = <-status.Events:
+		}
+		if !ok {
+			cfg.logger.Info("Repo sync has completed")
+			return &report
+		}
+
+		if !applyEvent(&report, event) {
+			continue
+		}
+		switch event.Type {
+		case EventCheckedBatch:
+			cfg.logger.Info("Checked", "files", report.Checked)
+		case EventSentBatch:
+			cfg.logger.Info("Sent", "files", report.Sent.FileNumb, "bytes", report.Sent.Bytes)
+		case EventError:
+			cfg.logger.Warn("Failed to push a batch", "batch", event.Batch, "err", event.Err)
+		}
+		if cfg.onEvent != nil {
+			cfg.onEvent(event, report)
+		}
+		if cfg.progress != nil {
+			cfg.progress(report)
+		}
+	}
+}
+
+// applyEvent adds an outcome of a batch carried by an event to a report, false is returned if there is none
+func applyEvent(report *Report, event Event) bool {
+	switch event.Type {
+	case EventCheckedBatch:
+		report.Checked += event.Checked
+		addCorrupted(report, event.Corrupted)
+	case EventSentBatch:
+		if event.Sent == nil {
+			return false
+		}
+		report.Sent.FileNumb += event.Sent.FileNumb
+		report.Sent.ObjNumb += event.Sent.ObjNumb
+		report.Sent.Bytes += event.Sent.Bytes
+		report.Sent.DedupNumb += event.Sent.DedupNumb
+		report.Sent.DedupBytes += event.Sent.DedupBytes
+	case EventSyncedBatch:
+		if event.Synced == nil {
+			return false
+		}
+		addSyncReport(&report.Synced, event.Synced)
+		report.Synced.Changed = append(report.Synced.Changed, event.Synced.Changed...)
+		addFailures(report, event.Synced.Failures)
+	case EventError:
+		report.BatchErrors++
+	}
+	return true
+}
+
+// MergeReports sums reports of several pushes up, e.g. of several repos pushed in a single run
+func MergeReports(reports ...*Report) *Report {
+	var total Report
+	for _, r := range reports {
+		total.Checked += r.Checked
+		total.Sent.FileNumb += r.Sent.FileNumb
+		total.Sent.ObjNumb += r.Sent.ObjNumb
+		total.Sent.Bytes += r.Sent.Bytes
+		total.Sent.DedupNumb += r.Sent.DedupNumb
+		total.Sent.DedupBytes += r.Sent.DedupBytes
+		addSyncReport(&total.Synced, &r.Synced)
+		total.Synced.Changed = append(total.Synced.Changed, r.Synced.Changed...)
+		addFailures(&total, r.Failures)
+		for ref, commit := range r.Refs {
+			if total.Refs == nil {
+				total.Refs = make(map[string]string)
+			}
+			total.Refs[ref] = commit
+		}
+		addCorrupted(&total, r.Corrupted)
+		total.Resumed += r.Resumed
+		total.BatchErrors += r.BatchErrors
+		total.CommitMeta.Checked += r.CommitMeta.Checked
+		total.CommitMeta.Sent += r.CommitMeta.Sent
+		total.CommitMeta.Bytes += r.CommitMeta.Bytes
+		total.CommitMeta.Synced += r.CommitMeta.Synced
+		total.CommitMeta.Failed += r.CommitMeta.Failed
+		total.Timing = mergeTiming(total.Timing, r.Timing, total.Sent.Bytes)
+	}
+	return &total
+}
+
+func addFailures(r *Report, failures map[string]string) {
+	if len(failures) == 0 {
+		return
+	}
+	if r.Failures == nil {
+		r.Failures = make(map[string]string)
+	}
+	for path, reason := range failures {
+		r.Failures[path] = reason
+	}
+}
+
+func addCorrupted(r *Report, corrupted map[string]string) {
+	if len(corrupted) == 0 {
+		return
+	}
+	if r.Corrupted == nil {
+		r.Corrupted = make(map[string]string)
+	}
+	for path, reason := range corrupted {
+		r.Corrupted[path] = reason
+	}
+}
+
+func addSyncReport(total *oshub.SyncReport, r *oshub.SyncReport) {
+	total.UploadedFileNumb += r.UploadedFileNumb
+	total.SyncedFileNumb += r.SyncedFileNumb
+	total.UploadSyncedFileNumb += r.UploadSyncedFileNumb
+	total.SyncFailedNumb += r.SyncFailedNumb
+	total.ObjectNumb += r.ObjectNumb
+	total.SpilledFileNumb += r.SpilledFileNumb
+}
@@ -0,0 +1,125 @@
+package fiopush
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+type (
+	// archiver calculates CRC, and optionally SHA-256, and a size of files of a bare repo converted to archive-z2
+	archiver struct {
+		repoDir string
+		mode    string
+		crc     hash.Hash32
+		sha     hash.Hash
+	}
+
+	byteCounter int64
+)
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// convertsToArchive returns true if content objects of the repo are converted to archive-z2 ones while they are pushed
+func (p *pusher) convertsToArchive() bool {
+	return p.toArchive && p.mode != "" && !ostree.IsArchiveMode(p.mode)
+}
+
+func (p *pusher) newArchiver() *archiver {
+	a := &archiver{repoDir: p.repo, mode: p.mode, crc: crc32.New(crc32.MakeTable(crc32.Castagnoli))}
+	if p.sha256 {
+		a.sha = sha256.New()
+	}
+	return a
+}
+
+// convert turns a file of a bare repo to the one of an archive-z2 repo, i.e. a content object becomes a .filez
+// object and the config gets the archive-z2 mode, other files are left as is. The file is converted to calculate
+// its CRC and size, it's converted once again while it's sent, as the conversion always makes the same file.
+// A converted file is a copy, so files shared by several pushers aren't changed.
+func (a *archiver) convert(f *oshub.RepoFile) (*oshub.RepoFile, error) {
+	if !oshub.IsConvertedToArchive(f.Path) {
+		return f, nil
+	}
+	a.crc.Reset()
+	var size byteCounter
+	dst := io.MultiWriter(a.crc, &size)
+	if a.sha != nil {
+		a.sha.Reset()
+		dst = io.MultiWriter(a.crc, a.sha, &size)
+	}
+	sem := fileSemaphore()
+	sem.Acquire(1)
+	err := oshub.ArchiveRepoFile(dst, a.repoDir, f.Path, a.mode)
+	sem.Release(1)
+	if err != nil {
+		return nil, err
+	}
+	converted := &oshub.RepoFile{Path: oshub.ArchivedObjectPath(f.Path), CRC32: a.crc.Sum32(), Size: int64(size)}
+	if a.sha != nil {
+		converted.SHA256 = hex.EncodeToString(a.sha.Sum(nil))
+	}
+	return converted, nil
+}
+
+// archiveRepoFiles converts queued files of a bare repo to the ones of an archive-z2 repo by several workers
+func (p *pusher) archiveRepoFiles(ctx context.Context, files <-chan *oshub.RepoFile) <-chan *oshub.RepoFile {
+	queue := make(chan *oshub.RepoFile, p.queueSize)
+	go func() {
+		defer close(queue)
+		var wg sync.WaitGroup
+		for ii := 0; ii < crcWorkerNumb; ii++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a := p.newArchiver()
+				for f := range files {
+					converted, err := a.convert(f)
+					if err != nil {
+						p.fail(fmt.Errorf("failed to convert %s to an archive-z2 one: %s", f.Path, err.Error()))
+						continue
+					}
+					select {
+					case queue <- converted:
+					case <-ctx.Done():
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	return queue
+}
+
+// readRepoFiles calculates CRC, and optionally SHA-256, of given repo files the way they are pushed,
+// paths of converted content objects are the archived ones
+func (p *pusher) readRepoFiles(paths []string) ([]*oshub.RepoFile, error) {
+	if !p.convertsToArchive() {
+		return crcRepoFiles(p.repo, paths, p.sha256)
+	}
+	src := make([]string, len(paths))
+	for ii, path := range paths {
+		src[ii] = oshub.BareObjectPath(path)
+	}
+	files, err := crcRepoFiles(p.repo, src, p.sha256)
+	if err != nil {
+		return nil, err
+	}
+	a := p.newArchiver()
+	for ii, f := range files {
+		if files[ii], err = a.convert(f); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
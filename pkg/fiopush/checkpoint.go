@@ -0,0 +1,370 @@
+package fiopush
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCheckpointInterval is how often a checkpoint of a push is written, see WithCheckpoint
+	DefaultCheckpointInterval = 30 * time.Second
+	checkpointVersion         = 1
+	// a suffix of a file next to the checkpoint listing objects confirmed synced, a line per object
+	syncedListSuffix = ".synced"
+)
+
+type (
+	// Checkpoint is a state of a push written to a file periodically, see WithCheckpoint, so the progress of
+	// a long push can be monitored externally and the push resumes where it stopped, e.g. after a reboot
+	Checkpoint struct {
+		Version int    `json:"version"`
+		HubURL  string `json:"hub_url"`
+		Factory string `json:"factory"`
+		// an absolute path of the repo
+		Repo    string    `json:"repo"`
+		Session string    `json:"session"`
+		Phase   string    `json:"phase"`
+		Started time.Time `json:"started"`
+		Updated time.Time `json:"updated"`
+		// objects confirmed synced by this and previous sessions of the push, they are listed next to the checkpoint
+		SyncedObjects uint `json:"synced_objects"`
+		// objects not pushed by this session since previous sessions confirmed them synced
+		ResumedObjects uint `json:"resumed_objects"`
+		// counters of this session, the same as the ones of Report
+		Checked   uint   `json:"checked"`
+		SentFiles uint   `json:"sent_files"`
+		SentBytes int64  `json:"sent_bytes"`
+		Failed    uint32 `json:"failed"`
+		// refs of the repo pushed by the session, ref names mapped to commit checksums
+		Refs map[string]string `json:"refs,omitempty"`
+		// set once refs have been pushed
+		RefsPushed bool `json:"refs_pushed"`
+		// set once all files have been pushed, a push starting from a completed checkpoint checks all files again
+		Done bool `json:"done"`
+	}
+
+	// checkpointer records objects confirmed synced by the hub and writes a checkpoint of the push
+	checkpointer struct {
+		mu    sync.Mutex
+		file  string
+		state Checkpoint
+		// objects confirmed synced by previous sessions mapped to their CRC, they are removed once they are skipped
+		resumed map[string]uint32
+		synced  *os.File
+		w       *bufio.Writer
+		// the first error of writing the list of synced objects, nothing is added to the list afterwards
+		err    error
+		stop   chan struct{}
+		logger Logger
+	}
+)
+
+// CheckpointFile returns a checkpoint file of a push to a given factory written to a given directory
+func CheckpointFile(dir string, factory string) string {
+	return filepath.Join(dir, factory+".checkpoint.json")
+}
+
+// ReadCheckpoint reads a checkpoint file written by a push, e.g. to monitor its progress
+func ReadCheckpoint(file string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a checkpoint: %s", err.Error())
+	}
+	return &c, nil
+}
+
+// openCheckpoint starts writing a checkpoint of the push, objects confirmed synced by a previous session are skipped
+// if it has been interrupted, i.e. its checkpoint is of the same repo and factory and it hasn't completed
+func (p *pusher) openCheckpoint() error {
+	if err := os.MkdirAll(p.checkpointDir, 0755); err != nil {
+		return fmt.Errorf("failed to create a checkpoint directory: %s", err.Error())
+	}
+	repo, err := filepath.Abs(p.repo)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the repo directory: %s", err.Error())
+	}
+	c := &checkpointer{
+		file: CheckpointFile(p.checkpointDir, p.hub.Factory),
+		state: Checkpoint{
+			Version: checkpointVersion,
+			HubURL:  p.hub.URL,
+			Factory: p.hub.Factory,
+			Repo:    repo,
+			Session: p.session,
+			Phase:   PhaseObjects,
+			Started: time.Now().UTC(),
+			Refs:    p.pushedRefs(),
+		},
+		stop:   make(chan struct{}),
+		logger: p.logger.With("checkpoint", CheckpointFile(p.checkpointDir, p.hub.Factory)),
+	}
+	var syncedSize int64
+	prev, err := ReadCheckpoint(c.file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		c.logger.Warn("Failed to read a checkpoint of a previous push, all files are checked", "err", err)
+	case prev.Done || prev.HubURL != c.state.HubURL || prev.Factory != c.state.Factory || prev.Repo != c.state.Repo:
+		c.logger.Debug("The previous push has completed or it's of another repo, all files are checked", "session", prev.Session)
+	case p.forceUpload:
+		c.logger.Info("All files are pushed regardless of the checkpoint of the previous push", "session", prev.Session)
+	default:
+		if c.resumed, syncedSize, err = readSyncedList(c.file + syncedListSuffix); err != nil {
+			c.logger.Warn("Failed to read objects synced by the previous push, all files are checked", "err", err)
+			break
+		}
+		c.state.SyncedObjects = uint(len(c.resumed))
+		c.logger.Info("Resuming the push from a checkpoint", "session", prev.Session, "synced", len(c.resumed))
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if c.resumed != nil {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	if c.synced, err = os.OpenFile(c.file+syncedListSuffix, flags, 0644); err != nil {
+		return fmt.Errorf("failed to open a list of synced objects: %s", err.Error())
+	}
+	if c.resumed != nil {
+		// objects are appended after the ones listed before, a torn line is dropped
+		if err := c.synced.Truncate(syncedSize); err != nil {
+			c.synced.Close()
+			return fmt.Errorf("failed to truncate a list of synced objects: %s", err.Error())
+		}
+	}
+	c.w = bufio.NewWriter(c.synced)
+	if err := c.write(p.snapshot.get()); err != nil {
+		c.synced.Close()
+		return fmt.Errorf("failed to write a checkpoint: %s", err.Error())
+	}
+	p.checkpoint = c
+	go p.writeCheckpoints(c.stop)
+	return nil
+}
+
+// writeCheckpoints writes the checkpoint every interval until it's stopped
+func (p *pusher) writeCheckpoints(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.checkpoint.write(p.snapshot.get()); err != nil {
+				p.checkpoint.logger.Warn("Failed to write a checkpoint", "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// closeCheckpoint writes the final checkpoint of the push, the list of synced objects is removed
+// if the push has completed since the next push checks all files anyway
+func (p *pusher) closeCheckpoint(report *Report) {
+	c := p.checkpoint
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	c.mu.Lock()
+	c.state.RefsPushed = !report.RefsSkipped && !report.Interrupted
+	c.state.Done = c.state.RefsPushed && report.Synced.SyncFailedNumb == 0 && report.BatchErrors == 0
+	c.mu.Unlock()
+	if err := c.write(*report); err != nil {
+		c.logger.Warn("Failed to write a checkpoint", "err", err)
+	}
+	c.synced.Close()
+	if c.state.Done {
+		os.Remove(c.file + syncedListSuffix)
+	}
+	c.logger.Debug("Wrote the final checkpoint of the push", "done", c.state.Done)
+}
+
+// pushedRefs returns refs of the repo that are pushed, ref names mapped to commit checksums
+func (p *pusher) pushedRefs() map[string]string {
+	refs, err := (&ostree.Repo{Dir: p.repo}).Refs()
+	if err != nil {
+		p.logger.Warn("Failed to read refs of the repo", "err", err)
+		return nil
+	}
+	filter := p.repoFilter()
+	for ref := range refs {
+		if !filter.match("./refs/" + ref) {
+			delete(refs, ref)
+		}
+	}
+	return refs
+}
+
+// isResumable returns true if a repo file is skipped by a resumed push once it's been confirmed synced,
+// i.e. it's an object other than detached commit metadata which is pushed along with refs
+func isResumable(path string) bool {
+	return strings.HasPrefix(path, "./objects/") && !isCommitMeta(path)
+}
+
+// skipSynced passes through queued files except objects confirmed synced by previous sessions of the push,
+// an object changed since then, i.e. of another CRC, is pushed again
+func (c *checkpointer) skipSynced(ctx context.Context, files <-chan *oshub.RepoFile, queueSize uint) <-chan *oshub.RepoFile {
+	if len(c.resumed) == 0 {
+		return files
+	}
+	queue := make(chan *oshub.RepoFile, queueSize)
+	go func() {
+		defer close(queue)
+		for f := range files {
+			c.mu.Lock()
+			crc, ok := c.resumed[f.Path]
+			if ok {
+				delete(c.resumed, f.Path)
+			}
+			if skip := ok && crc == f.CRC32 && isResumable(f.Path); skip {
+				c.state.ResumedObjects += 1
+				c.mu.Unlock()
+				continue
+			}
+			c.mu.Unlock()
+			select {
+			case queue <- f:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return queue
+}
+
+// confirm records objects the hub has confirmed synced, either it had them or they have been synced,
+// except the pending ones, e.g. the ones the hub lacks or failed to sync
+func (c *checkpointer) confirm(files map[string]uint32, pending func(path string) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	for path, crc := range files {
+		if !isResumable(path) || pending(path) {
+			continue
+		}
+		if _, c.err = fmt.Fprintf(c.w, "%08x %s\n", crc, path); c.err != nil {
+			c.logger.Warn("Failed to record synced objects, the push resumes from the last checkpoint", "err", c.err)
+			return
+		}
+		c.state.SyncedObjects += 1
+	}
+}
+
+// setPhase records a phase the push has entered
+func (c *checkpointer) setPhase(phase string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.state.Phase = phase
+	c.mu.Unlock()
+}
+
+// resumedObjects returns a number of objects skipped since previous sessions confirmed them synced
+func (c *checkpointer) resumedObjects() uint {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.ResumedObjects
+}
+
+// write updates the checkpoint file with given counters of the push, objects confirmed synced so far are flushed
+// to disk first, so the checkpoint never counts objects missing from the list
+func (c *checkpointer) write(r Report) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		if c.err = c.w.Flush(); c.err == nil {
+			c.err = c.synced.Sync()
+		}
+		if c.err != nil {
+			c.logger.Warn("Failed to record synced objects, the push resumes from the last checkpoint", "err", c.err)
+		}
+	}
+	c.state.Updated = time.Now().UTC()
+	c.state.Checked = r.Checked
+	c.state.SentFiles = r.Sent.FileNumb
+	c.state.SentBytes = r.Sent.Bytes
+	c.state.Failed = r.Synced.SyncFailedNumb
+	data, err := json.MarshalIndent(&c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so a checkpoint is never read partially written, even after a reboot
+	tmp, err := ioutil.TempFile(filepath.Dir(c.file), ".checkpoint-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// the checkpoint is readable by monitoring tools run by other users
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// readSyncedList reads objects confirmed synced mapped to their CRC, an object listed several times has the CRC
+// it was synced with last. It returns a size of complete lines too, a line torn by a crash follows them.
+func readSyncedList(file string) (map[string]uint32, int64, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return map[string]uint32{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	synced := make(map[string]uint32)
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return synced, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+		fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+		if len(fields) != 2 || len(fields[0]) != 8 || !isResumable(fields[1]) {
+			continue
+		}
+		if crc, err := strconv.ParseUint(fields[0], 16, 32); err == nil {
+			synced[fields[1]] = uint32(crc)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package fiopush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHub is a hub lacking all objects, each PUT stream is passed to onPut which returns a status to respond with
+type testHub struct {
+	mu    sync.Mutex
+	puts  [][]string
+	onPut func(w http.ResponseWriter, r *http.Request, put int, files []string) int
+}
+
+func (h *testHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		body, _ := ioutil.ReadAll(r.Body)
+		objs := map[string]uint32{}
+		json.Unmarshal(body, &objs)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(objs)
+	case "PUT":
+		tr, err := oshub.NewTarReader(r.Body, r.Header.Get("Content-Encoding"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var files []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			files = append(files, hdr.Name)
+		}
+		h.mu.Lock()
+		h.puts = append(h.puts, files)
+		put := len(h.puts)
+		h.mu.Unlock()
+		status := http.StatusOK
+		if h.onPut != nil {
+			status = h.onPut(w, r, put, files)
+		}
+		if status != http.StatusOK {
+			http.Error(w, "injected failure", status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(oshub.SyncReport{UploadedFileNumb: uint32(len(files))})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// pushed returns objects of the streams the hub has received so far
+func (h *testHub) pushed() map[string]bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make(map[string]bool)
+	for _, files := range h.puts {
+		for _, f := range files {
+			if strings.HasPrefix(f, "./objects/") {
+				res[f] = true
+			}
+		}
+	}
+	return res
+}
+
+func (h *testHub) reset() {
+	h.mu.Lock()
+	h.puts = nil
+	h.onPut = nil
+	h.mu.Unlock()
+}
+
+// makeTestRepo makes an archive repo of a given number of content objects, their paths are returned
+func makeTestRepo(t *testing.T, objects int) (string, []string) {
+	dir, err := ioutil.TempDir("", "fiopush-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte("[core]\nrepo_version=1\nmode=archive-z2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for ii := 0; ii < objects; ii++ {
+		name := fmt.Sprintf("%02x/%062x.filez", ii, ii)
+		if err := os.MkdirAll(filepath.Join(dir, "objects", name[:2]), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "objects", name), []byte(strings.Repeat(name, 10)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, "./objects/"+name)
+	}
+	return dir, paths
+}
+
+// pushTestRepo pushes a repo a batch per object, so a batch a hub fails fails only its object
+func pushTestRepo(t *testing.T, ctx context.Context, repo string, hubURL string, checkpointDir string) *Report {
+	p, err := NewPusherNoAuth(repo, hubURL, "factory", WithContext(ctx), WithWorkers(1), WithBatchBytes(1),
+		WithObjectVerification(false), WithCheckpoint(checkpointDir, time.Hour), WithRetries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("failed to run a push: %s", err)
+	}
+	report, err := p.Wait()
+	if err != nil {
+		t.Fatalf("push has failed: %s", err)
+	}
+	return report
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var res []string
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// checkResumed checks that a resumed push has pushed all objects but the ones confirmed by the previous push
+func checkResumed(t *testing.T, objects []string, confirmed map[string]bool, pushed map[string]bool) {
+	for _, obj := range objects {
+		if confirmed[obj] && pushed[obj] {
+			t.Errorf("an object confirmed synced is pushed again: %s", obj)
+		}
+		if !confirmed[obj] && !pushed[obj] {
+			t.Errorf("an object not confirmed synced hasn't been pushed: %s", obj)
+		}
+	}
+}
+
+func TestCheckpointResumeCancelledBatch(t *testing.T) {
+	repo, objects := makeTestRepo(t, 6)
+	hub := &testHub{}
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	checkpointDir, err := ioutil.TempDir("", "fiopush-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(checkpointDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	confirmed := make(map[string]bool)
+	var cancelled string
+	hub.onPut = func(w http.ResponseWriter, r *http.Request, put int, files []string) int {
+		obj := files[0]
+		if !strings.HasPrefix(obj, "./objects/") {
+			return http.StatusOK
+		}
+		if len(confirmed) == 2 {
+			// the stream has been received completely, yet the push is cancelled before the hub responds
+			cancelled = obj
+			cancel()
+			<-r.Context().Done()
+			return http.StatusServiceUnavailable
+		}
+		confirmed[obj] = true
+		return http.StatusOK
+	}
+	report := pushTestRepo(t, ctx, repo, srv.URL, checkpointDir)
+	if !report.Interrupted {
+		t.Fatalf("the cancelled push isn't reported interrupted: %+v", report)
+	}
+	if cancelled == "" {
+		t.Fatalf("the push hasn't been cancelled")
+	}
+	c, err := ReadCheckpoint(CheckpointFile(checkpointDir, "factory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done || c.SyncedObjects != uint(len(confirmed)) {
+		t.Fatalf("unexpected checkpoint of the cancelled push, done: %v, synced: %d, expected %d",
+			c.Done, c.SyncedObjects, len(confirmed))
+	}
+
+	hub.reset()
+	report = pushTestRepo(t, context.Background(), repo, srv.URL, checkpointDir)
+	if report.Interrupted || report.BatchErrors > 0 || report.RefsSkipped {
+		t.Fatalf("the resumed push hasn't completed: %+v", report)
+	}
+	if report.Resumed != uint(len(confirmed)) {
+		t.Fatalf("resumed %d objects, expected %d", report.Resumed, len(confirmed))
+	}
+	pushed := hub.pushed()
+	if !pushed[cancelled] {
+		t.Fatalf("the object of the cancelled batch hasn't been pushed again, pushed: %v", sortedKeys(pushed))
+	}
+	checkResumed(t, objects, confirmed, pushed)
+}
+
+func TestCheckpointResumeUnconfirmedBatch(t *testing.T) {
+	repo, objects := makeTestRepo(t, 4)
+	hub := &testHub{}
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	checkpointDir, err := ioutil.TempDir("", "fiopush-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(checkpointDir)
+
+	// the hub fails a batch without a SyncReport, e.g. a proxy in front of it is unavailable
+	failed := objects[1]
+	hub.onPut = func(w http.ResponseWriter, r *http.Request, put int, files []string) int {
+		if files[0] == failed {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	}
+	report := pushTestRepo(t, context.Background(), repo, srv.URL, checkpointDir)
+	if report.BatchErrors != 1 || !report.RefsSkipped {
+		t.Fatalf("the unconfirmed batch isn't reported: %+v", report)
+	}
+	c, err := ReadCheckpoint(CheckpointFile(checkpointDir, "factory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done || c.SyncedObjects != uint(len(objects)-1) {
+		t.Fatalf("unexpected checkpoint, done: %v, synced: %d, expected %d", c.Done, c.SyncedObjects, len(objects)-1)
+	}
+
+	hub.reset()
+	report = pushTestRepo(t, context.Background(), repo, srv.URL, checkpointDir)
+	if report.BatchErrors > 0 || report.RefsSkipped {
+		t.Fatalf("the resumed push hasn't completed: %+v", report)
+	}
+	confirmed := make(map[string]bool)
+	for _, obj := range objects {
+		confirmed[obj] = obj != failed
+	}
+	checkResumed(t, objects, confirmed, hub.pushed())
+}
@@ -0,0 +1,169 @@
+package fiopush
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxAdaptiveWorkers bounds a number of batches pushed concurrently if it's tuned, see WithAdaptiveConcurrency
+	DefaultMaxAdaptiveWorkers int = 64
+	// a number of batches pushed concurrently a tuned push starts with
+	initialAdaptiveWorkers float64 = 4
+	// a batch size limit the tuner doesn't shrink batches below of, it's also a step batches grow by
+	minAdaptiveBatchBytes int64 = 8 * 1024 * 1024
+	// a check request slower than the fastest one observed by the factor is a sign of an overloaded hub,
+	// unless it's faster than congestionLatencyFloor which is fine regardless of the baseline
+	congestionLatencyFactor = 3
+	congestionLatencyFloor  = time.Second
+)
+
+type (
+	// concurrencyTuner adjusts a number of batches pushed concurrently and a batch size limit the AIMD way:
+	// they grow additively while batches succeed and the hub responds as fast as it used to, and they are halved
+	// once the hub fails a batch, throttles the push or slows down, so a slow hub isn't overwhelmed
+	// while a fast one is used up to its capacity
+	concurrencyTuner struct {
+		mu   sync.Mutex
+		cond *sync.Cond
+		// a number of batches allowed in flight, it grows by a batch per batch pushed until the hub gets overloaded
+		// for the first time, by a fraction of a batch per batch afterwards, i.e. by one batch per round
+		limit      float64
+		maxWorkers int
+		active     int
+		// a current batch size limit, it's bounded by the configured one
+		batchBytes    int64
+		maxBatchBytes int64
+		// the fastest check request observed, i.e. the latency of a hub that isn't loaded
+		baseLatency time.Duration
+		// when the limits were decreased or the hub throttled the push for the last time,
+		// batches started before the decrease don't decrease them again
+		decreased time.Time
+		throttled time.Time
+		logger    Logger
+	}
+)
+
+func newConcurrencyTuner(maxWorkers int, maxBatchBytes int64, logger Logger) *concurrencyTuner {
+	t := &concurrencyTuner{
+		limit:         initialAdaptiveWorkers,
+		maxWorkers:    maxWorkers,
+		batchBytes:    maxBatchBytes,
+		maxBatchBytes: maxBatchBytes,
+		logger:        logger,
+	}
+	if t.limit > float64(maxWorkers) {
+		t.limit = float64(maxWorkers)
+	}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// workers returns a number of workers pushing batches, only as many of them as the limit allows push at once
+func (t *concurrencyTuner) workers() int {
+	return t.maxWorkers
+}
+
+// acquire blocks until fewer batches than the limit are in flight, it returns when a batch may start
+// and a batch size limit for it, ok is false if the push has been cancelled meanwhile
+func (t *concurrencyTuner) acquire(ctx context.Context) (start time.Time, batchBytes int64, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.active >= int(t.limit) && ctx.Err() == nil {
+		t.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return time.Time{}, 0, false
+	}
+	t.active += 1
+	return time.Now(), t.batchBytes, true
+}
+
+// tunesBatches returns true if batches are bounded by size, so their size limit can be tuned
+func (t *concurrencyTuner) tunesBatches() bool {
+	return t.maxBatchBytes > minAdaptiveBatchBytes
+}
+
+// release ends a batch started at a given time, congested is set if the batch has failed,
+// checkLatency is a duration of the request checking files of the batch, zero if it's unknown
+func (t *concurrencyTuner) release(start time.Time, checkLatency time.Duration, congested bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active -= 1
+	defer t.cond.Broadcast()
+
+	if checkLatency > 0 && (t.baseLatency == 0 || checkLatency < t.baseLatency) {
+		t.baseLatency = checkLatency
+	}
+	slow := checkLatency > congestionLatencyFloor && checkLatency > congestionLatencyFactor*t.baseLatency
+	if congested || slow || t.throttled.After(start) {
+		if !start.After(t.decreased) {
+			// the limits have been decreased while the batch was in flight
+			return
+		}
+		t.decreased = time.Now()
+		t.limit /= 2
+		if t.limit < 1 {
+			t.limit = 1
+		}
+		if t.tunesBatches() {
+			if t.batchBytes /= 2; t.batchBytes < minAdaptiveBatchBytes {
+				t.batchBytes = minAdaptiveBatchBytes
+			}
+		}
+		t.logger.Info("The hub is overloaded, decreased concurrency", "workers", int(t.limit), "batch_bytes", t.batchBytes,
+			"failed", congested, "latency", checkLatency)
+		return
+	}
+
+	prev := int(t.limit)
+	if t.decreased.IsZero() {
+		t.limit += 1
+	} else {
+		t.limit += 1 / t.limit
+	}
+	if t.limit > float64(t.maxWorkers) {
+		t.limit = float64(t.maxWorkers)
+	}
+	if t.tunesBatches() {
+		if t.batchBytes += minAdaptiveBatchBytes; t.batchBytes > t.maxBatchBytes {
+			t.batchBytes = t.maxBatchBytes
+		}
+	}
+	if int(t.limit) != prev {
+		t.logger.Debug("Increased concurrency", "workers", int(t.limit), "batch_bytes", t.batchBytes)
+	}
+}
+
+// abandon ends a batch that hasn't been pushed, e.g. as there are no more files or the push has been cancelled,
+// the limits aren't changed
+func (t *concurrencyTuner) abandon() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.active -= 1
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// throttle records that the hub has throttled the push, batches in flight decrease the limits once they end
+func (t *concurrencyTuner) throttle() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.throttled = time.Now()
+	t.mu.Unlock()
+}
+
+// wake wakes up workers waiting for a batch to end, e.g. once the push has been cancelled
+func (t *concurrencyTuner) wake() {
+	t.mu.Lock()
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
@@ -2,12 +2,16 @@ package fiopush
 
 import (
 	"archive/zip"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strings"
 )
 
@@ -16,11 +20,22 @@ type (
 		Server string `json:"server"`
 		ID     string `json:"client_id"`
 		Secret string `json:"client_secret"`
+		// optional, tokens are obtained by it rather than by the client credentials grant, it's replaced
+		// in place if the auth server rotates it. A client without a secret can obtain tokens only by it
+		RefreshToken string `json:"refresh_token,omitempty"`
+	}
+
+	// BasicAuth is a user and a password of a hub fronted by a reverse proxy with basic auth, e.g. a self-hosted one
+	BasicAuth struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
 	}
 
 	OSTreeInfo struct {
-		Auth   OAuth2 `json:"oauth2"`
-		NoAuth bool   `json:"no_auth"`
+		Auth   OAuth2    `json:"oauth2"`
+		HMAC   HMACKey   `json:"hmac"`
+		Basic  BasicAuth `json:"basic_auth"`
+		NoAuth bool      `json:"no_auth"`
 		Server struct {
 			URL string `json:"server"`
 		} `json:"ostree"`
@@ -29,49 +44,110 @@ type (
 	OSTreeHub struct {
 		URL     string
 		Factory string
-		Auth    *OAuth2
+		// nil if a hub doesn't require OAuth
+		Auth *OAuth2
+		// set if requests to a hub are signed with a shared secret instead
+		HMAC *HMACKey
+		// set if a hub requires basic auth instead
+		Basic *BasicAuth
+		// a config with a client certificate if a hub authenticates clients by certificates
+		TLS *tls.Config
 	}
 
 	OAuthToken struct {
 		Token   string `json:"access_token"`
 		Expires uint64 `json:"expires_in"`
+		// set by grants that issue refresh tokens, e.g. the device authorization one
+		RefreshToken string `json:"refresh_token,omitempty"`
 	}
 )
 
 const (
 	treehubFile string = "treehub.json"
+	tufRepoFile string = "tufrepo.url"
+	caFile      string = "ca.crt"
+	// a signature of a local file header a zip archive starts with
+	zipMagic string = "PK\x03\x04"
+)
+
+var (
+	// pairs of certificate and private key files of a client certificate
+	clientCertFiles = [][2]string{
+		{"client.pem", "pkey.pem"},
+		{"client.crt", "client.key"},
+	}
 )
 
 func GetOAuthToken(auth *OAuth2) (string, error) {
+	if auth.RefreshToken != "" {
+		tok, err := refreshOAuthToken(auth, auth.RefreshToken)
+		if err == nil {
+			if tok.RefreshToken != "" {
+				auth.RefreshToken = tok.RefreshToken
+			}
+			return tok.Token, nil
+		}
+		if auth.Secret == "" {
+			return "", err
+		}
+	}
+	tok, err := requestOAuthToken(auth)
+	if err != nil {
+		return "", err
+	}
+	return tok.Token, nil
+}
+
+// refreshOAuthToken obtains a token by means of a refresh token, the response carries a new refresh token
+// if the auth server rotates them
+func refreshOAuthToken(auth *OAuth2, refreshToken string) (*OAuthToken, error) {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	tok, oerr, err := postTokenForm(auth.Server, form, auth.ID, auth.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if oerr != nil {
+		return nil, fmt.Errorf("Failed to refresh oauth2 token: %s %s\n", oerr.Error, oerr.Description)
+	}
+	return tok, nil
+}
+
+func requestOAuthToken(auth *OAuth2) (*OAuthToken, error) {
 	authUrl := auth.Server + "/token?grant_type=client_credentials"
 	form := url.Values{"grant_type": {"client_credentials"}}
 	req, err := http.NewRequest("POST", authUrl, strings.NewReader(form.Encode()))
 	if err != nil {
-		return "", fmt.Errorf("Failed to make a request for an oauth2 token: %s\n", err.Error())
+		return nil, fmt.Errorf("Failed to make a request for an oauth2 token: %s\n", err.Error())
 	}
 	req.SetBasicAuth(auth.ID, auth.Secret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("Failed to make a request for an oauth2 token: %s\n", err.Error())
+		return nil, fmt.Errorf("Failed to make a request for an oauth2 token: %s\n", err.Error())
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Failed to get oauth2 token: %s\n", resp.Status)
+		return nil, fmt.Errorf("Failed to get oauth2 token: %s\n", resp.Status)
 	}
 	rd, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("Failed to get oauth2 token: %s\n", err.Error())
+		return nil, fmt.Errorf("Failed to get oauth2 token: %s\n", err.Error())
 	}
 	var tok OAuthToken
 	err = json.Unmarshal(rd, &tok)
 	if err != nil {
-		return "", fmt.Errorf("Failed to unmarshal oauth2 token: %s\n", err.Error())
+		return nil, fmt.Errorf("Failed to unmarshal oauth2 token: %s\n", err.Error())
 	}
-	return tok.Token, nil
+	return &tok, nil
 }
 
+// ExtractUrlAndFactory returns a hub specified by credentials, either a credential archive, a bare treehub.json file
+// or treehub.json content itself, e.g. passed via FIOPUSH_CREDS environment variable
 func ExtractUrlAndFactory(credZip string) (*OSTreeHub, error) {
-	info, err := ParseCredArchive(credZip)
+	files, err := readCredFiles(credZip)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseTreehubInfo(files, credZip)
 	if err != nil {
 		return nil, err
 	}
@@ -80,13 +156,88 @@ func ExtractUrlAndFactory(credZip string) (*OSTreeHub, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse the server  URL: %s\n", err.Error())
 	}
-	// e.g. /ota/treehub/msul-dev01/api/v3/
-	pathElements := strings.Split(url.Path, "/")
-	factory := pathElements[3]
-	return &OSTreeHub{URL: url.Scheme + "://" + url.Host, Factory: factory, Auth: &info.Auth}, err
+	if url.Scheme == "" || url.Host == "" {
+		return nil, fmt.Errorf("Invalid server URL in %s: %s\n", treehubFile, info.Server.URL)
+	}
+	factory, err := deriveFactory(url.Path, files)
+	if err != nil {
+		return nil, err
+	}
+	hub := &OSTreeHub{URL: url.Scheme + "://" + url.Host, Factory: factory}
+
+	if hub.TLS, err = clientTLSConfig(files); err != nil {
+		return nil, err
+	}
+	switch {
+	case info.NoAuth:
+	case info.HMAC.Secret != "":
+		if info.HMAC.ID == "" {
+			return nil, fmt.Errorf("The credential archive specifies an HMAC secret without a key ID: %s\n", credsName(credZip))
+		}
+		hub.HMAC = &info.HMAC
+	case info.Basic.User != "":
+		hub.Basic = &info.Basic
+	case info.Auth.Server != "":
+		hub.Auth = &info.Auth
+	case hub.TLS == nil:
+		return nil, fmt.Errorf("The credential archive specifies neither oauth2 credentials, HMAC key, basic auth nor client certificates: %s\n", credsName(credZip))
+	}
+	return hub, nil
+}
+
+// setAuthHeader sets Authorization header of a request to a hub, either basic auth if the hub requires it
+// or a given OAuth token
+func setAuthHeader(h http.Header, hub *OSTreeHub, token string) {
+	if hub.Basic != nil {
+		r := http.Request{Header: h}
+		r.SetBasicAuth(hub.Basic.User, hub.Basic.Password)
+		return
+	}
+	h.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 }
 
 func ParseCredArchive(credZip string) (*OSTreeInfo, error) {
+	files, err := readCredFiles(credZip)
+	if err != nil {
+		return nil, err
+	}
+	return parseTreehubInfo(files, credZip)
+}
+
+// readCredFiles returns files of a credential archive, a bare treehub.json file or inline treehub.json content
+// is returned as the only file of an archive
+func readCredFiles(creds string) (map[string][]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(creds), "{") {
+		return map[string][]byte{treehubFile: []byte(creds)}, nil
+	}
+	f, err := os.Open(creds)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open the credential archive: %s, err: %s\n", creds, err.Error())
+	}
+	magic := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err == nil && string(magic) == zipMagic {
+		return readCredArchive(creds)
+	}
+	data, err := ioutil.ReadFile(creds)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the credentials file: %s, err: %s\n", creds, err.Error())
+	}
+	return map[string][]byte{treehubFile: data}, nil
+}
+
+// credsName returns a name of credentials to refer to them by in messages, inline ones may carry secrets
+func credsName(creds string) string {
+	if strings.HasPrefix(strings.TrimSpace(creds), "{") {
+		return "inline credentials"
+	}
+	return creds
+}
+
+// readCredArchive returns files of a credential archive keyed by their base names,
+// so archives with files nested in a directory are handled as well
+func readCredArchive(credZip string) (map[string][]byte, error) {
 	f, err := os.Open(credZip)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to open the credential archive: %s, err: %s\n", credZip, err.Error())
@@ -103,30 +254,92 @@ func ParseCredArchive(credZip string) (*OSTreeInfo, error) {
 		return nil, fmt.Errorf("Failed to create a zip reader: %s\n", err.Error())
 	}
 
-	var infoFile *zip.File = nil
+	files := make(map[string][]byte)
 	for _, zipFile := range r.File {
-		if zipFile.Name == treehubFile {
-			infoFile = zipFile
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+		fi, err := zipFile.Open()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open %s file located in the credential archive: %s\n", zipFile.Name, err.Error())
+		}
+		data, err := ioutil.ReadAll(fi)
+		fi.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read data from %s file located in the credential archive: %s\n", zipFile.Name, err.Error())
 		}
+		files[path.Base(zipFile.Name)] = data
 	}
-	if infoFile == nil {
+	return files, nil
+}
+
+func parseTreehubInfo(files map[string][]byte, credZip string) (*OSTreeInfo, error) {
+	data, ok := files[treehubFile]
+	if !ok {
 		return nil, fmt.Errorf("Failed to find %s file in the archive: %s\n", treehubFile, credZip)
 	}
-
-	fi, err := infoFile.Open()
+	var serverInfo OSTreeInfo
+	err := json.Unmarshal(data, &serverInfo)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to open %s file located in the credential archive: %s\n", treehubFile, err.Error())
+		return nil, fmt.Errorf("Failed to parse OSTree info json: %s\n", err.Error())
 	}
-	defer fi.Close()
+	return &serverInfo, nil
+}
 
-	data, err := ioutil.ReadAll(fi)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read data from %s file located in the credential archive: %s\n", treehubFile, err.Error())
+// deriveFactory returns a factory name specified in the treehub server URL path, e.g. /ota/treehub/<factory>/api/v3/,
+// or in the TUF repo URL path, e.g. /ota/repo/<factory>/api/v1/user_repo/, if the former doesn't specify it
+func deriveFactory(treehubPath string, files map[string][]byte) (string, error) {
+	if factory := pathElementAfter(treehubPath, "treehub"); factory != "" {
+		return factory, nil
 	}
-	var serverInfo OSTreeInfo
-	err = json.Unmarshal(data, &serverInfo)
+	tufRepo, ok := files[tufRepoFile]
+	if !ok {
+		return "", fmt.Errorf("Failed to derive a factory name from the server URL path %s, "+
+			"and the credential archive doesn't contain %s\n", treehubPath, tufRepoFile)
+	}
+	u, err := url.Parse(strings.TrimSpace(string(tufRepo)))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to parse OSTree info json: %s\n", err.Error())
+		return "", fmt.Errorf("Failed to parse the TUF repo URL: %s\n", err.Error())
 	}
-	return &serverInfo, nil
+	if factory := pathElementAfter(u.Path, "repo"); factory != "" {
+		return factory, nil
+	}
+	return "", fmt.Errorf("Failed to derive a factory name from neither the server URL path %s nor the TUF repo URL path %s\n",
+		treehubPath, u.Path)
+}
+
+// pathElementAfter returns a URL path element following a given one, e.g. /ota/treehub/<factory>/ -> <factory>
+func pathElementAfter(urlPath string, element string) string {
+	elements := strings.Split(strings.Trim(urlPath, "/"), "/")
+	for ii := 0; ii < len(elements)-1; ii++ {
+		if elements[ii] == element {
+			return elements[ii+1]
+		}
+	}
+	return ""
+}
+
+// clientTLSConfig returns a TLS config with a client certificate if the credential archive contains one,
+// either as client.pem/pkey.pem or as client.crt/client.key, along with an optional CA certificate
+func clientTLSConfig(files map[string][]byte) (*tls.Config, error) {
+	for _, pair := range clientCertFiles {
+		certPEM, hasCert := files[pair[0]]
+		keyPEM, hasKey := files[pair[1]]
+		if !hasCert || !hasKey {
+			continue
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load the client certificate %s: %s\n", pair[0], err.Error())
+		}
+		cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+		if caPEM, ok := files[caFile]; ok {
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("Failed to load the CA certificate %s\n", caFile)
+			}
+		}
+		return cfg, nil
+	}
+	return nil, nil
 }
@@ -0,0 +1,134 @@
+package fiopush
+
+import (
+	"bytes"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultOstreeBin = "ostree"
+)
+
+// generateStaticDeltas generates static deltas from commits of remote refs to commits of local refs pointing
+// elsewhere, so devices upgrade by fetching a few delta parts instead of each object. It returns path prefixes
+// of delta directories to push along with refs, e.g. ./deltas/ab/cdef-ghij/. A delta isn't generated if the
+// previous commit of a ref isn't present in the local repo or the ref is new to the hub.
+func (p *pusher) generateStaticDeltas() ([]string, error) {
+	remote, err := p.RemoteRefs()
+	if err != nil {
+		return nil, err
+	}
+	r := &ostree.Repo{Dir: p.repo}
+	local, err := r.Refs()
+	if err != nil {
+		return nil, err
+	}
+	filter := p.repoFilter()
+	refs := make([]string, 0, len(local))
+	for ref := range local {
+		// refs that aren't pushed, e.g. of local remotes, don't need deltas
+		if filter.match("./refs/" + ref) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+
+	var dirs []string
+	done := map[string]bool{}
+	for _, ref := range refs {
+		from, to := remote[ref], local[ref]
+		if from == "" || from == to {
+			continue
+		}
+		if !r.HasObject(from, ostree.ObjectCommit) {
+			p.logger.Warn("The previous commit of a ref is absent in the repo, no static delta is generated",
+				"ref", ref, "commit", from)
+			continue
+		}
+		dir, err := ostree.DeltaDir(from, to)
+		if err != nil {
+			return nil, err
+		}
+		if done[dir] {
+			continue
+		}
+		done[dir] = true
+		if _, err := os.Stat(filepath.Join(p.repo, filepath.FromSlash(dir), ostree.DeltaSuperblock)); err == nil {
+			p.logger.Debug("Static delta exists already", "ref", ref, "from", from, "to", to)
+		} else if err := p.generateStaticDelta(from, to); err != nil {
+			return nil, err
+		} else {
+			p.logger.Info("Generated a static delta", "ref", ref, "from", from, "to", to)
+		}
+		dirs = append(dirs, "./"+dir+"/")
+	}
+	return dirs, nil
+}
+
+// generateStaticDelta runs `ostree static-delta generate` between two commits of the repo
+func (p *pusher) generateStaticDelta(from string, to string) error {
+	cmd := exec.CommandContext(p.parent, p.ostreeBin, "static-delta", "generate", "--repo="+p.repo, "--from="+from, "--to="+to)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := err.Error()
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			msg += ", " + out
+		}
+		return fmt.Errorf("failed to generate a static delta from %s to %s: %s", from, to, msg)
+	}
+	return nil
+}
+
+// deltaFiles returns paths of files of given delta directories relative to the repo root
+func (p *pusher) deltaFiles(dirs []string) ([]string, error) {
+	var paths []string
+	for _, dir := range dirs {
+		root := filepath.Join(p.repo, filepath.FromSlash(dir))
+		err := filepath.Walk(root, func(fullPath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(root, fullPath)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, dir+filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read a static delta %s: %s", dir, err.Error())
+		}
+	}
+	return paths, nil
+}
+
+// addStaticDeltas generates static deltas and adds their files to files pushed along with refs
+func (p *pusher) addStaticDeltas() error {
+	dirs, err := p.generateStaticDeltas()
+	if err != nil || len(dirs) == 0 {
+		return err
+	}
+	if p.files == nil {
+		// the repo is walked by Run, a new slice so filters shared with other pushers aren't changed
+		p.filters = append(append([]string{}, p.filters...), dirs...)
+		return nil
+	}
+	paths, err := p.deltaFiles(dirs)
+	if err != nil {
+		return err
+	}
+	files, err := crcRepoFiles(p.repo, paths, p.sha256)
+	if err != nil {
+		return err
+	}
+	p.files = append(append([]*oshub.RepoFile{}, p.files...), files...)
+	return nil
+}
@@ -0,0 +1,106 @@
+package fiopush
+
+import (
+	"context"
+	"foundriesio/ostreehub/pkg/ostree"
+	"sort"
+	"strings"
+)
+
+type (
+	// RefDiff is a ref whose commit differs locally and remotely, an empty commit means the ref is absent
+	RefDiff struct {
+		Ref    string `json:"ref"`
+		Local  string `json:"local"`
+		Remote string `json:"remote"`
+	}
+
+	// Diff reports how a local repo differs from the one published by OSTree Hub
+	Diff struct {
+		Refs []RefDiff `json:"refs"`
+		// commits refs point to locally that OSTree Hub doesn't have
+		NewCommits []string `json:"new_commits"`
+		Checked    uint     `json:"checked"`
+		// objects and files (objects, refs and config) that would be transferred by a push
+		Objects uint  `json:"objects"`
+		Files   uint  `json:"files"`
+		Bytes   int64 `json:"bytes"`
+	}
+)
+
+func (p *pusher) Diff() (*Diff, error) {
+	if err := p.auth(); err != nil {
+		return nil, err
+	}
+	remoteRefs, err := p.RemoteRefs()
+	if err != nil {
+		return nil, err
+	}
+	localRefs, err := (&ostree.Repo{Dir: p.repo}).Refs()
+	if err != nil {
+		return nil, err
+	}
+
+	var diff Diff
+	for ref, local := range localRefs {
+		if remote := remoteRefs[ref]; remote != local {
+			diff.Refs = append(diff.Refs, RefDiff{Ref: ref, Local: local, Remote: remote})
+		}
+	}
+	for ref, remote := range remoteRefs {
+		if _, ok := localRefs[ref]; !ok {
+			diff.Refs = append(diff.Refs, RefDiff{Ref: ref, Remote: remote})
+		}
+	}
+	sort.Slice(diff.Refs, func(i, j int) bool { return diff.Refs[i].Ref < diff.Refs[j].Ref })
+
+	if p.toArchive && p.mode == "" {
+		if mode, err := ostree.ReadMode(p.repo); err == nil {
+			p.mode = mode
+		}
+	}
+	toSync := make(map[string]bool)
+	sizes := make(map[string]int64)
+	batch := make(map[string]uint32)
+	check := func() {
+		objs, _, _ := p.checkRepo(context.Background(), batch, p.logger)
+		for o := range objs {
+			toSync[o] = true
+		}
+		diff.Checked += uint(len(batch))
+		batch = make(map[string]uint32)
+	}
+	files := feedRepoFiles(context.Background(), p.files)
+	if p.files == nil {
+		files = walkAndCrcRepo(context.Background(), p.repo, false, p.repoFilter(), p.queueSize)
+	}
+	if p.convertsToArchive() {
+		files = p.archiveRepoFiles(context.Background(), files)
+	}
+	for file := range files {
+		batch[file.Path] = file.CRC32
+		sizes[file.Path] = file.Size
+		if len(batch) > filesToCheckMaxNumb {
+			check()
+		}
+	}
+	if len(batch) > 0 {
+		check()
+	}
+
+	for f := range toSync {
+		diff.Files += 1
+		diff.Bytes += sizes[f]
+		if strings.HasPrefix(f, "./objects/") {
+			diff.Objects += 1
+		}
+	}
+	seen := make(map[string]bool)
+	for _, r := range diff.Refs {
+		if r.Local != "" && !seen[r.Local] && toSync[ostree.ObjectRelPath(r.Local, ostree.ObjectCommit)] {
+			seen[r.Local] = true
+			diff.NewCommits = append(diff.NewCommits, r.Local)
+		}
+	}
+	return &diff, nil
+}
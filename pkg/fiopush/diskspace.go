@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package fiopush
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// freeSpace returns a number of bytes available to an unprivileged user on a volume a given directory is located at
+func freeSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
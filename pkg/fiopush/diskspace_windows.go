@@ -0,0 +1,18 @@
+package fiopush
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns a number of bytes available to a caller on a volume a given directory is located at
+func freeSpace(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,180 @@
+package fiopush
+
+import (
+	"encoding/json"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	DiagnosisStatus string
+
+	// Diagnosis is a result of a single doctor check along with a hint how to fix a found issue
+	Diagnosis struct {
+		Check  string
+		Status DiagnosisStatus
+		Detail string
+		Fix    string
+	}
+
+	DoctorConfig struct {
+		Repo      string
+		CredFile  string
+		ServerURL string
+		Factory   string
+	}
+)
+
+const (
+	DiagnosisOK   DiagnosisStatus = "OK"
+	DiagnosisWarn DiagnosisStatus = "WARN"
+	DiagnosisFail DiagnosisStatus = "FAIL"
+
+	// a clock skew that can break token validation
+	maxClockSkew = 30 * time.Second
+	// a free space on a repo volume to warn about
+	minFreeSpace uint64 = 1 << 30
+)
+
+// Doctor diagnoses common misconfigurations of a repo, credentials and network access to OSTree Hub
+func Doctor(cfg DoctorConfig) []Diagnosis {
+	var res []Diagnosis
+	add := func(check string, status DiagnosisStatus, detail string, fix string) {
+		res = append(res, Diagnosis{Check: check, Status: status, Detail: detail, Fix: fix})
+	}
+
+	if err := checkRepoDir(cfg.Repo); err != nil {
+		add("repo", DiagnosisFail, strings.TrimSpace(err.Error()), "Specify a path to an ostree repo with -repo")
+	} else if mode, err := ostree.ReadMode(cfg.Repo); err != nil {
+		add("repo", DiagnosisWarn, err.Error(), "Check the [core] section of the repo config")
+	} else {
+		add("repo", DiagnosisOK, fmt.Sprintf("%s, mode: %s", cfg.Repo, mode), "")
+	}
+
+	if free, err := freeSpace(cfg.Repo); err != nil {
+		add("disk space", DiagnosisWarn, err.Error(), "")
+	} else if free < minFreeSpace {
+		add("disk space", DiagnosisWarn, fmt.Sprintf("%d bytes available on the repo volume", free),
+			"Free up space, the repo may be incomplete if a build ran out of space")
+	} else {
+		add("disk space", DiagnosisOK, fmt.Sprintf("%d bytes available on the repo volume", free), "")
+	}
+
+	hub := &OSTreeHub{URL: cfg.ServerURL, Factory: cfg.Factory}
+	if cfg.CredFile != "" {
+		var err error
+		if hub, err = ExtractUrlAndFactory(cfg.CredFile); err != nil {
+			add("credentials", DiagnosisFail, strings.TrimSpace(err.Error()),
+				"Specify a valid credential archive of the factory")
+			return res
+		}
+		add("credentials", DiagnosisOK, fmt.Sprintf("factory: %s, hub: %s", hub.Factory, hub.URL), "")
+	} else if hub.Factory == "" || hub.URL == "" {
+		add("credentials", DiagnosisFail, "neither credentials nor a server URL and factory are specified",
+			"Specify -creds or -server and -factory")
+		return res
+	}
+
+	u, err := repoUrl(hub)
+	if err != nil {
+		add("hub", DiagnosisFail, err.Error(), "Check the hub URL")
+		return res
+	}
+
+	if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: u}); err != nil {
+		add("proxy", DiagnosisFail, err.Error(), "Fix HTTPS_PROXY/HTTP_PROXY environment variables")
+	} else if proxy != nil {
+		add("proxy", DiagnosisOK, fmt.Sprintf("requests to %s go through %s", u.Host, proxy.Host),
+			"Set NO_PROXY if the hub must be accessed directly")
+	} else {
+		add("proxy", DiagnosisOK, "no proxy is used", "")
+	}
+
+	token := ""
+	if hub.Auth != nil {
+		if token, err = GetOAuthToken(hub.Auth); err != nil {
+			add("token", DiagnosisFail, strings.TrimSpace(err.Error()),
+				"Check that the credentials haven't expired or been revoked")
+			return res
+		}
+		add("token", DiagnosisOK, "obtained at "+hub.Auth.Server, "")
+	}
+
+	resp, err := checkNoObjects(hub, u, token)
+	if err != nil {
+		add("hub", DiagnosisFail, err.Error(), "Check network connectivity and firewall rules to "+u.Host)
+		return res
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		add("hub", DiagnosisFail, resp.Status, "Make sure the credentials have the push scope for the factory")
+		return res
+	case resp.StatusCode != http.StatusOK:
+		add("hub", DiagnosisFail, resp.Status, "Check the hub URL and the factory name")
+		return res
+	}
+	caps := resp.Header.Get(oshub.CapabilitiesHeader)
+	if caps == "" {
+		caps = "none"
+	}
+	add("hub", DiagnosisOK, fmt.Sprintf("%s is reachable, optional capabilities: %s", u.Host, caps), "")
+
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err != nil {
+		add("clock", DiagnosisWarn, "the hub doesn't report its time", "")
+	} else if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		add("clock", DiagnosisWarn, fmt.Sprintf("local clock differs from the hub clock by %s", skew.Round(time.Second)),
+			"Synchronize the local clock, e.g. enable NTP")
+	} else {
+		add("clock", DiagnosisOK, "local clock is in sync with the hub", "")
+	}
+
+	req, err := http.NewRequest("GET", subUrl(u, "gcs").String(), nil)
+	if err != nil {
+		add("gcs", DiagnosisFail, err.Error(), "")
+		return res
+	}
+	setAuthHeader(req.Header, hub, token)
+	resp, err = hubClient(hub).Do(req)
+	if err != nil {
+		add("gcs", DiagnosisFail, err.Error(), "")
+		return res
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var bucket oshub.BucketStatus
+	_ = json.Unmarshal(body, &bucket)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		add("gcs", DiagnosisWarn, "the hub doesn't support GCS checks", "")
+	case resp.StatusCode != http.StatusOK:
+		add("gcs", DiagnosisFail, fmt.Sprintf("%s: %s", resp.Status, bucket.Err),
+			"The hub cannot access its GCS bucket, contact the hub operators")
+	default:
+		add("gcs", DiagnosisOK, fmt.Sprintf("the hub can access the bucket %s", bucket.Bucket), "")
+	}
+	return res
+}
+
+// checkNoObjects makes an authenticated no-op request to the hub, a check of an empty list of objects,
+// it requests the SHA-256 capability along with given ones
+func checkNoObjects(hub *OSTreeHub, u *url.URL, token string, caps ...string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u.String(), strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthHeader(req.Header, hub, token)
+	req.Header.Set(oshub.CapabilitiesHeader, oshub.FormatCapabilities(append([]string{oshub.CapabilitySHA256}, caps...)...))
+	resp, err := hubClient(hub).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,41 @@
+package fiopush
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"time"
+)
+
+type (
+	EventType string
+
+	// Event is an update of a push batch, events of a batch are delivered in order: CheckedBatch, then SentBatch
+	// and SyncedBatch if the batch had files the hub lacked, or Error if the batch couldn't be pushed
+	Event struct {
+		Type  EventType
+		Time  time.Time
+		Batch uint32
+		// set for EventCheckedBatch, a number of files checked and a number of them the hub lacked
+		Checked uint
+		ToSync  uint
+		// set for EventCheckedBatch, objects the hub lacked whose checksum doesn't match their name mapped to reasons,
+		// they haven't been sent, see WithObjectVerification
+		Corrupted map[string]string
+		// set for EventSentBatch
+		Sent *oshub.SendReport
+		// set for EventSyncedBatch
+		Synced *oshub.SyncReport
+		// set for EventError
+		Err error
+	}
+)
+
+const (
+	EventCheckedBatch EventType = "checked"
+	EventSentBatch    EventType = "sent"
+	EventSyncedBatch  EventType = "synced"
+	EventError        EventType = "error"
+)
+
+func newEvent(t EventType, batch uint32) Event {
+	return Event{Type: t, Time: time.Now(), Batch: batch}
+}
@@ -0,0 +1,61 @@
+package fiopush
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io"
+	"sort"
+	"time"
+)
+
+// ExportBundle writes a bundle of a repo, i.e. a TAR stream of all repo files Pusher would push made the same way
+// as streams Pusher sends, CRC32C and optionally SHA-256 PAX records included, prefixed by a manifest listing
+// the files and refs. The bundle can be transferred offline and imported by the hub later.
+func ExportBundle(repoDir string, w io.Writer, withSHA256 bool) (*oshub.BundleManifest, error) {
+	repoFiles, err := ScanRepo(repoDir, withSHA256)
+	if err != nil {
+		return nil, err
+	}
+	r := &ostree.Repo{Dir: repoDir}
+	refs, err := r.Refs()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &oshub.BundleManifest{Version: oshub.BundleVersion, Created: time.Now().UTC(), Refs: refs}
+	// the mode is optional as it is for pushes
+	if mode, err := ostree.ReadMode(repoDir); err == nil {
+		manifest.Mode = mode
+	}
+
+	files := make(map[string]uint32, len(repoFiles))
+	digests := make(map[string]string)
+	for _, f := range repoFiles {
+		files[f.Path] = f.CRC32
+		if f.SHA256 != "" {
+			digests[f.Path] = f.SHA256
+		}
+		manifest.Files = append(manifest.Files, oshub.BundleFile{Path: f.Path, CRC32: f.CRC32, Size: f.Size, SHA256: f.SHA256})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+	opts := []oshub.TarOption{oshub.WithBundleManifest(manifest)}
+	if manifest.Mode != "" {
+		opts = append(opts, oshub.WithRepoMode(manifest.Mode))
+	}
+	if len(digests) > 0 {
+		opts = append(opts, oshub.WithSHA256(digests))
+	}
+
+	tarReader, reportChannel := oshub.Tar(repoDir, files, opts...)
+	_, copyErr := io.Copy(w, tarReader)
+	// unblocks Tar if the copy has failed
+	tarReader.Close()
+	report := <-reportChannel
+	if report.Err != "" {
+		return nil, fmt.Errorf("failed to make the bundle: %s", report.Err)
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("failed to write the bundle: %s", copyErr.Error())
+	}
+	return manifest, nil
+}
@@ -0,0 +1,181 @@
+package fiopush
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/ostree"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// PathFilter selects repo files to push by glob patterns of their paths relative to the repo root,
+	// e.g. refs/remotes/** or delta-indexes/. Segments of a path are matched by path.Match, ** matches
+	// any number of segments, and a pattern ending with a slash matches everything under a directory.
+	PathFilter struct {
+		// files pushed in addition to objects, refs and config
+		Include []string
+		// files never pushed even if they are included, e.g. refs/heads/tmp-*
+		Exclude []string
+	}
+
+	// repoFilter decides which repo files are pushed, ones matching path prefixes or include patterns
+	// unless they match skipped path prefixes or exclude patterns. Refs of skipped namespaces are pushed
+	// only if include patterns match them.
+	repoFilter struct {
+		prefixes []string
+		skip     []string
+		paths    PathFilter
+	}
+)
+
+var (
+	// refs of local remotes and mirrors, they are pushed only if PathFilter includes them, e.g. refs/remotes/
+	skippedRefNamespaces = []string{ostree.RefNamespaceRemotes, ostree.RefNamespaceMirrors}
+)
+
+// DefaultSkippedRefNamespaces returns namespaces of refs that are not pushed unless PathFilter includes them,
+// i.e. remotes and mirrors whose refs track other repos rather than branches of the factory
+func DefaultSkippedRefNamespaces() []string {
+	return append([]string{}, skippedRefNamespaces...)
+}
+
+// Validate makes sure all patterns of the filter are well-formed
+func (f PathFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		for _, seg := range strings.Split(filepath.ToSlash(pattern), "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("invalid path pattern %q: %s", pattern, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// merge returns a filter including and excluding files of both filters, patterns of the other filter
+// given with OS path separators, e.g. refs\remotes\ on Windows, are turned into slash-separated ones
+func (f PathFilter) merge(other PathFilter) PathFilter {
+	return PathFilter{
+		Include: append(append([]string{}, f.Include...), toSlash(other.Include)...),
+		Exclude: append(append([]string{}, f.Exclude...), toSlash(other.Exclude)...),
+	}
+}
+
+// toSlash turns paths given with OS path separators into slash-separated repo paths, e.g. .\tmp\ to ./tmp/,
+// so paths given on Windows match repo paths which are always slash-separated
+func toSlash(paths []string) []string {
+	if paths == nil {
+		return nil
+	}
+	res := make([]string, len(paths))
+	for ii, p := range paths {
+		res[ii] = filepath.ToSlash(p)
+	}
+	return res
+}
+
+func mergePathFilters(filters []PathFilter) PathFilter {
+	var merged PathFilter
+	for _, f := range filters {
+		merged = merged.merge(f)
+	}
+	return merged
+}
+
+// defaultRepoFilter selects objects, refs and config, and skips ostree transaction and staging files
+func defaultRepoFilter(paths PathFilter) *repoFilter {
+	return &repoFilter{prefixes: repoFileFilterIn, skip: repoFileSkip, paths: paths}
+}
+
+func (p *pusher) repoFilter() *repoFilter {
+	return &repoFilter{prefixes: p.filters, skip: p.skip, paths: p.paths}
+}
+
+// match tells whether a repo file at a given path, e.g. ./refs/heads/main, is pushed
+func (f *repoFilter) match(path string) bool {
+	if isSkipped(path, f.skip) || matchAny(f.paths.Exclude, path) {
+		return false
+	}
+	if matchAny(f.paths.Include, path) {
+		return true
+	}
+	if isSkippedRef(path) {
+		return false
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSkippedRef tells whether a repo path is a ref of a namespace skipped by default, e.g. ./refs/remotes/origin/main
+func isSkippedRef(path string) bool {
+	if !strings.HasPrefix(path, "./refs/") {
+		return false
+	}
+	ns := ostree.RefNamespace(strings.TrimPrefix(path, "./refs/"))
+	for _, s := range skippedRefNamespaces {
+		if ns == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches a repo path against a glob pattern of PathFilter, both can be prefixed by ./
+func matchPattern(pattern string, p string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(strings.TrimPrefix(p, "./"), "/"))
+}
+
+func matchSegments(pattern []string, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for ii := 0; ii <= len(segs); ii++ {
+				if matchSegments(pattern[1:], segs[ii:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// logSkippedRefs tells how many refs of the repo aren't pushed because of their namespace, e.g. refs of local remotes
+func (p *pusher) logSkippedRefs() {
+	refs, err := (&ostree.Repo{Dir: p.repo}).Refs()
+	if err != nil {
+		return
+	}
+	skipped := 0
+	for ref := range refs {
+		if path := "./refs/" + ref; isSkippedRef(path) && !matchAny(p.paths.Include, path) {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		p.logger.Info("Refs of local remotes and mirrors are not pushed unless they are included", "refs", skipped)
+	}
+}
@@ -0,0 +1,24 @@
+package fiopush
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"net/http"
+)
+
+// checkForceUpload makes sure the hub announces CapabilityForce, otherwise it would skip objects it has
+// no matter what Pusher sends
+func (p *pusher) checkForceUpload() error {
+	resp, err := checkNoObjects(p.hub, p.url, p.accessToken(), oshub.CapabilityForce)
+	if err != nil {
+		return fmt.Errorf("failed to check whether the hub supports forced uploads: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to check whether the hub supports forced uploads: HTTP %d", resp.StatusCode)
+	}
+	if !oshub.ParseCapabilities(resp.Header.Get(oshub.CapabilitiesHeader))[oshub.CapabilityForce] {
+		return fmt.Errorf("the hub doesn't support forced uploads, it would skip objects it has already")
+	}
+	p.logger.Info("Forcing upload of all repo files")
+	return nil
+}
@@ -0,0 +1,57 @@
+package fiopush
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"io/ioutil"
+	"net/http"
+)
+
+type (
+	// HMACKey is a shared secret requests to a hub are signed with, it's specified in treehub.json of
+	// a credential archive as {"hmac": {"key_id": "...", "secret": "..."}} for hubs that don't run an OAuth server
+	HMACKey struct {
+		ID     string `json:"key_id"`
+		Secret string `json:"secret"`
+	}
+
+	// signingTransport signs requests by HMACKey, bodies that can be read again, e.g. JSON of check requests,
+	// are signed along with the request, streamed bodies like TAR streams are sent unsigned
+	signingTransport struct {
+		next http.RoundTripper
+		key  *HMACKey
+	}
+)
+
+// signedClient returns a copy of a given client signing its requests
+func signedClient(c *http.Client, key *HMACKey) *http.Client {
+	signed := *c
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	signed.Transport = &signingTransport{next: next, key: key}
+	return &signed
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	digest := oshub.UnsignedPayload
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+		digest = oshub.BodyDigest(nil)
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		digest = oshub.BodyDigest(data)
+	}
+	// a round tripper must not modify a request it's given
+	signed := req.Clone(req.Context())
+	oshub.SignRequest(signed, t.key.ID, []byte(t.key.Secret), digest)
+	return t.next.RoundTrip(signed)
+}
@@ -0,0 +1,100 @@
+package fiopush
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// tracingTransport logs metadata of requests made to the hub and of their responses along with
+	// connection events, e.g. DNS lookups and TLS handshakes, at the debug level
+	tracingTransport struct {
+		next   http.RoundTripper
+		logger Logger
+	}
+)
+
+var (
+	// headers whose values are never logged
+	secretHeaders = map[string]bool{
+		"Authorization": true,
+		"Cookie":        true,
+		"Set-Cookie":    true,
+	}
+)
+
+// WithHTTPTrace makes Pusher log metadata of each HTTP request to the hub and its response at the debug level,
+// headers carrying credentials are redacted
+func WithHTTPTrace() Option {
+	return func(p *pusher) {
+		p.httpTrace = true
+	}
+}
+
+// tracedClient returns a copy of a given client tracing its requests
+func tracedClient(c *http.Client, logger Logger) *http.Client {
+	traced := *c
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	traced.Transport = &tracingTransport{next: next, logger: logger}
+	return &traced
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	logger := t.logger.With("method", req.Method, "url", req.URL.Redacted())
+	since := func() string { return time.Since(start).String() }
+	trace := &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			logger.Debug("DNS lookup done", "addrs", info.Addrs, "err", info.Err, "elapsed", since())
+		},
+		ConnectDone: func(network, addr string, err error) {
+			logger.Debug("Connected", "addr", addr, "err", err, "elapsed", since())
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			logger.Debug("TLS handshake done", "version", state.Version, "proto", state.NegotiatedProtocol, "err", err, "elapsed", since())
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			logger.Debug("Got a connection", "reused", info.Reused, "idle", info.IdleTime.String())
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			logger.Debug("Wrote the request", "err", info.Err, "elapsed", since())
+		},
+		GotFirstResponseByte: func() {
+			logger.Debug("Got the first response byte", "elapsed", since())
+		},
+	}
+	logger.Debug("HTTP request", "proto", req.Proto, "content_length", req.ContentLength, "headers", formatHeaders(req.Header))
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		logger.Debug("HTTP request failed", "err", err, "elapsed", since())
+		return resp, err
+	}
+	logger.Debug("HTTP response", "status", resp.StatusCode, "proto", resp.Proto,
+		"content_length", resp.ContentLength, "headers", formatHeaders(resp.Header), "elapsed", since())
+	return resp, nil
+}
+
+// formatHeaders returns headers sorted by name with values of secret ones redacted
+func formatHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ",")
+		if secretHeaders[name] {
+			value = "<redacted>"
+		}
+		pairs = append(pairs, name+": "+value)
+	}
+	return "{" + strings.Join(pairs, "; ") + "}"
+}
@@ -0,0 +1,77 @@
+package fiopush
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type (
+	// keyringCreds are secrets of a credential archive stored in the OS keyring, client certificates
+	// aren't as the keyring of some systems limits the size of a secret
+	keyringCreds struct {
+		URL     string     `json:"url"`
+		Factory string     `json:"factory"`
+		Auth    *OAuth2    `json:"oauth2,omitempty"`
+		HMAC    *HMACKey   `json:"hmac,omitempty"`
+		Basic   *BasicAuth `json:"basic_auth,omitempty"`
+	}
+)
+
+const (
+	// secrets of fiopush are stored in the OS keyring under this service name
+	keyringService = "fiopush"
+
+	keyringCredsPrefix = "creds:"
+	keyringTokenPrefix = "token:"
+	keyringLogin       = "login"
+)
+
+var (
+	// ErrKeyringNotFound is returned if the OS keyring doesn't hold a requested secret
+	ErrKeyringNotFound = errors.New("no such secret in the OS keyring")
+)
+
+// StoreCredsInKeyring stores secrets of a credential archive in the OS keyring, i.e. macOS Keychain,
+// Secret Service or Windows Credential Manager, so the archive doesn't need to be kept in plaintext
+// on a developer workstation. It returns the hub the archive specifies.
+func StoreCredsInKeyring(credZip string) (*OSTreeHub, error) {
+	hub, err := ExtractUrlAndFactory(credZip)
+	if err != nil {
+		return nil, err
+	}
+	if hub.TLS != nil {
+		return nil, fmt.Errorf("The credential archive contains a client certificate which can't be stored in the OS keyring: %s\n", credZip)
+	}
+	data, err := json.Marshal(&keyringCreds{URL: hub.URL, Factory: hub.Factory, Auth: hub.Auth, HMAC: hub.HMAC, Basic: hub.Basic})
+	if err != nil {
+		return nil, err
+	}
+	label := fmt.Sprintf("fiopush credentials of %s factory", hub.Factory)
+	if err := keyringSet(keyringCredsPrefix+hub.Factory, label, string(data)); err != nil {
+		return nil, fmt.Errorf("Failed to store the credentials in the OS keyring: %s\n", err.Error())
+	}
+	return hub, nil
+}
+
+// LoadCredsFromKeyring returns a hub of a given factory whose credentials have been stored in the OS keyring,
+// the returned error wraps ErrKeyringNotFound if they haven't
+func LoadCredsFromKeyring(factory string) (*OSTreeHub, error) {
+	data, err := keyringGet(keyringCredsPrefix + factory)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read credentials of %s factory from the OS keyring: %w", factory, err)
+	}
+	var creds keyringCreds
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal credentials of %s factory stored in the OS keyring: %s\n", factory, err.Error())
+	}
+	return &OSTreeHub{URL: creds.URL, Factory: creds.Factory, Auth: creds.Auth, HMAC: creds.HMAC, Basic: creds.Basic}, nil
+}
+
+// RemoveCredsFromKeyring removes credentials of a given factory from the OS keyring, it's no-op if there are none
+func RemoveCredsFromKeyring(factory string) error {
+	if err := keyringDelete(keyringCredsPrefix + factory); err != nil && !errors.Is(err, ErrKeyringNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package fiopush
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// an exit status of security(1) if there is no such item in the keychain
+	securityItemNotFound = 44
+)
+
+func keyringGet(account string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", securityError(err, &stderr)
+	}
+	// secrets are JSON documents or tokens, so they are printable and security(1) prints them as is
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+func keyringSet(account string, label string, secret string) error {
+	var stderr bytes.Buffer
+	// the secret is passed via stdin of the interactive mode so it doesn't show up in the process list,
+	// and hex encoded so it needs no quoting
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -l %s -X %s\n",
+		quoteSecurityArg(keyringService), quoteSecurityArg(account), quoteSecurityArg(label), hex.EncodeToString([]byte(secret))))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return securityError(err, &stderr)
+	}
+	// the interactive mode exits with 0 even if a command fails
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("security failed: %s", msg)
+	}
+	return nil
+}
+
+func keyringDelete(account string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return securityError(err, &stderr)
+	}
+	return nil
+}
+
+func securityError(err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == securityItemNotFound {
+		return ErrKeyringNotFound
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("security failed: %s", msg)
+	}
+	return fmt.Errorf("security failed: %s", err.Error())
+}
+
+func quoteSecurityArg(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
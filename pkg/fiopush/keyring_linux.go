@@ -0,0 +1,56 @@
+package fiopush
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// the Secret Service is accessed by means of secret-tool of libsecret, so no D-Bus client is linked in
+
+func keyringGet(account string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// secret-tool exits with 1 and prints nothing if there is no such secret
+		if _, ok := err.(*exec.ExitError); ok && stdout.Len() == 0 && stderr.Len() == 0 {
+			return "", ErrKeyringNotFound
+		}
+		return "", secretToolError(err, &stderr)
+	}
+	return stdout.String(), nil
+}
+
+func keyringSet(account string, label string, secret string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label="+label, "service", keyringService, "account", account)
+	// the secret is passed via stdin so it doesn't show up in the process list
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError(err, &stderr)
+	}
+	return nil
+}
+
+func keyringDelete(account string) error {
+	if _, err := keyringGet(account); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "clear", "service", keyringService, "account", account)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return secretToolError(err, &stderr)
+	}
+	return nil
+}
+
+func secretToolError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("secret-tool failed: %s", msg)
+	}
+	return fmt.Errorf("secret-tool failed: %s", err.Error())
+}
@@ -0,0 +1,21 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package fiopush
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func keyringGet(account string) (string, error) {
+	return "", fmt.Errorf("the OS keyring is not supported on %s", runtime.GOOS)
+}
+
+func keyringSet(account string, label string, secret string) error {
+	return fmt.Errorf("the OS keyring is not supported on %s", runtime.GOOS)
+}
+
+func keyringDelete(account string) error {
+	return fmt.Errorf("the OS keyring is not supported on %s", runtime.GOOS)
+}
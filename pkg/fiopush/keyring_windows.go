@@ -0,0 +1,109 @@
+package fiopush
+
+import (
+	"golang.org/x/sys/windows"
+	"unsafe"
+)
+
+type (
+	// CREDENTIALW of wincred.h
+	winCredential struct {
+		Flags              uint32
+		Type               uint32
+		TargetName         *uint16
+		Comment            *uint16
+		LastWritten        windows.Filetime
+		CredentialBlobSize uint32
+		CredentialBlob     *byte
+		Persist            uint32
+		AttributeCount     uint32
+		Attributes         uintptr
+		TargetAlias        *uint16
+		UserName           *uint16
+	}
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+var (
+	advapi32       = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+// secrets are stored as generic credentials of Windows Credential Manager named fiopush:<account>
+func credTarget(account string) (*uint16, error) {
+	return windows.UTF16PtrFromString(keyringService + ":" + account)
+}
+
+func keyringGet(account string) (string, error) {
+	target, err := credTarget(account)
+	if err != nil {
+		return "", err
+	}
+	var cred *winCredential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		return "", credError(err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	// a credential blob is at most 5*512 bytes
+	return string((*[1 << 16]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]), nil
+}
+
+func keyringSet(account string, label string, secret string) error {
+	target, err := credTarget(account)
+	if err != nil {
+		return err
+	}
+	comment, err := windows.UTF16PtrFromString(label)
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		Comment:            comment,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return credError(err)
+	}
+	return nil
+}
+
+func keyringDelete(account string) error {
+	target, err := credTarget(account)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 {
+		return credError(err)
+	}
+	return nil
+}
+
+func credError(err error) error {
+	if err == windows.ERROR_NOT_FOUND {
+		return ErrKeyringNotFound
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package fiopush
+
+import (
+	"encoding/json"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// LockMode specifies what Pusher does if the factory repo is locked by another push session
+	LockMode int
+)
+
+const (
+	// LockFail makes Run fail
+	LockFail LockMode = iota
+	// LockWait makes Run wait until the lock is released or expires
+	LockWait
+	// LockSteal makes Run take over the lock, the other push session is rejected by the hub afterwards
+	LockSteal
+)
+
+// lock takes the factory repo lock for the push session and keeps renewing it until release is called,
+// nothing is done if the hub doesn't support locks
+func (p *pusher) lock() error {
+	steal := p.lockMode == LockSteal
+	for {
+		lock, resp, err := p.requestLock(steal)
+		if err != nil {
+			return err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			p.logger.Debug("Repo lock has been taken", "expires", lock.Expires)
+			p.unlock = make(chan struct{})
+			go p.renewLock(lock, p.unlock)
+			return nil
+		case http.StatusNotFound, http.StatusMethodNotAllowed:
+			p.logger.Debug("OSTree Hub doesn't support repo locks")
+			return nil
+		case http.StatusConflict:
+			if p.lockMode != LockWait {
+				return fmt.Errorf("the factory repo is locked by push session %s until %s, wait for or steal the lock",
+					lock.Holder, lock.Expires.Local().Format(time.RFC1123))
+			}
+			delay := retryAfter(resp)
+			p.logger.Info("Waiting for the repo lock", "holder", lock.Holder, "expires", lock.Expires, "retry_after", delay)
+			select {
+			case <-time.After(delay):
+			case <-p.parent.Done():
+				return p.parent.Err()
+			}
+		default:
+			return fmt.Errorf("failed to lock the factory repo: %s", resp.Status)
+		}
+	}
+}
+
+// renewLock renews the lock once a half of its TTL has elapsed until done is closed
+func (p *pusher) renewLock(lock *oshub.PushLock, done <-chan struct{}) {
+	for {
+		delay := time.Until(lock.Expires) / 2
+		if delay < time.Second {
+			delay = time.Second
+		}
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return
+		}
+		renewed, resp, err := p.requestLock(false)
+		if err != nil {
+			p.logger.Warn("Failed to renew the repo lock", "err", err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			p.logger.Warn("Failed to renew the repo lock", "status", resp.Status, "holder", renewed.Holder)
+			continue
+		}
+		lock = renewed
+	}
+}
+
+// release stops renewal of the lock and releases it
+func (p *pusher) release() {
+	if p.unlock == nil {
+		return
+	}
+	close(p.unlock)
+	p.unlock = nil
+	req, err := http.NewRequest("DELETE", subUrl(p.url, "lock").String(), nil)
+	if err != nil {
+		p.logger.Warn("Failed to release the repo lock", "err", err)
+		return
+	}
+	p.setHeaders(req.Header)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.logger.Warn("Failed to release the repo lock", "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		p.logger.Warn("Failed to release the repo lock", "status", resp.Status)
+	}
+}
+
+// requestLock asks the hub to take or renew the lock, the returned lock is the current one if the repo is locked
+func (p *pusher) requestLock(steal bool) (*oshub.PushLock, *http.Response, error) {
+	u := subUrl(p.url, "lock")
+	if steal {
+		q := u.Query()
+		q.Set("steal", "true")
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(p.parent, "POST", u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.setHeaders(req.Header)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make a request to lock the factory repo: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read a response to lock request: %s", err.Error())
+	}
+	var lock oshub.PushLock
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusConflict {
+		if err := json.Unmarshal(body, &lock); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal a repo lock: %s, %s", err.Error(), strings.TrimSpace(string(body)))
+		}
+	}
+	return &lock, resp, nil
+}
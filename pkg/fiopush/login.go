@@ -0,0 +1,283 @@
+package fiopush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// DeviceCode is a response of the auth server to a device authorization request, see RFC 8628,
+	// a user approves the request by entering UserCode at VerificationURI
+	DeviceCode struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+		ExpiresIn               uint64 `json:"expires_in"`
+		// seconds to wait between token requests
+		Interval uint64 `json:"interval,omitempty"`
+	}
+
+	// Login is a result of the interactive login, it's stored on a developer workstation and used
+	// to obtain OAuth tokens instead of a credential archive
+	Login struct {
+		// an URL of the auth server, e.g. DefaultAuthServer
+		AuthServer   string `json:"auth_server"`
+		ClientID     string `json:"client_id"`
+		RefreshToken string `json:"refresh_token"`
+		// optional, an URL of OSTree Hub and a factory to push to by default
+		Server  string `json:"server,omitempty"`
+		Factory string `json:"factory,omitempty"`
+		// set if the login is stored in the OS keyring rather than in a file
+		inKeyring bool
+	}
+
+	oauthError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+)
+
+const (
+	DefaultAuthServer    = "https://app.foundries.io/oauth"
+	DefaultLoginClientID = "fiopush"
+	// a scope of tokens obtained by the login
+	loginScope = "ostreehub:push"
+
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// RFC 8628 requires clients to poll at most every 5 seconds unless the server says otherwise
+	defaultDevicePollInterval = 5 * time.Second
+	loginFile                 = "login.json"
+)
+
+// RequestDeviceCode starts the device authorization flow at a given auth server
+func RequestDeviceCode(authServer string, clientID string) (*DeviceCode, error) {
+	form := url.Values{"client_id": {clientID}, "scope": {loginScope}}
+	resp, err := http.PostForm(authServer+"/device/code", form)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request a device code: %s\n", err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read a device code: %s\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to request a device code: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var dc DeviceCode
+	if err := json.Unmarshal(body, &dc); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal a device code: %s\n", err.Error())
+	}
+	return &dc, nil
+}
+
+// PollDeviceToken waits until a user approves the device authorization request and returns the obtained token,
+// it fails if the user denies the request, the device code expires or the context is done
+func PollDeviceToken(ctx context.Context, authServer string, clientID string, dc *DeviceCode) (*OAuthToken, error) {
+	interval := defaultDevicePollInterval
+	if dc.Interval > 0 {
+		interval = time.Duration(dc.Interval) * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	form := url.Values{"grant_type": {deviceGrantType}, "device_code": {dc.DeviceCode}, "client_id": {clientID}}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if dc.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("The device code has expired, run the login again\n")
+		}
+		tok, oerr, err := postTokenForm(authServer, form, clientID, "")
+		if err != nil {
+			return nil, err
+		}
+		if oerr == nil {
+			return tok, nil
+		}
+		switch oerr.Error {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, fmt.Errorf("The login has been denied\n")
+		case "expired_token":
+			return nil, fmt.Errorf("The device code has expired, run the login again\n")
+		default:
+			return nil, fmt.Errorf("Failed to get oauth2 token: %s %s\n", oerr.Error, oerr.Description)
+		}
+	}
+}
+
+// Token obtains an OAuth token by means of the refresh token of the login, the login is stored again
+// if the auth server has rotated the refresh token
+func (l *Login) Token() (string, error) {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {l.RefreshToken}}
+	tok, oerr, err := postTokenForm(l.AuthServer, form, l.ClientID, "")
+	if err != nil {
+		return "", err
+	}
+	if oerr != nil {
+		if oerr.Error == "invalid_grant" {
+			return "", fmt.Errorf("The login has expired or been revoked, run fiopush login again\n")
+		}
+		return "", fmt.Errorf("Failed to get oauth2 token: %s %s\n", oerr.Error, oerr.Description)
+	}
+	if tok.RefreshToken != "" && tok.RefreshToken != l.RefreshToken {
+		l.RefreshToken = tok.RefreshToken
+		save := SaveLogin
+		if l.inKeyring {
+			save = SaveKeyringLogin
+		}
+		if err := save(l); err != nil {
+			return "", err
+		}
+	}
+	return tok.Token, nil
+}
+
+// postTokenForm requests a token at the token endpoint, a confidential client authenticates by its secret,
+// a public one without a secret sends its ID in the form. An OAuth error response is returned as oauthError
+func postTokenForm(authServer string, form url.Values, clientID string, secret string) (*OAuthToken, *oauthError, error) {
+	if secret == "" {
+		form.Set("client_id", clientID)
+	}
+	req, err := http.NewRequest("POST", authServer+"/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to make a request for an oauth2 token: %s\n", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if secret != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to make a request for an oauth2 token: %s\n", err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get oauth2 token: %s\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oerr oauthError
+		if err := json.Unmarshal(body, &oerr); err != nil || oerr.Error == "" {
+			return nil, nil, fmt.Errorf("Failed to get oauth2 token: %s\n", resp.Status)
+		}
+		return nil, &oerr, nil
+	}
+	var tok OAuthToken
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, nil, fmt.Errorf("Failed to unmarshal oauth2 token: %s\n", err.Error())
+	}
+	return &tok, nil, nil
+}
+
+// LoadLogin returns the stored login, it returns nil without an error if there is none
+func LoadLogin() (*Login, error) {
+	file, err := loginPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the login: %s\n", err.Error())
+	}
+	var l Login
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal the login %s: %s\n", file, err.Error())
+	}
+	return &l, nil
+}
+
+// SaveLogin stores a login under the user config directory, e.g. ~/.config/fiopush/login.json,
+// readable only by the user as the refresh token grants push access
+func SaveLogin(l *Login) error {
+	file, err := loginPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("Failed to create the login directory: %s\n", err.Error())
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("Failed to store the login: %s\n", err.Error())
+	}
+	return nil
+}
+
+// RemoveLogin removes the stored login, it's no-op if there is none
+func RemoveLogin() error {
+	file, err := loginPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// LoadKeyringLogin returns the login stored in the OS keyring, it returns nil without an error if there is none
+func LoadKeyringLogin() (*Login, error) {
+	data, err := keyringGet(keyringLogin)
+	if errors.Is(err, ErrKeyringNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the login from the OS keyring: %s\n", err.Error())
+	}
+	var l Login
+	if err := json.Unmarshal([]byte(data), &l); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal the login stored in the OS keyring: %s\n", err.Error())
+	}
+	l.inKeyring = true
+	return &l, nil
+}
+
+// SaveKeyringLogin stores a login in the OS keyring instead of a plaintext file
+func SaveKeyringLogin(l *Login) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if err := keyringSet(keyringLogin, "fiopush login at "+l.AuthServer, string(data)); err != nil {
+		return fmt.Errorf("Failed to store the login in the OS keyring: %s\n", err.Error())
+	}
+	l.inKeyring = true
+	return nil
+}
+
+// RemoveKeyringLogin removes the login stored in the OS keyring, it's no-op if there is none
+func RemoveKeyringLogin() error {
+	if err := keyringDelete(keyringLogin); err != nil && !errors.Is(err, ErrKeyringNotFound) {
+		return err
+	}
+	return nil
+}
+
+func loginPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the user config directory: %s\n", err.Error())
+	}
+	return filepath.Join(dir, "fiopush", loginFile), nil
+}
@@ -0,0 +1,53 @@
+package fiopush
+
+const (
+	// a size of read and write buffers of a connection TAR streams are pushed over
+	transportBufferSize = 10 * 1024 * 1024
+	// bounds of buffers and queues shrunk to fit a memory budget, see WithMaxMemory
+	minTransportBufferSize = 64 * 1024
+	minResumableChunkSize  = 256 * 1024
+	minQueueSize           = 100
+	// an estimate of memory taken by a queued repo file, i.e. its path, CRC and digest
+	queuedFileBytes = 512
+	// a number of file queues of a push, i.e. walked paths, hashed files and objects
+	fileQueueNumb = 3
+)
+
+// applyMemoryBudget bounds queues, transport buffers and a number of batches in flight, so memory they take
+// fits the budget set by WithMaxMemory. Queues take up to an eighth of the budget, TAR streams in flight take
+// up to a half of it, each of them takes transport buffers and a resumable chunk, the rest is left to the runtime.
+func (p *pusher) applyMemoryBudget() {
+	budget := p.maxMemory
+	if queueSize := budget / 8 / (fileQueueNumb * queuedFileBytes); queueSize < int64(p.queueSize) {
+		if queueSize < minQueueSize {
+			queueSize = minQueueSize
+		}
+		p.queueSize = uint(queueSize)
+	}
+	if size := budget / 64; size < int64(p.bufferSize) {
+		if size < minTransportBufferSize {
+			size = minTransportBufferSize
+		}
+		p.bufferSize = int(size)
+	}
+	if size := budget / 32; size < int64(p.chunkSize) {
+		if size < minResumableChunkSize {
+			size = minResumableChunkSize
+		}
+		p.chunkSize = int(size)
+	}
+
+	streamBytes := int64(2*p.bufferSize + p.chunkSize)
+	workers := int(budget / 2 / (streamBytes * int64(p.streams)))
+	if workers < 1 {
+		workers = 1
+	}
+	if p.workers > workers {
+		p.workers = workers
+	}
+	if p.maxAdaptiveWorkers > workers {
+		p.maxAdaptiveWorkers = workers
+	}
+	p.logger.Debug("Bounded memory of the push", "max_memory", budget, "queue_size", p.queueSize,
+		"buffer_size", p.bufferSize, "chunk_size", p.chunkSize, "workers", workers)
+}
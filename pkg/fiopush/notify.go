@@ -0,0 +1,50 @@
+package fiopush
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type (
+	// Notification is posted to a webhook once a push completes
+	Notification struct {
+		Factory string `json:"factory"`
+		Hub     string `json:"hub"`
+		Session string `json:"session"`
+		// refs the hub has updated mapped to their commits
+		Refs   map[string]string `json:"refs"`
+		Report *Report           `json:"report"`
+		Failed bool              `json:"failed"`
+	}
+)
+
+const (
+	notifyTimeout = 30 * time.Second
+)
+
+func (p *pusher) sendNotification(report *Report) error {
+	data, err := json.Marshal(&Notification{
+		Factory: p.hub.Factory,
+		Hub:     p.hub.URL,
+		Session: p.session,
+		Refs:    report.Refs,
+		Report:  report,
+		Failed:  report.Synced.SyncFailedNumb > 0 || report.BatchErrors > 0,
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(p.notify, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
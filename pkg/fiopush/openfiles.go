@@ -0,0 +1,58 @@
+package fiopush
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"sync"
+)
+
+const (
+	// file descriptors left to connections to the hub, log files and the runtime
+	reservedFileNumb = 256
+	// the most repo files opened at once, more of them don't speed a push up
+	maxOpenFileNumb = 1024
+	// a limit of open files pushes of large repos are slowed down below
+	lowFileLimit = 1024
+)
+
+var (
+	// bounds repo files opened at once by repo walkers and TAR streams of all pushers of the process,
+	// it's sized by the limit of open files raised once
+	openFiles     *oshub.FileSemaphore
+	fileLimit     uint64
+	fileLimitErr  error
+	fileLimitOnce sync.Once
+	fileLimitWarn sync.Once
+)
+
+// fileSemaphore returns the semaphore of repo files opened at once, the limit of open files of the process is raised
+// up to the hard one the first time. A half of the limit is left to connections and other files if the limit is low.
+func fileSemaphore() *oshub.FileSemaphore {
+	fileLimitOnce.Do(func() {
+		fileLimit, fileLimitErr = raiseFileLimit()
+		n := maxOpenFileNumb
+		if fileLimitErr == nil && fileLimit > 0 && fileLimit < uint64(maxOpenFileNumb+reservedFileNumb) {
+			n = int(fileLimit) - reservedFileNumb
+			if half := int(fileLimit / 2); half > n {
+				n = half
+			}
+		}
+		openFiles = oshub.NewFileSemaphore(n)
+	})
+	return openFiles
+}
+
+// checkFileLimit warns once per process if the limit of open files is unknown or low
+func (p *pusher) checkFileLimit() {
+	fileSemaphore()
+	fileLimitWarn.Do(func() {
+		switch {
+		case fileLimitErr != nil:
+			p.logger.Warn("Failed to determine the limit of open files", "err", fileLimitErr)
+		case fileLimit > 0 && fileLimit < lowFileLimit:
+			p.logger.Warn("The limit of open files is low, fewer repo files are read at once and the push may be slow, "+
+				"raise it by `ulimit -n`", "limit", fileLimit, "recommended", lowFileLimit)
+		default:
+			p.logger.Debug("Determined the limit of open files", "limit", fileLimit)
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package fiopush
+
+import (
+	"context"
+	"foundriesio/ostreehub/pkg/oshub"
+	"net/http"
+	"time"
+)
+
+type (
+	Option func(*pusher)
+
+	// Logger is a leveled structured logger Pusher reports its progress and errors to
+	Logger = oshub.Logger
+
+	// RetryPolicy specifies how Pusher retries what has failed, zero fields keep the defaults
+	RetryPolicy struct {
+		// a number of passes retrying objects that failed to sync once the push has completed, see WithRetries
+		Passes int
+		// a delay before a retry pass, or a retry of a resumable upload chunk, it grows linearly with the attempt number
+		Delay time.Duration
+		// for how long requests throttled by the hub are made again
+		MaxThrottledPeriod time.Duration
+	}
+)
+
+var (
+	defaultRetryPolicy = RetryPolicy{
+		Passes:             defaultRetryPasses,
+		Delay:              retryDelay,
+		MaxThrottledPeriod: maxThrottledPeriod,
+	}
+)
+
+// WithToken makes Pusher use a given OAuth token instead of obtaining one by means of the credential archive
+func WithToken(token string) Option {
+	return func(p *pusher) {
+		p.token = token
+	}
+}
+
+// WithBasicAuth makes Pusher authenticate to the hub with a given user and password, e.g. to a self-hosted hub
+// fronted by a reverse proxy with basic auth, it overrides the auth material of the credential archive
+func WithBasicAuth(user string, password string) Option {
+	return func(p *pusher) {
+		p.hub.Auth = nil
+		p.hub.Basic = &BasicAuth{User: user, Password: password}
+	}
+}
+
+// WithVaultCreds makes Pusher authenticate by credentials read from Vault, either by a given token
+// or by tokens obtained with a given client secret, the client ID and the auth server of the credential
+// archive are used unless the secret specifies others
+func WithVaultCreds(creds *VaultCreds) Option {
+	return func(p *pusher) {
+		if creds.Token != "" {
+			p.token = creds.Token
+			return
+		}
+		auth := OAuth2{Server: DefaultAuthServer}
+		if p.hub.Auth != nil {
+			auth = *p.hub.Auth
+		}
+		if creds.Server != "" {
+			auth.Server = creds.Server
+		}
+		if creds.ClientID != "" {
+			auth.ID = creds.ClientID
+		}
+		auth.Secret = creds.ClientSecret
+		p.hub.Auth = &auth
+	}
+}
+
+// WithKeyring makes Pusher cache OAuth tokens in the OS keyring instead of plaintext files
+func WithKeyring() Option {
+	return func(p *pusher) {
+		p.tokens = keyringTokenCache{}
+	}
+}
+
+// WithCompression enables gzip compression of TAR streams pushed to OSTree Hub
+func WithCompression() Option {
+	return func(p *pusher) {
+		p.compress = true
+	}
+}
+
+// WithSHA256 makes Pusher calculate SHA-256 digest of each repo file and send it along with CRC32C
+// if OSTree Hub supports it
+func WithSHA256() Option {
+	return func(p *pusher) {
+		p.sha256 = true
+	}
+}
+
+// WithRateLimit caps the overall upload bandwidth, in bytes per second
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(p *pusher) {
+		if bytesPerSec > 0 {
+			p.limiter = newRateLimiter(bytesPerSec)
+		}
+	}
+}
+
+// WithLogger makes Pusher log its messages by means of a given logger
+func WithLogger(l Logger) Option {
+	return func(p *pusher) {
+		p.logger = l
+	}
+}
+
+// WithMetadata attaches build provenance metadata, e.g. git SHA or CI job URL, to a push session
+func WithMetadata(meta map[string]string) Option {
+	return func(p *pusher) {
+		p.meta = meta
+	}
+}
+
+// WithNotifyURL makes Pusher post the final report to a given webhook once the push completes
+func WithNotifyURL(url string) Option {
+	return func(p *pusher) {
+		p.notify = url
+	}
+}
+
+// WithRepoFiles makes Pusher push files returned by ScanRepo instead of walking through the repo,
+// it saves re-hashing of the repo if it's pushed to several factories
+func WithRepoFiles(files []*oshub.RepoFile) Option {
+	return func(p *pusher) {
+		p.files = files
+	}
+}
+
+// WithContext makes Pusher stop the push once a given context is done, batches in flight are cancelled
+// and Wait returns a partial report marked as interrupted
+func WithContext(ctx context.Context) Option {
+	return func(p *pusher) {
+		p.parent = ctx
+	}
+}
+
+// WithRetries sets a number of passes retrying objects that failed to sync once the push has completed,
+// zero disables retries
+func WithRetries(passes int) Option {
+	return func(p *pusher) {
+		p.retry.Passes = passes
+	}
+}
+
+// WithRetryPolicy sets how Pusher retries objects that failed to sync, chunks of resumable uploads
+// and requests throttled by the hub, zero fields of the policy except Passes keep the defaults
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(p *pusher) {
+		if policy.Delay == 0 {
+			policy.Delay = defaultRetryPolicy.Delay
+		}
+		if policy.MaxThrottledPeriod == 0 {
+			policy.MaxThrottledPeriod = defaultRetryPolicy.MaxThrottledPeriod
+		}
+		p.retry = policy
+	}
+}
+
+// WithWorkers sets a fixed number of batches pushed concurrently, 20 by default
+func WithWorkers(n int) Option {
+	return func(p *pusher) {
+		if n > 0 {
+			p.workers = n
+			p.maxAdaptiveWorkers = 0
+		}
+	}
+}
+
+// WithMaxMemory bounds memory taken by a push by a given number of bytes, so it can run in a memory constrained
+// container. Queues of repo files, transport buffers, which are 10MB per connection otherwise, resumable upload chunks
+// and a number of batches pushed concurrently are bounded to fit the budget, options setting them are bounded too.
+// Buffers of a client given by WithHTTPClient are not affected.
+func WithMaxMemory(bytes int64) Option {
+	return func(p *pusher) {
+		p.maxMemory = bytes
+	}
+}
+
+// WithAdaptiveConcurrency makes Run tune a number of batches pushed concurrently, up to a given maximum, and a batch
+// size limit, up to the one set by WithBatchBytes, by failures and latency of the hub rather than push a fixed number
+// of batches concurrently. They grow while the hub copes with the push and are halved once it fails batches, throttles
+// the push or slows down. Zero maximum means DefaultMaxAdaptiveWorkers.
+func WithAdaptiveConcurrency(maxWorkers int) Option {
+	return func(p *pusher) {
+		if maxWorkers <= 0 {
+			maxWorkers = DefaultMaxAdaptiveWorkers
+		}
+		p.maxAdaptiveWorkers = maxWorkers
+	}
+}
+
+// WithHTTPClient makes Pusher talk to the hub by means of a given client, e.g. one with a proxy
+// or custom TLS settings, it overrides the client certificate of the credential archive. TAR streams are pushed
+// by it too, so its transport should allow HTTP/2 for concurrent streams to be multiplexed
+func WithHTTPClient(c *http.Client) Option {
+	return func(p *pusher) {
+		p.client = c
+	}
+}
+
+// WithTimeout makes Pusher stop the push once a given period has elapsed since Run, as if it was aborted,
+// so Wait returns a partial report marked as interrupted
+func WithTimeout(d time.Duration) Option {
+	return func(p *pusher) {
+		p.timeout = d
+	}
+}
+
+// WithFilters sets path prefixes of repo files to push, e.g. ./objects/ and ./refs/heads/,
+// objects, refs and config are pushed by default
+func WithFilters(prefixes ...string) Option {
+	return func(p *pusher) {
+		p.filters = toSlash(prefixes)
+	}
+}
+
+// WithPathFilter pushes repo files matching include patterns of a filter in addition to the ones selected by
+// WithFilters, e.g. delta-indexes/, and never pushes files matching its exclude patterns, e.g. refs/remotes/.
+// Refs of namespaces returned by DefaultSkippedRefNamespaces are pushed only if they are included, e.g. refs/remotes/.
+// It doesn't affect files given by WithRepoFiles, ScanRepo takes the filter instead.
+func WithPathFilter(f PathFilter) Option {
+	return func(p *pusher) {
+		p.paths = p.paths.merge(f)
+	}
+}
+
+// DefaultSkippedFiles returns path prefixes of repo files skipped by default, i.e. ostree transaction state
+// and staging files
+func DefaultSkippedFiles() []string {
+	return append([]string{}, repoFileSkip...)
+}
+
+// WithCheckpoint makes Pusher write a checkpoint of the push to a file named by CheckpointFile in a given directory
+// every interval, DefaultCheckpointInterval if it's zero, and once the push ends. The checkpoint tells objects
+// confirmed synced, bytes sent and refs being pushed, so the progress of a long push can be monitored externally.
+// If a push of the same repo to the same factory hasn't completed, e.g. it's been interrupted, the next one skips objects
+// listed as synced by its checkpoint instead of checking them with the hub again, unless they have changed since then.
+func WithCheckpoint(dir string, interval time.Duration) Option {
+	return func(p *pusher) {
+		p.checkpointDir = dir
+		if interval > 0 {
+			p.checkpointInterval = interval
+		}
+	}
+}
+
+// WithSkippedFiles sets path prefixes of repo files never pushed even if filters include them,
+// ostree transaction state and staging files like ./tmp/ and ./transaction are skipped by default
+func WithSkippedFiles(prefixes ...string) Option {
+	return func(p *pusher) {
+		p.skip = toSlash(prefixes)
+	}
+}
+
+// WithTransactionMode specifies what Run does if an ostree transaction is in progress in the repo,
+// TransactionFail by default. TransactionWait makes it wait until the repo stays unchanged for a given period.
+func WithTransactionMode(mode TransactionMode, quiescence time.Duration) Option {
+	return func(p *pusher) {
+		p.txnMode = mode
+		if quiescence > 0 {
+			p.quiescence = quiescence
+		}
+	}
+}
+
+// WithStaticDeltas makes Run generate static deltas from commits of refs published by the hub to commits
+// of local refs and push them along with refs, so devices get efficient upgrades. Deltas are generated by
+// a given ostree binary, the one found in PATH if it's empty.
+func WithStaticDeltas(ostreeBin string) Option {
+	return func(p *pusher) {
+		if ostreeBin == "" {
+			ostreeBin = defaultOstreeBin
+		}
+		p.ostreeBin = ostreeBin
+	}
+}
+
+// WithForceRefs makes the hub accept refs that don't fast-forward refs it has published, e.g. to roll a branch back
+// on purpose, by default the hub rejects them so a push of a stale repo doesn't undo newer commits
+func WithForceRefs() Option {
+	return func(p *pusher) {
+		p.forceRefs = true
+	}
+}
+
+// WithForceUpload makes Run push all repo files regardless of what the hub has, the hub uploads them to the bucket
+// even if it has them with the same CRC, e.g. to recover from a suspected bucket corruption or to apply new metadata
+// of objects. The hub has to announce CapabilityForce, otherwise Run fails.
+func WithForceUpload() Option {
+	return func(p *pusher) {
+		p.forceUpload = true
+	}
+}
+
+// WithObjectVerification specifies whether Run verifies that a checksum of each object the hub lacks matches
+// its name before sending it, which is the case by default. Mismatched objects, e.g. corrupted by a build,
+// are not pushed and reported by Report.Corrupted, refs aren't pushed then either.
+func WithObjectVerification(enabled bool) Option {
+	return func(p *pusher) {
+		p.verifyObjects = enabled
+	}
+}
+
+// WithArchiveConversion makes Run convert content objects of a bare or bare-user repo to archive-z2 ones while
+// they are pushed, so such a repo can be pushed to a hub serving archive-z2 repos to devices. The repo config is
+// pushed with the archive-z2 mode, objects are converted twice, to calculate their CRC and while they are sent,
+// rather than being stored. It doesn't affect archive repos.
+func WithArchiveConversion() Option {
+	return func(p *pusher) {
+		p.toArchive = true
+	}
+}
+
+// WithLockMode specifies what Run does if the factory repo is locked by another push session, LockFail by default
+func WithLockMode(mode LockMode) Option {
+	return func(p *pusher) {
+		p.lockMode = mode
+	}
+}
+
+// WithBatchBytes bounds a cumulative size of files pushed in a single batch in addition to a number of files,
+// DefaultBatchBytes by default, zero bounds batches only by a number of files
+func WithBatchBytes(bytes int64) Option {
+	return func(p *pusher) {
+		p.batchBytes = bytes
+	}
+}
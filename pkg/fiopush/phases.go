@@ -0,0 +1,106 @@
+package fiopush
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"strings"
+)
+
+type (
+	// files held back until all objects have been synced
+	heldFiles struct {
+		commitMeta []*oshub.RepoFile
+		refs       []*oshub.RepoFile
+	}
+)
+
+// splitRepoFiles passes objects through and holds detached commit metadata, refs and config back,
+// they are sent to the returned channel once the input queue is closed, objects are queued up to a given capacity
+func splitRepoFiles(files <-chan *oshub.RepoFile, queueSize uint) (<-chan *oshub.RepoFile, <-chan heldFiles) {
+	objects := make(chan *oshub.RepoFile, queueSize)
+	held := make(chan heldFiles, 1)
+	go func() {
+		defer close(held)
+		defer close(objects)
+		var h heldFiles
+		for f := range files {
+			switch {
+			case isCommitMeta(f.Path):
+				h.commitMeta = append(h.commitMeta, f)
+			case strings.HasPrefix(f.Path, "./objects/"):
+				objects <- f
+			default:
+				h.refs = append(h.refs, f)
+			}
+		}
+		held <- h
+	}()
+	return objects, held
+}
+
+// isCommitMeta returns true if a repo file is detached metadata of a commit, e.g. its signatures
+func isCommitMeta(path string) bool {
+	return strings.HasPrefix(path, "./objects/") && strings.HasSuffix(path, "."+string(ostree.ObjectCommitMeta))
+}
+
+// pushCommitMeta pushes detached commit metadata once all objects have been synced, so metadata never reaches
+// the hub before its commit, it's skipped if any object or batch failed to sync or the push was interrupted
+func (p *pusher) pushCommitMeta(report *Report, commitMeta []*oshub.RepoFile) {
+	if len(commitMeta) == 0 || p.parent.Err() != nil || report.Synced.SyncFailedNumb > 0 || report.BatchErrors > 0 || len(report.Corrupted) > 0 {
+		return
+	}
+	logger := p.logger.With("phase", PhaseCommitMeta)
+	metaReport := Aggregate(p.push(feedRepoFiles(p.ctx, commitMeta)), p.aggOptions(PhaseCommitMeta, logger)...)
+	report.CommitMeta.Checked += metaReport.Checked
+	report.CommitMeta.Sent += metaReport.Sent.FileNumb
+	report.CommitMeta.Bytes += metaReport.Sent.Bytes
+	report.CommitMeta.Synced += metaReport.Synced.SyncedFileNumb
+	report.CommitMeta.Failed += metaReport.Synced.SyncFailedNumb
+	report.BatchErrors += metaReport.BatchErrors
+	addFailures(report, metaReport.Failures)
+	if metaReport.Synced.SyncFailedNumb > 0 {
+		logger.Warn("Failed to sync detached commit metadata", "failed", metaReport.Synced.SyncFailedNumb)
+	}
+}
+
+// pushRefs pushes refs and config once all objects have been synced, the second phase is skipped
+// if any object or detached commit metadata failed to sync or is corrupted, a batch of them failed to be checked
+// or sent, or the push was interrupted
+func (p *pusher) pushRefs(report *Report, refs []*oshub.RepoFile) {
+	if p.parent.Err() != nil || report.Synced.SyncFailedNumb > 0 || report.CommitMeta.Failed > 0 || report.BatchErrors > 0 || len(report.Corrupted) > 0 {
+		report.RefsSkipped = true
+		p.logger.Warn("Refs haven't been pushed since not all objects have been synced",
+			"failed", report.Synced.SyncFailedNumb+report.CommitMeta.Failed, "batch_errors", report.BatchErrors,
+			"corrupted", len(report.Corrupted), "refs", len(refs))
+		return
+	}
+	if len(refs) == 0 {
+		return
+	}
+	refsReport := Aggregate(p.push(feedRepoFiles(p.ctx, refs)), p.aggOptions(PhaseRefs, p.logger.With("phase", PhaseRefs))...)
+	report.Checked += refsReport.Checked
+	report.Sent.FileNumb += refsReport.Sent.FileNumb
+	report.Sent.Bytes += refsReport.Sent.Bytes
+	addSyncReport(&report.Synced, &refsReport.Synced)
+	report.Synced.Changed = append(report.Synced.Changed, refsReport.Synced.Changed...)
+	addFailures(report, refsReport.Failures)
+	report.BatchErrors += refsReport.BatchErrors
+	report.Refs = p.updatedRefs(refsReport.Synced.Changed)
+}
+
+// updatedRefs returns refs of the repo the hub reports as changed mapped to their commits
+func (p *pusher) updatedRefs(changed []string) map[string]string {
+	local, err := (&ostree.Repo{Dir: p.repo}).Refs()
+	if err != nil {
+		p.logger.Warn("Failed to read refs of the repo", "err", err)
+	}
+	refs := make(map[string]string)
+	for _, path := range changed {
+		if !strings.HasPrefix(path, "./refs/") {
+			continue
+		}
+		ref := strings.TrimPrefix(path, "./refs/")
+		refs[ref] = local[ref]
+	}
+	return refs
+}
@@ -0,0 +1,129 @@
+package fiopush
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	PhaseObjects    string = "objects"
+	PhaseRetry      string = "retry"
+	PhaseCommitMeta string = "commitmeta"
+	PhaseRefs       string = "refs"
+
+	ProgressCheck string = "check"
+	ProgressSend  string = "send"
+	ProgressSync  string = "sync"
+	ProgressError string = "error"
+	ProgressDone  string = "done"
+)
+
+type (
+	// ProgressRecord is a line of an NDJSON progress stream, counters are cumulative within a phase,
+	// a record of the done event carries the final report of the push
+	ProgressRecord struct {
+		Time      time.Time `json:"time"`
+		Event     string    `json:"event"`
+		Factory   string    `json:"factory"`
+		Session   string    `json:"session"`
+		Phase     string    `json:"phase,omitempty"`
+		Batch     uint32    `json:"batch,omitempty"`
+		Err       string    `json:"err,omitempty"`
+		Checked   uint      `json:"checked"`
+		SentFiles uint      `json:"sent_files"`
+		SentBytes int64     `json:"sent_bytes"`
+		Synced    uint32    `json:"synced"`
+		Failed    uint32    `json:"failed"`
+		Report    *Report   `json:"report,omitempty"`
+	}
+
+	// ndjsonProgress writes progress records to a writer, pushes of several factories can share it
+	ndjsonProgress struct {
+		mu  *sync.Mutex
+		enc *json.Encoder
+	}
+)
+
+var (
+	progressEvents = map[EventType]string{
+		EventCheckedBatch: ProgressCheck,
+		EventSentBatch:    ProgressSend,
+		EventSyncedBatch:  ProgressSync,
+		EventError:        ProgressError,
+	}
+
+	// serializes writes of pushers sharing the same writer, e.g. stdout
+	progressMu sync.Mutex
+)
+
+// WithNDJSONProgress makes Pusher write a JSON record per check, send and sync update, and a final one
+// once the push completes, to a given writer, e.g. os.Stdout, so tools can render the push progress
+func WithNDJSONProgress(w io.Writer) Option {
+	return func(p *pusher) {
+		p.progress = &ndjsonProgress{mu: &progressMu, enc: json.NewEncoder(w)}
+	}
+}
+
+func (n *ndjsonProgress) write(r *ProgressRecord) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.enc.Encode(r)
+}
+
+// WithProgress makes Pusher call a given function with each event of the push, including events of retry passes
+// and of the refs push, it's called by Wait sequentially in order of events, so it must not block for long
+func WithProgress(fn func(Event)) Option {
+	return func(p *pusher) {
+		p.onProgress = fn
+	}
+}
+
+// aggOptions returns options of Aggregate for a given phase of the push
+func (p *pusher) aggOptions(phase string, logger Logger) []AggOption {
+	p.checkpoint.setPhase(phase)
+	return []AggOption{AggLogger(logger), func(c *aggConfig) {
+		c.onEvent = func(e Event, r Report) {
+			p.snapshot.apply(e)
+			if p.onProgress != nil {
+				p.onProgress(e)
+			}
+			if p.progress == nil {
+				return
+			}
+			record := p.progressRecord(progressEvents[e.Type], phase, &r)
+			record.Time = e.Time.UTC()
+			record.Batch = e.Batch
+			if e.Err != nil {
+				record.Err = e.Err.Error()
+			}
+			p.progress.write(record)
+		}
+	}}
+}
+
+func (p *pusher) progressRecord(event string, phase string, r *Report) *ProgressRecord {
+	return &ProgressRecord{
+		Time:      time.Now().UTC(),
+		Event:     event,
+		Factory:   p.hub.Factory,
+		Session:   p.session,
+		Phase:     phase,
+		Checked:   r.Checked,
+		SentFiles: r.Sent.FileNumb,
+		SentBytes: r.Sent.Bytes,
+		Synced:    r.Synced.SyncedFileNumb,
+		Failed:    r.Synced.SyncFailedNumb,
+	}
+}
+
+// progressDone writes the final progress record
+func (p *pusher) progressDone(report *Report) {
+	if p.progress == nil {
+		return
+	}
+	r := p.progressRecord(ProgressDone, "", report)
+	r.Report = report
+	p.progress.write(r)
+}
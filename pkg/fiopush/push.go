@@ -2,9 +2,18 @@ package fiopush
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"foundriesio/ostreehub/internal/faults"
 	"foundriesio/ostreehub/pkg/oshub"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"hash"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
@@ -12,10 +21,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
@@ -25,34 +36,198 @@ type (
 
 		Run() error
 		Wait() (*Report, error)
+		// RemoteRefs returns refs published by OSTree Hub, a map of ref names to commit checksums
+		RemoteRefs() (map[string]string, error)
+		// Diff compares the repo with the one published by OSTree Hub without pushing anything
+		Diff() (*Diff, error)
+		// RemoteObjects returns paths of objects stored by OSTree Hub, e.g. ./objects/ab/cdef.filez
+		RemoteObjects() ([]string, error)
+		// ListRemoteObjects returns a page of objects stored by OSTree Hub along with their size and CRC,
+		// an empty page token requests the first page
+		ListRemoteObjects(pageToken string, pageSize int) (*oshub.ObjectPage, error)
+		// Prune asks OSTree Hub to delete given objects
+		Prune(objects []string) (*oshub.PruneReport, error)
+		// DeleteRepo asks OSTree Hub to move the whole factory repo to the trash, it can be restored for a while
+		DeleteRepo() (*oshub.DeleteReport, error)
+		// RestoreRepo asks OSTree Hub to restore the latest deleted factory repo
+		RestoreRepo() (*oshub.RestoreReport, error)
+		// Abort stops a running push, Wait returns a partial report marked as interrupted
+		Abort()
+		// StatusSnapshot returns cumulative counters of a running push without consuming its events
+		StatusSnapshot() Report
 	}
 
+	// Finalizer finalizes a push session, it's implemented by pushers made by this package, so callers check
+	// whether a Pusher is a Finalizer rather than Pusher requiring custom implementations to finalize pushes
+	Finalizer interface {
+		// Receipt asks OSTree Hub to finalize the push and returns a receipt signed by the hub
+		Receipt() (*oshub.SignedReceipt, error)
+	}
+
+	// Status delivers events of push batches, the channel is closed once all batches are done
 	Status struct {
-		Check <-chan uint
-		Send  <-chan *oshub.SendReport
-		Sync  <-chan *oshub.SyncReport
+		Events <-chan Event
 	}
 
 	Report struct {
-		Checked uint
-		Sent    oshub.SendReport
-		Synced  oshub.SyncReport
+		Checked uint             `json:"checked"`
+		Sent    oshub.SendReport `json:"sent"`
+		Synced  oshub.SyncReport `json:"synced"`
+		Session string           `json:"session,omitempty"`
+		// paths of objects that failed to sync mapped to failure reasons reported by the hub
+		Failures map[string]string `json:"failures,omitempty"`
+		// number of objects pushed once again because they failed to sync, see WithRetries
+		Retried uint `json:"retried,omitempty"`
+		// set if the hub has rejected the push, e.g. its credentials, the push is aborted then
+		Rejected *Rejection `json:"rejected,omitempty"`
+		// number of batches that failed to be checked or sent, e.g. dropped or whose stream has failed, objects
+		// of such batches may be missing on the hub even though they aren't counted as failed to sync
+		BatchErrors uint `json:"batch_errors,omitempty"`
+		// paths of objects whose checksum doesn't match their name mapped to reasons, they haven't been pushed,
+		// see WithObjectVerification
+		Corrupted map[string]string `json:"corrupted,omitempty"`
+		// set if refs and config haven't been pushed because some objects failed to sync or the push was interrupted,
+		// so devices never see a ref pointing to a commit whose objects are missing
+		RefsSkipped bool `json:"refs_skipped,omitempty"`
+		// refs the hub has updated mapped to their commits, refs it has rejected, e.g. by RefGuard, aren't listed
+		Refs map[string]string `json:"refs,omitempty"`
+		// set if the push has been cancelled before all files have been pushed,
+		// re-running it resumes the push since files already synced by the hub are skipped
+		Interrupted bool `json:"interrupted,omitempty"`
+		// number of objects not pushed since a previous push that hasn't completed confirmed them synced, see WithCheckpoint
+		Resumed uint `json:"resumed,omitempty"`
+		// set by Pusher, it's nil for reports of custom pipelines summed up by Aggregate
+		Timing *Timing `json:"timing,omitempty"`
+		// detached metadata of commits, they aren't counted by Checked, Sent and Synced
+		CommitMeta CommitMetaReport `json:"commitmeta"`
+	}
+
+	// Rejection is an HTTP status and a reason the hub has rejected a push with
+	Rejection struct {
+		Status int    `json:"status"`
+		Reason string `json:"reason"`
+	}
+
+	// CommitMetaReport counts detached metadata objects of commits, e.g. ./objects/ab/cdef.commitmeta carrying
+	// GPG signatures, they are pushed once all other objects have been synced, so they never precede their commits
+	CommitMetaReport struct {
+		Checked uint   `json:"checked"`
+		Sent    uint   `json:"sent"`
+		Bytes   int64  `json:"bytes"`
+		Synced  uint32 `json:"synced"`
+		Failed  uint32 `json:"failed"`
 	}
 )
 
 type (
 	pusher struct {
-		repo   string
-		url    *url.URL
-		hub    *OSTreeHub
-		token  string
-		status *Status
+		repo  string
+		url   *url.URL
+		hub   *OSTreeHub
+		token string
+		// expiry of the token obtained from hub.Auth, it's renewed once it gets close, zero if it doesn't expire
+		tokenExpires time.Time
+		tokenLock    sync.Mutex
+		// where tokens obtained from hub.Auth are cached between runs
+		tokens   tokenCache
+		status   *Status
+		compress bool
+		sha256   bool
+		limiter  *rateLimiter
+		logger   Logger
+		session  string
+		meta     map[string]string
+		ctx      context.Context
+		span     trace.Span
+		notify   string
+		files    []*oshub.RepoFile
+		client   *http.Client
+		// a client TAR streams are pushed by, its transport has large buffers
+		pushClient *http.Client
+		// a context cancelling the push, see WithContext
+		parent context.Context
+		// how objects that failed to sync, upload chunks and throttled requests are retried
+		retry RetryPolicy
+		// number of batches pushed concurrently
+		workers int
+		// number of TAR streams a batch is split into, see WithStreams
+		streams int
+		// the push is stopped once it elapses since Run, see WithTimeout
+		timeout  time.Duration
+		deadline *time.Timer
+		// path prefixes of repo files to push and of those to skip
+		filters []string
+		skip    []string
+		// glob patterns of repo files to push in addition to filters and of those not to push, see WithPathFilter
+		paths PathFilter
+		// what to do if an ostree transaction is in progress in the repo, see WithTransactionMode
+		txnMode    TransactionMode
+		quiescence time.Duration
+		// a mode of the repo read from its config, e.g. archive-z2 or bare-user, empty if it's unknown
+		mode string
+		// an ostree binary generating static deltas, empty if they aren't generated, see WithStaticDeltas
+		ostreeBin string
+		// refs that don't fast-forward published refs are accepted by the hub, see WithForceRefs
+		forceRefs bool
+		// all files are pushed and uploaded regardless of what the hub has, see WithForceUpload
+		forceUpload bool
+		// the maximum number of batches pushed concurrently if it's tuned rather than fixed, see WithAdaptiveConcurrency
+		maxAdaptiveWorkers int
+		// tunes concurrency of a push session, nil if it's fixed
+		tuner *concurrencyTuner
+		// checksums of objects are verified before they are sent, see WithObjectVerification
+		verifyObjects bool
+		// content objects of a bare repo are converted to archive-z2 ones, see WithArchiveConversion
+		toArchive bool
+		// detached commit metadata, refs and config of the repo, they are available once all objects have been enqueued
+		held <-chan heldFiles
+		// what to do if the factory repo is locked by another push session
+		lockMode LockMode
+		// closed to stop renewal of the repo lock, nil if the lock isn't held
+		unlock chan struct{}
+		// maximum cumulative size of files of a batch, zero means batches are bounded only by a number of files
+		batchBytes int64
+		// a memory budget queues and buffers are bounded by, zero if it's unbounded, see WithMaxMemory
+		maxMemory int64
+		// a capacity of file queues, a size of transport buffers and of a resumable upload chunk
+		queueSize  uint
+		bufferSize int
+		chunkSize  int
+		timer      *pushTimer
+		// log metadata of HTTP requests to the hub, see WithHTTPTrace
+		httpTrace bool
+		// set if the progress is written as NDJSON, see WithNDJSONProgress
+		progress *ndjsonProgress
+		// called with each push event, see WithProgress
+		onProgress func(Event)
+		// where and how often a checkpoint of the push is written, see WithCheckpoint
+		checkpointDir      string
+		checkpointInterval time.Duration
+		// records objects confirmed synced, nil if no checkpoint is written
+		checkpoint *checkpointer
+		// cancels the parent context, see Abort
+		abort    context.CancelFunc
+		snapshot statusSnapshot
+		// set once the hub rejects the push, see reject
+		rejection  *hubError
+		rejectOnce sync.Once
+		// set once the push can't proceed, e.g. a repo file can't be converted, see fail
+		failure  error
+		failOnce sync.Once
+	}
+
+	repoPath struct {
+		fullPath string
+		relPath  string
+		size     int64
+		// a target of a symlink, a symlink content is its target path
+		link string
 	}
 )
 
 const (
-	// a single goroutine traverses an ostree repo,
-	// generates CRC for each file and enqueue a file info to the queue/channel
+	// a single goroutine traverses an ostree repo and enqueues paths of files to push,
+	// a pool of goroutines generates CRC for each file and enqueue a file info to the queue/channel
 	walkQueueSize uint = 10000
 	// a number of goroutine to read from the file queue and push them to OSTreeHub
 	// each goroutine at first checks if given files are already present on GCS and uploads
@@ -60,17 +235,33 @@ const (
 	concurrentPusherNumb int = 20
 	// maximum number of files to check per a single HTTP request
 	filesToCheckMaxNumb int = oshub.FilesToCheckMaxNumb
+	// DefaultBatchBytes bounds a cumulative size of files of a batch, so a batch of large objects
+	// doesn't turn into a multi-GB TAR stream which has to be sent again entirely if it fails
+	DefaultBatchBytes int64 = 256 * 1024 * 1024
+	// for how long a TAR stream is held back until the hub accepts the push request with 100 Continue,
+	// so a request rejected by the hub, e.g. because of auth, doesn't stream a whole batch for nothing
+	expectContinueTimeout = 5 * time.Second
 )
 
 var (
+	// a number of goroutines that read file paths found by the repo walker and calculate their CRC
+	crcWorkerNumb = runtime.NumCPU()
+
 	repoFileFilterIn = []string{
 		"./objects/",
 		"./config",
 		"./refs/",
 	}
+	// ostree transaction state and staging files, they are never pushed even if filters include them
+	repoFileSkip = []string{
+		"./tmp/",
+		"./state/",
+		"./transaction",
+		"./.lock",
+	}
 )
 
-func NewPusher(repo string, credFile string) (Pusher, error) {
+func NewPusher(repo string, credFile string, opts ...Option) (Pusher, error) {
 	if err := checkRepoDir(repo); err != nil {
 		return nil, err
 	}
@@ -78,14 +269,32 @@ func NewPusher(repo string, credFile string) (Pusher, error) {
 	if err != nil {
 		return nil, err
 	}
-	reqUrl, err := url.Parse(hub.URL + "/ota/ostreehub/" + hub.Factory + "/v1/repos/lmp")
+	reqUrl, err := repoUrl(hub)
+	if err != nil {
+		return nil, err
+	}
+	return newPusher(&pusher{repo: repo, url: reqUrl, hub: hub, token: ""}, opts), nil
+}
+
+// NewPusherFromKeyring returns Pusher authenticating by the credentials of a given factory stored in the OS keyring
+// by StoreCredsInKeyring, tokens are cached in the keyring as well. The returned error wraps ErrKeyringNotFound
+// if there are no credentials of the factory.
+func NewPusherFromKeyring(repo string, factory string, opts ...Option) (Pusher, error) {
+	if err := checkRepoDir(repo); err != nil {
+		return nil, err
+	}
+	hub, err := LoadCredsFromKeyring(factory)
+	if err != nil {
+		return nil, err
+	}
+	reqUrl, err := repoUrl(hub)
 	if err != nil {
 		return nil, err
 	}
-	return &pusher{repo: repo, url: reqUrl, hub: hub, token: ""}, nil
+	return newPusher(&pusher{repo: repo, url: reqUrl, hub: hub, token: ""}, append([]Option{WithKeyring()}, opts...)), nil
 }
 
-func NewPusherNoAuth(repo string, hubURL string, factory string) (Pusher, error) {
+func NewPusherNoAuth(repo string, hubURL string, factory string, opts ...Option) (Pusher, error) {
 	if err := checkRepoDir(repo); err != nil {
 		return nil, err
 	}
@@ -99,11 +308,50 @@ func NewPusherNoAuth(repo string, hubURL string, factory string) (Pusher, error)
 		URL:     hubURL,
 		Factory: factory,
 	}
-	reqUrl, err := url.Parse(hub.URL + "/v1/repos/lmp?factory=" + hub.Factory)
+	reqUrl, err := repoUrl(&hub)
 	if err != nil {
 		return nil, err
 	}
-	return &pusher{repo: repo, url: reqUrl, hub: &hub, token: ""}, nil
+	return newPusher(&pusher{repo: repo, url: reqUrl, hub: &hub, token: ""}, opts), nil
+}
+
+func newPusher(p *pusher, opts []Option) *pusher {
+	p.logger = oshub.NewStdLogger(oshub.LevelInfo)
+	p.parent = context.Background()
+	p.retry = defaultRetryPolicy
+	p.batchBytes = DefaultBatchBytes
+	p.workers = concurrentPusherNumb
+	p.streams = 1
+	p.tokens = fileTokenCache{}
+	p.filters = repoFileFilterIn
+	p.skip = repoFileSkip
+	p.quiescence = defaultQuiescence
+	p.verifyObjects = true
+	p.queueSize = walkQueueSize
+	p.bufferSize = transportBufferSize
+	p.chunkSize = resumableChunkSize
+	p.checkpointInterval = DefaultCheckpointInterval
+	for _, o := range opts {
+		o(p)
+	}
+	p.parent, p.abort = context.WithCancel(p.parent)
+	p.logger = p.logger.With("factory", p.hub.Factory)
+	if p.maxMemory > 0 {
+		p.applyMemoryBudget()
+	}
+	if p.client == nil {
+		p.client = hubClient(p.hub)
+		p.pushClient = pushClient(p.hub, p.bufferSize)
+	} else {
+		if p.hub.HMAC != nil {
+			p.client = signedClient(p.client, p.hub.HMAC)
+		}
+		p.pushClient = p.client
+	}
+	if p.httpTrace {
+		p.client = tracedClient(p.client, p.logger)
+	}
+	return p
 }
 
 func (p *pusher) HubUrl() string {
@@ -122,251 +370,748 @@ func (p *pusher) Run() error {
 	if p.status != nil {
 		return fmt.Errorf("cannot run Pusher if there are unfinished push jobs")
 	}
-	p.status = push(p.repo, walkAndCrcRepo(p.repo), p.url, p.token)
+	session, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	p.session = session
+	p.timer = newPushTimer()
+	p.snapshot.reset(session)
+	p.logger.Info("Starting a push session", "session", p.session)
+	p.checkFileLimit()
+	if err := p.checkTransaction(); err != nil {
+		return err
+	}
+	if err := p.checkRepoMode(); err != nil {
+		return err
+	}
+	p.logSkippedRefs()
+	if p.forceUpload {
+		if err := p.checkForceUpload(); err != nil {
+			return err
+		}
+	}
+	if p.ostreeBin != "" {
+		if err := p.addStaticDeltas(); err != nil {
+			return err
+		}
+	}
+	if err := p.lock(); err != nil {
+		return err
+	}
+	if p.checkpointDir != "" {
+		if err := p.openCheckpoint(); err != nil {
+			p.release()
+			return err
+		}
+	}
+	if p.maxAdaptiveWorkers > 0 {
+		p.tuner = newConcurrencyTuner(p.maxAdaptiveWorkers, p.batchBytes, p.logger)
+	}
+	if p.timeout > 0 {
+		p.deadline = time.AfterFunc(p.timeout, func() {
+			p.logger.Warn("Push has timed out", "session", p.session, "timeout", p.timeout)
+			p.abort()
+		})
+	}
+	p.ctx, p.span = tracer.Start(p.parent, "fiopush.push", trace.WithAttributes(
+		attribute.String("factory", p.hub.Factory), attribute.String("session", p.session)))
+	files := feedRepoFiles(p.ctx, p.files)
+	if p.files == nil {
+		files = walkAndCrcRepo(p.ctx, p.repo, p.sha256, p.repoFilter(), p.queueSize)
+	}
+	if p.convertsToArchive() {
+		files = p.archiveRepoFiles(p.ctx, files)
+	}
+	if p.checkpoint != nil {
+		files = p.checkpoint.skipSynced(p.ctx, files, p.queueSize)
+	}
+	// detached commit metadata, refs and config are pushed by Wait once all objects are synced
+	var objects <-chan *oshub.RepoFile
+	objects, p.held = splitRepoFiles(files, p.queueSize)
+	p.status = p.push(objects)
 	return nil
 }
 
+// fail aborts the push because of a given error, Wait returns the first one once the push has stopped
+func (p *pusher) fail(err error) {
+	p.failOnce.Do(func() {
+		p.logger.Error("Push has failed", "err", err)
+		p.failure = err
+		p.abort()
+	})
+}
+
 func (p *pusher) Wait() (*Report, error) {
 	if p.status == nil {
 		return nil, fmt.Errorf("cannot wait for Pusher jobs completion if there are none of running jobs")
 	}
-	return wait(p.status), nil
+	report := Aggregate(p.status, p.aggOptions(PhaseObjects, p.logger)...)
+	p.retryFailed(report)
+	held := <-p.held
+	p.pushCommitMeta(report, held.commitMeta)
+	p.pushRefs(report, held.refs)
+	p.release()
+	if p.deadline != nil {
+		p.deadline.Stop()
+	}
+	report.Session = p.session
+	report.Timing = p.timer.timing(report.Sent.Bytes)
+	report.Resumed = p.checkpoint.resumedObjects()
+	if p.rejection != nil {
+		report.Rejected = &Rejection{Status: p.rejection.status, Reason: p.rejection.msg}
+	}
+	if p.parent.Err() != nil {
+		report.Interrupted = true
+		p.logger.Warn("Push has been interrupted", "session", p.session, "err", p.parent.Err())
+	}
+	p.closeCheckpoint(report)
+	p.span.End()
+	p.progressDone(report)
+	if p.notify != "" {
+		if err := p.sendNotification(report); err != nil {
+			p.logger.Warn("Failed to send a push notification", "url", p.notify, "err", err)
+		}
+	}
+	return report, p.failure
+}
+
+func (p *pusher) Receipt() (*oshub.SignedReceipt, error) {
+	if err := p.auth(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", subUrl(p.url, "finalize").String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a request to finalize the push: %s", err.Error())
+	}
+	p.setHeaders(req.Header)
+	injectTraceContext(p.ctx, req.Header)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make a request to finalize the push: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read a push receipt: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to finalize the push: %s, %s", resp.Status, string(body))
+	}
+	var receipt oshub.SignedReceipt
+	if err := json.Unmarshal(body, &receipt); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a push receipt: %s", err.Error())
+	}
+	return &receipt, nil
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate a push session ID: %s", err.Error())
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// hubClient returns an HTTP client presenting a client certificate to a hub if the hub requires it
+func hubClient(hub *OSTreeHub) *http.Client {
+	c := http.DefaultClient
+	if hub.TLS != nil {
+		c = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: hub.TLS, ForceAttemptHTTP2: true}}
+	}
+	if hub.HMAC != nil {
+		c = signedClient(c, hub.HMAC)
+	}
+	return c
+}
+
+// pushClient returns a client to push TAR streams to the hub with, HTTP/2 is negotiated if the hub supports it,
+// so concurrent streams are multiplexed over a single connection. Connections buffer given bytes both ways.
+func pushClient(hub *OSTreeHub, bufferSize int) *http.Client {
+	//TODO: timeout
+	c := &http.Client{Transport: &http.Transport{DisableCompression: false, TLSClientConfig: hub.TLS, ForceAttemptHTTP2: true,
+		ExpectContinueTimeout: expectContinueTimeout, WriteBufferSize: bufferSize, ReadBufferSize: bufferSize}}
+	if hub.HMAC != nil {
+		c = signedClient(c, hub.HMAC)
+	}
+	return c
+}
+
+// repoUrl returns an URL of the factory repo endpoint of OSTree Hub, the hub is accessed through
+// the Foundries API gateway if auth material is specified, otherwise directly
+func repoUrl(hub *OSTreeHub) (*url.URL, error) {
+	if hub.Auth != nil || hub.TLS != nil {
+		return url.Parse(hub.URL + "/ota/ostreehub/" + hub.Factory + "/v1/repos/lmp")
+	}
+	return url.Parse(hub.URL + "/v1/repos/lmp?factory=" + hub.Factory)
+}
+
+// subUrl returns an URL of a given sub-resource of the factory repo endpoint
+func subUrl(u *url.URL, sub string) *url.URL {
+	s := *u
+	s.Path += "/" + sub
+	return &s
 }
 
 func checkRepoDir(dir string) error {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		return fmt.Errorf("The specified directory doesn't exist: %s\n", dir)
 	}
-	if _, err := os.Stat(path.Join(dir, "config")); os.IsNotExist(err) {
+	if _, err := os.Stat(filepath.Join(dir, "config")); os.IsNotExist(err) {
 		return fmt.Errorf("The specified directory doesn't contain an ostree repo: %s\n", dir)
 	}
-	if _, err := os.Stat(path.Join(dir, "objects")); os.IsNotExist(err) {
+	if _, err := os.Stat(filepath.Join(dir, "objects")); os.IsNotExist(err) {
 		return fmt.Errorf("The specified directory doesn't contain ostree repo objects: %s\n", dir)
 	}
 	return nil
 }
 
 func (p *pusher) auth() error {
-	if p.hub.Auth == nil {
+	p.tokenLock.Lock()
+	defer p.tokenLock.Unlock()
+	if p.hub.Auth == nil || p.token != "" {
 		return nil
 	}
-	t, err := GetOAuthToken(p.hub.Auth)
+	if err := p.obtainToken(); err != nil {
+		return err
+	}
+	p.logger.Info("OAuth token has been successfully obtained", "server", p.hub.Auth.Server)
+	return nil
+}
+
+// accessToken returns the token to authenticate requests by, a token obtained from hub.Auth is renewed
+// once it's about to expire so a long push or a watch doesn't fail halfway through
+func (p *pusher) accessToken() string {
+	p.tokenLock.Lock()
+	defer p.tokenLock.Unlock()
+	if p.hub.Auth != nil && !p.tokenExpires.IsZero() && time.Until(p.tokenExpires) < tokenExpiryMargin {
+		if err := p.obtainToken(); err != nil {
+			// the current token may still be good for a while, the next request retries
+			p.logger.Warn("Failed to renew OAuth token", "server", p.hub.Auth.Server, "err", err)
+		} else {
+			p.logger.Debug("OAuth token has been renewed", "expires", p.tokenExpires)
+		}
+	}
+	return p.token
+}
+
+// obtainToken must be called with tokenLock held
+func (p *pusher) obtainToken() error {
+	tok, err := oauthToken(p.hub.Auth, p.tokens)
 	if err != nil {
 		return err
 	}
-	log.Printf("OAuth token has been successfully obtained at %s\n", p.hub.Auth.Server)
-	p.token = t
+	p.token = tok.Token
+	p.tokenExpires = tok.Expires
 	return nil
 }
 
-func walkAndCrcRepo(repoDir string) <-chan *oshub.RepoFile {
-	dir := filepath.Clean(repoDir)
-	queue := make(chan *oshub.RepoFile, walkQueueSize)
+// walkAndCrcRepo enqueues repo files along with their CRC to a queue of a given capacity,
+// it stops walking through the repo once the context is done
+func walkAndCrcRepo(ctx context.Context, repoDir string, withSHA256 bool, filter *repoFilter, queueSize uint) <-chan *oshub.RepoFile {
+	pathQueue := make(chan *repoPath, queueSize)
+	queue := make(chan *oshub.RepoFile, queueSize)
 	go func() {
-		defer close(queue)
-		table := crc32.MakeTable(crc32.Castagnoli)
-		hasher := crc32.New(table)
-
-		if err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, walkErr error) error {
-			if walkErr != nil {
-				log.Fatalf("Failed to walk through a repo: %s\n", walkErr.Error())
-			}
-			if info.IsDir() {
+		defer close(pathQueue)
+		if err := walkRepo(repoDir, filter.skip, func(fullPath string, relPath string, info os.FileInfo) error {
+			if !filter.match(relPath) {
 				return nil
 			}
-			relPath := strings.Replace(fullPath, dir, ".", 1)
-			if !filterRepoFiles(relPath) {
+			rp, err := newRepoPath(fullPath, relPath, info)
+			if err != nil {
+				return err
+			}
+			select {
+			case pathQueue <- rp:
 				return nil
+			case <-ctx.Done():
+				return ctx.Err()
 			}
+		}); err != nil && ctx.Err() == nil {
+			log.Fatalf("Failed to walk through a repo directory: %s\n", err.Error())
+		}
+	}()
 
-			f, err := os.Open(fullPath)
-			if err != nil {
-				log.Fatalf("Failed to open file: %s\n", err.Error())
-			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					panic(err)
+	go func() {
+		defer close(queue)
+		var wg sync.WaitGroup
+		for ii := 0; ii < crcWorkerNumb; ii++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+				var shaHasher hash.Hash
+				if withSHA256 {
+					shaHasher = sha256.New()
+				}
+				for p := range pathQueue {
+					crc, digest := crcFile(hasher, shaHasher, p)
+					if faults.Active().CorruptCRC(p.relPath) {
+						crc ^= 0xffffffff
+					}
+					select {
+					case queue <- &oshub.RepoFile{Path: p.relPath, CRC32: crc, SHA256: digest, Size: p.size}:
+					case <-ctx.Done():
+					}
 				}
 			}()
+		}
+		wg.Wait()
+	}()
+	return queue
+}
 
-			hasher.Reset()
-			w, err := io.Copy(hasher, f)
-			if err != nil {
-				log.Fatalf("Failed to write file data to CRC hasher: %s\n", err.Error())
-			}
-			if w != info.Size() {
-				log.Fatalf("Invalid amount of data written to CRC hasher: %s\n", err.Error())
+// ScanRepo walks through a repo and calculates CRC32C, and optionally SHA-256, of its files, the result can be shared
+// by several pushers of the repo by means of WithRepoFiles. Files are selected the way Pusher does by default,
+// optionally including and excluding more files by given filters.
+func ScanRepo(repoDir string, withSHA256 bool, filters ...PathFilter) ([]*oshub.RepoFile, error) {
+	if err := checkRepoDir(repoDir); err != nil {
+		return nil, err
+	}
+	var files []*oshub.RepoFile
+	for f := range walkAndCrcRepo(context.Background(), repoDir, withSHA256, defaultRepoFilter(mergePathFilters(filters)), walkQueueSize) {
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func feedRepoFiles(ctx context.Context, files []*oshub.RepoFile) <-chan *oshub.RepoFile {
+	queue := make(chan *oshub.RepoFile, walkQueueSize)
+	go func() {
+		defer close(queue)
+		for _, f := range files {
+			select {
+			case queue <- f:
+			case <-ctx.Done():
+				return
 			}
-			crc := hasher.Sum32()
-			queue <- &oshub.RepoFile{Path: relPath, CRC32: crc}
-			return nil
-		}); err != nil {
-			log.Fatalf("Failed to walk through a repo directory: %s\n", err.Error())
 		}
 	}()
 	return queue
 }
 
-func filterRepoFiles(path string) bool {
-	for _, f := range repoFileFilterIn {
-		if strings.HasPrefix(path, f) {
-			return true
+// newRepoPath describes a repo file found at a given path, symlinks are not followed,
+// e.g. symlink objects of a bare repo, and their target path is used as their content
+func newRepoPath(fullPath string, relPath string, info os.FileInfo) (*repoPath, error) {
+	rp := &repoPath{fullPath: fullPath, relPath: relPath, size: info.Size()}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read a symlink %s: %s", fullPath, err.Error())
 		}
+		// the same slash-separated target Tar sends
+		rp.link = filepath.ToSlash(link)
+		rp.size = int64(len(rp.link))
 	}
-	return false
+	return rp, nil
 }
 
-func push(repoDir string, fileQueue <-chan *oshub.RepoFile, url *url.URL, token string) *Status {
-	checkReportQueue := make(chan uint, concurrentPusherNumb)
-	reportQueue := make(chan *oshub.SendReport, concurrentPusherNumb)
-	recvReportQueue := make(chan *oshub.SyncReport, concurrentPusherNumb)
+func crcFile(hasher hash.Hash32, shaHasher hash.Hash, p *repoPath) (uint32, string) {
+	var f io.Reader
+	if p.link != "" {
+		f = strings.NewReader(p.link)
+	} else {
+		sem := fileSemaphore()
+		sem.Acquire(1)
+		defer sem.Release(1)
+		file, err := os.Open(p.fullPath)
+		if err != nil {
+			log.Fatalf("Failed to open file: %s\n", err.Error())
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				panic(err)
+			}
+		}()
+		f = file
+	}
 
+	hasher.Reset()
+	var dst io.Writer = hasher
+	if shaHasher != nil {
+		shaHasher.Reset()
+		dst = io.MultiWriter(hasher, shaHasher)
+	}
+	w, err := io.Copy(dst, f)
+	if err != nil {
+		log.Fatalf("Failed to write file data to CRC hasher: %s\n", err.Error())
+	}
+	if w != p.size {
+		log.Fatalf("Invalid amount of data written to CRC hasher: %s, %d vs %d\n", p.fullPath, w, p.size)
+	}
+	if shaHasher == nil {
+		return hasher.Sum32(), ""
+	}
+	return hasher.Sum32(), hex.EncodeToString(shaHasher.Sum(nil))
+}
+
+func (p *pusher) push(fileQueue <-chan *oshub.RepoFile) *Status {
+	encoding := ""
+	if p.compress {
+		encoding = oshub.EncodingGzip
+	}
+
+	workers := p.workers
+	if p.tuner != nil {
+		workers = p.tuner.workers()
+	}
+	events := make(chan Event, 3*workers)
+
+	var batchNumb uint32
 	go func() {
 		var wg sync.WaitGroup
-		for ii := 0; ii < concurrentPusherNumb; ii++ {
+		stopped := make(chan struct{})
+		if p.tuner != nil {
+			go func() {
+				select {
+				case <-p.ctx.Done():
+					p.tuner.wake()
+				case <-stopped:
+				}
+			}()
+		}
+		for ii := 0; ii < workers; ii++ {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				for {
+				for p.ctx.Err() == nil {
+					maxBatchBytes := p.batchBytes
+					var started time.Time
+					if p.tuner != nil {
+						var ok bool
+						if started, maxBatchBytes, ok = p.tuner.acquire(p.ctx); !ok {
+							break
+						}
+					}
 					objectsToCheck := make(map[string]uint32)
+					digests := make(map[string]string)
+					sizes := make(map[string]int64)
+					var batchBytes int64
 
 					for object := range fileQueue {
 						objectsToCheck[object.Path] = object.CRC32
-						if len(objectsToCheck) > filesToCheckMaxNumb {
+						sizes[object.Path] = object.Size
+						if object.SHA256 != "" {
+							digests[object.Path] = object.SHA256
+						}
+						batchBytes += object.Size
+						// a file larger than the limit makes up a batch on its own
+						if len(objectsToCheck) > filesToCheckMaxNumb || (maxBatchBytes > 0 && batchBytes >= maxBatchBytes) {
 							break
 						}
 					}
 
 					if len(objectsToCheck) == 0 {
+						p.tuner.abandon()
 						break
 					}
 
-					objectsToSync := checkRepo(objectsToCheck, url, token)
+					batch := atomic.AddUint32(&batchNumb, 1)
+					logger := p.logger.With("batch", batch)
+					if faults.Active().DropBatch(batch) {
+						logger.Warn("Fault injected, dropping a batch", "files", len(objectsToCheck))
+						e := newEvent(EventError, batch)
+						e.Err = fmt.Errorf("fault injected, the batch has been dropped")
+						events <- e
+						p.tuner.release(started, 0, true)
+						continue
+					}
+					ctx, span := tracer.Start(p.ctx, "fiopush.batch", trace.WithAttributes(
+						attribute.Int64("batch", int64(batch)), attribute.Int("files", len(objectsToCheck))))
+					checkStart := time.Now()
+					objectsToSync, caps, err := p.checkRepo(ctx, objectsToCheck, logger)
+					checkTime := time.Since(checkStart)
+					if err != nil {
+						e := newEvent(EventError, batch)
+						e.Err = err
+						events <- e
+						span.End()
+						if p.ctx.Err() != nil {
+							// the push has been cancelled, e.g. the hub has rejected it
+							logger.Warn("Batch has been cancelled", "err", err)
+							p.tuner.abandon()
+							break
+						}
+						logger.Error("Failed to check a batch", "err", err)
+						p.tuner.release(started, checkTime, true)
+						continue
+					}
+					if p.forceUpload {
+						// the hub is asked only for its capabilities and whether it accepts the push
+						objectsToSync = objectsToCheck
+					}
+					p.checkpoint.confirm(objectsToCheck, func(path string) bool {
+						_, ok := objectsToSync[path]
+						return ok
+					})
+					var corrupted map[string]string
+					if p.verifyObjects {
+						corrupted = p.verifyObjectsToSync(objectsToSync, logger)
+					}
+					logger.Debug("Checked a batch", "files", len(objectsToCheck), "bytes", batchBytes, "to_sync", len(objectsToSync))
 
-					checkReportQueue <- uint(len(objectsToCheck))
+					e := newEvent(EventCheckedBatch, batch)
+					e.Corrupted = corrupted
+					e.Checked = uint(len(objectsToCheck))
+					e.ToSync = uint(len(objectsToSync))
+					events <- e
 
+					var sendTime time.Duration
+					var failed bool
 					if len(objectsToSync) > 0 {
-						tarReader, sendReportChannel := oshub.Tar(repoDir, objectsToSync)
-						recvReportChannel := pushRepo(tarReader, url, token)
-
-						reportQueue <- <-sendReportChannel
-						recvReportQueue <- <-recvReportChannel
+						sendStart := time.Now()
+						tarOpts := p.tarOptions(ctx)
+						if caps[oshub.CapabilitySHA256] && len(digests) > 0 {
+							tarOpts = append(tarOpts, oshub.WithSHA256(digests))
+						}
+						if p.convertsToArchive() {
+							tarOpts = append(tarOpts, oshub.WithArchiveConversion(p.mode, sizes))
+						}
+						sendReport, syncReport, ok := p.sendStreams(ctx, objectsToSync, sizes, tarOpts, encoding, caps[oshub.CapabilityResumable], logger)
+						e := newEvent(EventSentBatch, batch)
+						e.Sent = sendReport
+						events <- e
+						// files of the batch are known to be synced only if the hub has reported on all of them
+						confirmed := ok && sendReport.Err == ""
+						if !confirmed && ctx.Err() == nil {
+							err := sendReport.Err
+							if err == "" {
+								err = "OSTree Hub hasn't confirmed the batch sync"
+							}
+							logger.Error("Failed to send a batch", "err", err)
+							e = newEvent(EventError, batch)
+							e.Err = errors.New(err)
+							events <- e
+						}
+						if syncReport.StagingMode == oshub.StagingSpill {
+							logger.Info("Hub scratch space limit reached, objects streamed directly to GCS",
+								"spilled", syncReport.SpilledFileNumb)
+						}
+						// the hub lists at most MaxReportedFailures of failed files, the unlisted ones are unknown
+						if confirmed && len(syncReport.Failures) < oshub.MaxReportedFailures {
+							p.checkpoint.confirm(objectsToSync, func(path string) bool {
+								_, failed := syncReport.Failures[path]
+								return failed
+							})
+						}
+						e = newEvent(EventSyncedBatch, batch)
+						e.Synced = syncReport
+						events <- e
+						sendTime = time.Since(sendStart)
+						failed = !confirmed || syncReport.SyncFailedNumb > 0
 					}
+					p.timer.batch(checkTime, sendTime)
+					p.tuner.release(started, checkTime, failed)
+					span.End()
 				}
 			}()
 		}
 		wg.Wait()
-		close(checkReportQueue)
-		close(reportQueue)
-		close(recvReportQueue)
+		close(stopped)
+		close(events)
 	}()
-	return &Status{Check: checkReportQueue, Send: reportQueue, Sync: recvReportQueue}
+	return &Status{Events: events}
 }
 
-func checkRepo(objs map[string]uint32, url *url.URL, token string) map[string]uint32 {
-	jsonObjects, _ := json.Marshal(objs)
-	req, err := http.NewRequest("GET", url.String(), bytes.NewBuffer(jsonObjects))
-	if err != nil {
-		log.Fatalf("Failed to create a request to check objects presence: %s\n", err.Error())
+func (p *pusher) tarOptions(ctx context.Context) []oshub.TarOption {
+	var opts []oshub.TarOption
+	if p.compress {
+		opts = append(opts, oshub.WithGzip())
+	}
+	if p.limiter != nil {
+		opts = append(opts, oshub.WithLimiter(ctx, p.limiter))
+	}
+	if p.mode != "" {
+		opts = append(opts, oshub.WithRepoMode(p.mode))
+	}
+	opts = append(opts, oshub.WithFileSemaphore(fileSemaphore()))
+	return opts
+}
+
+func (p *pusher) capabilities() string {
+	caps := []string{oshub.CapabilityResumable}
+	if p.sha256 {
+		caps = append(caps, oshub.CapabilitySHA256)
+	}
+	if p.isBare() {
+		caps = append(caps, oshub.CapabilityBare)
+	}
+	if p.forceUpload {
+		caps = append(caps, oshub.CapabilityForce)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return oshub.FormatCapabilities(caps...)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// setHeaders sets headers common for all requests made by Pusher
+func (p *pusher) setHeaders(h http.Header) {
+	setAuthHeader(h, p.hub, p.accessToken())
+	if p.session != "" {
+		h.Set(oshub.SessionHeader, p.session)
+	}
+	if len(p.meta) > 0 {
+		h.Set(oshub.MetaHeader, oshub.EncodeMeta(p.meta))
+	}
+	if p.mode != "" {
+		h.Set(oshub.RepoModeHeader, p.mode)
+	}
+	if p.forceRefs {
+		h.Set(oshub.ForceRefsHeader, "true")
+	}
+	if p.forceUpload {
+		h.Set(oshub.ForceUploadHeader, "true")
+	}
+}
+
+// checkRepo returns files the hub lacks and capabilities it supports, an error is returned only if the context is done
+func (p *pusher) checkRepo(ctx context.Context, objs map[string]uint32, logger Logger) (map[string]uint32, map[string]bool, error) {
+	ctx, span := tracer.Start(ctx, "fiopush.check")
+	defer span.End()
+	jsonObjects, _ := json.Marshal(objs)
+	resp, err := p.doThrottled(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", p.url.String(), bytes.NewBuffer(jsonObjects))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a request to check objects presence: %s", err.Error())
+		}
+		req.Header.Set("Content-Type", "application/json")
+		p.setHeaders(req.Header)
+		injectTraceContext(ctx, req.Header)
+		if caps := p.capabilities(); caps != "" {
+			req.Header.Set(oshub.CapabilitiesHeader, caps)
+		}
+		return req, nil
+	}, logger)
 	if err != nil {
-		log.Fatalf("Failed to make request to check objects presence: %s\n", err.Error())
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("failed to make request to check objects presence: %s", err.Error())
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Printf("Failed to close a response body: %s\n", err.Error())
+			logger.Warn("Failed to close a response body", "err", err)
 		}
 	}()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Failed to read response: %s\n", err.Error())
+		return nil, nil, fmt.Errorf("failed to read response: %s", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		hErr := &hubError{status: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+		if hErr.rejectsPush() {
+			// e.g. the factory has exceeded its storage quota
+			p.reject(hErr, logger)
+		}
+		return nil, nil, hErr
 	}
 
 	respMap := map[string]uint32{}
 	if err := json.Unmarshal(body, &respMap); err != nil {
-		log.Fatalf("Failed to read response: %s\n", err.Error())
+		return nil, nil, fmt.Errorf("failed to read response: %s", err.Error())
 	}
-	return respMap
+	return respMap, oshub.ParseCapabilities(resp.Header.Get(oshub.CapabilitiesHeader)), nil
 }
 
-func pushRepo(pr *io.PipeReader, u *url.URL, token string) <-chan *oshub.SyncReport {
-	req := &http.Request{
-		Method:           "PUT",
-		ProtoMajor:       1,
-		ProtoMinor:       1,
-		URL:              u,
-		TransferEncoding: []string{"chunked"},
-		Body:             pr,
-		Header:           make(map[string][]string),
+// sendBatch sends a TAR stream of given files to the hub, the stream is made and sent again if the hub throttles the push
+func (p *pusher) sendBatch(ctx context.Context, files map[string]uint32, tarOpts []oshub.TarOption, encoding string,
+	resumable bool, logger Logger) (*oshub.SendReport, *oshub.SyncReport, bool) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		tarReader, sendReportChannel := oshub.Tar(p.repo, files, tarOpts...)
+		var recvChannel <-chan *pushResponse
+		if resumable {
+			recvChannel = p.pushRepoResumable(ctx, tarReader, encoding, logger)
+		} else {
+			recvChannel = p.pushRepo(ctx, tarReader, encoding, logger)
+		}
+		resp := <-recvChannel
+		// the stream is closed once the response is received, so Tar stops if it hasn't been sent completely
+		tarReader.Close()
+		sendReport := <-sendReportChannel
+		if resp.retryAfter == 0 || time.Since(start) > p.retry.MaxThrottledPeriod {
+			return sendReport, resp.report, resp.ok
+		}
+		logger.Warn("OSTree Hub throttled the push, retrying", "after", resp.retryAfter, "attempt", attempt)
+		p.tuner.throttle()
+		select {
+		case <-time.After(resp.retryAfter):
+		case <-ctx.Done():
+			return sendReport, resp.report, false
+		}
 	}
-	req.Header.Set("Expect", "100-continue")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+}
 
-	//TODO: timeout
-	client := &http.Client{}
-	client.Transport = &http.Transport{DisableCompression: false,
-		WriteBufferSize: 1024 * 1025 * 10, ReadBufferSize: 1024 * 1024 * 10}
+func (p *pusher) pushRepo(ctx context.Context, pr *io.PipeReader, encoding string, logger Logger) <-chan *pushResponse {
+	ctx, span := tracer.Start(ctx, "fiopush.tar_push")
+	reportChannel := make(chan *pushResponse, 1)
+	// the stream length is unknown, so it's sent chunked over HTTP/1.1 or as a sequence of DATA frames over HTTP/2
+	req, err := http.NewRequestWithContext(ctx, "PUT", p.url.String(), pr)
+	if err != nil {
+		logger.Error("Failed to create a push request", "err", err)
+		span.End()
+		reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+		close(reportChannel)
+		return reportChannel
+	}
+	req.Header.Set("Expect", "100-continue")
+	p.setHeaders(req.Header)
+	injectTraceContext(ctx, req.Header)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 
-	reportChannel := make(chan *oshub.SyncReport, 1)
 	go func() {
 		defer close(reportChannel)
-		resp, err := client.Do(req)
+		defer span.End()
+		if d := faults.Active().DelayPut(); d > 0 {
+			time.Sleep(d)
+		}
+		resp, err := p.pushClient.Do(req)
 		if err != nil {
-			panic(err)
+			var tarErr *oshub.TarError
+			if errors.As(err, &tarErr) {
+				// Tar reports the failure along with the files sent so far
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+				return
+			}
+			if ctx.Err() == nil {
+				logger.Error("Failed to push a batch", "err", err)
+			} else {
+				logger.Warn("Push of a batch has been cancelled", "err", err)
+			}
+			reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
 		} else {
 			defer resp.Body.Close()
 
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
-				log.Printf("Filed to read response: %s\n", err.Error())
+				logger.Error("Failed to read response", "err", err)
+			}
+			if hErr := (&hubError{status: resp.StatusCode, msg: strings.TrimSpace(string(body))}); hErr.rejectsPush() {
+				// the hub has rejected the request before the stream was sent, e.g. the factory has exceeded
+				// its storage quota, other batches would be rejected too
+				p.reject(hErr, logger)
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+				return
+			}
+			if resp.StatusCode == http.StatusTooManyRequests {
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}, retryAfter: retryAfter(resp)}
+				return
+			}
+			if resp.StatusCode != http.StatusOK {
+				logger.Error("OSTree Hub failed to sync a batch", "status", resp.StatusCode, "err", strings.TrimSpace(string(body)))
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+				return
 			}
 			var status oshub.SyncReport
 			if err := json.Unmarshal(body, &status); err != nil {
-				log.Printf("Filed to umarshal response: %s\n", err.Error())
+				logger.Error("Failed to unmarshal response", "err", err)
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+				return
 			}
-			reportChannel <- &status
+			reportChannel <- &pushResponse{report: &status, ok: true}
 		}
 	}()
 	return reportChannel
 }
-
-func wait(statusQueue *Status) *Report {
-	var totalChecked uint
-	var totalSendReport oshub.SendReport
-	var totalRecvReport oshub.SyncReport
-	for {
-		select {
-		case checked, ok := <-statusQueue.Check:
-			if !ok {
-				continue
-			}
-			totalChecked += checked
-			log.Printf("Checked: %d\n", totalChecked)
-
-		case sendReport, ok := <-statusQueue.Send:
-			if !ok || sendReport == nil {
-				continue
-			}
-			totalSendReport.FileNumb += sendReport.FileNumb
-			totalSendReport.ObjNumb += sendReport.ObjNumb
-			totalSendReport.Bytes += sendReport.Bytes
-			log.Printf("Sent: %d\n", totalSendReport.FileNumb)
-
-		case recvReport, ok := <-statusQueue.Sync:
-			if !ok {
-				log.Println("Repo sync has completed")
-				return &Report{totalChecked, totalSendReport, totalRecvReport}
-			}
-			totalRecvReport.UploadedFileNumb += recvReport.UploadedFileNumb
-			totalRecvReport.SyncedFileNumb += recvReport.SyncedFileNumb
-			totalRecvReport.UploadSyncedFileNumb += recvReport.UploadSyncedFileNumb
-			totalRecvReport.SyncFailedNumb += recvReport.SyncFailedNumb
-		}
-	}
-}
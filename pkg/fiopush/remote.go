@@ -0,0 +1,168 @@
+package fiopush
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PruneCandidates returns remote objects that are absent in a local repo or not reachable from its refs
+func PruneCandidates(repoDir string, remote []string) ([]string, error) {
+	repo, err := ostree.OpenRepo(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := repo.Refs()
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		// most likely a wrong repo is specified, pruning against it would wipe out the remote repo
+		return nil, fmt.Errorf("the repo doesn't have any refs: %s", repoDir)
+	}
+	closure, err := repo.Closure(refs, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	for _, o := range remote {
+		if !closure.Objects[o] {
+			candidates = append(candidates, o)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
+
+func (p *pusher) RemoteRefs() (map[string]string, error) {
+	body, err := p.call("GET", "refs", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote refs: %s", err.Error())
+	}
+	refs := make(map[string]string)
+	if err := json.Unmarshal(body, &refs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal remote refs: %s", err.Error())
+	}
+	return refs, nil
+}
+
+func (p *pusher) RemoteObjects() ([]string, error) {
+	body, err := p.call("GET", "objects", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote objects: %s", err.Error())
+	}
+	var objects []string
+	if err := json.Unmarshal(body, &objects); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a list of remote objects: %s", err.Error())
+	}
+	return objects, nil
+}
+
+func (p *pusher) ListRemoteObjects(pageToken string, pageSize int) (*oshub.ObjectPage, error) {
+	u := subUrl(p.url, "objects/list")
+	q := u.Query()
+	if pageToken != "" {
+		q.Set("page_token", pageToken)
+	}
+	if pageSize > 0 {
+		q.Set("page_size", strconv.Itoa(pageSize))
+	}
+	u.RawQuery = q.Encode()
+	body, err := p.callUrl("GET", u, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote objects: %s", err.Error())
+	}
+	var page oshub.ObjectPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a page of remote objects: %s", err.Error())
+	}
+	return &page, nil
+}
+
+func (p *pusher) Prune(objects []string) (*oshub.PruneReport, error) {
+	data, err := json.Marshal(oshub.PruneRequest{Objects: objects})
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.call("POST", "prune", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune remote objects: %s", err.Error())
+	}
+	var report oshub.PruneReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a prune report: %s", err.Error())
+	}
+	return &report, nil
+}
+
+func (p *pusher) DeleteRepo() (*oshub.DeleteReport, error) {
+	header := http.Header{}
+	header.Set(oshub.ConfirmDeleteHeader, p.hub.Factory)
+	body, err := p.callUrl("DELETE", p.url, nil, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete the remote repo: %s", err.Error())
+	}
+	var report oshub.DeleteReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a delete report: %s", err.Error())
+	}
+	return &report, nil
+}
+
+func (p *pusher) RestoreRepo() (*oshub.RestoreReport, error) {
+	body, err := p.call("POST", "restore", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore the remote repo: %s", err.Error())
+	}
+	var report oshub.RestoreReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a restore report: %s", err.Error())
+	}
+	return &report, nil
+}
+
+// call makes a request to a given sub-resource of the factory repo endpoint and returns the response body
+func (p *pusher) call(method string, sub string, body io.Reader) ([]byte, error) {
+	return p.callUrl(method, subUrl(p.url, sub), body, nil)
+}
+
+// callUrl makes a request to a given URL of the hub, extra headers can be nil
+func (p *pusher) callUrl(method string, u *url.URL, body io.Reader, header http.Header) ([]byte, error) {
+	if err := p.auth(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	p.setHeaders(req.Header)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s, %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
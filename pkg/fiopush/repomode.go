@@ -0,0 +1,49 @@
+package fiopush
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"net/http"
+)
+
+// checkRepoMode reads the repo mode from its config, a repo whose content objects are not compressed,
+// i.e. a bare or bare-user one, can be pushed only to a hub announcing CapabilityBare
+func (p *pusher) checkRepoMode() error {
+	mode, err := ostree.ReadMode(p.repo)
+	if err != nil {
+		// e.g. a partial repo made for a push, it's handled the way it was before repo modes were announced
+		p.logger.Warn("Failed to determine the repo mode", "err", err)
+		return nil
+	}
+	if !ostree.IsKnownMode(mode) {
+		return fmt.Errorf("unsupported mode of %s repo: %s", p.repo, mode)
+	}
+	p.mode = mode
+	p.logger.Debug("Determined the repo mode", "mode", mode)
+	if p.convertsToArchive() {
+		p.logger.Info("Content objects of the repo are converted to archive-z2 objects while they are pushed", "mode", mode)
+		return nil
+	}
+	if !p.isBare() {
+		return nil
+	}
+
+	resp, err := checkNoObjects(p.hub, p.url, p.accessToken(), oshub.CapabilityBare)
+	if err != nil {
+		return fmt.Errorf("failed to check whether the hub accepts %s repos: %s", mode, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to check whether the hub accepts %s repos: HTTP %d", mode, resp.StatusCode)
+	}
+	if !oshub.ParseCapabilities(resp.Header.Get(oshub.CapabilitiesHeader))[oshub.CapabilityBare] {
+		return fmt.Errorf("%s is a %s repo while the hub serves only archive-z2 repos, "+
+			"convert it by ostree pull-local to an archive-z2 repo first or convert its objects while pushing them", p.repo, mode)
+	}
+	return nil
+}
+
+// isBare returns true if content objects of the repo are pushed uncompressed, i.e. as .file objects
+func (p *pusher) isBare() bool {
+	return p.mode != "" && !ostree.IsArchiveMode(p.mode) && !p.toArchive
+}
@@ -0,0 +1,211 @@
+package fiopush
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// a TAR stream is uploaded in chunks of this size unless it's bounded by WithMaxMemory, a chunk is kept
+	// in memory until the hub confirms its receipt
+	resumableChunkSize = 8 * 1024 * 1024
+	// a number of attempts to send a single chunk, the upload is resumed from the offset the hub reports
+	resumableAttempts = 5
+)
+
+// pushRepoResumable uploads a TAR stream by means of chunks, if a connection drops in the middle of the stream
+// only the unconfirmed part of the current chunk is sent again, see oshub.UploadStore
+func (p *pusher) pushRepoResumable(ctx context.Context, pr *io.PipeReader, encoding string, logger Logger) <-chan *pushResponse {
+	reportChannel := make(chan *pushResponse, 1)
+	go func() {
+		defer close(reportChannel)
+		ctx, span := tracer.Start(ctx, "fiopush.tar_push")
+		defer span.End()
+		// unblocks Tar if the upload fails
+		defer pr.Close()
+
+		id, err := newUploadID()
+		if err != nil {
+			logger.Error("Failed to generate an upload ID", "err", err)
+			reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+			return
+		}
+		logger = logger.With("upload", id)
+		uploadUrl := subUrl(p.url, "uploads/"+id).String()
+
+		chunk := make([]byte, p.chunkSize)
+		var offset int64
+		for {
+			n, err := io.ReadFull(pr, chunk)
+			last := err == io.EOF || err == io.ErrUnexpectedEOF
+			if err != nil && !last {
+				var tarErr *oshub.TarError
+				if !errors.As(err, &tarErr) {
+					logger.Error("Failed to read a TAR stream", "err", err)
+				}
+				// Tar reports its failure along with the files sent so far
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+				return
+			}
+			body, err := p.sendChunk(ctx, uploadUrl, chunk[:n], offset, last, encoding, logger)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("Failed to push a batch", "err", err)
+				} else {
+					logger.Warn("Push of a batch has been cancelled", "err", err)
+				}
+				reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+				return
+			}
+			offset += int64(n)
+			if last {
+				var status oshub.SyncReport
+				if err := json.Unmarshal(body, &status); err != nil {
+					logger.Error("Failed to unmarshal response", "err", err)
+					reportChannel <- &pushResponse{report: &oshub.SyncReport{}}
+					return
+				}
+				reportChannel <- &pushResponse{report: &status, ok: true}
+				return
+			}
+		}
+	}()
+	return reportChannel
+}
+
+// sendChunk sends a chunk starting at a given offset of the upload, after a failure it asks the hub
+// for the number of bytes received so far and sends the rest of the chunk
+func (p *pusher) sendChunk(ctx context.Context, uploadUrl string, chunk []byte, offset int64, last bool, encoding string, logger Logger) ([]byte, error) {
+	var sent int64
+	var err error
+	for attempt := 1; attempt <= resumableAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(time.Duration(attempt-1) * p.retry.Delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			received, offsetErr := p.uploadOffset(ctx, uploadUrl, logger)
+			if offsetErr != nil {
+				err = offsetErr
+				logger.Warn("Failed to get an upload offset", "attempt", attempt, "err", err)
+				continue
+			}
+			if received < offset || received > offset+int64(len(chunk)) {
+				return nil, fmt.Errorf("the hub has received %d bytes of the upload, expected %d-%d", received, offset, offset+int64(len(chunk)))
+			}
+			sent = received - offset
+			logger.Info("Resuming an upload", "offset", received)
+		}
+
+		var body []byte
+		body, err = p.doChunk(ctx, func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, "PATCH", uploadUrl, bytes.NewReader(chunk[sent:]))
+			if err != nil {
+				return nil, err
+			}
+			p.setHeaders(req.Header)
+			injectTraceContext(ctx, req.Header)
+			req.Header.Set(oshub.UploadOffsetHeader, strconv.FormatInt(offset+sent, 10))
+			if last {
+				req.Header.Set(oshub.UploadCompleteHeader, "true")
+			}
+			if encoding != "" {
+				req.Header.Set("Content-Encoding", encoding)
+			}
+			return req, nil
+		}, logger)
+		if err == nil {
+			return body, nil
+		}
+		if hErr, ok := err.(*hubError); ok && hErr.rejectsPush() {
+			p.reject(hErr, logger)
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger.Warn("Failed to send an upload chunk", "attempt", attempt, "offset", offset+sent, "err", err)
+	}
+	return nil, err
+}
+
+func (p *pusher) doChunk(ctx context.Context, newReq func() (*http.Request, error), logger Logger) ([]byte, error) {
+	resp, err := p.doThrottled(ctx, newReq, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &hubError{status: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
+
+func (p *pusher) uploadOffset(ctx context.Context, uploadUrl string, logger Logger) (int64, error) {
+	resp, err := p.doThrottled(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", uploadUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.setHeaders(req.Header)
+		return req, nil
+	}, logger)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return strconv.ParseInt(resp.Header.Get(oshub.UploadOffsetHeader), 10, 64)
+}
+
+type hubError struct {
+	status int
+	msg    string
+}
+
+func (e *hubError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.status, e.msg)
+}
+
+// rejectsPush tells whether the hub rejects the whole push rather than a single request, e.g. the push credentials
+// or since the factory has exceeded its storage quota
+func (e *hubError) rejectsPush() bool {
+	return e.status == http.StatusUnauthorized || e.status == http.StatusForbidden || e.status == http.StatusInsufficientStorage
+}
+
+// reject aborts a push the hub has rejected, e.g. since it doesn't accept the push credentials, so other batches
+// aren't sent just to be rejected too, Wait reports the first rejection
+func (p *pusher) reject(err *hubError, logger Logger) {
+	p.rejectOnce.Do(func() {
+		logger.Error("OSTree Hub rejected the push", "err", err)
+		p.rejection = err
+		p.abort()
+	})
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,83 @@
+package fiopush
+
+import (
+	"crypto/sha256"
+	"foundriesio/ostreehub/pkg/oshub"
+	"hash"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRetryPasses = 2
+	// a delay before a retry pass grows linearly with the pass number, transient GCS errors tend to be short
+	retryDelay = 2 * time.Second
+)
+
+// retryFailed pushes objects that failed to sync once again, up to the configured number of passes,
+// the report is updated with the outcome of the retries
+func (p *pusher) retryFailed(report *Report) {
+	for pass := 1; pass <= p.retry.Passes && len(report.Failures) > 0 && p.parent.Err() == nil; pass++ {
+		paths := make([]string, 0, len(report.Failures))
+		for path := range report.Failures {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		logger := p.logger.With("retry", pass)
+		logger.Info("Retrying objects that failed to sync", "objects", len(paths))
+		select {
+		case <-time.After(time.Duration(pass) * p.retry.Delay):
+		case <-p.parent.Done():
+			return
+		}
+
+		files, err := p.readRepoFiles(paths)
+		if err != nil {
+			logger.Warn("Failed to read objects to retry", "err", err)
+			return
+		}
+		retry := Aggregate(p.push(feedRepoFiles(p.ctx, files)), p.aggOptions(PhaseRetry, logger)...)
+
+		report.Retried += uint(len(paths))
+		report.BatchErrors += retry.BatchErrors
+		report.Sent.FileNumb += retry.Sent.FileNumb
+		report.Sent.ObjNumb += retry.Sent.ObjNumb
+		report.Sent.Bytes += retry.Sent.Bytes
+		report.Sent.DedupNumb += retry.Sent.DedupNumb
+		report.Sent.DedupBytes += retry.Sent.DedupBytes
+		report.Synced.UploadSyncedFileNumb += retry.Synced.UploadSyncedFileNumb
+		if fixed := uint32(len(paths) - len(retry.Failures)); fixed < report.Synced.SyncFailedNumb {
+			report.Synced.SyncFailedNumb -= fixed
+		} else {
+			report.Synced.SyncFailedNumb = uint32(len(retry.Failures))
+		}
+		report.Failures = retry.Failures
+	}
+}
+
+// crcRepoFiles calculates CRC, and optionally SHA-256, of given repo files
+func crcRepoFiles(repoDir string, paths []string, withSHA256 bool) ([]*oshub.RepoFile, error) {
+	hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	var shaHasher hash.Hash
+	if withSHA256 {
+		shaHasher = sha256.New()
+	}
+	files := make([]*oshub.RepoFile, 0, len(paths))
+	for _, path := range paths {
+		fullPath := filepath.Join(repoDir, filepath.FromSlash(path))
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := newRepoPath(fullPath, path, info)
+		if err != nil {
+			return nil, err
+		}
+		crc, digest := crcFile(hasher, shaHasher, rp)
+		files = append(files, &oshub.RepoFile{Path: path, CRC32: crc, SHA256: digest, Size: rp.size})
+	}
+	return files, nil
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package fiopush
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// raiseFileLimit raises the soft limit of open files of the process up to the hard one and returns the limit in effect
+func raiseFileLimit() (uint64, error) {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim); err != nil {
+		return 0, err
+	}
+	if lim.Cur < lim.Max {
+		raised := lim
+		raised.Cur = raised.Max
+		if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &raised); err == nil {
+			return uint64(raised.Cur), nil
+		}
+		// e.g. macOS refuses a soft limit above OPEN_MAX even if the hard one is unlimited
+	}
+	return uint64(lim.Cur), nil
+}
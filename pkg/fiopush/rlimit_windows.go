@@ -0,0 +1,6 @@
+package fiopush
+
+// raiseFileLimit returns zero as Windows doesn't limit open files of a process the way unix does
+func raiseFileLimit() (uint64, error) {
+	return 0, nil
+}
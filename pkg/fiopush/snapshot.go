@@ -0,0 +1,57 @@
+package fiopush
+
+import (
+	"sync"
+)
+
+type (
+	// statusSnapshot accumulates events of all phases of a push, so the push state can be polled
+	statusSnapshot struct {
+		mu     sync.Mutex
+		report Report
+	}
+)
+
+func (s *statusSnapshot) apply(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	applyEvent(&s.report, e)
+}
+
+func (s *statusSnapshot) reset(session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = Report{Session: session}
+}
+
+func (s *statusSnapshot) get() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.report
+	if s.report.Failures != nil {
+		r.Failures = make(map[string]string, len(s.report.Failures))
+		for path, reason := range s.report.Failures {
+			r.Failures[path] = reason
+		}
+	}
+	if s.report.Corrupted != nil {
+		r.Corrupted = make(map[string]string, len(s.report.Corrupted))
+		for path, reason := range s.report.Corrupted {
+			r.Corrupted[path] = reason
+		}
+	}
+	return r
+}
+
+// Abort stops the push, batches in flight are cancelled and Wait returns a partial report marked as interrupted,
+// the pusher can't be run again afterwards
+func (p *pusher) Abort() {
+	p.logger.Warn("Aborting the push", "session", p.session)
+	p.abort()
+}
+
+// StatusSnapshot returns cumulative counters of the current push, including its retry passes and the refs push,
+// they are updated while Wait consumes the push events
+func (p *pusher) StatusSnapshot() Report {
+	return p.snapshot.get()
+}
@@ -0,0 +1,88 @@
+package fiopush
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SpoolRepoStream extracts a TAR stream of a repo, e.g. made on another machine and piped to stdin, to a given
+// directory and returns files to push as WithRepoFiles expects them. The stream can be gzip compressed. CRC32C
+// of files is calculated while they are extracted, so the directory isn't walked again, SHA-256 digests are
+// taken from the stream if it's made by fiopush and calculated otherwise. Files that are never pushed, e.g.
+// ostree staging files, are extracted but not returned, given filters select files the way ScanRepo does.
+func SpoolRepoStream(r io.Reader, dstDir string, withSHA256 bool, filters ...PathFilter) ([]*oshub.RepoFile, error) {
+	filter := defaultRepoFilter(mergePathFilters(filters))
+	in := bufio.NewReader(r)
+	var tarStream io.Reader = in
+	if magic, err := in.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the gzip compressed repo stream: %s", err.Error())
+		}
+		defer gz.Close()
+		tarStream = gz
+	}
+
+	var failure error
+	queue := oshub.Untar(tar.NewReader(tarStream), dstDir, nil, oshub.WithFailureHandler(func(err error) {
+		failure = err
+	}))
+	var files []*oshub.RepoFile
+	var rejected *oshub.RepoFile
+	for f := range queue {
+		if f.Err() != "" && rejected == nil {
+			rejected = f
+		}
+		if filter.match(f.Path) {
+			files = append(files, f)
+		}
+	}
+	if failure != nil {
+		return nil, fmt.Errorf("failed to extract the repo stream: %s", failure.Error())
+	}
+	if rejected != nil {
+		return nil, fmt.Errorf("the repo stream is corrupted, %s: %s", rejected.Path, rejected.Err())
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("the repo stream contains no repo files")
+	}
+
+	var toDigest []string
+	for _, f := range files {
+		fullPath := filepath.Join(dstDir, filepath.FromSlash(f.Path))
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := newRepoPath(fullPath, f.Path, info)
+		if err != nil {
+			return nil, err
+		}
+		f.Size = rp.size
+		if withSHA256 && f.SHA256 == "" {
+			toDigest = append(toDigest, f.Path)
+		}
+	}
+	if len(toDigest) > 0 {
+		digested, err := crcRepoFiles(dstDir, toDigest, true)
+		if err != nil {
+			return nil, err
+		}
+		digests := make(map[string]string, len(digested))
+		for _, f := range digested {
+			digests[f.Path] = f.SHA256
+		}
+		for _, f := range files {
+			if digest, ok := digests[f.Path]; ok {
+				f.SHA256 = digest
+			}
+		}
+	}
+	return files, nil
+}
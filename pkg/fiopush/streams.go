@@ -0,0 +1,119 @@
+package fiopush
+
+import (
+	"context"
+	"foundriesio/ostreehub/pkg/oshub"
+	"sort"
+	"sync"
+)
+
+// WithStreams makes Pusher split each batch into a given number of TAR streams pushed in parallel,
+// e.g. so a single batch of large objects can saturate a high-bandwidth link, a batch is sent as a single stream by default
+func WithStreams(n int) Option {
+	return func(p *pusher) {
+		if n > 0 {
+			p.streams = n
+		}
+	}
+}
+
+// sendStreams sends a batch as several TAR streams in parallel and merges their reports
+func (p *pusher) sendStreams(ctx context.Context, files map[string]uint32, sizes map[string]int64, tarOpts []oshub.TarOption,
+	encoding string, resumable bool, logger Logger) (*oshub.SendReport, *oshub.SyncReport, bool) {
+	parts := splitBatch(files, sizes, p.streams)
+	if len(parts) == 1 {
+		return p.sendBatch(ctx, files, tarOpts, encoding, resumable, logger)
+	}
+	sendReports := make([]*oshub.SendReport, len(parts))
+	syncReports := make([]*oshub.SyncReport, len(parts))
+	oks := make([]bool, len(parts))
+	var wg sync.WaitGroup
+	for ii, part := range parts {
+		wg.Add(1)
+		go func(ii int, part map[string]uint32) {
+			defer wg.Done()
+			sendReports[ii], syncReports[ii], oks[ii] = p.sendBatch(ctx, part, tarOpts, encoding, resumable, logger.With("stream", ii+1))
+		}(ii, part)
+	}
+	wg.Wait()
+
+	sendReport := &oshub.SendReport{}
+	syncReport := &oshub.SyncReport{}
+	ok := true
+	for ii := range parts {
+		mergeSendReport(sendReport, sendReports[ii])
+		mergeSyncReport(syncReport, syncReports[ii])
+		ok = ok && oks[ii]
+	}
+	return sendReport, syncReport, ok
+}
+
+// splitBatch splits files of a batch into at most n parts of about the same size, the largest files are
+// assigned first, each to the part that is the smallest so far
+func splitBatch(files map[string]uint32, sizes map[string]int64, n int) []map[string]uint32 {
+	if n > len(files) {
+		n = len(files)
+	}
+	if n <= 1 {
+		return []map[string]uint32{files}
+	}
+	paths := make([]string, 0, len(files))
+	for file := range files {
+		paths = append(paths, file)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if sizes[paths[i]] != sizes[paths[j]] {
+			return sizes[paths[i]] > sizes[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	parts := make([]map[string]uint32, n)
+	partBytes := make([]int64, n)
+	for ii := range parts {
+		parts[ii] = make(map[string]uint32)
+	}
+	for _, file := range paths {
+		smallest := 0
+		for ii := range parts {
+			if partBytes[ii] < partBytes[smallest] || (partBytes[ii] == partBytes[smallest] && len(parts[ii]) < len(parts[smallest])) {
+				smallest = ii
+			}
+		}
+		parts[smallest][file] = files[file]
+		partBytes[smallest] += sizes[file]
+	}
+	return parts
+}
+
+func mergeSendReport(total *oshub.SendReport, r *oshub.SendReport) {
+	total.FileNumb += r.FileNumb
+	total.ObjNumb += r.ObjNumb
+	total.Bytes += r.Bytes
+	total.DedupNumb += r.DedupNumb
+	total.DedupBytes += r.DedupBytes
+	if total.Err == "" {
+		total.Err = r.Err
+	}
+}
+
+// mergeSyncReport adds a report of one stream of a batch to the batch report, the staging mode of the batch
+// is the one of the stream that bypassed a local disk the most
+func mergeSyncReport(total *oshub.SyncReport, r *oshub.SyncReport) {
+	addSyncReport(total, r)
+	total.StreamedFileNumb += r.StreamedFileNumb
+	total.Changed = append(total.Changed, r.Changed...)
+	for path, reason := range r.Failures {
+		if total.Failures == nil {
+			total.Failures = make(map[string]string)
+		}
+		total.Failures[path] = reason
+	}
+	switch {
+	case total.StagingMode == oshub.StagingStream || r.StagingMode == oshub.StagingStream:
+		total.StagingMode = oshub.StagingStream
+	case total.StagingMode == oshub.StagingSpill || r.StagingMode == oshub.StagingSpill:
+		total.StagingMode = oshub.StagingSpill
+	default:
+		total.StagingMode = r.StagingMode
+	}
+}
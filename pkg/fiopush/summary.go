@@ -0,0 +1,146 @@
+package fiopush
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// PushRecord is an outcome of a single push, Failure is one of Failure* categories if the push has failed
+	PushRecord struct {
+		Factory  string
+		Start    time.Time
+		Duration time.Duration
+		Report   *Report
+		Failure  string
+		Err      string
+	}
+
+	FactorySummary struct {
+		Factory     string  `json:"factory"`
+		Pushes      int     `json:"pushes"`
+		AvgDuration float64 `json:"avg_duration_sec"`
+		MaxDuration float64 `json:"max_duration_sec"`
+	}
+
+	// FleetSummary aggregates pushes made within a time window, e.g. by a daemon pushing several repos
+	FleetSummary struct {
+		From       time.Time        `json:"from"`
+		To         time.Time        `json:"to"`
+		Pushes     int              `json:"pushes"`
+		Failed     int              `json:"failed"`
+		SentBytes  int64            `json:"sent_bytes"`
+		Checked    uint             `json:"checked"`
+		Sent       uint             `json:"sent"`
+		DedupFiles uint             `json:"dedup_files"`
+		DedupRatio float64          `json:"dedup_ratio"`
+		Failures   map[string]int   `json:"failures"`
+		Slowest    []FactorySummary `json:"slowest_factories"`
+	}
+
+	// SummaryCollector keeps records of pushes made within a sliding time window
+	SummaryCollector struct {
+		window  time.Duration
+		mu      sync.Mutex
+		records []PushRecord
+	}
+)
+
+const (
+	FailureRun      string = "run"
+	FailurePush     string = "push"
+	FailureSync     string = "sync"
+	FailureFinalize string = "finalize"
+
+	slowestFactoryNumb = 5
+)
+
+func NewSummaryCollector(window time.Duration) *SummaryCollector {
+	return &SummaryCollector{window: window}
+}
+
+func (s *SummaryCollector) Add(r PushRecord) {
+	if r.Failure == "" && r.Report != nil && (r.Report.Synced.SyncFailedNumb > 0 || r.Report.BatchErrors > 0) {
+		r.Failure = FailureSync
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+}
+
+func (s *SummaryCollector) Summary() *FleetSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	from := now.Add(-s.window)
+	// records are appended in order of completion, drop the ones that fell out of the window
+	first := 0
+	for first < len(s.records) && s.records[first].Start.Add(s.records[first].Duration).Before(from) {
+		first++
+	}
+	s.records = s.records[first:]
+
+	summary := &FleetSummary{From: from, To: now, Failures: make(map[string]int)}
+	factories := make(map[string]*FactorySummary)
+	for _, r := range s.records {
+		summary.Pushes += 1
+		if r.Failure != "" {
+			summary.Failed += 1
+			summary.Failures[r.Failure] += 1
+		}
+		if r.Report != nil {
+			summary.SentBytes += r.Report.Sent.Bytes
+			summary.Checked += r.Report.Checked
+			summary.Sent += r.Report.Sent.FileNumb
+		}
+		f, ok := factories[r.Factory]
+		if !ok {
+			f = &FactorySummary{Factory: r.Factory}
+			factories[r.Factory] = f
+		}
+		f.Pushes += 1
+		f.AvgDuration += r.Duration.Seconds()
+		if r.Duration.Seconds() > f.MaxDuration {
+			f.MaxDuration = r.Duration.Seconds()
+		}
+	}
+	if summary.Checked > summary.Sent {
+		summary.DedupFiles = summary.Checked - summary.Sent
+		summary.DedupRatio = float64(summary.DedupFiles) / float64(summary.Checked)
+	}
+
+	for _, f := range factories {
+		f.AvgDuration /= float64(f.Pushes)
+		summary.Slowest = append(summary.Slowest, *f)
+	}
+	sort.Slice(summary.Slowest, func(i, j int) bool {
+		return summary.Slowest[i].AvgDuration > summary.Slowest[j].AvgDuration
+	})
+	if len(summary.Slowest) > slowestFactoryNumb {
+		summary.Slowest = summary.Slowest[:slowestFactoryNumb]
+	}
+	return summary
+}
+
+// WriteFile stores the current summary as a JSON artifact
+func (s *SummaryCollector) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s.Summary(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Handler serves the current summary as JSON
+func (s *SummaryCollector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.Summary()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package fiopush
+
+import (
+	"context"
+	"foundriesio/ostreehub/pkg/oshub"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// for how long a request throttled by the hub is made again, see oshub.RequestLimiter
+	maxThrottledPeriod = 5 * time.Minute
+	// bounds of a delay before a throttled request is made again, the hub specifies it in Retry-After
+	minThrottleDelay = 1 * time.Second
+	maxThrottleDelay = 1 * time.Minute
+)
+
+type (
+	// pushResponse is a response to a TAR stream pushed to the hub, retryAfter is set if the hub has throttled the push.
+	// ok is set only if the hub has responded with 200 and a SyncReport, otherwise the report is empty and
+	// nothing is known about files of the stream
+	pushResponse struct {
+		report     *oshub.SyncReport
+		retryAfter time.Duration
+		ok         bool
+	}
+)
+
+// retryAfter returns a delay specified in Retry-After header of a given response, either in seconds or as a date,
+// a random jitter is added so concurrent pushers throttled at once don't hit the hub at once again
+func retryAfter(resp *http.Response) time.Duration {
+	d := minThrottleDelay
+	v := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	if d < minThrottleDelay {
+		d = minThrottleDelay
+	}
+	if d > maxThrottleDelay {
+		d = maxThrottleDelay
+	}
+	return d + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// doThrottled makes a request and makes it again after the delay the hub asks for if the hub throttles it,
+// newReq is called for each attempt as a request body can be read only once
+func (p *pusher) doThrottled(ctx context.Context, newReq func() (*http.Request, error), logger Logger) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || time.Since(start) > p.retry.MaxThrottledPeriod {
+			return resp, err
+		}
+		delay := retryAfter(resp)
+		resp.Body.Close()
+		logger.Warn("OSTree Hub throttled a request, retrying", "url", req.URL.Path, "after", delay, "attempt", attempt)
+		p.tuner.throttle()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
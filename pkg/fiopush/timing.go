@@ -0,0 +1,101 @@
+package fiopush
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Timing reports how long a push and its phases took, check and send times are summed up over all batches
+	// which are pushed concurrently, so they can exceed the push duration
+	Timing struct {
+		Duration   float64 `json:"duration_sec"`
+		Check      float64 `json:"check_sec"`
+		Send       float64 `json:"send_sec"`
+		Throughput float64 `json:"throughput_bytes_per_sec"`
+		Batches    int     `json:"batches"`
+		BatchMin   float64 `json:"batch_min_sec"`
+		BatchAvg   float64 `json:"batch_avg_sec"`
+		BatchP95   float64 `json:"batch_p95_sec"`
+		BatchMax   float64 `json:"batch_max_sec"`
+
+		// latencies of batches, they are kept to merge timings of several pushes
+		latencies []time.Duration
+	}
+
+	// pushTimer measures a push and its batches, it's safe for concurrent use by batch goroutines
+	pushTimer struct {
+		start     time.Time
+		mu        sync.Mutex
+		check     time.Duration
+		send      time.Duration
+		latencies []time.Duration
+	}
+)
+
+func newPushTimer() *pushTimer {
+	return &pushTimer{start: time.Now()}
+}
+
+// batch records durations of a batch check and send steps
+func (t *pushTimer) batch(check time.Duration, send time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.check += check
+	t.send += send
+	t.latencies = append(t.latencies, check+send)
+}
+
+// timing returns timing of the push so far, sentBytes are bytes sent to the hub
+func (t *pushTimer) timing(sentBytes int64) *Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := &Timing{
+		Duration:  time.Since(t.start).Seconds(),
+		Check:     t.check.Seconds(),
+		Send:      t.send.Seconds(),
+		latencies: append([]time.Duration{}, t.latencies...),
+	}
+	timing.setStats(sentBytes)
+	return timing
+}
+
+// setStats calculates throughput and batch latency statistics
+func (t *Timing) setStats(sentBytes int64) {
+	if t.Duration > 0 {
+		t.Throughput = float64(sentBytes) / t.Duration
+	}
+	t.Batches = len(t.latencies)
+	if t.Batches == 0 {
+		return
+	}
+	sorted := append([]time.Duration{}, t.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	t.BatchMin = sorted[0].Seconds()
+	t.BatchMax = sorted[len(sorted)-1].Seconds()
+	t.BatchAvg = (sum / time.Duration(len(sorted))).Seconds()
+	t.BatchP95 = sorted[(len(sorted)*95+99)/100-1].Seconds()
+}
+
+// mergeTiming adds a timing of a push to the timing of pushes made concurrently, e.g. of several repos
+func mergeTiming(total *Timing, t *Timing, sentBytes int64) *Timing {
+	if t == nil {
+		return total
+	}
+	if total == nil {
+		total = &Timing{}
+	}
+	if t.Duration > total.Duration {
+		total.Duration = t.Duration
+	}
+	total.Check += t.Check
+	total.Send += t.Send
+	total.latencies = append(total.latencies, t.latencies...)
+	total.setStats(sentBytes)
+	return total
+}
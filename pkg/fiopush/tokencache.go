@@ -0,0 +1,181 @@
+package fiopush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type (
+	cachedToken struct {
+		Token   string    `json:"access_token"`
+		Expires time.Time `json:"expires_at"`
+		// set if the auth server has issued one, the next token is obtained by it
+		RefreshToken string `json:"refresh_token,omitempty"`
+	}
+
+	// tokenCache keeps tokens between runs
+	tokenCache interface {
+		read(auth *OAuth2) (*cachedToken, error)
+		write(auth *OAuth2, tok *cachedToken) error
+	}
+
+	// fileTokenCache caches tokens in files under $XDG_CACHE_HOME/fiopush
+	fileTokenCache struct{}
+
+	// keyringTokenCache caches tokens in the OS keyring instead of plaintext files
+	keyringTokenCache struct{}
+)
+
+const (
+	// a cached token is not used if it expires sooner than this, so it doesn't expire in the middle of a push,
+	// a pusher obtains a new token once its token gets this close to its expiry
+	tokenExpiryMargin = 5 * time.Minute
+	// expiry of a token is counted from the moment it has been requested less this allowance,
+	// so neither a slow response nor an auth server clock running ahead make the token outlive its expiry
+	tokenClockSkew = 30 * time.Second
+)
+
+// GetCachedOAuthToken returns an OAuth token cached on disk by a previous run if it's still valid,
+// otherwise it obtains a new token and caches it under $XDG_CACHE_HOME/fiopush
+func GetCachedOAuthToken(auth *OAuth2) (string, error) {
+	tok, err := oauthToken(auth, fileTokenCache{})
+	if err != nil {
+		return "", err
+	}
+	return tok.Token, nil
+}
+
+// oauthToken returns a valid token of a client, either the cached one, one obtained by the refresh token
+// if the auth server has issued it, or a new one obtained by the client credentials grant
+func oauthToken(auth *OAuth2, cache tokenCache) (*cachedToken, error) {
+	cached, err := cache.read(auth)
+	if err == nil && time.Until(cached.Expires) > tokenExpiryMargin {
+		return cached, nil
+	}
+
+	refresh := auth.RefreshToken
+	if cached != nil && cached.RefreshToken != "" {
+		refresh = cached.RefreshToken
+	}
+	start := time.Now()
+	var tok *OAuthToken
+	if refresh != "" {
+		tok, err = refreshOAuthToken(auth, refresh)
+		if err != nil && auth.Secret == "" {
+			// a public client has no other way to obtain a token
+			return nil, err
+		}
+		if err == nil && tok.RefreshToken == "" {
+			// the auth server doesn't rotate refresh tokens
+			tok.RefreshToken = refresh
+		}
+	}
+	if tok == nil {
+		if tok, err = requestOAuthToken(auth); err != nil {
+			return nil, err
+		}
+	}
+	ct := &cachedToken{Token: tok.Token, RefreshToken: tok.RefreshToken}
+	if tok.Expires > 0 {
+		ct.Expires = start.Add(time.Duration(tok.Expires)*time.Second - tokenClockSkew)
+	}
+	if tok.Expires > 0 {
+		// failure to cache a token is not fatal, the next run just requests a new one
+		_ = cache.write(auth, ct)
+	}
+	return ct, nil
+}
+
+// tokenCacheFile returns a file a token of a given client is cached at, the file name is derived
+// from the auth server and the client ID so tokens of different factories don't clash
+func tokenCacheFile(auth *OAuth2) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fiopush", tokenCacheKey(auth)+".json"), nil
+}
+
+// tokenCacheKey identifies tokens of a given client without revealing its ID
+func tokenCacheKey(auth *OAuth2) string {
+	key := sha256.Sum256([]byte(auth.Server + "\n" + auth.ID))
+	return hex.EncodeToString(key[:])
+}
+
+func (fileTokenCache) read(auth *OAuth2) (*cachedToken, error) {
+	cacheFile, err := tokenCacheFile(auth)
+	if err != nil {
+		return nil, err
+	}
+	return readCachedToken(cacheFile)
+}
+
+func (fileTokenCache) write(auth *OAuth2, tok *cachedToken) error {
+	cacheFile, err := tokenCacheFile(auth)
+	if err != nil {
+		return err
+	}
+	return writeCachedToken(cacheFile, tok)
+}
+
+func (keyringTokenCache) read(auth *OAuth2) (*cachedToken, error) {
+	data, err := keyringGet(keyringTokenPrefix + tokenCacheKey(auth))
+	if err != nil {
+		return nil, err
+	}
+	var tok cachedToken
+	if err := json.Unmarshal([]byte(data), &tok); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a cached token: %s", err.Error())
+	}
+	return &tok, nil
+}
+
+func (keyringTokenCache) write(auth *OAuth2, tok *cachedToken) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return keyringSet(keyringTokenPrefix+tokenCacheKey(auth), "fiopush OAuth token of "+auth.Server, string(data))
+}
+
+func readCachedToken(cacheFile string) (*cachedToken, error) {
+	data, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var tok cachedToken
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a cached token: %s", err.Error())
+	}
+	return &tok, nil
+}
+
+func writeCachedToken(cacheFile string, tok *cachedToken) error {
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so concurrent runs never read a partially written token
+	tmp, err := ioutil.TempFile(filepath.Dir(cacheFile), ".token-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cacheFile)
+}
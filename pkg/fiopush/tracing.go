@@ -0,0 +1,18 @@
+package fiopush
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+)
+
+var (
+	// spans are recorded only if an application sets an OpenTelemetry tracer provider,
+	// a trace context is propagated to OSTree Hub if the application sets a text map propagator
+	tracer = otel.Tracer("foundriesio/ostreehub/pkg/fiopush")
+)
+
+func injectTraceContext(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
@@ -0,0 +1,144 @@
+package fiopush
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type (
+	// TransactionMode specifies what Pusher does if an ostree transaction is in progress in the repo,
+	// e.g. a build is committing to it, pushing it would race with the commit
+	TransactionMode int
+)
+
+const (
+	// TransactionFail makes Run fail, a transaction left by a crashed process is ignored with a warning
+	TransactionFail TransactionMode = iota
+	// TransactionWait makes Run wait until no transaction is in progress and the repo stays unchanged for a while
+	TransactionWait
+	// TransactionIgnore makes Run push the repo regardless, staging files are skipped anyway
+	TransactionIgnore
+
+	// for how long the repo has to stay unchanged before it's pushed in TransactionWait mode
+	defaultQuiescence = 10 * time.Second
+	// how often the repo is checked while waiting for quiescence
+	quiescencePollInterval = time.Second
+)
+
+// checkTransaction makes sure no ostree transaction is in progress in the repo according to the transaction mode
+func (p *pusher) checkTransaction() error {
+	switch p.txnMode {
+	case TransactionIgnore:
+		return nil
+	case TransactionWait:
+		p.logger.Debug("Waiting for the repo to become quiescent", "period", p.quiescence)
+		return WaitRepoQuiescent(p.parent, p.repo, p.quiescence)
+	}
+	// the repo mode doesn't matter, so repos whose config doesn't specify it are fine
+	r := &ostree.Repo{Dir: p.repo}
+	txn, err := r.Transaction()
+	if err != nil {
+		return err
+	}
+	if txn != nil && !txn.Alive {
+		p.logger.Warn("The repo has a transaction of an exited process, ignoring it", "pid", txn.PID)
+	} else if txn != nil {
+		return fmt.Errorf("an ostree transaction of process %d is in progress in %s, "+
+			"wait until it finishes or make Pusher wait for it", txn.PID, p.repo)
+	}
+	return checkPartialRefs(r)
+}
+
+// checkPartialRefs fails if a ref points to a commit whose objects haven't been all written yet
+func checkPartialRefs(r *ostree.Repo) error {
+	partial, err := r.PartialCommits()
+	if err != nil || len(partial) == 0 {
+		return err
+	}
+	refs, err := r.Refs()
+	if err != nil {
+		return err
+	}
+	isPartial := make(map[string]bool)
+	for _, c := range partial {
+		isPartial[c] = true
+	}
+	for ref, commit := range refs {
+		if isPartial[commit] {
+			return fmt.Errorf("ref %s points to commit %s which is not complete yet", ref, commit)
+		}
+	}
+	return nil
+}
+
+// WaitRepoQuiescent waits until no ostree transaction is in progress in a repo and neither its refs
+// nor its object directories change for a given period, e.g. to push a repo a build may still be committing to
+func WaitRepoQuiescent(ctx context.Context, repoDir string, period time.Duration) error {
+	r := &ostree.Repo{Dir: repoDir}
+	var last [sha256.Size]byte
+	stableSince := time.Now()
+	ticker := time.NewTicker(quiescencePollInterval)
+	defer ticker.Stop()
+	for {
+		state, busy, err := repoState(r)
+		if err != nil {
+			return err
+		}
+		if busy || state != last {
+			last = state
+			stableSince = time.Now()
+		} else if time.Since(stableSince) >= period {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for %s to become quiescent: %s", repoDir, ctx.Err())
+		}
+	}
+}
+
+// repoState returns a digest of refs and modification times of object directories of a repo,
+// ostree adds objects to the latter, along with whether a transaction is in progress or a ref is partial
+func repoState(r *ostree.Repo) ([sha256.Size]byte, bool, error) {
+	var state [sha256.Size]byte
+	txn, err := r.Transaction()
+	if err != nil {
+		return state, false, err
+	}
+	if txn != nil && txn.Alive {
+		return state, true, nil
+	}
+	if err := checkPartialRefs(r); err != nil {
+		return state, true, nil
+	}
+
+	refs, err := r.Refs()
+	if err != nil {
+		return state, false, err
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, ref := range names {
+		fmt.Fprintf(h, "%s %s\n", ref, refs[ref])
+	}
+	dirs, err := ioutil.ReadDir(filepath.Join(r.Dir, "objects"))
+	if err != nil {
+		return state, false, fmt.Errorf("failed to read the repo objects: %s", err.Error())
+	}
+	for _, d := range dirs {
+		fmt.Fprintf(h, "%s %d\n", d.Name(), d.ModTime().UnixNano())
+	}
+	copy(state[:], h.Sum(nil))
+	return state, false, nil
+}
@@ -0,0 +1,128 @@
+package fiopush
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidateCreds checks credentials step by step, parsing the archive, resolving the factory and URLs,
+// obtaining an OAuth token and making an authenticated no-op request to the hub. It stops at the first
+// failed step, so the last diagnosis tells why pushes by the credentials fail.
+func ValidateCreds(creds string) []Diagnosis {
+	var res []Diagnosis
+	add := func(check string, status DiagnosisStatus, detail string, fix string) {
+		res = append(res, Diagnosis{Check: check, Status: status, Detail: detail, Fix: fix})
+	}
+
+	files, err := readCredFiles(creds)
+	if err != nil {
+		add("archive", DiagnosisFail, strings.TrimSpace(err.Error()),
+			"Specify a credential archive downloaded for the factory, a treehub.json file or its content")
+		return res
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	add("archive", DiagnosisOK, fmt.Sprintf("%s, files: %s", credsName(creds), strings.Join(names, ", ")), "")
+
+	info, err := parseTreehubInfo(files, credsName(creds))
+	if err != nil {
+		add("treehub.json", DiagnosisFail, strings.TrimSpace(err.Error()), "Download the credential archive again")
+		return res
+	}
+	add("treehub.json", DiagnosisOK, "parsed", "")
+
+	u, err := url.Parse(info.Server.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		add("server URL", DiagnosisFail, fmt.Sprintf("invalid server URL: %q", info.Server.URL),
+			"treehub.json must specify ostree.server like https://api.foundries.io/ota/treehub/<factory>/api/v3/")
+		return res
+	}
+	add("server URL", DiagnosisOK, info.Server.URL, "")
+
+	factory, err := deriveFactory(u.Path, files)
+	if err != nil {
+		add("factory", DiagnosisFail, strings.TrimSpace(err.Error()),
+			"Make sure the server URL or tufrepo.url of the archive specifies the factory")
+		return res
+	}
+	add("factory", DiagnosisOK, factory, "")
+
+	hub, err := ExtractUrlAndFactory(creds)
+	if err != nil {
+		add("auth", DiagnosisFail, strings.TrimSpace(err.Error()), "Download the credential archive again")
+		return res
+	}
+	reqUrl, err := repoUrl(hub)
+	if err != nil {
+		add("auth", DiagnosisFail, err.Error(), "")
+		return res
+	}
+	switch {
+	case hub.Auth != nil:
+		add("auth", DiagnosisOK, fmt.Sprintf("oauth2 client %s at %s", hub.Auth.ID, hub.Auth.Server), "")
+	case hub.HMAC != nil:
+		add("auth", DiagnosisOK, fmt.Sprintf("requests are signed by HMAC key %s", hub.HMAC.ID), "")
+	case hub.Basic != nil:
+		add("auth", DiagnosisOK, fmt.Sprintf("basic auth as %s", hub.Basic.User), "")
+	case hub.TLS != nil:
+		add("auth", DiagnosisOK, "client certificate", "")
+	default:
+		add("auth", DiagnosisWarn, "the archive specifies no auth", "")
+	}
+
+	token := ""
+	if hub.Auth != nil {
+		start := time.Now()
+		var tok *OAuthToken
+		if hub.Auth.RefreshToken != "" {
+			tok, err = refreshOAuthToken(hub.Auth, hub.Auth.RefreshToken)
+		} else {
+			tok, err = requestOAuthToken(hub.Auth)
+		}
+		if err != nil {
+			add("token", DiagnosisFail, strings.TrimSpace(err.Error()),
+				"Check that the client hasn't been revoked or download a new credential archive")
+			return res
+		}
+		detail := "obtained at " + hub.Auth.Server
+		if tok.Expires > 0 {
+			detail += fmt.Sprintf(", expires at %s", start.Add(time.Duration(tok.Expires)*time.Second).Format(time.RFC3339))
+		}
+		add("token", DiagnosisOK, detail, "")
+		token = tok.Token
+	}
+
+	resp, err := checkNoObjects(hub, reqUrl, token)
+	if err != nil {
+		add("hub", DiagnosisFail, err.Error(), "Check network connectivity and firewall rules to "+reqUrl.Host)
+		return res
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		add("hub", DiagnosisOK, fmt.Sprintf("%s accepts the credentials for %s factory", reqUrl.Host, hub.Factory), "")
+	case http.StatusUnauthorized:
+		fix := "The token is issued by an auth server the hub doesn't trust or the credentials belong to another hub"
+		switch {
+		case hub.HMAC != nil:
+			fix = "The hub doesn't know the HMAC key or its secret differs, check the local clock as well"
+		case hub.Basic != nil:
+			fix = "Check the user and the password"
+		}
+		add("hub", DiagnosisFail, resp.Status+", the hub doesn't accept the credentials", fix)
+	case http.StatusForbidden:
+		add("hub", DiagnosisFail, resp.Status+", the credentials are not allowed to push to "+hub.Factory,
+			"Make sure the credentials have the push scope for the factory")
+	case http.StatusNotFound:
+		add("hub", DiagnosisFail, resp.Status, "Check the hub URL and the factory name")
+	default:
+		add("hub", DiagnosisFail, resp.Status, "The hub may be unavailable, try again later")
+	}
+	return res
+}
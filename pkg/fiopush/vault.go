@@ -0,0 +1,189 @@
+package fiopush
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// VaultConfig specifies how to read push credentials from HashiCorp Vault, e.g. on CI runners
+	// where secrets can't be stored in files. It's usually filled from the standard Vault environment variables.
+	VaultConfig struct {
+		// an URL of Vault, e.g. https://vault.example.com:8200
+		Addr string
+		// a Vault token, if it's empty the client logs in by AppRole
+		Token     string
+		Namespace string
+		// AppRole credentials and a mount path of the AppRole auth method, "approle" by default
+		RoleID    string
+		SecretID  string
+		AuthMount string
+		// optional, a PEM file with a CA certificate to verify Vault's certificate by
+		CACert string
+		// an API path of a secret holding the credentials, e.g. secret/data/fiopush/<factory> of a KV v2 engine
+		Path string
+	}
+
+	// VaultCreds are push credentials stored in a Vault secret, either a ready-to-use OAuth token,
+	// e.g. a short-lived one issued by a Vault plugin, or an OAuth2 client secret
+	VaultCreds struct {
+		Token string `json:"token"`
+		// optional, the ones of the credential archive or DefaultAuthServer are used if they aren't set
+		Server       string `json:"server"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+
+	vaultResponse struct {
+		Data json.RawMessage `json:"data"`
+		Auth *struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+		Errors []string `json:"errors"`
+	}
+)
+
+const (
+	defaultVaultAuthMount = "approle"
+	vaultRequestTimeout   = 30 * time.Second
+)
+
+// VaultConfigFromEnv returns a config of a secret at a given API path read from VAULT_ADDR, VAULT_TOKEN,
+// VAULT_NAMESPACE, VAULT_ROLE_ID, VAULT_SECRET_ID, VAULT_AUTH_MOUNT and VAULT_CACERT environment variables
+func VaultConfigFromEnv(path string) *VaultConfig {
+	return &VaultConfig{
+		Addr:      os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		RoleID:    os.Getenv("VAULT_ROLE_ID"),
+		SecretID:  os.Getenv("VAULT_SECRET_ID"),
+		AuthMount: os.Getenv("VAULT_AUTH_MOUNT"),
+		CACert:    os.Getenv("VAULT_CACERT"),
+		Path:      path,
+	}
+}
+
+// FetchVaultCreds reads push credentials from Vault, the secret must specify either token
+// or client_secret field
+func FetchVaultCreds(ctx context.Context, cfg *VaultConfig) (*VaultCreds, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("Vault address is not specified, set VAULT_ADDR\n")
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("A path of the Vault secret is not specified\n")
+	}
+	client, err := vaultClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	token := cfg.Token
+	if token == "" {
+		if cfg.RoleID == "" {
+			return nil, fmt.Errorf("Neither Vault token nor AppRole is specified, set VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID\n")
+		}
+		if token, err = vaultLogin(ctx, client, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := vaultCall(ctx, client, cfg, token, "GET", strings.TrimPrefix(cfg.Path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	// a KV v2 engine nests the secret data along with its metadata
+	var kv2 struct {
+		Data     *VaultCreds     `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	creds := &VaultCreds{}
+	if err := json.Unmarshal(resp.Data, &kv2); err == nil && kv2.Data != nil && kv2.Metadata != nil {
+		creds = kv2.Data
+	} else if err := json.Unmarshal(resp.Data, creds); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal the Vault secret %s: %s\n", cfg.Path, err.Error())
+	}
+	if creds.Token == "" && creds.ClientSecret == "" {
+		return nil, fmt.Errorf("The Vault secret %s specifies neither token nor client_secret\n", cfg.Path)
+	}
+	return creds, nil
+}
+
+// vaultLogin obtains a Vault token by means of the AppRole auth method
+func vaultLogin(ctx context.Context, client *http.Client, cfg *VaultConfig) (string, error) {
+	mount := cfg.AuthMount
+	if mount == "" {
+		mount = defaultVaultAuthMount
+	}
+	body, err := json.Marshal(map[string]string{"role_id": cfg.RoleID, "secret_id": cfg.SecretID})
+	if err != nil {
+		return "", err
+	}
+	resp, err := vaultCall(ctx, client, cfg, "", "POST", "auth/"+strings.Trim(mount, "/")+"/login", body)
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("Vault hasn't issued a token on AppRole login\n")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+func vaultCall(ctx context.Context, client *http.Client, cfg *VaultConfig, token string, method string, path string, body []byte) (*vaultResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, vaultRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(cfg.Addr, "/")+"/v1/"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make a request to Vault: %s\n", err.Error())
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", cfg.Namespace)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make a request to Vault: %s\n", err.Error())
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read a response of Vault: %s\n", err.Error())
+	}
+	var resp vaultResponse
+	if err := json.Unmarshal(data, &resp); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("Failed to unmarshal a response of Vault: %s\n", err.Error())
+	}
+	if res.StatusCode != http.StatusOK {
+		if len(resp.Errors) > 0 {
+			return nil, fmt.Errorf("Vault request to /v1/%s failed: %s, %s\n", path, res.Status, strings.Join(resp.Errors, "; "))
+		}
+		return nil, fmt.Errorf("Vault request to /v1/%s failed: %s\n", path, res.Status)
+	}
+	return &resp, nil
+}
+
+func vaultClient(cfg *VaultConfig) (*http.Client, error) {
+	if cfg.CACert == "" {
+		return http.DefaultClient, nil
+	}
+	caPEM, err := ioutil.ReadFile(cfg.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Vault CA certificate: %s\n", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("Failed to parse Vault CA certificate %s\n", cfg.CACert)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
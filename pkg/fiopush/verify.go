@@ -0,0 +1,34 @@
+package fiopush
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"strings"
+)
+
+// verifyObjectsToSync verifies that checksums of objects the hub lacks match their names, mismatched objects
+// are removed from the given files and returned along with reasons. Objects whose checksum can't be verified,
+// e.g. content objects of bare repos or detached commit metadata, are left to be sent.
+func (p *pusher) verifyObjectsToSync(files map[string]uint32, logger Logger) map[string]string {
+	r := &ostree.Repo{Dir: p.repo}
+	var corrupted map[string]string
+	for path := range files {
+		if !strings.HasPrefix(path, "./objects/") {
+			continue
+		}
+		src := path
+		if p.convertsToArchive() {
+			// content objects converted to archive-z2 ones are verified the way the bare ones are
+			src = oshub.BareObjectPath(path)
+		}
+		if _, err := r.VerifyObjectFile(src); err != nil {
+			logger.Error("The object doesn't match its name, it's not pushed", "object", path, "err", err)
+			if corrupted == nil {
+				corrupted = make(map[string]string)
+			}
+			corrupted[path] = err.Error()
+			delete(files, path)
+		}
+	}
+	return corrupted
+}
@@ -0,0 +1,72 @@
+package fiopush
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	walkFunc func(fullPath string, relPath string, info os.FileInfo) error
+)
+
+// walkRepo walks through regular files and symlinks of an ostree repo and calls fn for each of them.
+// The repo root as well as its top-level entries (e.g. objects/ or refs/) can be symlinks
+// to directories located on a different volume, they are resolved so relative paths
+// passed to fn are always relative to the logical repo root, e.g. ./objects/ab/cdef.filez.
+// Top-level entries matching skipped path prefixes are neither resolved nor walked, e.g. ./tmp/ or
+// ./transaction which is a symlink to nowhere while ostree commits to the repo.
+func walkRepo(repoDir string, skip []string, fn walkFunc) error {
+	root, err := filepath.EvalSymlinks(filepath.Clean(repoDir))
+	if err != nil {
+		return fmt.Errorf("failed to resolve the repo directory %s: %s", repoDir, err.Error())
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("failed to read the repo directory %s: %s", root, err.Error())
+	}
+	for _, entry := range entries {
+		topRelPath := "./" + entry.Name()
+		if isSkipped(topRelPath, skip) || isSkipped(topRelPath+"/", skip) {
+			continue
+		}
+		topPath := filepath.Join(root, entry.Name())
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if topPath, err = filepath.EvalSymlinks(topPath); err != nil {
+				return fmt.Errorf("failed to resolve a symlink %s: %s", entry.Name(), err.Error())
+			}
+		}
+		if err := filepath.Walk(topPath, func(fullPath string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(topPath, fullPath)
+			if err != nil {
+				return err
+			}
+			relPath := topRelPath
+			if rel != "." {
+				relPath += "/" + filepath.ToSlash(rel)
+			}
+			return fn(fullPath, relPath, info)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSkipped returns true if a repo path starts with one of skipped path prefixes
+func isSkipped(path string, skip []string) bool {
+	for _, s := range skip {
+		if strings.HasPrefix(path, s) {
+			return true
+		}
+	}
+	return false
+}
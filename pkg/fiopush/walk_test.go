@@ -0,0 +1,147 @@
+package fiopush
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testObject = "./objects/ab/cdef.commit"
+	testRef    = "./refs/heads/main"
+)
+
+// makeSymlinkedRepo makes a repo whose objects/ and refs/ are symlinks to directories of another "volume",
+// the returned map has repo paths of files mapped to their full paths within the volume or the repo
+func makeSymlinkedRepo(t *testing.T) (string, map[string]string) {
+	repo := tempTestDir(t, "fiopush-repo")
+	volume := tempTestDir(t, "fiopush-volume")
+	files := map[string]string{
+		"./config":   filepath.Join(repo, "config"),
+		testObject:   filepath.Join(volume, "objects", "ab", "cdef.commit"),
+		testRef:      filepath.Join(volume, "refs", "heads", "main"),
+		"./summary":  filepath.Join(repo, "summary"),
+		"./tmp/junk": filepath.Join(repo, "tmp", "junk"),
+	}
+	for _, p := range files {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"objects", "refs"} {
+		if err := os.Symlink(filepath.Join(volume, dir), filepath.Join(repo, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ostree makes it while committing to the repo, it points to nowhere
+	if err := os.Symlink("transaction-00000000", filepath.Join(repo, "transaction")); err != nil {
+		t.Fatal(err)
+	}
+	delete(files, "./tmp/junk")
+	return repo, files
+}
+
+// walkTestRepo returns repo paths of files walkRepo passes on mapped to their full paths, with resolved symlinks
+func walkTestRepo(t *testing.T, repo string, skip []string) (map[string]string, error) {
+	walked := make(map[string]string)
+	err := walkRepo(repo, skip, func(fullPath string, relPath string, info os.FileInfo) error {
+		if info.IsDir() {
+			t.Errorf("a directory is passed on: %s", relPath)
+		}
+		walked[relPath] = fullPath
+		return nil
+	})
+	return walked, err
+}
+
+func evalPaths(t *testing.T, paths map[string]string) map[string]string {
+	res := make(map[string]string, len(paths))
+	for rel, full := range paths {
+		resolved, err := filepath.EvalSymlinks(full)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[rel] = resolved
+	}
+	return res
+}
+
+func TestWalkRepoSymlinkedDirs(t *testing.T) {
+	repo, files := makeSymlinkedRepo(t)
+	walked, err := walkTestRepo(t, repo, repoFileSkip)
+	if err != nil {
+		t.Fatalf("failed to walk the repo: %s", err)
+	}
+	if got, want := evalPaths(t, walked), evalPaths(t, files); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected files of the repo: %v, expected %v", got, want)
+	}
+}
+
+func TestWalkRepoSymlinkedRoot(t *testing.T) {
+	repo, files := makeSymlinkedRepo(t)
+	link := filepath.Join(tempTestDir(t, "fiopush-link"), "repo")
+	if err := os.Symlink(repo, link); err != nil {
+		t.Fatal(err)
+	}
+	walked, err := walkTestRepo(t, link, repoFileSkip)
+	if err != nil {
+		t.Fatalf("failed to walk the repo: %s", err)
+	}
+	if got, want := evalPaths(t, walked), evalPaths(t, files); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected files of the repo: %v, expected %v", got, want)
+	}
+}
+
+func TestWalkRepoSymlinkedObject(t *testing.T) {
+	repo, _ := makeSymlinkedRepo(t)
+	// content objects of bare repos can be symlinks, they are passed on rather than followed
+	link := filepath.Join(repo, "objects", "ab", "0123.file")
+	if err := os.Symlink("/nonexistent", link); err != nil {
+		t.Fatal(err)
+	}
+	var info os.FileInfo
+	err := walkRepo(repo, repoFileSkip, func(fullPath string, relPath string, i os.FileInfo) error {
+		if relPath == "./objects/ab/0123.file" {
+			info = i
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk the repo: %s", err)
+	}
+	if info == nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("the symlinked object isn't passed on as a symlink: %v", info)
+	}
+}
+
+func TestWalkRepoDanglingSymlink(t *testing.T) {
+	repo, _ := makeSymlinkedRepo(t)
+	// ./transaction is skipped by default, otherwise the dangling symlink can't be resolved
+	if _, err := walkTestRepo(t, repo, []string{"./tmp/"}); err == nil || !strings.Contains(err.Error(), "transaction") {
+		t.Fatalf("the dangling symlink hasn't failed the walk: %v", err)
+	}
+	if err := os.Remove(filepath.Join(repo, "transaction")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(repo, "missing"), filepath.Join(repo, "objects-link")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walkTestRepo(t, repo, repoFileSkip); err == nil {
+		t.Fatalf("the dangling top-level symlink hasn't failed the walk")
+	}
+}
+
+func tempTestDir(t *testing.T, prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
@@ -0,0 +1,103 @@
+package fiopush
+
+import (
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type (
+	WatchOption func(*watchConfig)
+
+	watchConfig struct {
+		stampFile string
+	}
+)
+
+// WithStampFile makes WatchRepo trigger on changes of a given stamp file instead of the repo refs,
+// e.g. a marker a build system touches once it has finished updating the repo
+func WithStampFile(stampFile string) WatchOption {
+	return func(c *watchConfig) {
+		c.stampFile = filepath.Clean(stampFile)
+	}
+}
+
+// WatchRepo monitors refs of a given repo and calls onChange once they have stopped changing for the debounce
+// period, e.g. once a build machine has committed to the repo. onChange calls are serialized, refs updated while
+// onChange is running trigger a new call after it returns. WatchRepo returns once the context is done.
+func WatchRepo(ctx context.Context, repoDir string, debounce time.Duration, onChange func(), opts ...WatchOption) error {
+	var cfg watchConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create a file watcher: %s", err.Error())
+	}
+	defer w.Close()
+
+	if cfg.stampFile != "" {
+		// watch the directory rather than the file itself, build systems often replace a stamp file by renaming
+		if err := w.Add(filepath.Dir(cfg.stampFile)); err != nil {
+			return fmt.Errorf("failed to watch the stamp file %s: %s", cfg.stampFile, err.Error())
+		}
+	} else if err := watchTree(w, filepath.Join(repoDir, "refs")); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(debounce)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case event, ok := <-w.Events:
+			if !ok {
+				return fmt.Errorf("file watcher has been closed")
+			}
+			if cfg.stampFile != "" {
+				// touching an existing stamp file changes its attributes only, so it makes a Chmod event
+				if filepath.Clean(event.Name) != cfg.stampFile || event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) == 0 {
+					continue
+				}
+			} else if event.Op&fsnotify.Create != 0 {
+				// refs can be nested, e.g. refs/heads/lmp/qemu, so new directories have to be watched too
+				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+					if err := watchTree(w, event.Name); err != nil {
+						return err
+					}
+				}
+			}
+			timer.Reset(debounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return fmt.Errorf("file watcher has been closed")
+			}
+			return fmt.Errorf("failed to watch the repo refs: %s", err.Error())
+		case <-timer.C:
+			onChange()
+		}
+	}
+}
+
+// watchTree adds a given directory and all its subdirectories to the watcher, fsnotify is not recursive
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if err := w.Add(p); err != nil {
+			return fmt.Errorf("failed to watch %s: %s", p, err.Error())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,53 @@
+package fiopush
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchRepoTouchedStamp(t *testing.T) {
+	dir := tempTestDir(t, "fiopush-watch")
+	stamp := filepath.Join(dir, "stamp")
+	if err := ioutil.WriteFile(stamp, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 1)
+	watched := make(chan error, 1)
+	go func() {
+		watched <- WatchRepo(ctx, dir, 10*time.Millisecond, func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}, WithStampFile(stamp))
+	}()
+	defer func() {
+		cancel()
+		if err := <-watched; err != nil {
+			t.Errorf("failed to watch the stamp file: %s", err)
+		}
+	}()
+
+	// the stamp is touched until the watcher notices it, as the watcher is set up concurrently with the test
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		now := time.Now()
+		if err := os.Chtimes(stamp, now, now); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changed:
+			return
+		case <-timeout:
+			t.Fatalf("touching the existing stamp file hasn't triggered a change")
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package fiopush
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// Identity describes who pushes to a hub would be made by
+	Identity struct {
+		Factory string
+		HubURL  string
+		// how requests are authenticated, e.g. "oauth2", "hmac", "basic", "certificate", "token" or "none"
+		Method string
+		// an OAuth server and a client ID if tokens are obtained by client credentials
+		AuthServer string
+		ClientID   string
+		// a user of basic auth or a key ID of HMAC signatures
+		Principal string
+		// claims of the token if it's a JWT, they are not verified
+		Subject string
+		Scopes  []string
+		Expires time.Time
+	}
+
+	jwtClaims struct {
+		Subject string          `json:"sub"`
+		Scope   string          `json:"scope"`
+		Scp     json.RawMessage `json:"scp"`
+		Expires int64           `json:"exp"`
+	}
+)
+
+// Whoami resolves auth material of a given hub along with options affecting it, e.g. WithToken, WithBasicAuth
+// or WithVaultCreds, the same way Pusher does. An OAuth token is obtained if needed, so its claims are reported too.
+func Whoami(hub *OSTreeHub, opts ...Option) (*Identity, error) {
+	reqUrl, err := repoUrl(hub)
+	if err != nil {
+		return nil, err
+	}
+	p := newPusher(&pusher{url: reqUrl, hub: hub, token: ""}, opts)
+	defer p.abort()
+	// a token given by an option is used as is rather than obtained by the client credentials
+	given := p.token != ""
+	if err := p.auth(); err != nil {
+		return nil, err
+	}
+
+	id := &Identity{Factory: hub.Factory, HubURL: hub.URL, Expires: p.tokenExpires}
+	switch {
+	case p.hub.Basic != nil:
+		id.Method = "basic"
+		id.Principal = p.hub.Basic.User
+	case p.hub.HMAC != nil:
+		id.Method = "hmac"
+		id.Principal = p.hub.HMAC.ID
+	case p.hub.Auth != nil && !given:
+		id.Method = "oauth2"
+		id.AuthServer = p.hub.Auth.Server
+		id.ClientID = p.hub.Auth.ID
+	case p.token != "":
+		id.Method = "token"
+	case p.hub.TLS != nil:
+		id.Method = "certificate"
+	default:
+		id.Method = "none"
+	}
+	if p.token != "" && p.hub.Basic == nil {
+		if claims, ok := parseJWTClaims(p.token); ok {
+			id.Subject = claims.Subject
+			id.Scopes = claims.scopes()
+			if claims.Expires > 0 {
+				id.Expires = time.Unix(claims.Expires, 0)
+			}
+		}
+	}
+	return id, nil
+}
+
+// parseJWTClaims returns claims of a token if it's a JWT, its signature is not verified
+func parseJWTClaims(token string) (*jwtClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, false
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// scopes returns scopes of a token specified either by a space separated scope claim or by a scp claim,
+// the latter is either a list or a space separated string
+func (c *jwtClaims) scopes() []string {
+	if c.Scope != "" {
+		return strings.Fields(c.Scope)
+	}
+	var list []string
+	if err := json.Unmarshal(c.Scp, &list); err == nil {
+		return list
+	}
+	var str string
+	if err := json.Unmarshal(c.Scp, &str); err == nil {
+		return strings.Fields(str)
+	}
+	return nil
+}
+
+func (id *Identity) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Factory:     %s\n", id.Factory)
+	fmt.Fprintf(&b, "Hub:         %s\n", id.HubURL)
+	switch id.Method {
+	case "oauth2":
+		fmt.Fprintf(&b, "Auth:        oauth2 client %s\n", id.ClientID)
+		fmt.Fprintf(&b, "Auth server: %s\n", id.AuthServer)
+	case "basic":
+		fmt.Fprintf(&b, "Auth:        basic auth as %s\n", id.Principal)
+	case "hmac":
+		fmt.Fprintf(&b, "Auth:        HMAC key %s\n", id.Principal)
+	case "token":
+		fmt.Fprintf(&b, "Auth:        a given token\n")
+	case "certificate":
+		fmt.Fprintf(&b, "Auth:        client certificate\n")
+	default:
+		fmt.Fprintf(&b, "Auth:        none\n")
+	}
+	if id.Subject != "" {
+		fmt.Fprintf(&b, "Subject:     %s\n", id.Subject)
+	}
+	if len(id.Scopes) > 0 {
+		fmt.Fprintf(&b, "Scopes:      %s\n", strings.Join(id.Scopes, " "))
+	}
+	if !id.Expires.IsZero() {
+		fmt.Fprintf(&b, "Expires:     %s (in %s)\n", id.Expires.Format(time.RFC3339), time.Until(id.Expires).Round(time.Second))
+	}
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package oshub
+
+import (
+	"archive/tar"
+	"fmt"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WithArchiveConversion makes Tar convert content objects of a bare or bare-user repo of a given mode to archive-z2
+// objects while they are sent, so such a repo can be pushed to a hub serving archive-z2 repos, the repo config
+// is sent with the archive-z2 mode too. Files to send list converted objects by their archived paths, see
+// ArchivedObjectPath, sizes of converted files are given by these paths as well, as a TAR entry size has to be
+// known before its content is made.
+func WithArchiveConversion(mode string, sizes map[string]int64) TarOption {
+	return func(c *tarConfig) {
+		c.archiveMode = mode
+		c.archiveSizes = sizes
+	}
+}
+
+// ArchivedObjectPath returns a path a content object of a bare repo has once it's converted to an archive-z2
+// object, e.g. ./objects/ab/cdef.filez for ./objects/ab/cdef.file, other paths are returned as is
+func ArchivedObjectPath(file string) string {
+	if isObjectOfType(file, ostree.ObjectFile) {
+		return file + "z"
+	}
+	return file
+}
+
+// BareObjectPath returns a path of a content object of a bare repo an archive-z2 object is made of,
+// it's the reverse of ArchivedObjectPath
+func BareObjectPath(file string) string {
+	if isObjectOfType(file, ostree.ObjectFileZ) {
+		return strings.TrimSuffix(file, "z")
+	}
+	return file
+}
+
+func isObjectOfType(file string, t ostree.ObjectType) bool {
+	return strings.HasPrefix(file, "./objects/") && strings.HasSuffix(file, "."+string(t))
+}
+
+// IsConvertedToArchive returns true if a file of a bare repo changes once the repo is converted to an archive-z2 one,
+// i.e. it's a content object or the repo config
+func IsConvertedToArchive(file string) bool {
+	return file == "./config" || isObjectOfType(file, ostree.ObjectFile)
+}
+
+// ArchiveRepoFile writes a file of a bare or bare-user repo of a given mode converted the way it's stored
+// in an archive-z2 repo, see IsConvertedToArchive, the path is relative to the repo root
+func ArchiveRepoFile(w io.Writer, repoDir string, file string, mode string) error {
+	if file != "./config" {
+		return ArchiveObject(w, repoDir, file, mode)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(repoDir, filepath.FromSlash(file)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(ostree.SetConfigMode(data, ostree.ModeArchiveZ2))
+	return err
+}
+
+// ArchiveObject writes a content object of a bare or bare-user repo of a given mode converted to an archive-z2 object,
+// the object path is relative to the repo root, e.g. ./objects/ab/cdef.file. Metadata of the object is read from
+// the file itself for bare repos, and from its user.ostreemeta extended attribute for bare-user repos.
+func ArchiveObject(w io.Writer, repoDir string, file string, mode string) error {
+	p := filepath.Join(repoDir, filepath.FromSlash(file))
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	xattrs, err := readXattrs(p)
+	if err != nil {
+		return fmt.Errorf("failed to read extended attributes of %s: %s", file, err.Error())
+	}
+
+	meta := &ostree.FileMeta{}
+	switch mode {
+	case ostree.ModeBare:
+		meta.Uid, meta.Gid, meta.Mode = unixStat(fi)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if meta.Target, err = os.Readlink(p); err != nil {
+				return err
+			}
+			meta.Target = filepath.ToSlash(meta.Target)
+		}
+		for name, value := range xattrs {
+			meta.Xattrs = append(meta.Xattrs, ostree.Xattr{Name: name, Value: []byte(value)})
+		}
+	case ostree.ModeBareUser:
+		data, ok := xattrs[ostree.BareUserMetaXattr]
+		if !ok {
+			return fmt.Errorf("%s lacks the %s attribute", file, ostree.BareUserMetaXattr)
+		}
+		if meta, err = ostree.ParseBareUserMeta([]byte(data)); err != nil {
+			return err
+		}
+		if meta.IsSymlink() {
+			// a symlink of a bare-user repo is a regular file whose content is the symlink target
+			target, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			meta.Target = string(target)
+		}
+	default:
+		return fmt.Errorf("content objects of %s repos can't be converted to archive-z2 objects", mode)
+	}
+
+	var content io.Reader
+	if fi.Mode().IsRegular() && !meta.IsSymlink() {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		content = f
+	}
+	return ostree.WriteArchivedContent(w, meta, content, fi.Size())
+}
+
+// writeArchivedFile writes a TAR entry of a file of a bare repo converted to the archive-z2 one,
+// it returns a number of bytes of the entry content
+func writeArchivedFile(tw *tar.Writer, repoDir string, file string, crc uint32, cfg *tarConfig) (int64, error) {
+	src := BareObjectPath(file)
+	fi, err := os.Lstat(filepath.Join(repoDir, filepath.FromSlash(src)))
+	if err != nil {
+		return 0, err
+	}
+	size, ok := cfg.archiveSizes[file]
+	if !ok {
+		return 0, fmt.Errorf("the size of the converted file is unknown")
+	}
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       file,
+		Mode:       0644,
+		Size:       size,
+		ModTime:    fi.ModTime(),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{crcPaxRecord: strconv.FormatUint(uint64(crc), 10)},
+	}
+	if digest, ok := cfg.digests[file]; ok {
+		hdr.PAXRecords[shaPaxRecord] = digest
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	if err := ArchiveRepoFile(tw, repoDir, src, cfg.archiveMode); err != nil {
+		return 0, err
+	}
+	// fails if the file has changed since its size was calculated
+	if err := tw.Flush(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// unixMode converts a file mode to a unix one including the file type
+func unixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m&os.ModeSymlink != 0:
+		mode |= 0120000
+	case m.IsDir():
+		mode |= 0040000
+	default:
+		mode |= 0100000
+	}
+	return mode
+}
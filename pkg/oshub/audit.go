@@ -0,0 +1,130 @@
+package oshub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HTTP header carrying an ID of a push session, the same for all requests made by a single push
+	SessionHeader string = "X-Fio-Push-Session"
+	// HTTP header carrying build provenance metadata (git SHA, CI job URL, etc) attached to a push session,
+	// the metadata are encoded as an URL query string, e.g. git_sha=abcd&ci_job=https%3A%2F%2Fci.example.com%2F1
+	MetaHeader string = "X-Fio-Push-Meta"
+)
+
+type (
+	// AuditRecord describes a finalized push session
+	AuditRecord struct {
+		Session   string            `json:"session"`
+		Factory   string            `json:"factory"`
+		Timestamp time.Time         `json:"timestamp"`
+		Meta      map[string]string `json:"meta,omitempty"`
+		Refs      map[string]string `json:"refs,omitempty"`
+	}
+
+	// AuditLog stores audit records of each factory as a JSON lines file in a given directory
+	AuditLog struct {
+		Dir string
+		mu  sync.Mutex
+	}
+)
+
+// EncodeMeta encodes push session metadata to a value of MetaHeader
+func EncodeMeta(meta map[string]string) string {
+	v := url.Values{}
+	for k, val := range meta {
+		v.Set(k, val)
+	}
+	return v.Encode()
+}
+
+// DecodeMeta decodes push session metadata from a value of MetaHeader
+func DecodeMeta(header string) (map[string]string, error) {
+	v, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push metadata: %s", err.Error())
+	}
+	meta := make(map[string]string, len(v))
+	for k := range v {
+		meta[k] = v.Get(k)
+	}
+	return meta, nil
+}
+
+func (a *AuditLog) Record(r *AuditRecord) error {
+	file, err := a.file(r.Factory)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open an audit log: %s", err.Error())
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Records returns audit records of a given factory matching a given session ID (if not empty)
+// and containing all given metadata
+func (a *AuditLog) Records(factory string, session string, meta map[string]string) ([]AuditRecord, error) {
+	file, err := a.file(factory)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open an audit log: %s", err.Error())
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse an audit record: %s", err.Error())
+		}
+		if session != "" && r.Session != session {
+			continue
+		}
+		match := true
+		for k, v := range meta {
+			if r.Meta[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+func (a *AuditLog) file(factory string) (string, error) {
+	if factory == "" || factory != filepath.Base(factory) || strings.HasPrefix(factory, ".") {
+		return "", fmt.Errorf("invalid factory name: %q", factory)
+	}
+	return filepath.Join(a.Dir, factory+".jsonl"), nil
+}
@@ -0,0 +1,24 @@
+package oshub
+
+import (
+	"context"
+)
+
+type (
+	// RepoDirFunc returns a path to a factory ostree repo kept by OSTree Hub
+	RepoDirFunc func(factory string) string
+
+	BucketStatus struct {
+		Bucket string `json:"bucket"`
+		Err    string `json:"error,omitempty"`
+	}
+)
+
+// CheckBucket reports whether the hub can access its GCS bucket
+func (u *Uploader) CheckBucket(ctx context.Context) *BucketStatus {
+	status := &BucketStatus{Bucket: u.bucketName}
+	if _, err := u.bucket.Attrs(ctx); err != nil {
+		status.Err = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,35 @@
+package oshub
+
+import (
+	"time"
+)
+
+type (
+	// BundleManifest describes a bundle, a TAR stream of a whole repo made the same way as a TAR stream pushed to
+	// the hub, so it can be transferred offline and imported later. It's the first entry of the bundle.
+	BundleManifest struct {
+		Version int       `json:"version"`
+		Created time.Time `json:"created"`
+		// a mode of the exported repo, e.g. archive-z2
+		Mode string `json:"mode,omitempty"`
+		// refs of the repo, a map key is a ref path relative to refs/, e.g. heads/main, a value is a commit checksum
+		Refs  map[string]string `json:"refs"`
+		Files []BundleFile      `json:"files"`
+	}
+
+	// BundleFile is a file of a bundle, Path is relative to the repo root, e.g. ./objects/ab/cdef.filez
+	BundleFile struct {
+		Path  string `json:"path"`
+		CRC32 uint32 `json:"crc32"`
+		Size  int64  `json:"size"`
+		// set if the bundle is made with SHA-256 digests
+		SHA256 string `json:"sha256,omitempty"`
+	}
+)
+
+const (
+	// a path of the bundle manifest entry
+	BundleManifestPath string = "./fiopush-bundle.json"
+	// a version of the bundle format made by the current fiopush
+	BundleVersion int = 1
+)
@@ -0,0 +1,64 @@
+package oshub
+
+import (
+	"strings"
+)
+
+const (
+	// HTTP header fiopush and OSTree Hub announce their optional protocol capabilities with
+	CapabilitiesHeader string = "X-Fio-Capabilities"
+	// SHA-256 digest of each repo file is sent along with its CRC32C, Untar rejects files not matching it
+	CapabilitySHA256 string = "sha256"
+	// TAR streams can be uploaded in chunks by means of UploadStore
+	CapabilityResumable string = "resumable"
+	// content objects of bare and bare-user repos, i.e. .file objects, are accepted, hubs lacking it serve
+	// only archive-z2 repos
+	CapabilityBare string = "bare"
+	// objects of streams sent with ForceUploadHeader are uploaded even if the bucket has them, see WithForceUpload
+	CapabilityForce string = "force"
+	// HTTP header fiopush announces a mode of the pushed repo with, e.g. archive-z2 or bare-user
+	RepoModeHeader string = "X-Fio-Repo-Mode"
+	// HTTP header fiopush sets to "true" to make the hub upload all files of a stream regardless of the bucket content
+	ForceUploadHeader string = "X-Fio-Force-Upload"
+
+	crcPaxRecord string = "FIO.ostree.CRC"
+	shaPaxRecord string = "FIO.ostree.SHA256"
+	// the prefix GNU tar and archive/tar store extended attributes of a file under
+	xattrPaxPrefix string = "SCHILY.xattr."
+)
+
+func ParseCapabilities(header string) map[string]bool {
+	caps := make(map[string]bool)
+	for _, c := range strings.Split(header, ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			caps[c] = true
+		}
+	}
+	return caps
+}
+
+func FormatCapabilities(caps ...string) string {
+	return strings.Join(caps, ",")
+}
+
+// NegotiateCapabilities returns a header value listing capabilities requested by a client and supported by a server
+func NegotiateCapabilities(requested string, supported ...string) string {
+	reqCaps := ParseCapabilities(requested)
+	var caps []string
+	for _, c := range supported {
+		if reqCaps[c] {
+			caps = append(caps, c)
+		}
+	}
+	return FormatCapabilities(caps...)
+}
+
+// IsForceUpload tells whether a request header asks to upload all files of a stream regardless of the bucket content
+func IsForceUpload(header string) bool {
+	return isTrue(header)
+}
+
+func isTrue(header string) bool {
+	return strings.EqualFold(strings.TrimSpace(header), "true")
+}
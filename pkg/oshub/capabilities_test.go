@@ -0,0 +1,65 @@
+package oshub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUntarVerifiesSHA256(t *testing.T) {
+	src, err := ioutil.TempDir("", "oshub-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "oshub-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	// files of the same content would be sent as links to the first one, see Tar
+	content := map[string][]byte{}
+	good := "./objects/00/" + strings.Repeat("1", 62) + ".filez"
+	bad := "./objects/00/" + strings.Repeat("2", 62) + ".filez"
+	files := make(map[string]uint32)
+	for _, f := range []string{good, bad} {
+		content[f] = []byte("content of " + f)
+		files[f] = crc32.Checksum(content[f], crc32.MakeTable(crc32.Castagnoli))
+		p := filepath.Join(src, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, content[f], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	digest := sha256.Sum256(content[good])
+	digests := map[string]string{
+		good: hex.EncodeToString(digest[:]),
+		bad:  strings.Repeat("0", 64),
+	}
+	pr, _ := Tar(src, files, WithSHA256(digests))
+	tr, err := NewTarReader(pr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(map[string]string)
+	for f := range Untar(tr, dst, NewStdLogger(LevelError)) {
+		errs[f.Path] = f.Err()
+	}
+	if errs[good] != "" {
+		t.Errorf("a file matching its digest is rejected: %s", errs[good])
+	}
+	if !strings.Contains(errs[bad], "SHA-256 mismatch") {
+		t.Errorf("a file not matching its digest is accepted: %q", errs[bad])
+	}
+	if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(bad))); !os.IsNotExist(err) {
+		t.Errorf("a file not matching its digest is kept: %v", err)
+	}
+}
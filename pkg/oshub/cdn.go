@@ -0,0 +1,146 @@
+package oshub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type (
+	// CDNInvalidator invalidates cached copies of given URL paths, e.g. /<factory>/refs/heads/main
+	CDNInvalidator interface {
+		Invalidate(ctx context.Context, paths []string) error
+	}
+
+	// CloudCDN invalidates paths cached by Cloud CDN of a given URL map, it authenticates
+	// with the application default credentials which must be allowed to invalidate the map's cache
+	CloudCDN struct {
+		Project string
+		URLMap  string
+		// optional, limits invalidation to requests of a given host
+		Host string
+
+		once   sync.Once
+		client *http.Client
+		err    error
+	}
+
+	// Cloudflare purges URLs cached by Cloudflare in a given zone, URLs are made of BaseURL and paths
+	Cloudflare struct {
+		ZoneID   string
+		APIToken string
+		// a URL the bucket is served at, e.g. https://ostree.example.com
+		BaseURL string
+	}
+)
+
+const (
+	cloudCDNApi   string = "https://compute.googleapis.com/compute/v1"
+	computeScope  string = "https://www.googleapis.com/auth/compute"
+	cloudflareApi string = "https://api.cloudflare.com/client/v4"
+	// Cloudflare accepts at most this many URLs per purge request
+	cloudflarePurgeMax int = 30
+)
+
+var (
+	// repo paths whose content changes in place, so they have to be invalidated after being synced
+	mutablePaths = []string{"./refs/", "./summary", "./config"}
+)
+
+// InvalidateChanged invalidates CDN cached copies of refs, summary and config files changed by a sync,
+// it's supposed to be called after Wait with a URL path prefix the repo is served at, e.g. /<factory>
+func InvalidateChanged(ctx context.Context, inv CDNInvalidator, urlPrefix string, report *SyncReport) error {
+	var paths []string
+	for _, p := range report.Changed {
+		for _, m := range mutablePaths {
+			if strings.HasPrefix(p, m) {
+				paths = append(paths, strings.TrimSuffix(urlPrefix, "/")+"/"+strings.TrimPrefix(p, "./"))
+				break
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	if err := inv.Invalidate(ctx, paths); err != nil {
+		cdnInvalidations.WithLabelValues("failed").Add(float64(len(paths)))
+		return err
+	}
+	cdnInvalidations.WithLabelValues("invalidated").Add(float64(len(paths)))
+	logger.Info("Invalidated CDN cache", "paths", len(paths))
+	return nil
+}
+
+// Invalidate makes an invalidation request per path, Cloud CDN has no batch invalidation
+func (c *CloudCDN) Invalidate(ctx context.Context, paths []string) error {
+	c.once.Do(func() {
+		c.client, _, c.err = htransport.NewClient(context.Background(), option.WithScopes(computeScope))
+	})
+	if c.err != nil {
+		return fmt.Errorf("failed to create Cloud CDN client: %s", c.err.Error())
+	}
+	u := fmt.Sprintf("%s/projects/%s/global/urlMaps/%s/invalidateCache", cloudCDNApi, url.PathEscape(c.Project), url.PathEscape(c.URLMap))
+	for _, p := range paths {
+		rule := map[string]string{"path": p}
+		if len(c.Host) > 0 {
+			rule["host"] = c.Host
+		}
+		if err := postJSON(ctx, c.client, u, nil, rule); err != nil {
+			return fmt.Errorf("failed to invalidate %s: %s", p, err.Error())
+		}
+	}
+	return nil
+}
+
+// Invalidate purges URLs in batches of at most cloudflarePurgeMax URLs
+func (c *Cloudflare) Invalidate(ctx context.Context, paths []string) error {
+	u := fmt.Sprintf("%s/zones/%s/purge_cache", cloudflareApi, url.PathEscape(c.ZoneID))
+	header := http.Header{"Authorization": []string{"Bearer " + c.APIToken}}
+	for start := 0; start < len(paths); start += cloudflarePurgeMax {
+		end := start + cloudflarePurgeMax
+		if end > len(paths) {
+			end = len(paths)
+		}
+		var files []string
+		for _, p := range paths[start:end] {
+			files = append(files, strings.TrimSuffix(c.BaseURL, "/")+p)
+		}
+		if err := postJSON(ctx, http.DefaultClient, u, header, map[string][]string{"files": files}); err != nil {
+			return fmt.Errorf("failed to purge Cloudflare cache: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, u string, header http.Header, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
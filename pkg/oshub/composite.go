@@ -0,0 +1,129 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// GCS composes at most this many objects at once
+	maxComposeSources int = 32
+)
+
+type (
+	compositeConfig struct {
+		threshold int64
+		parts     int
+	}
+)
+
+// WithCompositeUploads makes the uploader upload objects of threshold bytes or larger, e.g. large static delta
+// parts, as a given number of parts in parallel which are composed into the object afterwards. Only objects
+// staged on a disk are uploaded this way, the number of parts is capped by 32, the GCS compose limit.
+func WithCompositeUploads(threshold int64, parts int) UploaderOption {
+	if parts > maxComposeSources {
+		parts = maxComposeSources
+	}
+	return func(u *Uploader) {
+		u.composite = compositeConfig{threshold: threshold, parts: parts}
+	}
+}
+
+// useComposite tells whether an object of a given size should be uploaded as a composite
+func (u *Uploader) useComposite(size int64) bool {
+	return u.composite.threshold > 0 && u.composite.parts > 1 && size >= u.composite.threshold
+}
+
+// writeComposite uploads parts of a file in parallel as temporary objects and composes them into the object
+func (u *Uploader) writeComposite(ctx context.Context, obj *gcs.ObjectHandle, objectName string, object *RepoFile, f *os.File, size int64) *uploadStatus {
+	start := time.Now()
+	ctx, cancel := u.opContext(ctx)
+	defer cancel()
+
+	partSize := (size + int64(u.composite.parts) - 1) / int64(u.composite.parts)
+	suffix := fmt.Sprintf(".part-%d", time.Now().UnixNano())
+	var parts []*gcs.ObjectHandle
+	for off := int64(0); off < size; off += partSize {
+		parts = append(parts, u.bucket.Object(fmt.Sprintf("%s%s-%d", objectName, suffix, len(parts))))
+	}
+	defer func() {
+		for _, p := range parts {
+			if err := p.Delete(u.ctx); err != nil && err != gcs.ErrObjectNotExist {
+				logger.Warn("Failed to delete a part of a composite object", "object", p.ObjectName(), "err", err)
+			}
+		}
+	}()
+
+	logger.Debug("Uploading an object to GCS bucket as a composite", "object", objectName, "parts", len(parts))
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for ii, part := range parts {
+		wg.Add(1)
+		go func(ii int, part *gcs.ObjectHandle) {
+			defer wg.Done()
+			off := int64(ii) * partSize
+			n := partSize
+			if off+n > size {
+				n = size - off
+			}
+			errs[ii] = u.writePart(ctx, part, io.NewSectionReader(f, off, n), n)
+		}(ii, part)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			logger.Error("Failed to upload a part of an object to GCS bucket", "object", objectName, "err", err)
+			uploadFailures.WithLabelValues(failureCopy).Inc()
+			return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+		}
+	}
+
+	c := obj.ComposerFrom(parts...)
+	u.setMetadata(&c.ObjectAttrs, object.Path)
+	if object.SHA256 != "" {
+		c.Metadata = map[string]string{shaMetadataKey: object.SHA256}
+	}
+	if object.CRC32 != 0 {
+		// GCS rejects the composition if the composed object doesn't match the CRC
+		c.SendCRC32C = true
+		c.CRC32C = object.CRC32
+	}
+	attrs, err := c.Run(ctx)
+	if err != nil {
+		logger.Error("Failed to compose an object in GCS bucket", "object", objectName, "err", err)
+		uploadFailures.WithLabelValues(failureClose).Inc()
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+	}
+
+	if u.index != nil {
+		u.index.add(objectName, attrs.CRC32C)
+	}
+	uploadLatency.Observe(time.Since(start).Seconds())
+	bytesUploaded.Add(float64(size))
+	logger.Info("Successfully uploaded an object to GCS bucket", "object", objectName, "bytes", size, "parts", len(parts))
+	return &uploadStatus{Object: &object.Path, Exist: false}
+}
+
+// writePart uploads a part of a composite object and verifies it has been stored intact
+func (u *Uploader) writePart(ctx context.Context, part *gcs.ObjectHandle, r io.Reader, size int64) error {
+	w := part.NewWriter(ctx)
+	w.ChunkSize = u.chunkSize(size)
+	hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(io.MultiWriter(w, hasher), r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if stored := w.Attrs().CRC32C; stored != hasher.Sum32() {
+		return fmt.Errorf("CRC mismatch of part %s: got %d, expected %d", part.ObjectName(), stored, hasher.Sum32())
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package oshub
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Content-Encoding of a gzip compressed TAR stream
+	EncodingGzip string = "gzip"
+)
+
+type (
+	// gzipWriter compresses a TAR stream as a sequence of gzip members,
+	// a new member is started each time the compression level changes so already compressed
+	// entries (e.g. .filez objects) can be stored as is while the rest of the stream is deflated
+	gzipWriter struct {
+		dst   io.Writer
+		zw    *gzip.Writer
+		level int
+	}
+)
+
+func newGzipWriter(dst io.Writer) *gzipWriter {
+	return &gzipWriter{dst: dst, level: gzip.DefaultCompression}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.zw == nil {
+		zw, err := gzip.NewWriterLevel(w.dst, w.level)
+		if err != nil {
+			return 0, err
+		}
+		w.zw = zw
+	}
+	return w.zw.Write(p)
+}
+
+func (w *gzipWriter) SetLevel(level int) error {
+	if level == w.level {
+		return nil
+	}
+	w.level = level
+	return w.Close()
+}
+
+func (w *gzipWriter) Close() error {
+	if w.zw == nil {
+		return nil
+	}
+	err := w.zw.Close()
+	w.zw = nil
+	return err
+}
+
+// compressionLevel returns a level a given repo file should be compressed with
+func compressionLevel(file string) int {
+	if strings.HasSuffix(file, ".filez") {
+		// archive-z2 content objects are zlib compressed already
+		return gzip.NoCompression
+	}
+	return gzip.DefaultCompression
+}
+
+// NewTarReader returns a reader of a TAR stream sent by fiopush taking into account its Content-Encoding
+func NewTarReader(r io.Reader, encoding string) (*tar.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return tar.NewReader(r), nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a gzip reader: %s", err.Error())
+		}
+		return tar.NewReader(zr), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding of a TAR stream: %s", encoding)
+	}
+}
@@ -0,0 +1,68 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+type (
+	// Credentials selects how the uploader authenticates to GCS, the zero value means
+	// the application default credentials (ADC) with the GCS default scopes
+	Credentials struct {
+		// a service account key file to use instead of ADC
+		KeyFile string
+		// a service account to impersonate, the base credentials (ADC or KeyFile) must be granted
+		// roles/iam.serviceAccountTokenCreator on it, e.g. a workload identity of the hub's pod
+		ImpersonateServiceAccount string
+		// a delegation chain of service accounts to get to the impersonated one through, optional
+		Delegates []string
+		// OAuth2 scopes of access tokens, defaults to gcs.ScopeReadWrite if a service account is impersonated
+		Scopes []string
+	}
+)
+
+// WithCredentials sets the credentials the uploader authenticates to GCS with
+func WithCredentials(creds Credentials) UploaderOption {
+	return func(u *Uploader) {
+		u.creds = creds
+	}
+}
+
+// WithCredentialsFile makes the uploader authenticate with a given service account key file
+// instead of the application default credentials
+func WithCredentialsFile(path string) UploaderOption {
+	return func(u *Uploader) {
+		u.creds.KeyFile = path
+	}
+}
+
+// clientOptions returns GCS client options implementing the credentials
+func (c *Credentials) clientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	var base []option.ClientOption
+	if len(c.KeyFile) > 0 {
+		base = append(base, option.WithCredentialsFile(c.KeyFile))
+	}
+	if len(c.ImpersonateServiceAccount) == 0 {
+		if len(c.Scopes) > 0 {
+			base = append(base, option.WithScopes(c.Scopes...))
+		}
+		return base, nil
+	}
+
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{gcs.ScopeReadWrite}
+	}
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: c.ImpersonateServiceAccount,
+		Delegates:       c.Delegates,
+		Scopes:          scopes,
+	}, base...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate service account %s: %s", c.ImpersonateServiceAccount, err.Error())
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
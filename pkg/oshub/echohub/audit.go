@@ -0,0 +1,29 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+// AuditHandler handles admin API requests listing audit records of a factory,
+// records can be filtered by ?session=<id> and by metadata, e.g. ?meta.git_sha=<sha>
+func AuditHandler(a *oshub.AuditLog) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		meta := map[string]string{}
+		for k := range c.QueryParams() {
+			if strings.HasPrefix(k, "meta.") {
+				meta[strings.TrimPrefix(k, "meta.")] = c.QueryParam(k)
+			}
+		}
+		records, err := a.Records(Factory(c), c.QueryParam("session"), meta)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if records == nil {
+			records = []oshub.AuditRecord{}
+		}
+		return c.JSON(http.StatusOK, records)
+	}
+}
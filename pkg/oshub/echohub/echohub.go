@@ -0,0 +1,113 @@
+// Package echohub serves OSTree Hub APIs of the oshub package by means of Echo handlers and middlewares,
+// oshub itself depends on net/http only so hubs built on other frameworks can use it too
+package echohub
+
+import (
+	"foundriesio/ostreehub/internal/faults"
+	"foundriesio/ostreehub/pkg/oshub"
+	"foundriesio/ostreehub/pkg/ostree"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type echoLogger struct {
+	l      echo.Logger
+	fields []interface{}
+}
+
+// Factory returns a name of a factory a request is made for, it's either specified as a path parameter
+// (e.g. /ota/ostreehub/:factory/v1/repos/lmp) or as a query parameter (e.g. /v1/repos/lmp?factory=<factory>)
+func Factory(c echo.Context) string {
+	if f := c.Param("factory"); f != "" {
+		return f
+	}
+	return c.QueryParam("factory")
+}
+
+// Logger adapts an Echo logger to oshub.Logger so Echo based servers can pass their logger to oshub
+func Logger(l echo.Logger) oshub.Logger {
+	return &echoLogger{l: l}
+}
+
+func (e *echoLogger) Debug(msg string, fields ...interface{}) {
+	e.l.Debug(e.format(msg, fields))
+}
+
+func (e *echoLogger) Info(msg string, fields ...interface{}) {
+	e.l.Info(e.format(msg, fields))
+}
+
+func (e *echoLogger) Warn(msg string, fields ...interface{}) {
+	e.l.Warn(e.format(msg, fields))
+}
+
+func (e *echoLogger) Error(msg string, fields ...interface{}) {
+	e.l.Error(e.format(msg, fields))
+}
+
+func (e *echoLogger) With(fields ...interface{}) oshub.Logger {
+	all := make([]interface{}, 0, len(e.fields)+len(fields))
+	all = append(append(all, e.fields...), fields...)
+	return &echoLogger{l: e.l, fields: all}
+}
+
+func (e *echoLogger) format(msg string, fields []interface{}) string {
+	all := make([]interface{}, 0, len(e.fields)+len(fields))
+	all = append(append(all, e.fields...), fields...)
+	return oshub.FormatMessage(msg, all...)
+}
+
+// BucketCheckHandler reports whether the hub can access its GCS bucket
+func BucketCheckHandler(u *oshub.Uploader) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		status := u.CheckBucket(c.Request().Context())
+		if status.Err != "" {
+			return c.JSON(http.StatusServiceUnavailable, status)
+		}
+		return c.JSON(http.StatusOK, status)
+	}
+}
+
+// RefsHandler responds with refs of a factory repo, a map of ref names to commit checksums
+func RefsHandler(repoDir oshub.RepoDirFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		repo := ostree.Repo{Dir: repoDir(factory)}
+		refs, err := repo.Refs()
+		if err != nil {
+			c.Logger().Errorf("Failed to read refs: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, refs)
+	}
+}
+
+// InjectFaults makes the hub respond with 503 to requests picked by the fault injector,
+// it's no-op unless the hub is built with the "faults" build tag
+func InjectFaults() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if faults.Active().HubUnavailable() {
+				return c.String(http.StatusServiceUnavailable, "fault injected")
+			}
+			return next(c)
+		}
+	}
+}
+
+// AnnounceCapabilities makes the hub announce the capabilities a client requests out of given supported ones,
+// e.g. a hub serving UploadStore handlers supports oshub.CapabilityResumable and one extracting streams
+// oshub.WithForceUpload supports oshub.CapabilityForce. The header is set before a handler writes a response.
+func AnnounceCapabilities(supported ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if caps := oshub.NegotiateCapabilities(c.Request().Header.Get(oshub.CapabilitiesHeader), supported...); caps != "" {
+				c.Response().Header().Set(oshub.CapabilitiesHeader, caps)
+			}
+			return next(c)
+		}
+	}
+}
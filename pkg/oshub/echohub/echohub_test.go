@@ -0,0 +1,33 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnnounceCapabilities(t *testing.T) {
+	e := echo.New()
+	e.Use(AnnounceCapabilities(oshub.CapabilitySHA256, oshub.CapabilityResumable, oshub.CapabilityForce))
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	tests := []struct {
+		requested string
+		announced string
+	}{
+		{"", ""},
+		{"sha256", "sha256"},
+		{"bare, force, sha256", "sha256,force"},
+		{"bare", ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(oshub.CapabilitiesHeader, tc.requested)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if got := rec.Header().Get(oshub.CapabilitiesHeader); got != tc.announced {
+			t.Errorf("requested %q, announced %q, expected %q", tc.requested, got, tc.announced)
+		}
+	}
+}
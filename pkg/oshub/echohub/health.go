@@ -0,0 +1,27 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// HealthzHandler responds with 200 if Uploader.Healthz succeeds and with 503 otherwise
+func HealthzHandler(u *oshub.Uploader) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := u.Healthz(); err != nil {
+			return c.String(http.StatusServiceUnavailable, err.Error())
+		}
+		return c.String(http.StatusOK, "ok")
+	}
+}
+
+// ReadyzHandler responds with 200 if Uploader.Readyz succeeds and with 503 otherwise
+func ReadyzHandler(u *oshub.Uploader) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := u.Readyz(c.Request().Context()); err != nil {
+			return c.String(http.StatusServiceUnavailable, err.Error())
+		}
+		return c.String(http.StatusOK, "ok")
+	}
+}
@@ -0,0 +1,19 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// HMACMiddleware rejects requests that are not signed by a known key with 401
+func HMACMiddleware(v *oshub.HMACVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := v.Verify(c.Request()); err != nil {
+				return c.String(http.StatusUnauthorized, err.Error())
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package echohub
+
+import (
+	"errors"
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+)
+
+// ImportHandler imports a bundle made by `fiopush export` to a factory repo, see Uploader.Import. The bundle is
+// either the request body or an object of a given staging bucket named by the object query parameter,
+// e.g. POST /v1/repos/lmp/import?factory=<factory>&object=<factory>/bundle.tar.zst.
+// Bundles can't be imported from a bucket if stagingBucket is empty.
+func ImportHandler(u *oshub.Uploader, repoDir oshub.RepoDirFunc, prefix oshub.ObjectPrefixFunc, stagingBucket string,
+	opts ...oshub.ImportOption) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		ctx := c.Request().Context()
+		var body io.Reader = c.Request().Body
+		if object := c.QueryParam("object"); object != "" {
+			if stagingBucket == "" {
+				return c.String(http.StatusBadRequest, "bundles can't be imported from a bucket by this hub")
+			}
+			r, err := u.OpenBundle(ctx, stagingBucket, object)
+			if err != nil {
+				c.Logger().Errorf("Failed to read a bundle %s from %s bucket: %s\n", object, stagingBucket, err.Error())
+				return c.String(http.StatusBadRequest, "failed to read the bundle: "+err.Error())
+			}
+			defer r.Close()
+			body = r
+		}
+		reqOpts := append([]oshub.ImportOption{
+			oshub.WithImportLogger(Logger(c.Logger())),
+			oshub.WithImportForceRefs(oshub.IsForceRefs(c.Request().Header.Get(oshub.ForceRefsHeader))),
+		}, opts...)
+		report, err := u.Import(ctx, factory, body, repoDir, prefix, reqOpts...)
+		var bundleErr *oshub.BundleError
+		var updateErr *oshub.RefUpdateError
+		if errors.As(err, &bundleErr) {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if errors.As(err, &updateErr) {
+			return c.String(http.StatusConflict, err.Error())
+		}
+		if err != nil {
+			c.Logger().Errorf("Failed to import a bundle: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		if report.Synced.SyncFailedNumb > 0 {
+			return c.JSON(http.StatusInternalServerError, report)
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+}
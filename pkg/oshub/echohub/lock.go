@@ -0,0 +1,95 @@
+package echohub
+
+import (
+	"errors"
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LockHandler takes or renews a lock for a push session specified in SessionHeader, steal=true query parameter
+// makes it take over a lock held by another session. The hub responds with 409, Retry-After and the current lock
+// if the repo is locked by another session
+func LockHandler(l *oshub.PushLocks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory, holder, err := lockParams(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		steal := c.QueryParam("steal") == "true"
+		lock, err := l.Acquire(c.Request().Context(), factory, holder, steal)
+		var lockedErr *oshub.LockedError
+		if errors.As(err, &lockedErr) {
+			wait := time.Until(lockedErr.Lock.Expires)
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			return c.JSON(http.StatusConflict, lockedErr.Lock)
+		}
+		if err != nil {
+			c.Logger().Errorf("Failed to lock a repo of %s: %s\n", factory, err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		if steal {
+			c.Logger().Warnf("Push session %s has stolen the lock of %s\n", holder, factory)
+		}
+		return c.JSON(http.StatusOK, lock)
+	}
+}
+
+// UnlockHandler releases a lock held by a push session specified in SessionHeader
+func UnlockHandler(l *oshub.PushLocks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory, holder, err := lockParams(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		err = l.Release(c.Request().Context(), factory, holder)
+		var lockedErr *oshub.LockedError
+		if errors.As(err, &lockedErr) {
+			return c.JSON(http.StatusConflict, lockedErr.Lock)
+		}
+		if err != nil {
+			c.Logger().Errorf("Failed to unlock a repo of %s: %s\n", factory, err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// LockMiddleware rejects TAR streams of a push session with 423 if the repo is locked by another session,
+// pushes of clients that don't take locks are accepted as long as the repo isn't locked
+func LockMiddleware(l *oshub.PushLocks) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			factory := Factory(c)
+			if factory == "" || (method != http.MethodPut && method != http.MethodPatch) {
+				return next(c)
+			}
+			err := l.Check(c.Request().Context(), factory, c.Request().Header.Get(oshub.SessionHeader))
+			var lockedErr *oshub.LockedError
+			if errors.As(err, &lockedErr) {
+				return c.String(http.StatusLocked, lockedErr.Error())
+			}
+			if err != nil {
+				c.Logger().Errorf("Failed to check a lock of %s: %s\n", factory, err.Error())
+				return c.String(http.StatusInternalServerError, err.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+func lockParams(c echo.Context) (string, string, error) {
+	factory := Factory(c)
+	if factory == "" {
+		return "", "", fmt.Errorf("factory is not specified")
+	}
+	holder := c.Request().Header.Get(oshub.SessionHeader)
+	if holder == "" {
+		return "", "", fmt.Errorf("%s is not specified", oshub.SessionHeader)
+	}
+	return factory, holder, nil
+}
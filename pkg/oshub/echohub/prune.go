@@ -0,0 +1,67 @@
+package echohub
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+// ObjectsHandler responds with a list of objects stored in GCS bucket for a factory
+func ObjectsHandler(u *oshub.Uploader, prefix oshub.ObjectPrefixFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		objects, err := u.ListObjects(c.Request().Context(), prefix(factory))
+		if err != nil {
+			c.Logger().Errorf("Failed to list objects: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, objects)
+	}
+}
+
+// ObjectPageHandler responds with a page of objects stored in GCS bucket for a factory, the page is specified
+// by page_token and page_size query parameters, e.g. /v1/repos/lmp/objects/list?page_size=100&page_token=<token>
+func ObjectPageHandler(u *oshub.Uploader, prefix oshub.ObjectPrefixFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		pageSize := oshub.DefaultObjectPageSize
+		if v := c.QueryParam("page_size"); v != "" {
+			size, err := strconv.Atoi(v)
+			if err != nil || size <= 0 || size > oshub.MaxObjectPageSize {
+				return c.String(http.StatusBadRequest, fmt.Sprintf("invalid page size, it must be in the range 1-%d", oshub.MaxObjectPageSize))
+			}
+			pageSize = size
+		}
+		page, err := u.ListObjectsPage(c.Request().Context(), prefix(factory), c.QueryParam("page_token"), pageSize)
+		if err != nil {
+			c.Logger().Errorf("Failed to list objects: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// PruneHandler deletes objects specified in a PruneRequest from GCS bucket of a factory
+func PruneHandler(u *oshub.Uploader, prefix oshub.ObjectPrefixFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		var req oshub.PruneRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		report := u.DeleteObjects(c.Request().Context(), prefix(factory), req.Objects)
+		c.Logger().Infof("Pruned %d objects of %s, failed to delete %d\n", report.Deleted, factory, len(report.Failed))
+		return c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,47 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// QuotaMiddleware rejects requests of factories that have exceeded their quota with 507 and a message describing
+// the quota, a body of an accepted request, e.g. a TAR stream, is cut off once it reaches the remaining bytes quota
+func QuotaMiddleware(q *oshub.QuotaEnforcer) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			factory := Factory(c)
+			if factory == "" {
+				return next(c)
+			}
+			done, err := q.Admit(c.Request(), factory)
+			if qErr, ok := err.(*oshub.QuotaError); ok {
+				c.Logger().Warnf("Rejecting a request: %s\n", qErr.Error())
+				return c.String(http.StatusInsufficientStorage, qErr.Error())
+			}
+			if err != nil {
+				c.Logger().Errorf("Failed to check a factory quota: %s\n", err.Error())
+				return c.String(http.StatusInternalServerError, err.Error())
+			}
+			defer done()
+			return next(c)
+		}
+	}
+}
+
+// UsageHandler responds with usage and quota of a factory
+func UsageHandler(q *oshub.QuotaEnforcer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		u, err := q.Usage(c.Request().Context(), factory)
+		if err != nil {
+			c.Logger().Errorf("Failed to get a factory storage usage: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"usage": u, "quota": q.Quota(factory)})
+	}
+}
@@ -0,0 +1,37 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitMiddleware applies limits of a given limiter to requests of a factory, a throttled request
+// is responded with 429 and Retry-After
+func RateLimitMiddleware(l *oshub.RequestLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			factory := Factory(c)
+			if wait := l.Take(factory); wait > 0 {
+				return tooManyRequests(c, wait, "request rate limit exceeded")
+			}
+			method := c.Request().Method
+			if method != http.MethodPut && method != http.MethodPatch {
+				return next(c)
+			}
+			if !l.AcquireStream(factory) {
+				return tooManyRequests(c, oshub.StreamRetryAfter, "too many concurrent pushes")
+			}
+			defer l.ReleaseStream(factory)
+			return next(c)
+		}
+	}
+}
+
+func tooManyRequests(c echo.Context, wait time.Duration, msg string) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return c.String(http.StatusTooManyRequests, msg)
+}
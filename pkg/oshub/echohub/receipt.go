@@ -0,0 +1,31 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// FinalizeHandler handles a request to finalize a push, it responds with a signed receipt
+// of the current state of a factory repo
+func FinalizeHandler(s *oshub.ReceiptSigner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		var meta map[string]string
+		if s.Audit != nil {
+			var err error
+			if meta, err = oshub.DecodeMeta(c.Request().Header.Get(oshub.MetaHeader)); err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+		}
+		sr, err := s.Finalize(factory, c.Request().Header.Get(oshub.SessionHeader), meta)
+		if err != nil {
+			c.Logger().Errorf("Failed to finalize a push: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, sr)
+	}
+}
@@ -0,0 +1,84 @@
+package echohub
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// UploadCompleteFunc processes a TAR stream received by means of a resumable upload the same way
+// a PUT request handler processes a request body, i.e. it responds with SyncReport
+type UploadCompleteFunc func(c echo.Context, body io.Reader) error
+
+// OffsetHandler responds with a number of bytes of an upload received so far, it's zero for an unknown upload, e.g.
+//
+//	e.HEAD("/v1/repos/lmp/uploads/:id", echohub.OffsetHandler(store))
+//	e.PATCH("/v1/repos/lmp/uploads/:id", echohub.ChunkHandler(store, complete))
+func OffsetHandler(s *oshub.UploadStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, err := s.Upload(Factory(c), c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		offset, err := u.Offset()
+		if err != nil {
+			c.Logger().Errorf("Failed to get an upload offset: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Response().Header().Set(oshub.UploadOffsetHeader, strconv.FormatInt(offset, 10))
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// ChunkHandler appends a chunk to an upload, the chunk offset must be equal to the number of bytes received so far,
+// otherwise the hub responds with 409 and the actual offset. Once the last chunk is received the whole stream
+// is passed to a given function and removed afterwards
+func ChunkHandler(s *oshub.UploadStore, complete UploadCompleteFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, err := s.Upload(Factory(c), c.Param("id"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		offset, err := strconv.ParseInt(c.Request().Header.Get(oshub.UploadOffsetHeader), 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "invalid upload offset: "+err.Error())
+		}
+		if err := u.Acquire(); err != nil {
+			return c.String(http.StatusConflict, err.Error())
+		}
+		defer u.Release()
+		received, err := u.Offset()
+		if err != nil {
+			c.Logger().Errorf("Failed to get an upload offset: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Response().Header().Set(oshub.UploadOffsetHeader, strconv.FormatInt(received, 10))
+		if offset != received {
+			return c.String(http.StatusConflict, fmt.Sprintf("unexpected offset %d, received %d bytes so far", offset, received))
+		}
+		written, err := u.Append(c.Request().Body)
+		c.Response().Header().Set(oshub.UploadOffsetHeader, strconv.FormatInt(received+written, 10))
+		if err != nil {
+			c.Logger().Errorf("Failed to append an upload chunk: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		if c.Request().Header.Get(oshub.UploadCompleteHeader) != "true" {
+			return c.NoContent(http.StatusNoContent)
+		}
+		f, err := u.Open()
+		if err != nil {
+			c.Logger().Errorf("Failed to open an upload: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		defer func() {
+			f.Close()
+			if err := u.Remove(); err != nil {
+				c.Logger().Warnf("Failed to remove an upload file: %s\n", err.Error())
+			}
+		}()
+		return complete(c, f)
+	}
+}
@@ -0,0 +1,41 @@
+package echohub
+
+import (
+	"fmt"
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// SignHandler responds with signed URLs of repo files specified by path query parameters, the expiry
+// is specified by an optional expires_in parameter, e.g. /v1/repos/lmp/signed-urls?path=./refs/heads/main&expires_in=1h
+func SignHandler(s *oshub.URLSigner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		paths := c.QueryParams()["path"]
+		if len(paths) == 0 {
+			return c.String(http.StatusBadRequest, "no path is specified")
+		}
+		expiry := oshub.DefaultSignedURLExpiry
+		if v := c.QueryParam("expires_in"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return c.String(http.StatusBadRequest, fmt.Sprintf("invalid expiry: %s", err.Error()))
+			}
+			expiry = d
+		}
+		if err := s.Validate(factory, paths, expiry); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		urls, err := s.Sign(factory, paths, expiry)
+		if err != nil {
+			c.Logger().Errorf("Failed to sign URLs: %s\n", err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, urls)
+	}
+}
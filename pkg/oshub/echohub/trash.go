@@ -0,0 +1,44 @@
+package echohub
+
+import (
+	"foundriesio/ostreehub/pkg/oshub"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// DeleteHandler moves a factory repo to the trash, the request must have ConfirmDeleteHeader set to the factory name
+func DeleteHandler(t *oshub.RepoTrash) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		if c.Request().Header.Get(oshub.ConfirmDeleteHeader) != factory {
+			return c.String(http.StatusPreconditionFailed, oshub.ConfirmDeleteHeader+" must be set to the factory name")
+		}
+		report, err := t.Delete(c.Request().Context(), factory)
+		if err != nil {
+			c.Logger().Errorf("Failed to delete a repo of %s: %s\n", factory, err.Error())
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Logger().Infof("Moved %d objects of %s to the trash, failed to move %d\n", report.Moved, factory, len(report.Failed))
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// RestoreHandler restores the latest deleted repo of a factory
+func RestoreHandler(t *oshub.RepoTrash) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		factory := Factory(c)
+		if factory == "" {
+			return c.String(http.StatusBadRequest, "factory is not specified")
+		}
+		report, err := t.Restore(c.Request().Context(), factory)
+		if err != nil {
+			c.Logger().Errorf("Failed to restore a repo of %s: %s\n", factory, err.Error())
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		c.Logger().Infof("Restored %d objects of %s, failed to restore %d\n", report.Restored, factory, len(report.Failed))
+		return c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package oshub
+
+import (
+	"os"
+	"syscall"
+)
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinkID returns an ID of a file having more than one hard link, ok is false for other files
+func hardlinkID(fi os.FileInfo) (id fileID, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// unixStat returns the owner and the unix mode including the file type of a file
+func unixStat(fi os.FileInfo) (uid uint32, gid uint32, mode uint32) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid, uint32(st.Mode)
+	}
+	return 0, 0, unixMode(fi.Mode())
+}
@@ -0,0 +1,17 @@
+package oshub
+
+import (
+	"os"
+)
+
+type fileID struct{}
+
+// hardlinkID returns an ID of a file having more than one hard link, hard links are not detected on Windows
+func hardlinkID(fi os.FileInfo) (id fileID, ok bool) {
+	return fileID{}, false
+}
+
+// unixStat returns the owner and the unix mode including the file type of a file, Windows files are owned by root
+func unixStat(fi os.FileInfo) (uid uint32, gid uint32, mode uint32) {
+	return 0, 0, unixMode(fi.Mode())
+}
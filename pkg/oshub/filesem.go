@@ -0,0 +1,58 @@
+package oshub
+
+import (
+	"sync"
+)
+
+type (
+	// FileSemaphore bounds a number of files open at once by Tar and whoever else shares it, e.g. a repo walker,
+	// so a push of a repo of many objects doesn't exhaust the limit of open files of the process.
+	// A nil semaphore doesn't bound anything.
+	FileSemaphore struct {
+		mu   sync.Mutex
+		cond *sync.Cond
+		free int
+	}
+)
+
+// NewFileSemaphore returns a semaphore allowing up to a given number of files open at once
+func NewFileSemaphore(n int) *FileSemaphore {
+	if n < 2 {
+		// a file is compared with another one at once
+		n = 2
+	}
+	s := &FileSemaphore{free: n}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Acquire blocks until n more files can be opened, files opened together are acquired at once
+func (s *FileSemaphore) Acquire(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	for s.free < n {
+		s.cond.Wait()
+	}
+	s.free -= n
+	s.mu.Unlock()
+}
+
+// Release makes n files acquired before available to others once they are closed
+func (s *FileSemaphore) Release(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.free += n
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// WithFileSemaphore makes Tar acquire files from a given semaphore before opening them
+func WithFileSemaphore(s *FileSemaphore) TarOption {
+	return func(c *tarConfig) {
+		c.files = s
+	}
+}
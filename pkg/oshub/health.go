@@ -0,0 +1,84 @@
+package oshub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// a result of the bucket access check is reused for this long so frequent probes don't hammer GCS
+	readyCheckTTL = 30 * time.Second
+)
+
+var (
+	// permissions the hub needs to sync, list and prune objects of its bucket
+	requiredPermissions = []string{
+		"storage.objects.create",
+		"storage.objects.get",
+		"storage.objects.list",
+		"storage.objects.delete",
+	}
+)
+
+type (
+	readyCheck struct {
+		sync.Mutex
+		err       error
+		checkedAt time.Time
+	}
+)
+
+// Healthz reports whether the uploader has been initialized, it doesn't talk to GCS so a GCS outage
+// doesn't make an orchestrator restart the hub, e.g. it's suitable for a k8s liveness probe
+func (u *Uploader) Healthz() error {
+	if u == nil || u.client == nil || u.bucket == nil {
+		return fmt.Errorf("the uploader is not initialized")
+	}
+	return nil
+}
+
+// Readyz reports whether the hub can reach its GCS bucket and has all the permissions it needs,
+// e.g. it's suitable for a k8s readiness probe
+func (u *Uploader) Readyz(ctx context.Context) error {
+	if err := u.Healthz(); err != nil {
+		return err
+	}
+	u.ready.Lock()
+	defer u.ready.Unlock()
+	if !u.ready.checkedAt.IsZero() && time.Since(u.ready.checkedAt) < readyCheckTTL {
+		return u.ready.err
+	}
+	u.ready.err = u.checkAccess(ctx)
+	u.ready.checkedAt = time.Now()
+	return u.ready.err
+}
+
+func (u *Uploader) checkAccess(ctx context.Context) error {
+	if _, err := u.bucket.Attrs(ctx); err != nil {
+		return fmt.Errorf("failed to access bucket %s: %s", u.bucketName, err.Error())
+	}
+	if u.emulated {
+		return nil
+	}
+	granted, err := u.bucket.IAM().TestPermissions(ctx, requiredPermissions)
+	if err != nil {
+		return fmt.Errorf("failed to check permissions to bucket %s: %s", u.bucketName, err.Error())
+	}
+	has := make(map[string]bool)
+	for _, p := range granted {
+		has[p] = true
+	}
+	var missing []string
+	for _, p := range requiredPermissions {
+		if !has[p] {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions to bucket %s: %s", u.bucketName, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package oshub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// HMACScheme is an Authorization scheme of requests signed with a shared secret, e.g.
+	// Authorization: FIO-HMAC-SHA256 KeyId=<key id>, Signature=<hex encoded HMAC-SHA256>
+	HMACScheme string = "FIO-HMAC-SHA256"
+	// a time a signed request has been made at, RFC3339 in UTC
+	DateHeader string = "X-Fio-Date"
+	// hex encoded SHA-256 digest of a signed request body, or UnsignedPayload
+	ContentSHA256Header string = "X-Fio-Content-SHA256"
+	// a body digest of requests whose body is streamed, e.g. TAR streams, their files are verified by CRC instead,
+	// it's accepted only by streaming upload routes
+	UnsignedPayload string = "UNSIGNED-PAYLOAD"
+
+	DefaultHMACMaxSkew = 5 * time.Minute
+	// a signed body is read to memory to verify its digest, larger bodies have to be sent unsigned
+	maxSignedBodySize int64 = 16 * 1024 * 1024
+)
+
+type (
+	// HMACKeyFunc returns a shared secret of a given key ID, ok is false if the key is unknown
+	HMACKeyFunc func(keyID string) (secret []byte, ok bool)
+
+	// HMACVerifier authenticates requests signed with shared secrets by SignRequest, e.g. for hubs that
+	// don't run behind an OAuth server. A signature covers a method, a request URI, a date and a body digest
+	HMACVerifier struct {
+		Keys HMACKeyFunc
+		// DefaultHMACMaxSkew if not specified, requests made earlier or later than that are rejected
+		MaxSkew time.Duration
+		// reports whether a request is a streaming upload whose body may be sent with UnsignedPayload digest,
+		// IsStreamingUpload if not specified, bodies of all other requests must be signed
+		StreamingUpload func(r *http.Request) bool
+	}
+)
+
+// HMACSignature returns a hex encoded HMAC-SHA256 of a request made at a given date with a body of a given digest
+func HMACSignature(secret []byte, method string, requestURI string, date string, bodyDigest string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, date, bodyDigest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BodyDigest returns a hex encoded SHA-256 digest of a request body
+func BodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignRequest signs a request with a given key, bodyDigest is either BodyDigest of the request body or UnsignedPayload
+func SignRequest(r *http.Request, keyID string, secret []byte, bodyDigest string) {
+	date := time.Now().UTC().Format(time.RFC3339)
+	r.Header.Set(DateHeader, date)
+	r.Header.Set(ContentSHA256Header, bodyDigest)
+	sig := HMACSignature(secret, r.Method, r.URL.RequestURI(), date, bodyDigest)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", HMACScheme, keyID, sig))
+}
+
+// Verify fails if a request is not signed by a known key, a signed body is read to verify its digest
+// and the request body is replaced by a copy of it
+func (v *HMACVerifier) Verify(r *http.Request) error {
+	keyID, sig, err := parseHMACAuth(r.Header.Get("Authorization"))
+	if err != nil {
+		return err
+	}
+	secret, ok := v.Keys(keyID)
+	if !ok {
+		return fmt.Errorf("unknown key: %s", keyID)
+	}
+	date := r.Header.Get(DateHeader)
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %s", DateHeader, date)
+	}
+	if skew := time.Since(t); skew > v.maxSkew() || -skew > v.maxSkew() {
+		return fmt.Errorf("the request date is too far from the hub time: %s", date)
+	}
+	digest := r.Header.Get(ContentSHA256Header)
+	if digest == "" {
+		return fmt.Errorf("%s header is not specified", ContentSHA256Header)
+	}
+	expected := HMACSignature(secret, r.Method, r.URL.RequestURI(), date, digest)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	if digest == UnsignedPayload {
+		if !v.streamingUpload(r) {
+			return fmt.Errorf("a body of %s %s request must be signed", r.Method, r.URL.Path)
+		}
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read the request body: %s", err.Error())
+	}
+	if int64(len(body)) > maxSignedBodySize {
+		return fmt.Errorf("a signed request body must not exceed %d bytes", maxSignedBodySize)
+	}
+	if BodyDigest(body) != digest {
+		return fmt.Errorf("the request body doesn't match its digest")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+func (v *HMACVerifier) streamingUpload(r *http.Request) bool {
+	if v.StreamingUpload == nil {
+		return IsStreamingUpload(r)
+	}
+	return v.StreamingUpload(r)
+}
+
+// IsStreamingUpload reports whether a request streams its body to one of the hub streaming upload routes, i.e. it's
+// a TAR stream PUT /v1/repos/:repo or a resumable upload chunk PATCH /v1/repos/:repo/uploads/:id.
+// Hubs serving streaming uploads under other paths have to set HMACVerifier.StreamingUpload
+func IsStreamingUpload(r *http.Request) bool {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	isRepo := len(parts) >= 3 && parts[0] == "v1" && parts[1] == "repos" && parts[2] != ""
+	switch r.Method {
+	case http.MethodPut:
+		return isRepo && len(parts) == 3
+	case http.MethodPatch:
+		return isRepo && len(parts) == 5 && parts[3] == "uploads" && parts[4] != ""
+	}
+	return false
+}
+
+func (v *HMACVerifier) maxSkew() time.Duration {
+	if v.MaxSkew == 0 {
+		return DefaultHMACMaxSkew
+	}
+	return v.MaxSkew
+}
+
+// parseHMACAuth returns a key ID and a signature specified in Authorization header value
+func parseHMACAuth(header string) (string, string, error) {
+	if !strings.HasPrefix(header, HMACScheme+" ") {
+		return "", "", fmt.Errorf("the request is not signed by %s", HMACScheme)
+	}
+	var keyID, sig string
+	for _, param := range strings.Split(strings.TrimPrefix(header, HMACScheme+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "KeyId":
+			keyID = kv[1]
+		case "Signature":
+			sig = kv[1]
+		}
+	}
+	if keyID == "" || sig == "" {
+		return "", "", fmt.Errorf("invalid %s authorization: KeyId and Signature must be specified", HMACScheme)
+	}
+	return keyID, sig, nil
+}
@@ -0,0 +1,157 @@
+package oshub
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeyID = "ci"
+
+var testSecret = []byte("secret")
+
+// signedRequest makes a request signed with a given secret at a given time, digest is a digest the signature covers
+func signedRequest(method string, target string, body []byte, secret []byte, date time.Time, digest string) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	d := date.UTC().Format(time.RFC3339)
+	r.Header.Set(DateHeader, d)
+	r.Header.Set(ContentSHA256Header, digest)
+	sig := HMACSignature(secret, method, r.URL.RequestURI(), d, digest)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", HMACScheme, testKeyID, sig))
+	return r
+}
+
+func TestHMACVerify(t *testing.T) {
+	body := []byte(`{"./objects/ab/cdef.commit": 1}`)
+	large := make([]byte, maxSignedBodySize+1)
+	now := time.Now()
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		// a part of the expected error, the request is expected to be accepted if it's empty
+		err string
+	}{
+		{"signed body", func() *http.Request {
+			return signedRequest("POST", "/v1/repos/lmp/check", body, testSecret, now, BodyDigest(body))
+		}, ""},
+		{"stream", func() *http.Request {
+			return signedRequest("PUT", "/v1/repos/lmp?factory=f", body, testSecret, now, UnsignedPayload)
+		}, ""},
+		{"upload chunk", func() *http.Request {
+			return signedRequest("PATCH", "/v1/repos/lmp/uploads/0123", body, testSecret, now, UnsignedPayload)
+		}, ""},
+		{"not signed", func() *http.Request {
+			return httptest.NewRequest("GET", "/v1/repos/lmp/refs", nil)
+		}, "not signed"},
+		{"no key ID", func() *http.Request {
+			r := signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now, BodyDigest(nil))
+			r.Header.Set("Authorization", HMACScheme+" Signature=00")
+			return r
+		}, "KeyId and Signature must be specified"},
+		{"unknown key", func() *http.Request {
+			r := signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now, BodyDigest(nil))
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), testKeyID, "other", 1))
+			return r
+		}, "unknown key"},
+		{"invalid date", func() *http.Request {
+			r := signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now, BodyDigest(nil))
+			r.Header.Set(DateHeader, now.Format(time.RFC1123))
+			return r
+		}, "invalid " + DateHeader},
+		{"past skew", func() *http.Request {
+			return signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now.Add(-DefaultHMACMaxSkew-time.Minute), BodyDigest(nil))
+		}, "too far"},
+		{"future skew", func() *http.Request {
+			return signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now.Add(DefaultHMACMaxSkew+time.Minute), BodyDigest(nil))
+		}, "too far"},
+		{"no digest", func() *http.Request {
+			r := signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now, BodyDigest(nil))
+			r.Header.Del(ContentSHA256Header)
+			return r
+		}, ContentSHA256Header + " header is not specified"},
+		{"wrong key", func() *http.Request {
+			return signedRequest("GET", "/v1/repos/lmp/refs", nil, []byte("wrong"), now, BodyDigest(nil))
+		}, "invalid request signature"},
+		{"tampered signature", func() *http.Request {
+			r := signedRequest("GET", "/v1/repos/lmp/refs", nil, testSecret, now, BodyDigest(nil))
+			r.Header.Set("Authorization", r.Header.Get("Authorization")+"00")
+			return r
+		}, "invalid request signature"},
+		{"tampered URI", func() *http.Request {
+			r := signedRequest("DELETE", "/v1/repos/lmp?factory=f", nil, testSecret, now, BodyDigest(nil))
+			r.URL.RawQuery = "factory=other"
+			r.RequestURI = r.URL.RequestURI()
+			return r
+		}, "invalid request signature"},
+		{"unsigned POST", func() *http.Request {
+			return signedRequest("POST", "/v1/repos/lmp/check", body, testSecret, now, UnsignedPayload)
+		}, "must be signed"},
+		{"unsigned PUT to another route", func() *http.Request {
+			return signedRequest("PUT", "/v1/repos/lmp/lock", body, testSecret, now, UnsignedPayload)
+		}, "must be signed"},
+		{"unsigned PATCH to another route", func() *http.Request {
+			return signedRequest("PATCH", "/v1/repos/lmp/refs", body, testSecret, now, UnsignedPayload)
+		}, "must be signed"},
+		{"large body", func() *http.Request {
+			return signedRequest("POST", "/v1/repos/lmp/check", large, testSecret, now, BodyDigest(large))
+		}, "must not exceed"},
+		{"tampered body", func() *http.Request {
+			r := signedRequest("POST", "/v1/repos/lmp/check", body, testSecret, now, BodyDigest(body))
+			r.Body = ioutil.NopCloser(strings.NewReader(`{"./objects/ab/cdef.commit": 2}`))
+			return r
+		}, "doesn't match its digest"},
+	}
+	v := &HMACVerifier{Keys: func(keyID string) ([]byte, bool) {
+		return testSecret, keyID == testKeyID
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.req()
+			err := v.Verify(r)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("the request has been rejected: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("unexpected error: %v, expected %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestHMACVerifyRestoresBody(t *testing.T) {
+	body := []byte(`{"./objects/ab/cdef.commit": 1}`)
+	r := httptest.NewRequest("POST", "/v1/repos/lmp/check", bytes.NewReader(body))
+	SignRequest(r, testKeyID, testSecret, BodyDigest(body))
+	v := &HMACVerifier{Keys: func(keyID string) ([]byte, bool) { return testSecret, true }}
+	if err := v.Verify(r); err != nil {
+		t.Fatalf("the request has been rejected: %s", err)
+	}
+	read, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, body) {
+		t.Fatalf("the verified body differs from the signed one: %s", read)
+	}
+}
+
+func TestHMACVerifyStreamingUpload(t *testing.T) {
+	v := &HMACVerifier{
+		Keys:            func(keyID string) ([]byte, bool) { return testSecret, true },
+		StreamingUpload: func(r *http.Request) bool { return r.URL.Path == "/stream" },
+	}
+	if err := v.Verify(signedRequest("POST", "/stream", []byte("tar"), testSecret, time.Now(), UnsignedPayload)); err != nil {
+		t.Fatalf("the unsigned body of the streaming upload has been rejected: %s", err)
+	}
+	if err := v.Verify(signedRequest("PUT", "/v1/repos/lmp", []byte("tar"), testSecret, time.Now(), UnsignedPayload)); err == nil {
+		t.Fatalf("the unsigned body has been accepted on a route the verifier doesn't stream")
+	}
+}
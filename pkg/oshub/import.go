@@ -0,0 +1,285 @@
+package oshub
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	// BundleImportReport is a result of a bundle import, refs and config are published only
+	// once all objects of the bundle have been synced
+	BundleImportReport struct {
+		// objects of the bundle followed by refs and other files once they are published, Changed lists the latter
+		Synced *SyncReport `json:"synced"`
+		// refs and other files moved to the factory repo, empty if they haven't been published
+		Installed []string `json:"installed,omitempty"`
+	}
+
+	// BundleError is returned by Import if a bundle can't be read or doesn't match its manifest
+	BundleError struct {
+		Err error
+	}
+
+	ImportOption func(*importConfig)
+
+	importConfig struct {
+		// invalidates cached copies of published refs and config, see WithImportInvalidation
+		cdn       CDNInvalidator
+		urlPrefix ObjectPrefixFunc
+		forceRefs bool
+		logger    Logger
+	}
+)
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// WithImportInvalidation makes Import invalidate CDN cached copies of refs and config published by an import,
+// urlPrefix returns a URL path prefix a factory repo is served at, e.g. /<factory>, see InvalidateChanged
+func WithImportInvalidation(inv CDNInvalidator, urlPrefix ObjectPrefixFunc) ImportOption {
+	return func(c *importConfig) {
+		c.cdn = inv
+		c.urlPrefix = urlPrefix
+	}
+}
+
+// WithImportForceRefs makes Import publish refs of a bundle even if they move branches of the factory repo
+// backwards or sideways, see RefGuard
+func WithImportForceRefs(force bool) ImportOption {
+	return func(c *importConfig) {
+		c.forceRefs = force
+	}
+}
+
+// WithImportLogger sets a logger Import logs messages of a factory to, the package logger by default
+func WithImportLogger(l Logger) ImportOption {
+	return func(c *importConfig) {
+		c.logger = l
+	}
+}
+
+func (e *BundleError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BundleError) Unwrap() error {
+	return e.Err
+}
+
+// OpenBundle opens a bundle uploaded to a given staging bucket, e.g. by gsutil, so a large bundle
+// doesn't have to be sent to the hub within a single request
+func (u *Uploader) OpenBundle(ctx context.Context, bucket string, object string) (io.ReadCloser, error) {
+	return u.client.Bucket(bucket).Object(object).NewReader(ctx)
+}
+
+// Import imports a bundle made by `fiopush export` to a factory repo, e.g. for air-gapped factories that
+// can't push over the internet. The bundle goes through the same Untar, Check and Sync pipeline a push does,
+// it can be compressed by zstd or gzip. Its files are extracted to a scratch directory next to the factory repo,
+// objects are synced to GCS while refs and config are moved to the repo and synced afterwards, so the repo
+// isn't changed if the bundle doesn't match its manifest or objects fail to sync, the report lists the failures then.
+// BundleError is returned if the bundle can't be read or doesn't match its manifest and RefUpdateError
+// if its refs are rejected by RefGuard.
+func (u *Uploader) Import(ctx context.Context, factory string, body io.Reader, repoDir RepoDirFunc, prefix ObjectPrefixFunc,
+	opts ...ImportOption) (*BundleImportReport, error) {
+	cfg := importConfig{logger: logger}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	bundle, err := NewBundleReader(body)
+	if err != nil {
+		return nil, &BundleError{Err: err}
+	}
+	defer bundle.Close()
+	tr := tar.NewReader(bundle)
+	manifest, err := ReadBundleManifest(tr)
+	if err != nil {
+		return nil, &BundleError{Err: err}
+	}
+
+	dstDir := repoDir(factory)
+	scratch, err := ioutil.TempDir(filepath.Dir(dstDir), filepath.Base(dstDir)+".import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a scratch directory: %s", err.Error())
+	}
+	defer os.RemoveAll(scratch)
+
+	l := cfg.logger.With("factory", factory)
+	var failure error
+	files := Untar(tr, scratch, l, WithContext(ctx), WithTargetMode(manifest.Mode), WithFailureHandler(func(err error) {
+		failure = err
+	}))
+	// files other than objects are installed once all objects are synced
+	received := map[string]uint32{}
+	var others []*RepoFile
+	objects := make(chan *RepoFile, FilesToCheckMaxNumb)
+	go func() {
+		defer close(objects)
+		for f := range files {
+			received[f.Path] = f.CRC32
+			if strings.HasPrefix(f.Path, "./objects/") {
+				objects <- f
+			} else {
+				others = append(others, f)
+			}
+		}
+	}()
+	report := &BundleImportReport{Synced: u.syncFiles(ctx, objects, prefix(factory), scratch)}
+	if failure != nil {
+		return nil, &BundleError{Err: fmt.Errorf("failed to read the bundle: %s", failure.Error())}
+	}
+	if err := manifest.verify(received); err != nil {
+		return nil, &BundleError{Err: err}
+	}
+	if report.Synced.SyncFailedNumb > 0 {
+		l.Warn("Bundle objects have failed to sync, refs are not published", "failed", report.Synced.SyncFailedNumb)
+		return report, nil
+	}
+
+	guard := &RefGuard{RepoDir: dstDir, Uploader: u, ObjectPrefix: prefix(factory)}
+	if err := guard.checkImported(ctx, scratch, others, cfg.forceRefs, l); err != nil {
+		var updateErr *RefUpdateError
+		if errors.As(err, &updateErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to check imported refs: %s", err.Error())
+	}
+
+	// refs go last, so they never point to a commit whose objects haven't been installed
+	sort.Slice(others, func(i, j int) bool {
+		ri, rj := strings.HasPrefix(others[i].Path, "./refs/"), strings.HasPrefix(others[j].Path, "./refs/")
+		return !ri && rj || ri == rj && others[i].Path < others[j].Path
+	})
+	for _, f := range others {
+		if err := installFile(scratch, dstDir, f.Path); err != nil {
+			return nil, fmt.Errorf("failed to move an imported file to the repo: %s", err.Error())
+		}
+		report.Installed = append(report.Installed, f.Path)
+	}
+	// published files are synced the same way a push syncs them
+	queue := make(chan *RepoFile, len(others))
+	for _, f := range others {
+		queue <- f
+	}
+	close(queue)
+	filesReport := u.syncFiles(ctx, queue, prefix(factory), dstDir)
+	report.Synced.merge(filesReport)
+	if cfg.cdn != nil {
+		if err := InvalidateChanged(ctx, cfg.cdn, cfg.urlPrefix(factory), filesReport); err != nil {
+			l.Warn("Failed to invalidate CDN cache of imported files", "err", err)
+		}
+	}
+	if filesReport.SyncFailedNumb > 0 {
+		l.Warn("Imported refs and config have failed to sync", "failed", filesReport.SyncFailedNumb)
+		return report, nil
+	}
+	l.Info("Imported a bundle", "files", len(received), "refs", len(manifest.Refs))
+	return report, nil
+}
+
+// syncFiles checks and syncs given files the way a pushed TAR stream is synced
+func (u *Uploader) syncFiles(ctx context.Context, files <-chan *RepoFile, objectPrefix string, srcDir string) *SyncReport {
+	counted, reportQueue := Filter(files, "")
+	return u.Wait(reportQueue, u.Sync(ctx, u.Check(ctx, counted, objectPrefix), objectPrefix, srcDir))
+}
+
+// merge adds a report of another sync of the same repo, e.g. of refs synced after objects
+func (r *SyncReport) merge(other *SyncReport) {
+	r.UploadedFileNumb += other.UploadedFileNumb
+	r.SyncedFileNumb += other.SyncedFileNumb
+	r.UploadSyncedFileNumb += other.UploadSyncedFileNumb
+	r.SyncFailedNumb += other.SyncFailedNumb
+	r.ObjectNumb += other.ObjectNumb
+	r.SpilledFileNumb += other.SpilledFileNumb
+	r.StreamedFileNumb += other.StreamedFileNumb
+	r.Changed = append(r.Changed, other.Changed...)
+	for path, reason := range other.Failures {
+		if len(r.Failures) >= MaxReportedFailures {
+			break
+		}
+		if r.Failures == nil {
+			r.Failures = make(map[string]string)
+		}
+		r.Failures[path] = reason
+	}
+}
+
+// installFile moves an extracted file, e.g. a ref, from a scratch directory to the same path of a repo
+func installFile(scratch string, repoDir string, file string) error {
+	dst := filepath.Join(repoDir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(scratch, filepath.FromSlash(file)), dst)
+}
+
+// NewBundleReader returns a reader of an uncompressed bundle, a zstd or gzip compressed one is detected by its magic
+func NewBundleReader(r io.Reader) (io.ReadCloser, error) {
+	in := bufio.NewReader(r)
+	magic, _ := in.Peek(len(zstdMagic))
+	switch {
+	case len(magic) == len(zstdMagic) && string(magic) == string(zstdMagic):
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the zstd compressed bundle: %s", err.Error())
+		}
+		return zr.IOReadCloser(), nil
+	case len(magic) >= len(gzipMagic) && string(magic[:len(gzipMagic)]) == string(gzipMagic):
+		gr, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the gzip compressed bundle: %s", err.Error())
+		}
+		return gr, nil
+	}
+	return ioutil.NopCloser(in), nil
+}
+
+// ReadBundleManifest reads the manifest, the first entry of a bundle, the rest of the bundle can be read
+// from the reader afterwards
+func ReadBundleManifest(tr *tar.Reader) (*BundleManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the bundle: %s", err.Error())
+	}
+	if hdr.Name != BundleManifestPath {
+		return nil, fmt.Errorf("not a bundle, the first entry is %s rather than the manifest", hdr.Name)
+	}
+	var m BundleManifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse the bundle manifest: %s", err.Error())
+	}
+	if m.Version < 1 || m.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version: %d", m.Version)
+	}
+	return &m, nil
+}
+
+// verify makes sure that files received from a bundle along with their CRC match the ones listed in its manifest
+func (m *BundleManifest) verify(received map[string]uint32) error {
+	if len(received) != len(m.Files) {
+		return fmt.Errorf("the bundle contains %d files while its manifest lists %d", len(received), len(m.Files))
+	}
+	for _, f := range m.Files {
+		crc, ok := received[f.Path]
+		if !ok {
+			return fmt.Errorf("the bundle lacks %s listed in its manifest", f.Path)
+		}
+		if crc != f.CRC32 {
+			return fmt.Errorf("CRC of %s doesn't match the manifest: got %d, expected %d", f.Path, crc, f.CRC32)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// stale listings are dropped once the index has more directories, so listings of inactive factories don't pile up
+	maxIndexedDirs = 8192
+)
+
+type (
+	// objectIndex answers whether objects exist in GCS bucket, and what their CRC is, from listings of
+	// object "directories", e.g. <prefix>/ab/, instead of querying attributes of each object. A directory
+	// is listed once it's looked up for the first time and re-listed once its listing is older than ttl
+	objectIndex struct {
+		ttl  time.Duration
+		lock sync.Mutex
+		dirs map[string]*indexedDir
+	}
+
+	indexedDir struct {
+		lock     sync.Mutex
+		listedAt time.Time
+		objects  map[string]uint32
+	}
+)
+
+// WithListingChecks makes the uploader check whether objects exist by means of listing of the bucket rather than
+// by one request per object, listings are cached for a given period, objects uploaded by the hub are added to them
+func WithListingChecks(ttl time.Duration) UploaderOption {
+	return func(u *Uploader) {
+		u.index = &objectIndex{ttl: ttl, dirs: make(map[string]*indexedDir)}
+	}
+}
+
+// lookup returns CRC of an object, exists is false if the object is absent in the bucket
+func (x *objectIndex) lookup(ctx context.Context, bucket *gcs.BucketHandle, objectName string) (crc uint32, exists bool, err error) {
+	d := x.dir(path.Dir(objectName))
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.objects == nil || time.Since(d.listedAt) > x.ttl {
+		objects, err := listDir(ctx, bucket, path.Dir(objectName))
+		if err != nil {
+			return 0, false, err
+		}
+		d.objects = objects
+		d.listedAt = time.Now()
+		objectListings.Inc()
+	}
+	crc, exists = d.objects[objectName]
+	return crc, exists, nil
+}
+
+// add records an object uploaded by the hub so it's not uploaded again until the directory is re-listed
+func (x *objectIndex) add(objectName string, crc uint32) {
+	d := x.dir(path.Dir(objectName))
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.objects != nil {
+		d.objects[objectName] = crc
+	}
+}
+
+func (x *objectIndex) dir(name string) *indexedDir {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	d, ok := x.dirs[name]
+	if !ok {
+		if len(x.dirs) >= maxIndexedDirs {
+			x.evictStale()
+		}
+		d = &indexedDir{}
+		x.dirs[name] = d
+	}
+	return d
+}
+
+func (x *objectIndex) evictStale() {
+	for name, d := range x.dirs {
+		d.lock.Lock()
+		stale := time.Since(d.listedAt) > x.ttl
+		d.lock.Unlock()
+		if stale {
+			delete(x.dirs, name)
+		}
+	}
+}
+
+func listDir(ctx context.Context, bucket *gcs.BucketHandle, dir string) (map[string]uint32, error) {
+	objects := make(map[string]uint32)
+	q := &gcs.Query{Prefix: dir + "/", Delimiter: "/"}
+	if err := q.SetAttrSelection([]string{"Name", "CRC32C"}); err != nil {
+		return nil, err
+	}
+	it := bucket.Objects(ctx, q)
+	for {
+		attr, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %s", err.Error())
+		}
+		if attr.Name != "" {
+			objects[attr.Name] = attr.CRC32C
+		}
+	}
+	return objects, nil
+}
+
+// objectCRC returns CRC of an object stored in the bucket, either from the index or from the object attributes
+func (u *Uploader) objectCRC(ctx context.Context, obj *gcs.ObjectHandle, objectName string) (crc uint32, exists bool, err error) {
+	ctx, cancel := u.opContext(ctx)
+	defer cancel()
+	if u.index != nil {
+		return u.index.lookup(ctx, u.bucket, objectName)
+	}
+	attr, err := obj.Attrs(ctx)
+	if err == gcs.ErrObjectNotExist {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return attr.CRC32C, true, nil
+}
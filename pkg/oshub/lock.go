@@ -0,0 +1,161 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultLockTTL = 2 * time.Minute
+)
+
+type (
+	// PushLock is a lease a push session holds on a factory repo, it expires unless the session renews it
+	PushLock struct {
+		Holder  string    `json:"holder"`
+		Expires time.Time `json:"expires"`
+	}
+
+	// LockedError is returned if a factory repo is locked by another push session
+	LockedError struct {
+		Lock PushLock
+	}
+
+	// PushLocks are advisory locks of factory repos, they are stored as <Prefix>/<factory>.lock objects
+	// in GCS bucket, so they are shared by all hub instances. A lock is taken and updated by means
+	// of GCS preconditions, so two sessions can't take it at once
+	PushLocks struct {
+		// the uploader of the bucket locks are stored in
+		Uploader *Uploader
+		Prefix   string
+		// DefaultLockTTL if not specified
+		TTL time.Duration
+	}
+)
+
+var errLockChanged = errors.New("the lock has been changed concurrently")
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("the repo is locked by push session %s until %s", e.Lock.Holder, e.Lock.Expires.Format(time.RFC3339))
+}
+
+// Acquire takes or renews a lock of a factory repo for a given push session, a lock held by another session
+// is taken over if it has expired or steal is true, otherwise LockedError is returned
+func (l *PushLocks) Acquire(ctx context.Context, factory string, holder string, steal bool) (*PushLock, error) {
+	for {
+		current, gen, err := l.read(ctx, factory)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && current.Holder != holder && time.Now().Before(current.Expires) && !steal {
+			return nil, &LockedError{Lock: *current}
+		}
+		lock := &PushLock{Holder: holder, Expires: time.Now().Add(l.ttl()).UTC()}
+		err = l.write(ctx, factory, lock, gen)
+		if err == errLockChanged {
+			// another session has just taken or updated it, check it once again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return lock, nil
+	}
+}
+
+// Release releases a lock of a factory repo if it's held by a given push session
+func (l *PushLocks) Release(ctx context.Context, factory string, holder string) error {
+	current, gen, err := l.read(ctx, factory)
+	if err != nil || current == nil {
+		return err
+	}
+	if current.Holder != holder {
+		return &LockedError{Lock: *current}
+	}
+	err = l.object(factory).If(gcs.Conditions{GenerationMatch: gen}).Delete(ctx)
+	if err != nil && err != gcs.ErrObjectNotExist && !isPreconditionFailed(err) {
+		return fmt.Errorf("failed to delete a lock: %s", err.Error())
+	}
+	return nil
+}
+
+// Check returns LockedError if a factory repo is locked by a push session other than a given one
+func (l *PushLocks) Check(ctx context.Context, factory string, holder string) error {
+	current, _, err := l.read(ctx, factory)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Holder != holder && time.Now().Before(current.Expires) {
+		return &LockedError{Lock: *current}
+	}
+	return nil
+}
+
+func (l *PushLocks) ttl() time.Duration {
+	if l.TTL == 0 {
+		return DefaultLockTTL
+	}
+	return l.TTL
+}
+
+func (l *PushLocks) object(factory string) *gcs.ObjectHandle {
+	return l.Uploader.bucket.Object(l.Prefix + "/" + factory + ".lock")
+}
+
+// read returns the current lock of a factory repo and its generation, the lock is nil if the repo isn't locked
+func (l *PushLocks) read(ctx context.Context, factory string) (*PushLock, int64, error) {
+	r, err := l.object(factory).NewReader(ctx)
+	if err == gcs.ErrObjectNotExist {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read a lock: %s", err.Error())
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read a lock: %s", err.Error())
+	}
+	var lock PushLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal a lock: %s", err.Error())
+	}
+	return &lock, r.Attrs.Generation, nil
+}
+
+// write stores a lock if the lock object hasn't been changed since a given generation was read, zero means no object
+func (l *PushLocks) write(ctx context.Context, factory string, lock *PushLock, gen int64) error {
+	cond := gcs.Conditions{GenerationMatch: gen}
+	if gen == 0 {
+		cond = gcs.Conditions{DoesNotExist: true}
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	w := l.object(factory).If(cond).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write a lock: %s", err.Error())
+	}
+	if err := w.Close(); err != nil {
+		if isPreconditionFailed(err) {
+			return errLockChanged
+		}
+		return fmt.Errorf("failed to write a lock: %s", err.Error())
+	}
+	return nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
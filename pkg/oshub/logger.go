@@ -0,0 +1,118 @@
+package oshub
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type (
+	Level int
+
+	// Logger is a leveled structured logger an embedding application can supply,
+	// fields are key-value pairs, e.g. "factory", "foo", "object", "./objects/ab/cdef.filez"
+	Logger interface {
+		Debug(msg string, fields ...interface{})
+		Info(msg string, fields ...interface{})
+		Warn(msg string, fields ...interface{})
+		Error(msg string, fields ...interface{})
+		// With returns a logger adding given fields to each message
+		With(fields ...interface{}) Logger
+	}
+
+	// stdWriter writes to the current output of the standard logger, so redirecting it with log.SetOutput
+	// redirects messages of std loggers too
+	stdWriter struct{}
+
+	stdLogger struct {
+		out    *log.Logger
+		level  Level
+		fields []interface{}
+	}
+)
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	levelNames = map[Level]string{
+		LevelDebug: "DEBUG",
+		LevelInfo:  "INFO",
+		LevelWarn:  "WARN",
+		LevelError: "ERROR",
+	}
+
+	logger Logger = NewStdLogger(LevelInfo)
+)
+
+// NewStdLogger returns a logger printing messages of a given or higher level by means of the standard log package
+func NewStdLogger(level Level) Logger {
+	return &stdLogger{out: log.New(stdWriter{}, "", log.LstdFlags), level: level}
+}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	return log.Writer().Write(p)
+}
+
+// SetLogger sets a logger used by the package
+func SetLogger(l Logger) {
+	logger = l
+}
+
+func (l *stdLogger) Debug(msg string, fields ...interface{}) {
+	l.log(LevelDebug, msg, fields)
+}
+
+func (l *stdLogger) Info(msg string, fields ...interface{}) {
+	l.log(LevelInfo, msg, fields)
+}
+
+func (l *stdLogger) Warn(msg string, fields ...interface{}) {
+	l.log(LevelWarn, msg, fields)
+}
+
+func (l *stdLogger) Error(msg string, fields ...interface{}) {
+	l.log(LevelError, msg, fields)
+}
+
+func (l *stdLogger) With(fields ...interface{}) Logger {
+	all := make([]interface{}, 0, len(l.fields)+len(fields))
+	all = append(append(all, l.fields...), fields...)
+	return &stdLogger{out: l.out, level: l.level, fields: all}
+}
+
+func (l *stdLogger) log(level Level, msg string, fields []interface{}) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(levelNames[level])
+	b.WriteString(" ")
+	b.WriteString(msg)
+	writeFields(&b, l.fields)
+	writeFields(&b, fields)
+	l.out.Print(b.String())
+}
+
+// FormatMessage formats a message along with its key-value fields the way loggers of the package do,
+// e.g. for adapters of other logging libraries
+func FormatMessage(msg string, fields ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	writeFields(&b, fields)
+	return b.String()
+}
+
+func writeFields(b *strings.Builder, fields []interface{}) {
+	for ii := 0; ii < len(fields); ii += 2 {
+		if ii+1 == len(fields) {
+			fmt.Fprintf(b, " %v", fields[ii])
+			break
+		}
+		fmt.Fprintf(b, " %v=%v", fields[ii], fields[ii+1])
+	}
+}
@@ -0,0 +1,69 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"sort"
+	"strings"
+)
+
+type (
+	// ObjectMetadata specifies metadata set on objects whose repo paths start with Prefix, e.g. "objects/",
+	// and end with Suffix if it's set, so a CDN or devices pulling directly from the bucket cache them properly
+	ObjectMetadata struct {
+		Prefix       string
+		ContentType  string
+		CacheControl string
+
+		Suffix string
+	}
+)
+
+const (
+	cacheImmutable string = "public, max-age=31536000, immutable"
+	cacheNone      string = "no-cache"
+)
+
+var (
+	// content addressed objects never change while refs, summary and config are updated by each push,
+	// detached commit metadata is named by its commit and gets new signatures once the commit is signed again
+	defaultObjectMetadata = []ObjectMetadata{
+		{Prefix: "objects/", Suffix: ".commitmeta", ContentType: "application/octet-stream", CacheControl: cacheNone},
+		{Prefix: "objects/", ContentType: "application/octet-stream", CacheControl: cacheImmutable},
+		{Prefix: "deltas/", ContentType: "application/octet-stream", CacheControl: cacheImmutable},
+		{Prefix: "refs/", ContentType: "text/plain", CacheControl: cacheNone},
+		{Prefix: "summary", ContentType: "application/octet-stream", CacheControl: cacheNone},
+		{Prefix: "config", ContentType: "text/plain", CacheControl: cacheNone},
+	}
+)
+
+// WithObjectMetadata replaces the default metadata set on uploaded objects, the longest matching prefix wins,
+// a rule with a suffix wins over one of the same prefix, and objects matching no rule get no metadata,
+// so calling it with no arguments turns the metadata off
+func WithObjectMetadata(rules ...ObjectMetadata) UploaderOption {
+	sorted := make([]ObjectMetadata, len(rules))
+	for ii, r := range rules {
+		r.Prefix = strings.TrimPrefix(r.Prefix, "./")
+		sorted[ii] = r
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if len(sorted[i].Prefix) != len(sorted[j].Prefix) {
+			return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+		}
+		return len(sorted[i].Suffix) > len(sorted[j].Suffix)
+	})
+	return func(u *Uploader) {
+		u.metadata = sorted
+	}
+}
+
+// setMetadata sets metadata of an object being written according to its repo path
+func (u *Uploader) setMetadata(w *gcs.ObjectAttrs, repoPath string) {
+	p := strings.TrimPrefix(repoPath, "./")
+	for _, m := range u.metadata {
+		if strings.HasPrefix(p, m.Prefix) && strings.HasSuffix(p, m.Suffix) {
+			w.ContentType = m.ContentType
+			w.CacheControl = m.CacheControl
+			return
+		}
+	}
+}
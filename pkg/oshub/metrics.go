@@ -0,0 +1,99 @@
+package oshub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace string = "ostreehub"
+
+	failureAttrs  string = "attrs"
+	failureOpen   string = "open"
+	failureWriter string = "writer"
+	failureCopy   string = "copy"
+	failureClose  string = "close"
+	// the upload has been skipped because a client request has been cancelled
+	failureCancelled string = "cancelled"
+)
+
+var (
+	objectsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_received_total",
+		Help:      "Number of files extracted from TAR streams pushed by clients",
+	})
+	bytesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "received_bytes_total",
+		Help:      "Number of bytes of files extracted from TAR streams pushed by clients",
+	})
+	objectsCorrupted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_corrupted_total",
+		Help:      "Number of files extracted from TAR streams whose CRC doesn't match the one specified by clients",
+	})
+	objectsChecked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_checked_total",
+		Help:      "Number of objects checked for presence in GCS bucket by check result",
+	}, []string{"result"})
+	objectsUploaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_synced_total",
+		Help:      "Number of objects synced to GCS bucket by sync result",
+	}, []string{"result"})
+	bytesUploaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Number of bytes uploaded to GCS bucket",
+	})
+	uploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_failures_total",
+		Help:      "Number of failed object uploads to GCS bucket by failure reason",
+	}, []string{"reason"})
+	uploadLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Duration of an object upload to GCS bucket",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	objectListings = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_listings_total",
+		Help:      "Number of listings of object directories made to check presence of objects in GCS bucket",
+	})
+	cdnInvalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cdn_invalidations_total",
+		Help:      "Number of paths invalidated in CDN cache after syncs by result",
+	}, []string{"result"})
+	requestsThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_throttled_total",
+		Help:      "Number of requests rejected with 429 by the exceeded limit",
+	}, []string{"limit"})
+)
+
+// RegisterMetrics registers the package metrics in a given registry, e.g. prometheus.DefaultRegisterer,
+// so a hosting server can expose them for scraping
+func RegisterMetrics(r prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		objectsReceived,
+		bytesReceived,
+		objectsCorrupted,
+		objectsChecked,
+		objectsUploaded,
+		bytesUploaded,
+		uploadFailures,
+		uploadLatency,
+		objectListings,
+		cdnInvalidations,
+		requestsThrottled,
+	} {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
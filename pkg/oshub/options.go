@@ -0,0 +1,148 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	UploaderOption func(*Uploader)
+
+	// ChunkTier specifies a GCS writer chunk size used for objects of MinSize bytes or larger,
+	// zero ChunkSize makes the writer upload an object in a single request which cannot be retried,
+	// a non-zero one makes it do a resumable upload buffering ChunkSize bytes per object in memory
+	ChunkTier struct {
+		MinSize   int64
+		ChunkSize int
+	}
+
+	// RetryConfig tunes retries of GCS requests made by the uploader, zero values keep the GCS client defaults
+	RetryConfig struct {
+		// exponential backoff between attempts of a request
+		InitialBackoff time.Duration
+		MaxBackoff     time.Duration
+		Multiplier     float64
+		// RetryAll makes the client retry non-idempotent requests too, e.g. uploads without preconditions
+		RetryAll bool
+		// Deadline bounds a single object operation including all its retries, e.g. an object upload
+		Deadline time.Duration
+	}
+)
+
+const (
+	// GCS requires chunk sizes of resumable uploads to be multiple of 256 KiB
+	chunkSizeGranularity int = 256 * 1024
+)
+
+var (
+	defaultChunkTiers = []ChunkTier{
+		{MinSize: 0, ChunkSize: 0},
+		{MinSize: 8 * 1024 * 1024, ChunkSize: 4 * 1024 * 1024},
+	}
+)
+
+// WithChunkTiers sets GCS writer chunk sizes per object size tier, it bounds per-worker memory used
+// for uploads of large objects while keeping small object uploads single-shot. NewUploader fails if
+// a chunk size is not a multiple of 256 KiB
+func WithChunkTiers(tiers ...ChunkTier) UploaderOption {
+	sorted := append([]ChunkTier{}, tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinSize < sorted[j].MinSize })
+	return func(u *Uploader) {
+		u.chunkTiers = sorted
+	}
+}
+
+// validateChunkTiers returns an error if a chunk size of a tier is not accepted by GCS
+func validateChunkTiers(tiers []ChunkTier) error {
+	for _, t := range tiers {
+		if t.ChunkSize < 0 || t.ChunkSize%chunkSizeGranularity != 0 {
+			return fmt.Errorf("chunk size must be a multiple of %d bytes: %d", chunkSizeGranularity, t.ChunkSize)
+		}
+	}
+	return nil
+}
+
+// chunkSize returns a chunk size of the tier an object of a given size belongs to
+func (u *Uploader) chunkSize(objectSize int64) int {
+	chunkSize := 0
+	for _, t := range u.chunkTiers {
+		if objectSize >= t.MinSize {
+			chunkSize = t.ChunkSize
+		}
+	}
+	return chunkSize
+}
+
+// WithRetry sets the GCS client retry policy, e.g. longer backoff caps help to get through quota pressure
+// while a deadline makes an object operation fail instead of being retried for too long
+func WithRetry(cfg RetryConfig) UploaderOption {
+	return func(u *Uploader) {
+		u.retry = cfg
+	}
+}
+
+// applyRetry applies the retry configuration to the GCS client
+func (u *Uploader) applyRetry() {
+	var opts []gcs.RetryOption
+	cfg := u.retry
+	if cfg.InitialBackoff > 0 || cfg.MaxBackoff > 0 || cfg.Multiplier > 0 {
+		opts = append(opts, gcs.WithBackoff(gax.Backoff{Initial: cfg.InitialBackoff, Max: cfg.MaxBackoff, Multiplier: cfg.Multiplier}))
+	}
+	if cfg.RetryAll {
+		opts = append(opts, gcs.WithPolicy(gcs.RetryAlways))
+	}
+	if len(opts) > 0 {
+		u.client.SetRetry(opts...)
+	}
+}
+
+// opContext returns a context of a single object operation bound by the configured deadline, it's done
+// once either a given context, e.g. of a client request, or the uploader context is done
+func (u *Uploader) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if u.retry.Deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, u.retry.Deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if u.ctx.Done() == nil {
+		return ctx, cancel
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() { close(stop) })
+		cancel()
+	}
+}
+
+// WithEndpoint makes the uploader talk to a GCS compatible server instead of GCS, e.g. to fake-gcs-server
+// in integration tests or in air-gapped environments, the endpoint is the JSON API base URL like
+// http://localhost:4443/storage/v1/. An emulator usually implements no IAM so Readyz checks only bucket access.
+func WithEndpoint(endpoint string) UploaderOption {
+	return func(u *Uploader) {
+		u.clientOpts = append(u.clientOpts, option.WithEndpoint(endpoint))
+		u.emulated = true
+	}
+}
+
+// WithoutAuthentication makes the uploader send requests without credentials, e.g. to an emulator
+// which doesn't check them and for which no credentials are available
+func WithoutAuthentication() UploaderOption {
+	return func(u *Uploader) {
+		u.clientOpts = append(u.clientOpts, option.WithoutAuthentication())
+	}
+}
@@ -0,0 +1,107 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"strings"
+)
+
+type (
+	// ObjectPrefixFunc returns a prefix of GCS objects of a factory repo
+	ObjectPrefixFunc func(factory string) string
+
+	PruneRequest struct {
+		// paths of objects to delete relative to the repo root, e.g. ./objects/ab/cdef.filez
+		Objects []string `json:"objects"`
+	}
+
+	PruneReport struct {
+		Deleted int               `json:"deleted"`
+		Failed  map[string]string `json:"failed,omitempty"`
+	}
+
+	ObjectInfo struct {
+		// a path relative to the repo root, e.g. ./objects/ab/cdef.filez
+		Path  string `json:"path"`
+		Size  int64  `json:"size"`
+		CRC32 uint32 `json:"crc32c"`
+	}
+
+	// ObjectPage is a page of objects stored in GCS bucket, the next page is requested with NextPageToken
+	// which is empty for the last page
+	ObjectPage struct {
+		Objects       []ObjectInfo `json:"objects"`
+		NextPageToken string       `json:"next_page_token,omitempty"`
+	}
+)
+
+const (
+	DefaultObjectPageSize = 1000
+	MaxObjectPageSize     = 5000
+)
+
+// ListObjects returns paths of objects stored in GCS bucket under a given prefix, paths are relative
+// to the repo root, e.g. ./objects/ab/cdef.filez
+func (u *Uploader) ListObjects(ctx context.Context, objectPrefix string) ([]string, error) {
+	var objects []string
+	it := u.bucket.Objects(ctx, &gcs.Query{Prefix: objectPrefix + "/"})
+	for {
+		attr, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %s", err.Error())
+		}
+		objects = append(objects, "./objects"+strings.TrimPrefix(attr.Name, objectPrefix))
+	}
+	return objects, nil
+}
+
+// ListObjectsPage returns a page of objects stored in GCS bucket under a given prefix along with their size and CRC,
+// an empty page token requests the first page
+func (u *Uploader) ListObjectsPage(ctx context.Context, objectPrefix string, pageToken string, pageSize int) (*ObjectPage, error) {
+	it := u.bucket.Objects(ctx, &gcs.Query{Prefix: objectPrefix + "/"})
+	var attrs []*gcs.ObjectAttrs
+	nextToken, err := iterator.NewPager(it, pageSize, pageToken).NextPage(&attrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %s", err.Error())
+	}
+	page := &ObjectPage{Objects: make([]ObjectInfo, 0, len(attrs)), NextPageToken: nextToken}
+	for _, attr := range attrs {
+		page.Objects = append(page.Objects, ObjectInfo{
+			Path:  "./objects" + strings.TrimPrefix(attr.Name, objectPrefix),
+			Size:  attr.Size,
+			CRC32: attr.CRC32C,
+		})
+	}
+	return page, nil
+}
+
+// DeleteObjects deletes given objects from GCS bucket, a failure to delete one object doesn't stop deletion of the rest
+func (u *Uploader) DeleteObjects(ctx context.Context, objectPrefix string, objects []string) *PruneReport {
+	report := &PruneReport{Failed: make(map[string]string)}
+	for _, o := range objects {
+		if err := validObjectPath(o); err != nil {
+			report.Failed[o] = err.Error()
+			continue
+		}
+		err := u.bucket.Object(objectName(objectPrefix, o)).Delete(ctx)
+		if err != nil && err != gcs.ErrObjectNotExist {
+			logger.Warn("Failed to delete an object", "object", o, "err", err)
+			report.Failed[o] = err.Error()
+			continue
+		}
+		report.Deleted += 1
+	}
+	return report
+}
+
+func validObjectPath(p string) error {
+	if !strings.HasPrefix(p, "./objects/") || strings.Contains(p, "..") {
+		return fmt.Errorf("invalid object path: %s", p)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUsageTTL = 10 * time.Minute
+)
+
+type (
+	// Quota limits storage taken by a factory repo, a zero limit means no limit
+	Quota struct {
+		MaxBytes   int64 `json:"max_bytes,omitempty"`
+		MaxObjects int64 `json:"max_objects,omitempty"`
+	}
+
+	// QuotaFunc returns a quota of a given factory
+	QuotaFunc func(factory string) Quota
+
+	// Usage is storage taken by objects of a factory repo in GCS bucket
+	Usage struct {
+		Bytes   int64 `json:"bytes"`
+		Objects int64 `json:"objects"`
+	}
+
+	QuotaError struct {
+		Factory string
+		Usage   Usage
+		Quota   Quota
+	}
+
+	// QuotaEnforcer rejects pushes of factories that have exceeded their quota. Usage of a factory is obtained
+	// by listing its objects in GCS bucket and is cached for UsageTTL, bytes received in between are added to it
+	QuotaEnforcer struct {
+		Uploader     *Uploader
+		Quota        QuotaFunc
+		ObjectPrefix ObjectPrefixFunc
+		UsageTTL     time.Duration
+
+		lock  sync.Mutex
+		usage map[string]*cachedUsage
+	}
+
+	cachedUsage struct {
+		Usage
+		updatedAt time.Time
+	}
+
+	// quotaReader fails a request body once more bytes than left are read from it
+	quotaReader struct {
+		r    io.ReadCloser
+		left int64
+		read int64
+		err  *QuotaError
+	}
+)
+
+func (e *QuotaError) Error() string {
+	if e.Quota.MaxObjects > 0 && e.Usage.Objects >= e.Quota.MaxObjects {
+		return fmt.Sprintf("factory %s has exceeded its storage quota: %d objects stored, the limit is %d objects",
+			e.Factory, e.Usage.Objects, e.Quota.MaxObjects)
+	}
+	return fmt.Sprintf("factory %s has exceeded its storage quota: %d bytes stored, the limit is %d bytes",
+		e.Factory, e.Usage.Bytes, e.Quota.MaxBytes)
+}
+
+func (q Quota) exceeded(u Usage) bool {
+	return (q.MaxBytes > 0 && u.Bytes >= q.MaxBytes) || (q.MaxObjects > 0 && u.Objects >= q.MaxObjects)
+}
+
+// StorageUsage returns a total size and number of objects stored in GCS bucket under a given prefix
+func (u *Uploader) StorageUsage(ctx context.Context, objectPrefix string) (Usage, error) {
+	var usage Usage
+	it := u.bucket.Objects(ctx, &gcs.Query{Prefix: objectPrefix + "/"})
+	for {
+		attr, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return usage, fmt.Errorf("failed to list objects: %s", err.Error())
+		}
+		usage.Bytes += attr.Size
+		usage.Objects += 1
+	}
+	return usage, nil
+}
+
+// Usage returns the cached usage of a factory, it's refreshed once it's older than UsageTTL
+func (q *QuotaEnforcer) Usage(ctx context.Context, factory string) (Usage, error) {
+	q.lock.Lock()
+	cached, ok := q.usage[factory]
+	q.lock.Unlock()
+	ttl := q.UsageTTL
+	if ttl == 0 {
+		ttl = defaultUsageTTL
+	}
+	if ok && time.Since(cached.updatedAt) < ttl {
+		return cached.Usage, nil
+	}
+
+	u, err := q.Uploader.StorageUsage(ctx, q.ObjectPrefix(factory))
+	if err != nil {
+		return u, err
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.usage == nil {
+		q.usage = make(map[string]*cachedUsage)
+	}
+	q.usage[factory] = &cachedUsage{Usage: u, updatedAt: time.Now()}
+	return u, nil
+}
+
+// Check returns QuotaError if a factory has exceeded its quota
+func (q *QuotaEnforcer) Check(ctx context.Context, factory string) error {
+	_, _, err := q.check(ctx, factory)
+	return err
+}
+
+// check returns a quota of a factory and its usage, the usage is zero if the factory has no quota,
+// QuotaError is returned if the factory has exceeded the quota
+func (q *QuotaEnforcer) check(ctx context.Context, factory string) (Quota, Usage, error) {
+	quota := q.Quota(factory)
+	if quota.MaxBytes == 0 && quota.MaxObjects == 0 {
+		return quota, Usage{}, nil
+	}
+	u, err := q.Usage(ctx, factory)
+	if err != nil {
+		return quota, u, err
+	}
+	if quota.exceeded(u) {
+		return quota, u, &QuotaError{Factory: factory, Usage: u, Quota: quota}
+	}
+	return quota, u, nil
+}
+
+// Admit rejects a request of a factory that has exceeded its quota with QuotaError, a body of an accepted streaming
+// upload, see IsStreamingUpload, is cut off once it reaches the remaining bytes quota. The returned function accounts
+// bytes read from the body, it has to be called once the request is handled
+func (q *QuotaEnforcer) Admit(r *http.Request, factory string) (func(), error) {
+	quota, u, err := q.check(r.Context(), factory)
+	if err != nil {
+		return nil, err
+	}
+	if quota.MaxBytes == 0 || !IsStreamingUpload(r) {
+		return func() {}, nil
+	}
+	// the usage is the one the quota has just been checked against, so the remaining bytes are never
+	// calculated from a usage that failed to refresh
+	body := &quotaReader{r: r.Body, left: quota.MaxBytes - u.Bytes,
+		err: &QuotaError{Factory: factory, Usage: Usage{Bytes: quota.MaxBytes, Objects: u.Objects}, Quota: quota}}
+	r.Body = body
+	return func() { q.addBytes(factory, body.read) }, nil
+}
+
+// addBytes accounts bytes received since the usage was calculated, compressed TAR streams make it approximate
+func (q *QuotaEnforcer) addBytes(factory string, n int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if cached, ok := q.usage[factory]; ok {
+		cached.Bytes += n
+	}
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, r.err
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= int64(n)
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *quotaReader) Close() error {
+	return r.r.Close()
+}
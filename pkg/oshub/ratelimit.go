@@ -0,0 +1,94 @@
+package oshub
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// StreamRetryAfter is a delay a client is asked to retry after if a factory has too many concurrent streams
+	StreamRetryAfter = 5 * time.Second
+)
+
+type (
+	// RequestLimiter throttles requests of each factory, both their rate and a number of concurrent
+	// PUT/PATCH streams, a zero limit means no limit
+	RequestLimiter struct {
+		// requests per second, each factory has its own token bucket
+		Rate  float64
+		Burst int
+		// concurrent TAR streams per factory
+		MaxStreams int
+
+		lock    sync.Mutex
+		buckets map[string]*tokenBucket
+		streams map[string]int
+	}
+
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// Take takes a token from a factory bucket for a request, it returns how long to wait for a token if there is none
+func (l *RequestLimiter) Take(factory string) time.Duration {
+	if l.Rate <= 0 {
+		return 0
+	}
+	burst := float64(l.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	now := time.Now()
+	b, ok := l.buckets[factory]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[factory] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		requestsThrottled.WithLabelValues("rate").Inc()
+		return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	b.tokens -= 1
+	return 0
+}
+
+// AcquireStream counts a TAR stream of a factory, it returns false if the factory has too many concurrent streams,
+// otherwise the stream has to be released by ReleaseStream once it's processed
+func (l *RequestLimiter) AcquireStream(factory string) bool {
+	if l.MaxStreams <= 0 {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.streams == nil {
+		l.streams = make(map[string]int)
+	}
+	if l.streams[factory] >= l.MaxStreams {
+		requestsThrottled.WithLabelValues("streams").Inc()
+		return false
+	}
+	l.streams[factory] += 1
+	return true
+}
+
+// ReleaseStream releases a stream counted by AcquireStream
+func (l *RequestLimiter) ReleaseStream(factory string) {
+	if l.MaxStreams <= 0 {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.streams[factory] -= 1; l.streams[factory] <= 0 {
+		delete(l.streams, factory)
+	}
+}
@@ -0,0 +1,217 @@
+package oshub
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Receipt describes what OSTree Hub has published for a factory as a result of a push
+	Receipt struct {
+		Factory string            `json:"factory"`
+		Refs    map[string]string `json:"refs"`
+		// number of objects synced by the push, zero if the hub doesn't count them, see ReceiptSigner.Sessions
+		Objects   uint32    `json:"objects"`
+		Timestamp time.Time `json:"timestamp"`
+		Hub       string    `json:"hub"`
+	}
+
+	// SignedReceipt is a receipt serialized to JSON along with an Ed25519 signature of the serialized data,
+	// the serialized receipt is kept as is (base64 encoded) so re-formatting of a stored receipt doesn't break the signature
+	SignedReceipt struct {
+		Receipt   []byte `json:"receipt"`
+		Signature []byte `json:"signature"`
+	}
+
+	// ReceiptSigner finalizes push sessions, receipts are not signed if Key is nil,
+	// if Audit is set the finalized sessions along with their metadata are recorded to the audit log
+	ReceiptSigner struct {
+		Hub     string
+		Key     ed25519.PrivateKey
+		RepoDir RepoDirFunc
+		Audit   *AuditLog
+		// optional, counts objects synced by push sessions, a receipt reports objects of the finalized session
+		Sessions *PushSessions
+	}
+
+	// PushSessions counts objects synced by push sessions, a hub adds a SyncReport of each TAR stream sent
+	// with SessionHeader to it. Counters are kept in memory of a hub instance, so all streams of a session
+	// have to reach the same instance, counters of sessions that haven't been finalized expire after SessionTTL.
+	PushSessions struct {
+		lock     sync.Mutex
+		sessions map[string]*sessionObjects
+	}
+
+	sessionObjects struct {
+		objects uint32
+		updated time.Time
+	}
+)
+
+const (
+	// a push session is forgotten if no stream of it has been synced for this long
+	SessionTTL = 24 * time.Hour
+)
+
+// Add counts objects synced by a stream of a given push session
+func (s *PushSessions) Add(session string, r *SyncReport) {
+	if session == "" {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*sessionObjects)
+	}
+	now := time.Now()
+	for id, so := range s.sessions {
+		if now.Sub(so.updated) > SessionTTL {
+			delete(s.sessions, id)
+		}
+	}
+	so, ok := s.sessions[session]
+	if !ok {
+		so = &sessionObjects{}
+		s.sessions[session] = so
+	}
+	so.objects += r.ObjectNumb
+	so.updated = now
+}
+
+// Objects returns a number of objects synced by a given push session
+func (s *PushSessions) Objects(session string) uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if so, ok := s.sessions[session]; ok {
+		return so.objects
+	}
+	return 0
+}
+
+// NewReceipt makes a receipt listing refs, along with commits they point to, stored in a given factory repo
+// and a number of objects synced by the push
+func NewReceipt(factory string, hub string, repoDir string, objects uint32) (*Receipt, error) {
+	r := Receipt{Factory: factory, Refs: map[string]string{}, Objects: objects, Timestamp: time.Now().UTC(), Hub: hub}
+	refsDir := filepath.Join(repoDir, "refs")
+	if err := filepath.Walk(refsDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		commit, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		ref, err := filepath.Rel(refsDir, p)
+		if err != nil {
+			return err
+		}
+		r.Refs[filepath.ToSlash(ref)] = strings.TrimSpace(string(commit))
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read refs of %s: %s", repoDir, err.Error())
+	}
+	return &r, nil
+}
+
+func SignReceipt(r *Receipt, key ed25519.PrivateKey) (*SignedReceipt, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal a receipt: %s", err.Error())
+	}
+	if key == nil {
+		return &SignedReceipt{Receipt: data}, nil
+	}
+	return &SignedReceipt{Receipt: data, Signature: ed25519.Sign(key, data)}, nil
+}
+
+// VerifyReceipt checks a receipt signature and returns the receipt if the signature is valid
+func VerifyReceipt(sr *SignedReceipt, key ed25519.PublicKey) (*Receipt, error) {
+	if !ed25519.Verify(key, sr.Receipt, sr.Signature) {
+		return nil, fmt.Errorf("invalid receipt signature")
+	}
+	var r Receipt
+	if err := json.Unmarshal(sr.Receipt, &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal a receipt: %s", err.Error())
+	}
+	return &r, nil
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 Ed25519 private key the hub signs receipts with
+func LoadPrivateKey(keyFile string) (ed25519.PrivateKey, error) {
+	der, err := readPem(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse a private key: %s", err.Error())
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 private key: %s", keyFile)
+	}
+	return edKey, nil
+}
+
+// LoadPublicKey reads a PEM encoded PKIX Ed25519 public key receipts are verified with
+func LoadPublicKey(keyFile string) (ed25519.PublicKey, error) {
+	der, err := readPem(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse a public key: %s", err.Error())
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 public key: %s", keyFile)
+	}
+	return edKey, nil
+}
+
+func readPem(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read a key file: %s", err.Error())
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	return block.Bytes, nil
+}
+
+// Finalize finalizes a push session of a factory, it returns a signed receipt of the current state of the factory repo,
+// the session along with its metadata is recorded to the audit log if it's set
+func (s *ReceiptSigner) Finalize(factory string, session string, meta map[string]string) (*SignedReceipt, error) {
+	var objects uint32
+	if s.Sessions != nil {
+		objects = s.Sessions.Objects(session)
+	}
+	r, err := NewReceipt(factory, s.Hub, s.RepoDir(factory), objects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make a receipt: %s", err.Error())
+	}
+	if s.Audit != nil {
+		if err := s.Audit.Record(&AuditRecord{
+			Session:   session,
+			Factory:   factory,
+			Timestamp: r.Timestamp,
+			Meta:      meta,
+			Refs:      r.Refs,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to record a push session: %s", err.Error())
+		}
+	}
+	return SignReceipt(r, s.Key)
+}
@@ -0,0 +1,156 @@
+package oshub
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// RefGuard rejects updates of refs of a factory repo that don't fast-forward them, i.e. a new commit
+	// of a ref doesn't descend from the stored one, so a push of a stale repo doesn't roll a branch back
+	RefGuard struct {
+		// a directory of the factory repo stored refs are read from
+		RepoDir string
+		// commits absent in the repo directory and in a directory a stream is extracted to are read
+		// from the bucket of Uploader under ObjectPrefix, Uploader is optional
+		Uploader     *Uploader
+		ObjectPrefix string
+	}
+
+	// RefUpdateError reports a ref update rejected by RefGuard
+	RefUpdateError struct {
+		Ref    string
+		Stored string
+		New    string
+		Reason string
+	}
+)
+
+const (
+	// HTTP header fiopush sets to "true" to make the hub accept ref updates that don't fast-forward refs
+	ForceRefsHeader string = "X-Fio-Force-Refs"
+
+	// a sanity limit of a ref file size, a ref is a commit checksum followed by a new line
+	maxRefSize int64 = 1024
+)
+
+func (e *RefUpdateError) Error() string {
+	return fmt.Sprintf("non-fast-forward update of %s from %s to %s: %s, force it to update the ref anyway",
+		e.Ref, e.Stored, e.New, e.Reason)
+}
+
+// IsForceRefs tells whether a request header asks to accept ref updates that don't fast-forward refs
+func IsForceRefs(header string) bool {
+	return isTrue(header)
+}
+
+// Check makes sure a ref, e.g. heads/main, may be updated to a given commit, it's fine if the ref doesn't exist
+// yet or the commit descends from the stored one. Commits are looked up in given directories, e.g. the one
+// a stream is extracted to, then in the repo directory and the bucket.
+func (g *RefGuard) Check(ctx context.Context, ref string, commit string, dirs ...string) error {
+	data, err := ioutil.ReadFile(filepath.Join(g.RepoDir, "refs", filepath.FromSlash(ref)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ref %s: %s", ref, err.Error())
+	}
+	stored := strings.TrimSpace(string(data))
+	if stored == commit {
+		return nil
+	}
+	ok, err := ostree.IsAncestor(stored, commit, func(csum string) (*ostree.Commit, error) {
+		return g.readCommit(ctx, csum, dirs)
+	})
+	if err != nil {
+		return &RefUpdateError{Ref: ref, Stored: stored, New: commit, Reason: "the history of the new commit is incomplete, " + err.Error()}
+	}
+	if !ok {
+		return &RefUpdateError{Ref: ref, Stored: stored, New: commit, Reason: "the new commit doesn't descend from the stored one"}
+	}
+	return nil
+}
+
+// checkImported checks refs among files extracted to a scratch directory before they are installed to the repo,
+// updates that don't fast-forward refs are accepted with a warning if force is set
+func (g *RefGuard) checkImported(ctx context.Context, scratch string, files []*RepoFile, force bool, l Logger) error {
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, "./refs/") {
+			continue
+		}
+		r, err := os.Open(filepath.Join(scratch, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return err
+		}
+		commit, _, err := readRefEntry(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", f.Path, err.Error())
+		}
+		err = g.Check(ctx, strings.TrimPrefix(f.Path, "./refs/"), commit, scratch)
+		var updateErr *RefUpdateError
+		if errors.As(err, &updateErr) && force {
+			l.Warn("Forced a non-fast-forward ref update", "ref", updateErr.Ref, "stored", updateErr.Stored, "new", updateErr.New)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCommit reads a commit from the first directory having it, or from the bucket
+func (g *RefGuard) readCommit(ctx context.Context, csum string, dirs []string) (*ostree.Commit, error) {
+	for _, d := range append(dirs, g.RepoDir) {
+		r := ostree.Repo{Dir: d}
+		if r.HasObject(csum, ostree.ObjectCommit) {
+			return r.ReadCommit(csum)
+		}
+	}
+	if g.Uploader == nil || len(csum) != 2*sha256.Size {
+		return nil, os.ErrNotExist
+	}
+	obj := g.Uploader.bucket.Object(objectName(g.ObjectPrefix, ostree.ObjectRelPath(csum, ostree.ObjectCommit)))
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ostree.ParseCommit(data)
+}
+
+// readRefEntry reads a commit checksum a ref entry of a TAR stream points to
+func readRefEntry(r io.Reader) (string, []byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxRefSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if int64(len(data)) > maxRefSize {
+		return "", nil, fmt.Errorf("the ref is larger than %d bytes", maxRefSize)
+	}
+	return strings.TrimSpace(string(data)), data, nil
+}
+
+// WithRefGuard makes Untar reject refs that don't fast-forward refs of a factory repo checked by a given guard,
+// a rejected ref isn't extracted and its failure is passed through Sync so it's reported to the client.
+// A ref sent as a hard link is checked against the commit of its link target, a ref sent as a symlink is rejected.
+// If force is set, e.g. a client has sent ForceRefsHeader, such refs are accepted with a warning.
+func WithRefGuard(g *RefGuard, force bool) UntarOption {
+	return func(c *untarConfig) {
+		c.refGuard = g
+		c.forceRefs = force
+	}
+}
@@ -0,0 +1,211 @@
+package oshub
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"foundriesio/ostreehub/pkg/ostree"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCommit writes a commit object of a given parent, (a{sv}aya(say)sstayay), to a repo and returns its checksum
+func writeCommit(t *testing.T, repoDir string, parent string, subject string) string {
+	p, err := hex.DecodeString(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	// ends of variable-sized non-last members: a{sv}, ay, a(say), s, s, ay
+	var ends []int
+	ends = append(ends, len(data))
+	data = append(data, p...)
+	ends = append(ends, len(data), len(data))
+	data = append(append(data, subject...), 0)
+	ends = append(ends, len(data))
+	data = append(data, 0)
+	ends = append(ends, len(data))
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	data = append(data, make([]byte, 8)...)
+	data = append(data, bytes.Repeat([]byte{0x22}, 32)...)
+	ends = append(ends, len(data))
+	data = append(data, bytes.Repeat([]byte{0x33}, 32)...)
+	// the object is smaller than 256 bytes, so framing offsets take a byte each and go in reverse order
+	for i := len(ends) - 1; i >= 0; i-- {
+		data = append(data, byte(ends[i]))
+	}
+	if c, err := ostree.ParseCommit(data); err != nil || c.Parent != parent {
+		t.Fatalf("failed to make a commit: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	csum := hex.EncodeToString(sum[:])
+	writeRepoFiles(t, repoDir, map[string]string{ostree.ObjectRelPath(csum, ostree.ObjectCommit): string(data)})
+	return csum
+}
+
+type refGuardFixture struct {
+	guard  *RefGuard
+	stream string
+	// b descends from a and is stored as heads/main, c descends from b and is sent in a stream along with e
+	// whose parent is absent, d is a root commit stored in the repo
+	a, b, c, d, e string
+}
+
+func newRefGuardFixture(t *testing.T) *refGuardFixture {
+	repo, err := ioutil.TempDir("", "oshub-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(repo) })
+	stream, err := ioutil.TempDir("", "oshub-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(stream) })
+	f := &refGuardFixture{guard: &RefGuard{RepoDir: repo}, stream: stream}
+	f.a = writeCommit(t, repo, "", "a")
+	f.b = writeCommit(t, repo, f.a, "b")
+	f.c = writeCommit(t, stream, f.b, "c")
+	f.d = writeCommit(t, repo, "", "d")
+	f.e = writeCommit(t, stream, strings.Repeat("44", 32), "e")
+	writeRepoFiles(t, repo, map[string]string{"./refs/heads/main": f.b + "\n"})
+	return f
+}
+
+func (f *refGuardFixture) storedRef(t *testing.T) string {
+	data, err := ioutil.ReadFile(filepath.Join(f.guard.RepoDir, "refs", "heads", "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestRefGuardCheck(t *testing.T) {
+	f := newRefGuardFixture(t)
+	tests := []struct {
+		name   string
+		ref    string
+		commit string
+		// a part of the reason of the expected RefUpdateError, if any
+		reason string
+	}{
+		{"new ref", "heads/new", f.a, ""},
+		{"same commit", "heads/main", f.b, ""},
+		{"fast-forward", "heads/main", f.c, ""},
+		{"rollback", "heads/main", f.a, "doesn't descend"},
+		{"unrelated", "heads/main", f.d, "doesn't descend"},
+		{"missing history", "heads/main", f.e, "history of the new commit is incomplete"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := f.guard.Check(context.Background(), tc.ref, tc.commit, f.stream)
+			if tc.reason == "" {
+				if err != nil {
+					t.Fatalf("the ref update has been rejected: %s", err)
+				}
+				return
+			}
+			var updateErr *RefUpdateError
+			if !errors.As(err, &updateErr) || !strings.Contains(updateErr.Reason, tc.reason) {
+				t.Fatalf("unexpected error: %v, expected %q", err, tc.reason)
+			}
+			if updateErr.Stored != f.b || updateErr.New != tc.commit {
+				t.Errorf("unexpected commits of the rejected update: %+v", updateErr)
+			}
+		})
+	}
+}
+
+func TestRefGuardCheckImported(t *testing.T) {
+	f := newRefGuardFixture(t)
+	files := []*RepoFile{{Path: "./objects/ab/cdef.commit"}, {Path: "./refs/heads/main"}}
+	writeRepoFiles(t, f.stream, map[string]string{"./refs/heads/main": f.a + "\n"})
+	l := NewStdLogger(LevelError)
+	var updateErr *RefUpdateError
+	if err := f.guard.checkImported(context.Background(), f.stream, files, false, l); !errors.As(err, &updateErr) {
+		t.Fatalf("the imported rollback of the ref has been accepted: %v", err)
+	}
+	if err := f.guard.checkImported(context.Background(), f.stream, files, true, l); err != nil {
+		t.Fatalf("the forced rollback of the ref has been rejected: %s", err)
+	}
+	writeRepoFiles(t, f.stream, map[string]string{"./refs/heads/main": f.c + "\n"})
+	if err := f.guard.checkImported(context.Background(), f.stream, files, false, l); err != nil {
+		t.Fatalf("the imported fast-forward of the ref has been rejected: %s", err)
+	}
+}
+
+func TestUntarRefGuard(t *testing.T) {
+	tests := []struct {
+		name string
+		// the ref is sent as a link to another ref of the stream
+		link  bool
+		force bool
+	}{
+		{"file", false, false},
+		{"forced file", false, true},
+		{"link", true, false},
+		{"forced link", true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newRefGuardFixture(t)
+			pr, pw := io.Pipe()
+			go func() {
+				tw := tar.NewWriter(pw)
+				data := f.a + "\n"
+				if tc.link {
+					tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./refs/heads/new", Mode: 0644, Size: int64(len(data))})
+					io.WriteString(tw, data)
+					tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "./refs/heads/main", Linkname: "./refs/heads/new", Mode: 0644})
+				} else {
+					tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./refs/heads/main", Mode: 0644, Size: int64(len(data))})
+					io.WriteString(tw, data)
+				}
+				pw.CloseWithError(tw.Close())
+			}()
+			errs := make(map[string]string)
+			for file := range Untar(tar.NewReader(pr), f.guard.RepoDir, NewStdLogger(LevelError), WithRefGuard(f.guard, tc.force)) {
+				errs[file.Path] = file.Err()
+			}
+			if tc.force {
+				if errs["./refs/heads/main"] != "" || f.storedRef(t) != f.a {
+					t.Fatalf("the forced rollback of the ref hasn't been applied: %v", errs)
+				}
+				return
+			}
+			if !strings.Contains(errs["./refs/heads/main"], "non-fast-forward") {
+				t.Fatalf("the rollback of the ref hasn't been rejected: %v", errs)
+			}
+			if f.storedRef(t) != f.b {
+				t.Fatalf("the rejected rollback has changed the stored ref")
+			}
+		})
+	}
+}
+
+func TestUntarRefGuardSymlink(t *testing.T) {
+	f := newRefGuardFixture(t)
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "./refs/heads/main", Linkname: "../../../old-main", Mode: 0777})
+		pw.CloseWithError(tw.Close())
+	}()
+	for file := range Untar(tar.NewReader(pr), f.guard.RepoDir, NewStdLogger(LevelError), WithRefGuard(f.guard, true)) {
+		if !strings.Contains(file.Err(), "symlink") {
+			t.Fatalf("the symlinked ref hasn't been rejected: %q", file.Err())
+		}
+	}
+	if f.storedRef(t) != f.b {
+		t.Fatalf("the rejected symlink has changed the stored ref")
+	}
+}
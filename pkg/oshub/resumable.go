@@ -0,0 +1,166 @@
+package oshub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// ID of a resumable upload, a client picks it when it starts an upload
+	UploadIDHeader string = "X-Fio-Upload-Id"
+	// offset of a chunk within a resumable upload, the hub responds with a number of bytes it has received so far
+	UploadOffsetHeader string = "X-Fio-Upload-Offset"
+	// set to "true" along with the last chunk of a resumable upload
+	UploadCompleteHeader string = "X-Fio-Upload-Complete"
+)
+
+var (
+	uploadIDRegex = regexp.MustCompile(`^[a-zA-Z0-9-]{8,64}$`)
+
+	// ErrUploadInProgress is returned by Upload.Acquire if another chunk of the upload is being appended
+	ErrUploadInProgress = errors.New("the upload is in progress")
+)
+
+type (
+	// UploadStore keeps TAR streams being uploaded by means of chunks so a client can resume an upload
+	// from the last received byte after a connection drop instead of sending the whole stream again,
+	// see echohub.OffsetHandler and echohub.ChunkHandler
+	UploadStore struct {
+		Dir string
+		// uploads not updated for this long are removed by Expire
+		MaxAge time.Duration
+
+		lock   sync.Mutex
+		active map[string]bool
+	}
+
+	// Upload is a TAR stream of a factory being uploaded by means of chunks
+	Upload struct {
+		store *UploadStore
+		path  string
+	}
+)
+
+// Upload returns an upload of a factory with a given ID picked by a client, the factory is empty
+// if the hub serves a single one
+func (s *UploadStore) Upload(factory string, id string) (*Upload, error) {
+	if !uploadIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("invalid upload ID: %q", id)
+	}
+	if factory == "" {
+		factory = "default"
+	} else if factory != filepath.Base(factory) || factory == ".." {
+		return nil, fmt.Errorf("invalid factory: %q", factory)
+	}
+	return &Upload{store: s, path: filepath.Join(s.Dir, factory, id+".tar")}, nil
+}
+
+// Offset returns a number of bytes of the upload received so far, it's zero for an unknown upload
+func (u *Upload) Offset() (int64, error) {
+	return uploadOffset(u.path)
+}
+
+// Acquire makes sure chunks of the upload are appended one at a time, it returns ErrUploadInProgress
+// if another chunk is being appended, otherwise the upload has to be released by Release
+func (u *Upload) Acquire() error {
+	if !u.store.acquire(u.path) {
+		return ErrUploadInProgress
+	}
+	return nil
+}
+
+// Release lets other chunks of the upload be appended
+func (u *Upload) Release() {
+	u.store.release(u.path)
+}
+
+// Append appends a chunk to the upload and returns a number of bytes appended, whatever has been received
+// before a connection drop is kept, so the client resumes from there
+func (u *Upload) Append(r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(u.path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create an upload directory: %s", err.Error())
+	}
+	f, err := os.OpenFile(u.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open an upload file: %s", err.Error())
+	}
+	written, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, fmt.Errorf("failed to receive an upload chunk: %s", err.Error())
+	}
+	return written, nil
+}
+
+// Open opens the complete upload for reading, it's removed by Remove once it has been processed
+func (u *Upload) Open() (io.ReadCloser, error) {
+	f, err := os.Open(u.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open an upload file: %s", err.Error())
+	}
+	return f, nil
+}
+
+// Remove removes the upload, e.g. once it has been processed
+func (u *Upload) Remove() error {
+	return os.Remove(u.path)
+}
+
+// Expire removes uploads that haven't been updated for MaxAge and returns their number
+func (s *UploadStore) Expire() int {
+	var expired int
+	_ = filepath.Walk(s.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || time.Since(info.ModTime()) < s.MaxAge {
+			return nil
+		}
+		if !s.acquire(p) {
+			return nil
+		}
+		defer s.release(p)
+		if err := os.Remove(p); err != nil {
+			logger.Warn("Failed to remove an expired upload", "file", p, "err", err)
+			return nil
+		}
+		expired += 1
+		return nil
+	})
+	return expired
+}
+
+func (s *UploadStore) acquire(p string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active == nil {
+		s.active = make(map[string]bool)
+	}
+	if s.active[p] {
+		return false
+	}
+	s.active[p] = true
+	return true
+}
+
+func (s *UploadStore) release(p string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.active, p)
+}
+
+func uploadOffset(p string) (int64, error) {
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
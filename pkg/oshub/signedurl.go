@@ -0,0 +1,95 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSignedURLExpiry = 15 * time.Minute
+	// the longest expiry GCS accepts for V4 signed URLs
+	MaxSignedURLExpiry = 7 * 24 * time.Hour
+)
+
+type (
+	// URLSigner mints time-limited signed URLs to download files of factory repos directly from GCS bucket,
+	// so devices can pull from a private bucket. Signing requires the hub's credentials to be either
+	// a service account key or a service account with iam.serviceAccounts.signBlob permission on itself.
+	URLSigner struct {
+		Uploader     *Uploader
+		ObjectPrefix ObjectPrefixFunc
+		// optional, a prefix of GCS objects of repo files other than objects, e.g. refs and summary,
+		// only objects can be signed if it's not set
+		RepoPrefix ObjectPrefixFunc
+		// MaxSignedURLExpiry if not specified
+		MaxExpiry time.Duration
+	}
+
+	SignedURL struct {
+		// a path relative to the repo root, e.g. ./objects/ab/cdef.filez or ./refs/heads/main
+		Path    string    `json:"path"`
+		URL     string    `json:"url"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+// Sign returns signed URLs of given repo files valid for a given period
+func (s *URLSigner) Sign(factory string, paths []string, expiry time.Duration) ([]SignedURL, error) {
+	if err := s.Validate(factory, paths, expiry); err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(expiry).UTC().Truncate(time.Second)
+	urls := make([]SignedURL, 0, len(paths))
+	for _, p := range paths {
+		name, err := s.objectName(factory, p)
+		if err != nil {
+			return nil, err
+		}
+		u, err := s.Uploader.bucket.SignedURL(name, &gcs.SignedURLOptions{
+			Method:  http.MethodGet,
+			Expires: expires,
+			Scheme:  gcs.SigningSchemeV4,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign URL of %s: %s", p, err.Error())
+		}
+		urls = append(urls, SignedURL{Path: p, URL: u, Expires: expires})
+	}
+	return urls, nil
+}
+
+// Validate fails if given repo files can't be signed or the expiry is out of the allowed range
+func (s *URLSigner) Validate(factory string, paths []string, expiry time.Duration) error {
+	if expiry <= 0 || expiry > s.maxExpiry() {
+		return fmt.Errorf("invalid expiry %s, it must be in the range 1s-%s", expiry, s.maxExpiry())
+	}
+	for _, p := range paths {
+		if _, err := s.objectName(factory, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *URLSigner) objectName(factory string, p string) (string, error) {
+	if strings.HasPrefix(p, "./objects/") {
+		if err := validObjectPath(p); err != nil {
+			return "", err
+		}
+		return objectName(s.ObjectPrefix(factory), p), nil
+	}
+	if s.RepoPrefix == nil || !strings.HasPrefix(p, "./") || strings.Contains(p, "..") {
+		return "", fmt.Errorf("invalid path or signing of non-object paths is not configured: %s", p)
+	}
+	return s.RepoPrefix(factory) + "/" + strings.TrimPrefix(p, "./"), nil
+}
+
+func (s *URLSigner) maxExpiry() time.Duration {
+	if s.MaxExpiry > 0 && s.MaxExpiry < MaxSignedURLExpiry {
+		return s.MaxExpiry
+	}
+	return MaxSignedURLExpiry
+}
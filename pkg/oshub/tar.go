@@ -2,29 +2,182 @@ package oshub
 
 import (
 	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/labstack/echo/v4"
+	"foundriesio/ostreehub/pkg/ostree"
+	"go.opentelemetry.io/otel/codes"
+	"hash/crc32"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-func Untar(tarReader *tar.Reader, dstDir string, l echo.Logger) <-chan *RepoFile {
+type (
+	UntarOption func(*untarConfig)
+
+	untarConfig struct {
+		scratchLimit int64
+		streaming    bool
+		uploader     *Uploader
+		objectPrefix string
+		ctx          context.Context
+		// a mode of the repo the stream is extracted to, empty if it's unknown
+		mode string
+		// called if the stream can't be processed, before the file queue is closed
+		onFailure func(error)
+		// checks that refs fast-forward refs of the factory repo unless forceRefs is set, see WithRefGuard
+		refGuard  *RefGuard
+		forceRefs bool
+		// extracted files are uploaded even if the bucket has them, see WithForceUpload
+		forceUpload bool
+		// owners of objects of bare repos are restored, see WithOwnership
+		ownership bool
+	}
+
+	// UntarError reports a TAR entry rejected by Untar, e.g. the one escaping the destination directory
+	UntarError struct {
+		Path   string
+		Reason string
+	}
+
+	// TarError reports a failure to make a TAR stream, e.g. a repo file that can't be read, Path is empty
+	// if the stream itself can't be finished
+	TarError struct {
+		Path string
+		Err  error
+	}
+)
+
+func (e *UntarError) Error() string {
+	return fmt.Sprintf("invalid TAR entry %q: %s", e.Path, e.Reason)
+}
+
+func (e *TarError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("failed to finish TAR stream: %s", e.Err.Error())
+	}
+	return fmt.Sprintf("failed to add %s to TAR stream: %s", e.Path, e.Err.Error())
+}
+
+func (e *TarError) Unwrap() error {
+	return e.Err
+}
+
+// WithContext sets a context, e.g. returned by TraceContext, the untar span and spans of syncing
+// of extracted files are children of
+func WithContext(ctx context.Context) UntarOption {
+	return func(c *untarConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithScratchLimit bounds a disk space taken by objects extracted from a single TAR stream,
+// once the limit is reached the remaining objects are streamed directly to GCS bucket of an uploader under a given prefix
+func WithScratchLimit(maxBytes int64, u *Uploader, objectPrefix string) UntarOption {
+	return func(c *untarConfig) {
+		c.scratchLimit = maxBytes
+		c.uploader = u
+		c.objectPrefix = objectPrefix
+	}
+}
+
+// WithStreaming makes Untar stream all objects directly to GCS bucket of an uploader under a given prefix instead of
+// extracting them to a local disk first, CRC of each object is verified before the upload is committed.
+// Refs, config and other non-object files are still extracted to the destination directory
+func WithStreaming(u *Uploader, objectPrefix string) UntarOption {
+	return func(c *untarConfig) {
+		c.streaming = true
+		c.uploader = u
+		c.objectPrefix = objectPrefix
+	}
+}
+
+// WithTargetMode sets a mode of the repo a TAR stream is extracted to, e.g. announced by a client in RepoModeHeader.
+// Content objects of another mode, e.g. .file objects sent to an archive-z2 repo, are rejected.
+func WithTargetMode(mode string) UntarOption {
+	return func(c *untarConfig) {
+		c.mode = mode
+	}
+}
+
+// WithOwnership makes Untar restore owners of objects extracted to a bare repo, see WithTargetMode, as checksums
+// of their content objects cover them. It's off by default since the hub has to run as root or with CAP_CHOWN
+// to give files away, and a client could make it create files owned by any user, e.g. root, otherwise files
+// are owned by the hub user.
+func WithOwnership() UntarOption {
+	return func(c *untarConfig) {
+		c.ownership = true
+	}
+}
+
+// WithForceUpload makes extracted files be uploaded by Check and Sync even if the bucket has them with the same CRC,
+// e.g. if a client has sent ForceUploadHeader to recover from a corrupted bucket or to apply new object metadata
+func WithForceUpload(force bool) UntarOption {
+	return func(c *untarConfig) {
+		c.forceUpload = force
+	}
+}
+
+// WithFailureHandler sets a function called if Untar fails to process a stream, e.g. it's truncated or has an invalid
+// entry, it's called before the returned channel is closed, so a reader of the channel knows whether the stream is complete
+func WithFailureHandler(fn func(error)) UntarOption {
+	return func(c *untarConfig) {
+		c.onFailure = fn
+	}
+}
+
+// Untar extracts a TAR stream to a given directory, l can be nil, in this case the package logger is used
+func Untar(tarReader *tar.Reader, dstDir string, l Logger, opts ...UntarOption) <-chan *RepoFile {
+	cfg := untarConfig{ctx: context.Background()}
+	for _, o := range opts {
+		o(&cfg)
+	}
 	fileQueue := make(chan *RepoFile, 100)
-	logger := l
+	if l == nil {
+		l = logger
+	}
+	var scratchUsed int64
+	// GCS object names of files streamed to GCS, hard links to them are made by copying the objects
+	streamed := map[string]string{}
 
 	go func() {
+		ctx, span := tracer.Start(cfg.ctx, "oshub.untar")
+		defer span.End()
 		defer func() {
 			err := recover()
 			if err != nil {
 				// TODO: done/close channel
-				logger.Errorf("Failed to process an input TAR stream: %s\n", err)
+				l.Error("Failed to process an input TAR stream", "err", err)
+				span.SetStatus(codes.Error, fmt.Sprint(err))
+				if cfg.onFailure != nil {
+					if e, ok := err.(error); ok {
+						cfg.onFailure(e)
+					} else {
+						cfg.onFailure(fmt.Errorf("%v", err))
+					}
+				}
 			}
+			close(fileQueue)
 		}()
 
-		defer close(fileQueue)
+		var dirs []*tar.Header
+		defer func() {
+			for _, d := range dirs {
+				_, p, err := sanitizeEntry(dstDir, d)
+				if err == nil {
+					restoreAttrs(p, d, false, l)
+				}
+			}
+		}()
 		for {
 			header, err := tarReader.Next()
 			if err == io.EOF {
@@ -34,40 +187,167 @@ func Untar(tarReader *tar.Reader, dstDir string, l echo.Logger) <-chan *RepoFile
 				panic("failed to read an input TAR stream: " + err.Error())
 			}
 
-			name := header.Name
+			name, dstPath, err := sanitizeEntry(dstDir, header)
+			if err != nil {
+				panic(err)
+			}
+			if err := checkObjectMode(name, cfg.mode); err != nil {
+				panic(err)
+			}
+			// ownership is a part of content of bare repo objects only
+			chown := cfg.ownership && cfg.mode == ostree.ModeBare && strings.HasPrefix(name, "./objects/")
 			switch header.Typeflag {
 			case tar.TypeDir:
-				d := path.Join(dstDir, name)
+				d := dstPath
 				err := os.MkdirAll(d, 0755)
 				if err != nil {
 					panic("failed to create a directory: " + d + " " + err.Error())
 				}
+				// mtimes of directories are restored once all files are extracted as the extraction changes them
+				dirs = append(dirs, header)
 				continue
 
 			case tar.TypeReg:
-				p := path.Join(dstDir, name)
-				d := path.Dir(p)
-				err := os.MkdirAll(d, 0755)
+				expectedCrc, err := strconv.ParseUint(header.PAXRecords[crcPaxRecord], 10, 32)
+				hasCrc := err == nil
+				if !hasCrc {
+					expectedCrc = 0
+				}
+				file := &RepoFile{Path: name, CRC32: uint32(expectedCrc), SHA256: header.PAXRecords[shaPaxRecord], ctx: ctx, force: cfg.forceUpload}
+				objectsReceived.Inc()
+				bytesReceived.Add(float64(header.Size))
+				isObject := strings.HasPrefix(name, "./objects/")
+				spill := cfg.scratchLimit > 0 && isObject && scratchUsed+header.Size > cfg.scratchLimit
+				if spill || (cfg.streaming && isObject) {
+					// Sync just passes the upload status through, the object is rejected if its content doesn't match the expected CRC
+					objectName := objectName(cfg.objectPrefix, name)
+					file.status = tracedUpload(objectName, file, func() *uploadStatus {
+						return cfg.uploader.uploadStream(ctx, objectName, file, tarReader, header.Size)
+					})
+					file.status.Streamed = true
+					file.status.staging = StagingSpill
+					if !spill {
+						file.status.staging = StagingStream
+					}
+					if file.status.Err == "" {
+						streamed[name] = objectName
+					}
+					fileQueue <- file
+					continue
+				}
+				var content io.Reader = tarReader
+				if cfg.refGuard != nil && strings.HasPrefix(name, "./refs/") {
+					commit, data, err := readRefEntry(tarReader)
+					if err == nil {
+						err = cfg.checkRef(ctx, name, commit, dstDir, l)
+					}
+					if err != nil {
+						l.Warn("Rejected a ref update", "ref", name, "err", err)
+						file.status = &uploadStatus{Object: &file.Path, Err: err.Error()}
+						fileQueue <- file
+						continue
+					}
+					content = bytes.NewReader(data)
+				}
+				scratchUsed += header.Size
+
+				p := dstPath
+				d := filepath.Dir(p)
+				err = os.MkdirAll(d, 0755)
 				if err != nil {
 					panic("failed to create a directory: " + d + " " + err.Error())
 				}
+				if err := removeExisting(p); err != nil {
+					panic("failed to replace a file: " + p + " " + err.Error())
+				}
 				f, err := os.Create(p)
 				if err != nil {
 					panic("failed to create a file: " + p + " " + err.Error())
 				}
-				_, err = io.Copy(f, tarReader)
+				hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+				shaHasher := sha256.New()
+				_, err = io.Copy(io.MultiWriter(f, hasher, shaHasher), content)
 				if err != nil {
 					f.Close()
 					panic("failed to copy a file: " + p + " " + err.Error())
 				}
 				f.Close()
-				expectedCrc, err := strconv.ParseUint(header.PAXRecords["FIO.ostree.CRC"], 10, 0)
+				restoreAttrs(p, header, chown, l)
+				verifyCrc(file, hasCrc, hasher.Sum32(), p, l)
+				verifySHA256(file, shaHasher.Sum(nil), p, l)
+				fileQueue <- file
+
+			case tar.TypeSymlink, tar.TypeLink:
+				expectedCrc, err := strconv.ParseUint(header.PAXRecords[crcPaxRecord], 10, 32)
+				hasCrc := err == nil
+				file := &RepoFile{Path: name, CRC32: uint32(expectedCrc), SHA256: header.PAXRecords[shaPaxRecord], ctx: ctx, force: cfg.forceUpload}
+				objectsReceived.Inc()
+
+				p := dstPath
+				d := filepath.Dir(p)
+				if err := os.MkdirAll(d, 0755); err != nil {
+					panic("failed to create a directory: " + d + " " + err.Error())
+				}
+				var target string
+				if header.Typeflag == tar.TypeLink {
+					if target, err = linkTarget(dstDir, header); err != nil {
+						panic(err)
+					}
+				}
+				if cfg.refGuard != nil && strings.HasPrefix(name, "./refs/") {
+					// a ref sent as a link is checked by the guard as well, so links can't move refs backwards
+					err := fmt.Errorf("a ref must not be a symlink")
+					if header.Typeflag == tar.TypeLink {
+						err = cfg.checkLinkedRef(ctx, name, target, dstDir, l)
+					}
+					if err != nil {
+						l.Warn("Rejected a ref update", "ref", name, "err", err)
+						file.status = &uploadStatus{Object: &file.Path, Err: err.Error()}
+						fileQueue <- file
+						continue
+					}
+				}
+				// a stored ref is read by the guard, so it's replaced only once the update is accepted
+				if err := removeExisting(p); err != nil {
+					panic("failed to replace a file: " + p + " " + err.Error())
+				}
+				if header.Typeflag == tar.TypeSymlink {
+					// a symlink is never followed by Untar, so its target can point anywhere, its content is the target path
+					if err := os.Symlink(header.Linkname, p); err != nil {
+						panic("failed to create a symlink: " + p + " " + err.Error())
+					}
+					restoreAttrs(p, header, chown, l)
+					verifyCrc(file, hasCrc, crc32.Checksum([]byte(header.Linkname), crc32.MakeTable(crc32.Castagnoli)), p, l)
+					digest := sha256.Sum256([]byte(header.Linkname))
+					verifySHA256(file, digest[:], p, l)
+					fileQueue <- file
+					continue
+				}
+
+				if src, ok := streamed["./"+path.Clean(header.Linkname)]; ok && strings.HasPrefix(name, "./objects/") {
+					// the target has bypassed a local disk, so the link is made by copying it within GCS
+					objectName := objectName(cfg.objectPrefix, name)
+					file.status = tracedUpload(objectName, file, func() *uploadStatus {
+						return cfg.uploader.copyObject(ctx, src, objectName, file)
+					})
+					file.status.Streamed = true
+					file.status.staging = StagingStream
+					fileQueue <- file
+					continue
+				}
+				if err := os.Link(target, p); err != nil {
+					// e.g. the target has been streamed to GCS bypassing a local disk
+					file.status = &uploadStatus{Object: &file.Path, Err: "failed to create a hard link: " + err.Error()}
+					fileQueue <- file
+					continue
+				}
+				crc, digest, err := fileDigests(p)
 				if err != nil {
-					expectedCrc = 0
+					panic("failed to read a file: " + p + " " + err.Error())
 				}
-				fileQueue <- &RepoFile{Path: name, CRC32: uint32(expectedCrc)}
-			default:
-				panic("failed to read an input TAR stream")
+				verifyCrc(file, hasCrc, crc, p, l)
+				verifySHA256(file, digest, p, l)
+				fileQueue <- file
 			}
 		}
 	}()
@@ -75,55 +355,605 @@ func Untar(tarReader *tar.Reader, dstDir string, l echo.Logger) <-chan *RepoFile
 	return fileQueue
 }
 
-func Tar(repoDir string, files map[string]uint32) (*io.PipeReader, <-chan *SendReport) {
+// checkRef checks that a ref entry may update the ref to a given commit, see WithRefGuard
+func (cfg *untarConfig) checkRef(ctx context.Context, name string, commit string, dstDir string, l Logger) error {
+	err := cfg.refGuard.Check(ctx, strings.TrimPrefix(name, "./refs/"), commit, dstDir)
+	var updateErr *RefUpdateError
+	if errors.As(err, &updateErr) && cfg.forceRefs {
+		l.Warn("Forced a non-fast-forward ref update", "ref", updateErr.Ref, "stored", updateErr.Stored, "new", updateErr.New)
+		return nil
+	}
+	return err
+}
+
+// checkLinkedRef checks a ref entry sent as a hard link, the ref points to the commit its link target does
+func (cfg *untarConfig) checkLinkedRef(ctx context.Context, name string, target string, dstDir string, l Logger) error {
+	f, err := os.Open(target)
+	if err != nil {
+		return fmt.Errorf("failed to read the link target: %s", err.Error())
+	}
+	commit, _, err := readRefEntry(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read the link target: %s", err.Error())
+	}
+	return cfg.checkRef(ctx, name, commit, dstDir, l)
+}
+
+// removeExisting removes a file a TAR entry replaces, so the entry is never written through a hard link
+// or a symlink left by a previous stream, e.g. refs hard linked to each other by older clients
+func removeExisting(p string) error {
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sameContent tells whether two files have the same content, both of them are acquired from the file semaphore
+func (cfg *tarConfig) sameContent(p1 string, p2 string) bool {
+	cfg.files.Acquire(2)
+	defer cfg.files.Release(2)
+	return sameContent(p1, p2)
+}
+
+// sameContent tells whether two files have the same content
+func sameContent(p1 string, p2 string) bool {
+	f1, err := os.Open(p1)
+	if err != nil {
+		return false
+	}
+	defer f1.Close()
+	f2, err := os.Open(p2)
+	if err != nil {
+		return false
+	}
+	defer f2.Close()
+	b1 := make([]byte, 32*1024)
+	b2 := make([]byte, 32*1024)
+	for {
+		n1, err1 := io.ReadFull(f1, b1)
+		n2, err2 := io.ReadFull(f2, b2)
+		if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
+			return false
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF
+		}
+		if err1 != nil || err2 != nil {
+			return false
+		}
+	}
+}
+
+// verifyCrc compares CRC of an extracted file with the expected one, a mismatching file is removed
+// and its failure is passed through Sync so it's reported to the client
+func verifyCrc(file *RepoFile, hasCrc bool, crc uint32, p string, l Logger) {
+	if !hasCrc {
+		// let GCS verify the upload at least
+		file.CRC32 = crc
+		return
+	}
+	if crc == file.CRC32 {
+		return
+	}
+	l.Warn("CRC of an extracted file doesn't match the expected one", "file", file.Path, "crc", crc, "expected", file.CRC32)
+	objectsCorrupted.Inc()
+	if err := os.Remove(p); err != nil {
+		l.Warn("Failed to remove a corrupted file", "file", p, "err", err)
+	}
+	file.status = &uploadStatus{Object: &file.Path, Err: fmt.Sprintf("CRC mismatch: got %d, expected %d", crc, file.CRC32)}
+}
+
+// verifySHA256 compares SHA-256 of an extracted file with the one sent by a client, if any, the same way
+// verifyCrc does. A file already failed by verifyCrc is left as is.
+func verifySHA256(file *RepoFile, digest []byte, p string, l Logger) {
+	if file.SHA256 == "" || file.status != nil {
+		return
+	}
+	sum := hex.EncodeToString(digest)
+	if strings.EqualFold(sum, file.SHA256) {
+		return
+	}
+	l.Warn("SHA-256 of an extracted file doesn't match the expected one", "file", file.Path, "sha256", sum, "expected", file.SHA256)
+	objectsCorrupted.Inc()
+	if err := os.Remove(p); err != nil {
+		l.Warn("Failed to remove a corrupted file", "file", p, "err", err)
+	}
+	file.status = &uploadStatus{Object: &file.Path, Err: fmt.Sprintf("SHA-256 mismatch: got %s, expected %s", sum, file.SHA256)}
+}
+
+// fileDigests returns CRC32C and SHA-256 of a file
+func fileDigests(p string) (uint32, []byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	shaHasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(hasher, shaHasher), f); err != nil {
+		return 0, nil, err
+	}
+	return hasher.Sum32(), shaHasher.Sum(nil), nil
+}
+
+// checkObjectMode makes sure a repo file is not a content object of a repo mode other than a given one
+func checkObjectMode(name string, mode string) error {
+	if mode == "" || !strings.HasPrefix(name, "./objects/") {
+		return nil
+	}
+	ext := path.Ext(name)
+	if ext == "."+string(ostree.ObjectFile) && ostree.IsArchiveMode(mode) {
+		return &UntarError{Path: name, Reason: "a content object of a bare repo is sent to an " + mode + " repo"}
+	}
+	if ext == "."+string(ostree.ObjectFileZ) && !ostree.IsArchiveMode(mode) {
+		return &UntarError{Path: name, Reason: "a content object of an archive repo is sent to a " + mode + " repo"}
+	}
+	return nil
+}
+
+var (
+	// extended attributes restored by Untar, all of them in the user namespace, e.g. bare-user repos
+	// keep an owner, a mode and xattrs of a content object in user.ostreemeta
+	restoredXattrs = map[string]bool{
+		"user.ostreemeta": true,
+	}
+)
+
+// restoreAttrs applies permissions, a modification time and extended attributes of a TAR entry
+// to an extracted file, along with its owner if chown is set. Failures are just logged as the content is still valid.
+// Setuid, setgid and sticky bits are never applied, nor are extended attributes missing from restoredXattrs.
+func restoreAttrs(p string, header *tar.Header, chown bool, l Logger) {
+	isSymlink := header.Typeflag == tar.TypeSymlink
+	if chown {
+		// before chmod as changing the owner clears setuid and setgid bits
+		if err := os.Lchown(p, header.Uid, header.Gid); err != nil {
+			l.Warn("Failed to set file owner", "file", p, "uid", header.Uid, "gid", header.Gid, "err", err)
+		}
+	}
+	mode := header.FileInfo().Mode() & os.ModePerm
+	if isSymlink {
+		// both would be applied to the symlink target
+	} else if err := os.Chmod(p, mode); err != nil {
+		l.Warn("Failed to set file mode", "file", p, "mode", mode, "err", err)
+	}
+	for key, value := range header.PAXRecords {
+		if !strings.HasPrefix(key, xattrPaxPrefix) {
+			continue
+		}
+		if !restoredXattrs[strings.TrimPrefix(key, xattrPaxPrefix)] {
+			l.Warn("Ignored an extended attribute", "file", p, "attr", key)
+			continue
+		}
+		if err := writeXattr(p, strings.TrimPrefix(key, xattrPaxPrefix), value); err != nil {
+			l.Warn("Failed to set an extended attribute", "file", p, "attr", key, "err", err)
+		}
+	}
+	if !isSymlink && !header.ModTime.IsZero() {
+		if err := os.Chtimes(p, header.ModTime, header.ModTime); err != nil {
+			l.Warn("Failed to set file modification time", "file", p, "err", err)
+		}
+	}
+}
+
+// sanitizeEntry validates a TAR entry and returns its normalized name, e.g. ./objects/ab/cdef.filez,
+// and a path to extract it to. Only directories, regular files, symlinks and hard links located within
+// the destination directory are accepted, the destination must not be reached through a symlink pointing outside of it.
+func sanitizeEntry(dstDir string, header *tar.Header) (string, string, error) {
+	name := header.Name
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+	default:
+		return "", "", &UntarError{Path: name, Reason: fmt.Sprintf("unexpected type flag %q", header.Typeflag)}
+	}
+	clean, err := cleanEntryPath(name)
+	if err != nil {
+		return "", "", &UntarError{Path: name, Reason: err.Error()}
+	}
+	if clean == "." && header.Typeflag != tar.TypeDir {
+		return "", "", &UntarError{Path: name, Reason: "empty file path"}
+	}
+
+	dst := filepath.Join(dstDir, filepath.FromSlash(clean))
+	if err := checkNoSymlinkEscape(dstDir, dst); err != nil {
+		return "", "", &UntarError{Path: name, Reason: err.Error()}
+	}
+	if clean == "." {
+		return "./", dst, nil
+	}
+	return "./" + clean, dst, nil
+}
+
+// linkTarget returns a path of a regular file a hard link entry points to, it must have been extracted already
+func linkTarget(dstDir string, header *tar.Header) (string, error) {
+	clean, err := cleanEntryPath(header.Linkname)
+	if err != nil {
+		return "", &UntarError{Path: header.Name, Reason: "hard link target: " + err.Error()}
+	}
+	target := filepath.Join(dstDir, filepath.FromSlash(clean))
+	if err := checkNoSymlinkEscape(dstDir, target); err != nil {
+		return "", &UntarError{Path: header.Name, Reason: "hard link target: " + err.Error()}
+	}
+	return target, nil
+}
+
+// cleanEntryPath returns a cleaned relative path of a TAR entry, it fails if the path escapes the destination directory
+func cleanEntryPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "\x00\\") {
+		return "", fmt.Errorf("invalid characters in the path")
+	}
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("absolute path")
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path escapes the destination directory")
+	}
+	return clean, nil
+}
+
+// checkNoSymlinkEscape makes sure that the existing part of a given path resolves to a location within the root,
+// an existing symlink at the path itself is rejected as os.Create would follow it
+func checkNoSymlinkEscape(root string, p string) error {
+	if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("the destination is a symlink")
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if os.IsNotExist(err) {
+		// nothing has been extracted yet
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve the destination directory: %s", err.Error())
+	}
+	existing := p
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return nil
+		}
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the destination: %s", err.Error())
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("the destination resolves outside of the destination directory through a symlink")
+	}
+	return nil
+}
+
+type (
+	TarOption func(*tarConfig)
+
+	// Limiter throttles data written to a TAR stream, Wait blocks until n bytes can be written
+	// or the context is done
+	Limiter interface {
+		Wait(ctx context.Context, n int) error
+	}
+
+	tarConfig struct {
+		gzip    bool
+		digests map[string]string
+		limiter Limiter
+		// the context the limiter waits within
+		limiterCtx context.Context
+		mode       string
+		// written as the first entry of the stream if it's set
+		manifest *BundleManifest
+		// a mode of a bare repo whose content objects are converted to archive-z2 ones, see WithArchiveConversion
+		archiveMode  string
+		archiveSizes map[string]int64
+		// bounds files open at once, see WithFileSemaphore
+		files *FileSemaphore
+	}
+
+	// contentKey identifies content of a file sent within a TAR stream, files of the same key are compared
+	// byte by byte before one is sent as a link to another as CRC32C isn't collision resistant
+	contentKey struct {
+		crc  uint32
+		size int64
+	}
+
+	limitedWriter struct {
+		ctx context.Context
+		w   io.Writer
+		l   Limiter
+	}
+)
+
+// WithGzip makes Tar compress the output stream, already compressed .filez objects are stored as is
+func WithGzip() TarOption {
+	return func(c *tarConfig) {
+		c.gzip = true
+	}
+}
+
+// WithSHA256 makes Tar add SHA-256 digests of files to the stream, a map key is a file path
+func WithSHA256(digests map[string]string) TarOption {
+	return func(c *tarConfig) {
+		c.digests = digests
+	}
+}
+
+// WithLimiter makes Tar throttle the output stream by means of a given limiter, the stream is closed
+// rather than waiting for the limiter once a given context is done, e.g. the push has been cancelled
+func WithLimiter(ctx context.Context, l Limiter) TarOption {
+	return func(c *tarConfig) {
+		c.limiter = l
+		c.limiterCtx = ctx
+	}
+}
+
+// WithRepoMode sets a mode of the repo files are sent from, ownership of files is sent only for bare repos
+// as it's meaningless for archive repos and bare-user ones keep it in the user.ostreemeta extended attribute.
+// So the same files of such repos make the same stream regardless of a user pushing them.
+func WithRepoMode(mode string) TarOption {
+	return func(c *tarConfig) {
+		c.mode = mode
+	}
+}
+
+// WithBundleManifest makes Tar write a given bundle manifest as the first entry of the stream,
+// see BundleManifestPath
+func WithBundleManifest(m *BundleManifest) TarOption {
+	return func(c *tarConfig) {
+		c.manifest = m
+	}
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if err := w.l.Wait(w.ctx, len(p)); err != nil {
+		// the reader is about to go as the push is cancelled, so it's handled like a closed stream
+		return 0, fmt.Errorf("%w: %s", io.ErrClosedPipe, err.Error())
+	}
+	return w.w.Write(p)
+}
+
+// Tar makes a TAR stream of given repo files, the report is delivered once the stream is complete. If a file can't
+// be read the stream is closed with TarError, so a reader gets it instead of EOF, and the report carries the error.
+// Entries are ordered as described by tarOrder, so the same files always make the same stream
+func Tar(repoDir string, files map[string]uint32, opts ...TarOption) (*io.PipeReader, <-chan *SendReport) {
+	var cfg tarConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
 	pr, pw := io.Pipe()
 	reportChannel := make(chan *SendReport, 1)
 	go func() {
-		defer pw.Close()
-		tw := tar.NewWriter(pw)
-		defer tw.Close()
 		defer close(reportChannel)
-		var sr SendReport
-		for file, crc := range files {
-			f, err := os.Open(path.Join(repoDir, file))
-			if err != nil {
-				panic(err)
+		sr, err := writeTar(pw, repoDir, files, &cfg)
+		if err == errStreamClosed {
+			// the reader knows why it has gone, the report just tells the files haven't been sent completely
+			sr.Err = err.Error()
+			pw.Close()
+		} else if err != nil {
+			logger.Error("Failed to make TAR stream", "err", err)
+			sr.Err = err.Error()
+			pw.CloseWithError(err)
+		} else {
+			pw.Close()
+		}
+		reportChannel <- sr
+	}()
+	return pr, reportChannel
+}
+
+// errStreamClosed is returned by writeTar if the reader of a TAR stream has gone before all files have been written
+var errStreamClosed = errors.New("the TAR stream has been closed before all files have been sent")
+
+// writeTar writes given repo files to a TAR stream, it stops with errStreamClosed if the reader has gone
+// before all files have been written, e.g. the push has been cancelled
+func writeTar(pw io.Writer, repoDir string, files map[string]uint32, cfg *tarConfig) (*SendReport, error) {
+	var out io.Writer = pw
+	if cfg.limiter != nil {
+		out = &limitedWriter{ctx: cfg.limiterCtx, w: pw, l: cfg.limiter}
+	}
+	var gw *gzipWriter
+	if cfg.gzip {
+		gw = newGzipWriter(out)
+		out = gw
+	}
+	tw := tar.NewWriter(out)
+	sr := &SendReport{}
+	if cfg.manifest != nil {
+		if err := writeManifest(tw, cfg.manifest); err != nil {
+			if errors.Is(err, io.ErrClosedPipe) {
+				return sr, errStreamClosed
 			}
-			fileInfo, err := f.Stat()
-			if err != nil {
-				panic(err)
+			return sr, &TarError{Path: BundleManifestPath, Err: err}
+		}
+	}
+	// the first file of each set of hard links sent, the following ones are sent as links to it
+	linked := map[fileID]string{}
+	// the first file of each content sent, files of the same content are sent as links to it too
+	contents := map[contentKey]string{}
+	for _, file := range tarOrder(files) {
+		crc := files[file]
+		if cfg.archiveMode != "" && IsConvertedToArchive(BareObjectPath(file)) {
+			if gw != nil {
+				if err := gw.SetLevel(compressionLevel(file)); err != nil {
+					return sr, &TarError{Path: file, Err: err}
+				}
 			}
-			hdr, err := tar.FileInfoHeader(fileInfo, "")
+			cfg.files.Acquire(1)
+			w, err := writeArchivedFile(tw, repoDir, file, crc, cfg)
+			cfg.files.Release(1)
 			if err != nil {
-				panic(err)
+				if errors.Is(err, io.ErrClosedPipe) {
+					return sr, errStreamClosed
+				}
+				return sr, &TarError{Path: file, Err: err}
 			}
-			hdr.Name = file
-			hdr.Format = tar.FormatPAX
-			//paxRec := map[string]string{"FIO.ostree.CRC": strconv.FormatUint(uint64(crc), 10)}
-			hdr.PAXRecords = map[string]string{"FIO.ostree.CRC": strconv.FormatUint(uint64(crc), 10)}
-			if err := tw.WriteHeader(hdr); err != nil {
-				panic(err)
+			if strings.HasPrefix(file, "./objects") {
+				sr.ObjNumb += 1
 			}
-			if fileInfo.IsDir() {
-				f.Close()
-				continue
+			sr.FileNumb += 1
+			sr.Bytes += w
+			continue
+		}
+		p := filepath.Join(repoDir, filepath.FromSlash(file))
+		fileInfo, err := os.Lstat(p)
+		if err != nil {
+			return sr, &TarError{Path: file, Err: err}
+		}
+		var link string
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return sr, &TarError{Path: file, Err: err}
+			}
+			// a target read on Windows has backslashes, ostree targets are slash-separated
+			link = filepath.ToSlash(link)
+		}
+		hdr, err := tar.FileInfoHeader(fileInfo, link)
+		if err != nil {
+			return sr, &TarError{Path: file, Err: err}
+		}
+		// refs and config are always sent as regular files, so they never share an inode on the hub and each
+		// of them is checked by a ref guard of the hub on its own
+		isObject := strings.HasPrefix(file, "./objects/")
+		if id, ok := hardlinkID(fileInfo); ok && isObject && fileInfo.Mode().IsRegular() {
+			if first, ok := linked[id]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				linked[id] = file
+			}
+		}
+		// content objects of bare repos of the same bytes can still differ by their mode, owner or extended attributes,
+		// so only objects of archive repos are sent as links to objects of the same content
+		if hdr.Typeflag == tar.TypeReg && isObject && ostree.IsArchiveMode(cfg.mode) {
+			key := contentKey{crc: crc, size: hdr.Size}
+			if first, ok := contents[key]; ok && cfg.sameContent(filepath.Join(repoDir, filepath.FromSlash(first)), p) {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+				sr.DedupNumb += 1
+				sr.DedupBytes += fileInfo.Size()
+			} else if !ok {
+				contents[key] = file
+			}
+		}
+		var f *os.File
+		if hdr.Typeflag == tar.TypeReg {
+			cfg.files.Acquire(1)
+			if f, err = os.Open(p); err != nil {
+				cfg.files.Release(1)
+				return sr, &TarError{Path: file, Err: err}
+			}
+		}
+		hdr.Name = file
+		hdr.Format = tar.FormatPAX
+		if cfg.mode != "" && cfg.mode != ostree.ModeBare {
+			hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		}
+		hdr.PAXRecords = map[string]string{crcPaxRecord: strconv.FormatUint(uint64(crc), 10)}
+		if digest, ok := cfg.digests[file]; ok {
+			hdr.PAXRecords[shaPaxRecord] = digest
+		}
+		xattrs, err := readXattrs(p)
+		if err != nil {
+			logger.Warn("Failed to read extended attributes of a file", "file", file, "err", err)
+		}
+		for name, value := range xattrs {
+			hdr.PAXRecords[xattrPaxPrefix+name] = value
+		}
+		if gw != nil {
+			if err := gw.SetLevel(compressionLevel(file)); err != nil {
+				closeFile(f, cfg.files)
+				return sr, &TarError{Path: file, Err: err}
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			closeFile(f, cfg.files)
+			if errors.Is(err, io.ErrClosedPipe) {
+				// the reader has gone, e.g. the push has been cancelled
+				return sr, errStreamClosed
 			}
-			w, err := io.Copy(tw, f)
+			return sr, &TarError{Path: file, Err: err}
+		}
+		var w int64
+		if f != nil {
+			w, err = io.Copy(tw, f)
+			closeFile(f, cfg.files)
 			if err != nil {
-				f.Close()
-				fmt.Printf(">>>>>>>>>>> PANIC: %s\n", err.Error())
-				panic(err)
+				if errors.Is(err, io.ErrClosedPipe) {
+					return sr, errStreamClosed
+				}
+				return sr, &TarError{Path: file, Err: err}
 			}
 			tw.Flush()
-			f.Close()
+		} else if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
 
-			if strings.HasPrefix(file, "./objects") {
-				sr.ObjNumb += 1
-			}
-			sr.FileNumb += 1
-			sr.Bytes += w
+		if strings.HasPrefix(file, "./objects") {
+			sr.ObjNumb += 1
 		}
-		reportChannel <- &sr
-	}()
-	return pr, reportChannel
+		sr.FileNumb += 1
+		sr.Bytes += w
+	}
+	if err := tw.Close(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return sr, &TarError{Err: err}
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return sr, &TarError{Err: err}
+		}
+	}
+	return sr, nil
+}
+
+// writeManifest writes a bundle manifest entry with a CRC record like the one of any other file of the stream
+func writeManifest(tw *tar.Writer, m *BundleManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       BundleManifestPath,
+		Mode:       0644,
+		Size:       int64(len(data)),
+		ModTime:    m.Created,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{crcPaxRecord: strconv.FormatUint(uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))), 10)},
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// tarOrder returns paths of files in the order they are added to a TAR stream, it's a part of the push protocol:
+// objects go first sorted by path, then the rest of files, e.g. config and refs, sorted by path too. So refs
+// are received once objects they point to have been received, and a file sent as a link always follows its target
+func tarOrder(files map[string]uint32) []string {
+	paths := make([]string, 0, len(files))
+	for file := range files {
+		paths = append(paths, file)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		oi, oj := strings.HasPrefix(paths[i], "./objects/"), strings.HasPrefix(paths[j], "./objects/")
+		if oi != oj {
+			return oi
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// closeFile closes a file if it's open and releases it to a semaphore it has been acquired from
+func closeFile(f *os.File, s *FileSemaphore) {
+	if f != nil {
+		f.Close()
+		s.Release(1)
+	}
 }
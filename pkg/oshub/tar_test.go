@@ -0,0 +1,127 @@
+package oshub
+
+import (
+	"archive/tar"
+	"foundriesio/ostreehub/pkg/ostree"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRepoFiles writes files of given content to a repo directory and returns their CRCs
+func writeRepoFiles(t *testing.T, dir string, content map[string]string) map[string]uint32 {
+	files := make(map[string]uint32)
+	for f, data := range content {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files[f] = crc32.Checksum([]byte(data), crc32.MakeTable(crc32.Castagnoli))
+	}
+	return files
+}
+
+// tarEntries returns types of entries of a TAR stream made of given repo files mapped to their paths
+func tarEntries(t *testing.T, dir string, files map[string]uint32, opts ...TarOption) map[string]byte {
+	pr, _ := Tar(dir, files, opts...)
+	tr := tar.NewReader(pr)
+	entries := make(map[string]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = hdr.Typeflag
+	}
+	return entries
+}
+
+func TestTarLinksArchiveObjectsOnly(t *testing.T) {
+	commit := strings.Repeat("1", 64) + "\n"
+	tests := []struct {
+		mode string
+		ext  string
+		// paths of files sent as links
+		linked []string
+	}{
+		{ostree.ModeArchiveZ2, "filez", []string{"./objects/00/2.filez"}},
+		{ostree.ModeBare, "file", nil},
+		{ostree.ModeBareUser, "file", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			src, err := ioutil.TempDir("", "oshub-src")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(src)
+			files := writeRepoFiles(t, src, map[string]string{
+				"./objects/00/1." + tc.ext: "content",
+				"./objects/00/2." + tc.ext: "content",
+				"./refs/heads/a":           commit,
+				"./refs/heads/b":           commit,
+				"./config":                 "[core]\nmode=" + tc.mode + "\n",
+			})
+			// refs hard linked to each other within the repo are sent as regular files too
+			if err := os.Link(filepath.Join(src, "refs", "heads", "a"), filepath.Join(src, "refs", "heads", "c")); err != nil {
+				t.Fatal(err)
+			}
+			files["./refs/heads/c"] = files["./refs/heads/a"]
+
+			entries := tarEntries(t, src, files, WithRepoMode(tc.mode))
+			linked := make(map[string]bool)
+			for _, f := range tc.linked {
+				linked[f] = true
+			}
+			for f := range files {
+				if typ := entries[f]; (typ == tar.TypeLink) != linked[f] {
+					t.Errorf("unexpected type of %s: %c", f, typ)
+				}
+			}
+		})
+	}
+}
+
+func TestUntarReplacesLinks(t *testing.T) {
+	dst, err := ioutil.TempDir("", "oshub-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	// older clients send refs of the same commit as links to the first of them
+	for _, commit := range []string{strings.Repeat("1", 64), strings.Repeat("2", 64)} {
+		pr, pw := io.Pipe()
+		go func() {
+			tw := tar.NewWriter(pw)
+			data := commit + "\n"
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./refs/heads/a", Mode: 0644, Size: int64(len(data))})
+			io.WriteString(tw, data)
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "./refs/heads/b", Linkname: "./refs/heads/a", Mode: 0644})
+			pw.CloseWithError(tw.Close())
+		}()
+		for f := range Untar(tar.NewReader(pr), dst, NewStdLogger(LevelError)) {
+			if f.Err() != "" {
+				t.Fatalf("failed to extract %s: %s", f.Path, f.Err())
+			}
+		}
+		for _, ref := range []string{"a", "b"} {
+			data, err := ioutil.ReadFile(filepath.Join(dst, "refs", "heads", ref))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(data)) != commit {
+				t.Errorf("ref %s points to %s, expected %s", ref, data, commit)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package oshub
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+)
+
+var (
+	// spans are recorded only if a hosting server sets an OpenTelemetry tracer provider
+	tracer = otel.Tracer("foundriesio/ostreehub/pkg/oshub")
+)
+
+// TraceContext returns a request context along with a trace context propagated by fiopush, if any,
+// it should be passed to Untar by means of WithContext so the untar and sync spans join the client trace
+func TraceContext(r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
@@ -0,0 +1,189 @@
+package oshub
+
+import (
+	gcs "cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// a client has to set it to a name of the factory it deletes a repo of, it guards against accidental deletion
+	ConfirmDeleteHeader string = "X-Fio-Confirm-Delete"
+
+	DefaultRestoreWindow = 7 * 24 * time.Hour
+)
+
+type (
+	// RepoTrash soft-deletes factory repos, objects of a deleted repo are moved under
+	// <Prefix>/<factory>/<deletion unix time>/ and can be restored during RestoreWindow, Purge deletes them afterwards
+	RepoTrash struct {
+		Uploader     *Uploader
+		ObjectPrefix ObjectPrefixFunc
+		Prefix       string
+		// DefaultRestoreWindow if not specified
+		RestoreWindow time.Duration
+		// optional, a repo directory of a factory is moved aside along with its objects
+		RepoDir RepoDirFunc
+	}
+
+	DeleteReport struct {
+		Moved        int               `json:"moved"`
+		Failed       map[string]string `json:"failed,omitempty"`
+		DeletedAt    time.Time         `json:"deleted_at"`
+		RestoreUntil time.Time         `json:"restore_until"`
+	}
+
+	RestoreReport struct {
+		Restored  int               `json:"restored"`
+		Failed    map[string]string `json:"failed,omitempty"`
+		DeletedAt time.Time         `json:"deleted_at"`
+	}
+)
+
+// Delete moves all objects of a factory repo to the trash
+func (t *RepoTrash) Delete(ctx context.Context, factory string) (*DeleteReport, error) {
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	report := &DeleteReport{DeletedAt: deletedAt, RestoreUntil: deletedAt.Add(t.restoreWindow())}
+	if t.RepoDir != nil {
+		dir := t.RepoDir(factory)
+		if err := os.Rename(dir, deletedRepoDir(dir, deletedAt)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to move the repo directory aside: %s", err.Error())
+		}
+	}
+	var err error
+	report.Moved, report.Failed, err = t.Uploader.moveObjects(ctx, t.ObjectPrefix(factory), t.batchPrefix(factory, deletedAt))
+	return report, err
+}
+
+// Restore moves objects of the latest deleted repo of a factory back if it has been deleted within the restore window
+func (t *RepoTrash) Restore(ctx context.Context, factory string) (*RestoreReport, error) {
+	batches, err := t.deletions(ctx, factory)
+	if err != nil {
+		return nil, err
+	}
+	var latest time.Time
+	for _, b := range batches {
+		if b.After(latest) {
+			latest = b
+		}
+	}
+	if latest.IsZero() || time.Since(latest) > t.restoreWindow() {
+		return nil, fmt.Errorf("factory %s doesn't have a deleted repo that can be restored", factory)
+	}
+	report := &RestoreReport{DeletedAt: latest}
+	report.Restored, report.Failed, err = t.Uploader.moveObjects(ctx, t.batchPrefix(factory, latest), t.ObjectPrefix(factory))
+	if err != nil {
+		return report, err
+	}
+	if t.RepoDir != nil {
+		dir := t.RepoDir(factory)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.Rename(deletedRepoDir(dir, latest), dir); err != nil && !os.IsNotExist(err) {
+				return report, fmt.Errorf("failed to restore the repo directory: %s", err.Error())
+			}
+		}
+	}
+	return report, nil
+}
+
+// Purge permanently deletes trashed objects older than the restore window and returns their number
+func (t *RepoTrash) Purge(ctx context.Context) (int, error) {
+	var purged int
+	it := t.Uploader.bucket.Objects(ctx, &gcs.Query{Prefix: t.Prefix + "/"})
+	for {
+		attr, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return purged, fmt.Errorf("failed to list trashed objects: %s", err.Error())
+		}
+		// <prefix>/<factory>/<deletion time>/...
+		elems := strings.SplitN(strings.TrimPrefix(attr.Name, t.Prefix+"/"), "/", 3)
+		if len(elems) < 3 {
+			continue
+		}
+		ts, err := strconv.ParseInt(elems[1], 10, 64)
+		if err != nil || time.Since(time.Unix(ts, 0)) <= t.restoreWindow() {
+			continue
+		}
+		if err := t.Uploader.bucket.Object(attr.Name).Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+			logger.Warn("Failed to purge a trashed object", "object", attr.Name, "err", err)
+			continue
+		}
+		purged += 1
+	}
+	return purged, nil
+}
+
+func (t *RepoTrash) restoreWindow() time.Duration {
+	if t.RestoreWindow == 0 {
+		return DefaultRestoreWindow
+	}
+	return t.RestoreWindow
+}
+
+func (t *RepoTrash) batchPrefix(factory string, deletedAt time.Time) string {
+	return fmt.Sprintf("%s/%s/%d", t.Prefix, factory, deletedAt.Unix())
+}
+
+// deletions returns times a repo of a factory has been deleted at
+func (t *RepoTrash) deletions(ctx context.Context, factory string) ([]time.Time, error) {
+	var batches []time.Time
+	prefix := t.Prefix + "/" + factory + "/"
+	it := t.Uploader.bucket.Objects(ctx, &gcs.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attr, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list trashed repos: %s", err.Error())
+		}
+		if attr.Prefix == "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(attr.Prefix, prefix), "/"), 10, 64)
+		if err == nil {
+			batches = append(batches, time.Unix(ts, 0).UTC())
+		}
+	}
+	return batches, nil
+}
+
+// moveObjects moves objects from one prefix to another, GCS doesn't support moving so they are copied and deleted
+func (u *Uploader) moveObjects(ctx context.Context, srcPrefix string, dstPrefix string) (int, map[string]string, error) {
+	var moved int
+	failed := make(map[string]string)
+	it := u.bucket.Objects(ctx, &gcs.Query{Prefix: srcPrefix + "/"})
+	for {
+		attr, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return moved, failed, fmt.Errorf("failed to list objects: %s", err.Error())
+		}
+		src := u.bucket.Object(attr.Name)
+		dst := u.bucket.Object(dstPrefix + strings.TrimPrefix(attr.Name, srcPrefix))
+		if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+			failed[attr.Name] = err.Error()
+			continue
+		}
+		if err := src.Delete(ctx); err != nil && err != gcs.ErrObjectNotExist {
+			failed[attr.Name] = err.Error()
+			continue
+		}
+		moved += 1
+	}
+	return moved, failed, nil
+}
+
+func deletedRepoDir(dir string, deletedAt time.Time) string {
+	return fmt.Sprintf("%s.deleted-%d", strings.TrimSuffix(dir, "/"), deletedAt.Unix())
+}
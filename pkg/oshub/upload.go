@@ -3,24 +3,48 @@ package oshub
 import (
 	gcs "cloud.google.com/go/storage"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	"hash/crc32"
 	"io"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 type (
 	RepoFile struct {
 		Path  string
 		CRC32 uint32
+		// optional hex encoded SHA-256 digest, set if negotiated with a client
+		SHA256 string
+		// a size of the file content, it's known only to a client which bounds batches by it
+		Size int64
+
+		// set if a file has been streamed to GCS bypassing a local disk
+		status *uploadStatus
+		// a context of the span the file has been received within
+		ctx context.Context
+		// set if the file is uploaded even if the bucket has it with the same CRC, see WithForceUpload
+		force bool
 	}
 
 	SendReport struct {
-		FileNumb uint
-		ObjNumb  uint
-		Bytes    int64
+		FileNumb uint  `json:"files"`
+		ObjNumb  uint  `json:"objects"`
+		Bytes    int64 `json:"bytes"`
+		// files sent as links to files of identical content sent earlier in the same stream, and bytes saved by that
+		DedupNumb  uint  `json:"deduped,omitempty"`
+		DedupBytes int64 `json:"deduped_bytes,omitempty"`
+		// set if Tar has failed to make the stream, the counters cover files sent before the failure
+		Err string `json:"error,omitempty"`
 	}
 
 	SyncReport struct {
@@ -28,82 +52,169 @@ type (
 		SyncedFileNumb       uint32 `json:"synced"`
 		UploadSyncedFileNumb uint32 `json:"upload_synced"`
 		SyncFailedNumb       uint32 `json:"sync_failed"`
+		// number of files of ./objects/ synced without a failure, see PushSessions
+		ObjectNumb uint32 `json:"objects,omitempty"`
+		// number of objects streamed directly to GCS because the scratch space limit was reached
+		SpilledFileNumb uint32 `json:"spilled"`
+		// number of objects streamed directly to GCS in the streaming mode, see WithStreaming
+		StreamedFileNumb uint32 `json:"streamed,omitempty"`
+		// either StagingDisk, StagingSpill if some objects bypassed a local disk, or StagingStream
+		StagingMode string `json:"staging_mode,omitempty"`
+		// paths of objects that failed to sync mapped to failure reasons, at most MaxReportedFailures of them
+		Failures map[string]string `json:"failures,omitempty"`
+		// paths of files other than ./objects/ that have been uploaded, e.g. refs, see InvalidateChanged
+		Changed []string `json:"changed,omitempty"`
 	}
 )
 
 const (
 	FilesToCheckMaxNumb int = 500
+	MaxReportedFailures int = 1000
+
+	StagingDisk   string = "disk"
+	StagingSpill  string = "spill"
+	StagingStream string = "stream"
+
+	// custom metadata key of a GCS object to store SHA-256 digest of its content at
+	shaMetadataKey string = "fio-sha256"
 )
 
 type (
 	uploadStatus struct {
-		Object *string
-		Exist  bool
-		Err    string
+		Object   *string
+		Exist    bool
+		Err      string
+		Streamed bool
+		// either StagingSpill or StagingStream for a streamed object
+		staging string
 	}
-)
 
-var (
-	uploader struct {
+	// Uploader syncs files of factory repos to a GCS bucket, a hub serving several buckets runs one Uploader per bucket
+	Uploader struct {
 		ctx        context.Context
 		client     *gcs.Client
 		bucket     *gcs.BucketHandle
 		bucketName string
 		workerNumb int
+		chunkTiers []ChunkTier
+		// set if existence of objects is checked by means of listings, see WithListingChecks
+		index *objectIndex
+		retry RetryConfig
+		// GCS client options, e.g. an endpoint and credentials, see WithEndpoint
+		clientOpts []option.ClientOption
+		creds      Credentials
+		metadata   []ObjectMetadata
+		composite  compositeConfig
+		// set if the uploader talks to a GCS emulator rather than to GCS
+		emulated bool
+		// the latest result of the bucket access check, see Readyz
+		ready readyCheck
+	}
+
+	UploaderConfig struct {
+		// a name of GCS bucket objects are synced to
+		Bucket string
+		// number of objects checked and uploaded concurrently
+		Workers int
 	}
 )
 
-func InitUploader(bucket string, workerNumb int) {
-	uploader.ctx = context.Background()
-	client, err := gcs.NewClient(uploader.ctx)
+// NewUploader creates an uploader of a given bucket, the context bounds all the uploader's GCS requests,
+// e.g. it's cancelled once the hub shuts down
+func NewUploader(ctx context.Context, cfg UploaderConfig, opts ...UploaderOption) (*Uploader, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("GCS bucket is not specified")
+	}
+	if cfg.Workers <= 0 {
+		return nil, fmt.Errorf("invalid number of uploader workers: %d", cfg.Workers)
+	}
+	u := &Uploader{chunkTiers: defaultChunkTiers, metadata: defaultObjectMetadata}
+	for _, o := range opts {
+		o(u)
+	}
+	if err := validateChunkTiers(u.chunkTiers); err != nil {
+		return nil, err
+	}
+	u.ctx = ctx
+	credOpts, err := u.creds.clientOptions(u.ctx)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	client, err := gcs.NewClient(u.ctx, append(u.clientOpts, credOpts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %s", err.Error())
+	}
+
+	u.client = client
+	u.applyRetry()
+	u.bucketName = cfg.Bucket
+	u.bucket = u.client.Bucket(cfg.Bucket)
+	u.workerNumb = cfg.Workers
+	// the hub can start before the bucket becomes accessible, Readyz keeps reporting the problem until it's fixed
+	if err := u.Readyz(u.ctx); err != nil {
+		logger.Warn("GCS bucket is not ready", "bucket", cfg.Bucket, "err", err)
+	}
+	return u, nil
+}
 
-	uploader.client = client
-	uploader.bucketName = bucket
-	uploader.bucket = uploader.client.Bucket(bucket)
-	uploader.workerNumb = workerNumb
-	// TODO : check access permissions
+// Bucket returns a name of the bucket the uploader syncs objects to
+func (u *Uploader) Bucket() string {
+	return u.bucketName
 }
 
-func Bucket() string {
-	return uploader.bucketName
+// Close closes the GCS client, the uploader must not be used afterwards
+func (u *Uploader) Close() error {
+	return u.client.Close()
 }
 
-func Check(fileQueue <-chan *RepoFile, objectPrefix string) <-chan *RepoFile {
+// Err returns a reason a received file has been rejected for, e.g. a CRC mismatch, or an empty string
+func (f *RepoFile) Err() string {
+	if f.status == nil {
+		return ""
+	}
+	return f.status.Err
+}
+
+// Check passes on files that have to be synced, e.g. objects absent in GCS bucket, refs, config and files forced
+// to be uploaded by WithForceUpload.
+// Once the context is done, e.g. a client has disconnected, all remaining files are passed on unchecked,
+// Sync reports them as failed
+func (u *Uploader) Check(ctx context.Context, fileQueue <-chan *RepoFile, objectPrefix string) <-chan *RepoFile {
 	objToSyncCh := make(chan *RepoFile, FilesToCheckMaxNumb)
 	go func() {
 		var wg sync.WaitGroup
-		for ii := 0; ii < uploader.workerNumb; ii++ {
+		for ii := 0; ii < u.workerNumb; ii++ {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 				for file := range fileQueue {
-					if !strings.HasPrefix(file.Path, "./objects/") {
+					if !strings.HasPrefix(file.Path, "./objects/") || file.force || ctx.Err() != nil {
 						// upload ./refs and ./config by default
 						objToSyncCh <- file
 						continue
 					}
 
-					objectName := objectPrefix + file.Path[len("./objects/")-1:]
-					obj := uploader.bucket.Object(objectName)
-					attr, err := obj.Attrs(uploader.ctx)
-					if err != nil {
-						if err != gcs.ErrObjectNotExist {
-							fmt.Printf("Object doesn't exists: %s\n, err: %s\n", objectName, err.Error())
+					objectName := objectName(objectPrefix, file.Path)
+					crc, exists, err := u.objectCRC(ctx, u.bucket.Object(objectName), objectName)
+					if err != nil || !exists {
+						if err == nil {
+							logger.Debug("Object doesn't exist", "object", objectName)
+							objectsChecked.WithLabelValues("missing").Inc()
 						} else {
-							fmt.Printf("Failed to query GCS: %s\n, err: %s\n", objectName, err.Error())
+							logger.Warn("Failed to query GCS", "object", objectName, "err", err)
+							objectsChecked.WithLabelValues("error").Inc()
 						}
 						objToSyncCh <- file
 						continue
 					}
 
-					if file.CRC32 != attr.CRC32C {
-						fmt.Printf("CRC doesn't match: %s,  %d vs %d\n", objectName, file.CRC32, attr.CRC32C)
+					if file.CRC32 != crc {
+						logger.Debug("CRC doesn't match", "object", objectName, "crc", file.CRC32, "gcs_crc", crc)
+						objectsChecked.WithLabelValues("mismatch").Inc()
 						objToSyncCh <- file
 						continue
 					}
+					objectsChecked.WithLabelValues("exists").Inc()
 				}
 			}()
 		}
@@ -132,19 +243,36 @@ func Filter(fileQueue <-chan *RepoFile, filterPrefix string) (<-chan *RepoFile,
 	return objectQueue, reportQueue
 }
 
-func Sync(objectQueue <-chan *RepoFile, objectPrefix string, srcDir string) <-chan *uploadStatus {
-	statusQueue := make(chan *uploadStatus, uploader.workerNumb*100)
+// Sync uploads files to GCS bucket, once the context is done remaining files are reported as failed without uploading
+func (u *Uploader) Sync(ctx context.Context, objectQueue <-chan *RepoFile, objectPrefix string, srcDir string) <-chan *uploadStatus {
+	statusQueue := make(chan *uploadStatus, u.workerNumb*100)
 	go func() {
 		defer close(statusQueue)
 		var wg sync.WaitGroup
-		for i := 0; i < uploader.workerNumb; i++ {
+		for i := 0; i < u.workerNumb; i++ {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 				for object := range objectQueue {
-					objectName := objectPrefix + object.Path[len("./objects/")-1:]
-					srcFilePath := path.Join(srcDir, object.Path)
-					statusQueue <- upload(objectName, object, srcFilePath)
+					if object.status != nil {
+						observeUpload(object.status)
+						statusQueue <- object.status
+						continue
+					}
+					if err := ctx.Err(); err != nil {
+						uploadFailures.WithLabelValues(failureCancelled).Inc()
+						status := &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+						observeUpload(status)
+						statusQueue <- status
+						continue
+					}
+					objectName := objectName(objectPrefix, object.Path)
+					srcFilePath := filepath.Join(srcDir, filepath.FromSlash(object.Path))
+					status := tracedUpload(objectName, object, func() *uploadStatus {
+						return u.upload(ctx, objectName, object, srcFilePath)
+					})
+					observeUpload(status)
+					statusQueue <- status
 				}
 			}()
 		}
@@ -153,7 +281,7 @@ func Sync(objectQueue <-chan *RepoFile, objectPrefix string, srcDir string) <-ch
 	return statusQueue
 }
 
-func Wait(reportQueue <-chan uint32, statusQueue <-chan *uploadStatus) *SyncReport {
+func (u *Uploader) Wait(reportQueue <-chan uint32, statusQueue <-chan *uploadStatus) *SyncReport {
 	var status SyncReport
 	for {
 		select {
@@ -163,64 +291,243 @@ func Wait(reportQueue <-chan uint32, statusQueue <-chan *uploadStatus) *SyncRepo
 			}
 		case uploadStatus, ok := <-statusQueue:
 			if !ok {
+				status.StagingMode = StagingDisk
+				if status.StreamedFileNumb > 0 {
+					status.StagingMode = StagingStream
+				} else if status.SpilledFileNumb > 0 {
+					status.StagingMode = StagingSpill
+				}
 				return &status
 			}
+			if uploadStatus.Streamed {
+				if uploadStatus.staging == StagingStream {
+					status.StreamedFileNumb += 1
+				} else {
+					status.SpilledFileNumb += 1
+				}
+			}
 			status.SyncedFileNumb += 1
+			if uploadStatus.Err == "" && strings.HasPrefix(*uploadStatus.Object, "./objects/") {
+				status.ObjectNumb += 1
+			}
 			if uploadStatus.Err != "" {
 				status.SyncFailedNumb += 1
+				if len(status.Failures) < MaxReportedFailures {
+					if status.Failures == nil {
+						status.Failures = make(map[string]string)
+					}
+					status.Failures[*uploadStatus.Object] = uploadStatus.Err
+				}
 			}
 			if !uploadStatus.Exist {
 				status.UploadSyncedFileNumb += 1
+				if uploadStatus.Err == "" && !strings.HasPrefix(*uploadStatus.Object, "./objects/") {
+					status.Changed = append(status.Changed, *uploadStatus.Object)
+				}
 			}
 		} //select
 	} // for
 }
 
-func upload(objectName string, object *RepoFile, srcFilePath string) *uploadStatus {
+// objectName returns a GCS object name of a given repo file, e.g. ./objects/ab/cdef.filez -> <prefix>/ab/cdef.filez,
+// other files keep their repo path, e.g. ./refs/heads/main -> <prefix>/refs/heads/main
+func objectName(objectPrefix string, filePath string) string {
+	if !strings.HasPrefix(filePath, "./objects/") {
+		return objectPrefix + "/" + strings.TrimPrefix(filePath, "./")
+	}
+	return objectPrefix + filePath[len("./objects/")-1:]
+}
+
+func (u *Uploader) upload(ctx context.Context, objectName string, object *RepoFile, srcFilePath string) *uploadStatus {
 	// TODO: log error messages to Echo logger and return a list of failed objects along with failure reason to a client
-	obj := uploader.bucket.Object(objectName)
-	attr, err := obj.Attrs(uploader.ctx)
-	if err == nil && attr.CRC32C == object.CRC32 {
-		return &uploadStatus{Object: &object.Path, Exist: true}
+	obj := u.bucket.Object(objectName)
+	if status := u.existing(ctx, obj, objectName, object); status != nil {
+		return status
 	}
 
-	if err != nil && err != gcs.ErrObjectNotExist {
-		//fmt.Printf("invalid object state: %s\n", objectName)
-		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+	if info, err := os.Lstat(srcFilePath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// symlinks are never followed, their content is the target path
+		link, err := os.Readlink(srcFilePath)
+		if err != nil {
+			uploadFailures.WithLabelValues(failureOpen).Inc()
+			return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+		}
+		return u.write(ctx, obj, objectName, object, strings.NewReader(link), int64(len(link)))
 	}
 
 	f, err := os.Open(srcFilePath)
 	if err != nil {
 		//fmt.Printf("failed to open: %s\n", srcFilePath)
+		uploadFailures.WithLabelValues(failureOpen).Inc()
 		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		uploadFailures.WithLabelValues(failureOpen).Inc()
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+	}
+	if u.useComposite(info.Size()) {
+		return u.writeComposite(ctx, obj, objectName, object, f, info.Size())
+	}
+	return u.write(ctx, obj, objectName, object, f, info.Size())
+}
+
+// existing returns a status of an object the bucket has with the same CRC already, or of a failure to find it out,
+// nil means the object has to be uploaded, e.g. it's absent or forced to be uploaded
+func (u *Uploader) existing(ctx context.Context, obj *gcs.ObjectHandle, objectName string, object *RepoFile) *uploadStatus {
+	if object.force {
+		return nil
+	}
+	crc, exists, err := u.objectCRC(ctx, obj, objectName)
+	if err != nil {
+		uploadFailures.WithLabelValues(failureAttrs).Inc()
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+	}
+	if exists && crc == object.CRC32 {
+		return &uploadStatus{Object: &object.Path, Exist: true}
+	}
+	return nil
+}
 
+// uploadStream uploads an object read from a given reader, e.g. a TAR stream, to GCS bucket
+func (u *Uploader) uploadStream(ctx context.Context, objectName string, object *RepoFile, r io.Reader, size int64) *uploadStatus {
+	obj := u.bucket.Object(objectName)
+	if status := u.existing(ctx, obj, objectName, object); status != nil {
+		return status
+	}
+	return u.write(ctx, obj, objectName, object, r, size)
+}
+
+func (u *Uploader) write(ctx context.Context, obj *gcs.ObjectHandle, objectName string, object *RepoFile, r io.Reader, size int64) *uploadStatus {
 	// TODO:  upload by talking directly to GCS REST API. There is some memory leaking issue here
 	//https://github.com/googleapis/google-cloud-go/issues/1380
-	w := obj.NewWriter(uploader.ctx)
+	start := time.Now()
+	ctx, cancel := u.opContext(ctx)
+	defer cancel()
+	w := obj.NewWriter(ctx)
 	if w == nil {
-		fmt.Printf("failed to create a writer for: %s\n", objectName)
+		logger.Error("Failed to create a bucket object writer", "object", objectName)
+		uploadFailures.WithLabelValues(failureWriter).Inc()
 		return &uploadStatus{Object: &object.Path, Exist: false, Err: "failed to create a bucket object writer"}
 	}
-	fmt.Printf("Uploading an object to GCS bucket: %s\n", objectName)
+	logger.Debug("Uploading an object to GCS bucket", "object", objectName)
 	if object.CRC32 != 0 {
 		w.SendCRC32C = true
 		w.CRC32C = object.CRC32
 	}
-	w.ChunkSize = 0
-	size, err := io.Copy(w, f)
+	if object.SHA256 != "" {
+		w.Metadata = map[string]string{shaMetadataKey: object.SHA256}
+	}
+	u.setMetadata(&w.ObjectAttrs, object.Path)
+	w.ChunkSize = u.chunkSize(size)
+	hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	shaHasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(w, hasher, shaHasher), r)
 	if err != nil {
-		fmt.Printf("failed to copy for: %s\n", objectName)
+		logger.Error("Failed to copy an object to GCS bucket", "object", objectName, "err", err)
+		uploadFailures.WithLabelValues(failureCopy).Inc()
 		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
 	}
+	crc := hasher.Sum32()
+	if object.CRC32 != 0 && crc != object.CRC32 {
+		// abort the upload so the corrupted content never gets to the bucket
+		cancel()
+		w.Close()
+		logger.Warn("CRC of an object doesn't match the expected one", "object", objectName, "crc", crc, "expected", object.CRC32)
+		objectsCorrupted.Inc()
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: fmt.Sprintf("CRC mismatch: got %d, expected %d", crc, object.CRC32)}
+	}
+	if sum := hex.EncodeToString(shaHasher.Sum(nil)); object.SHA256 != "" && !strings.EqualFold(sum, object.SHA256) {
+		cancel()
+		w.Close()
+		logger.Warn("SHA-256 of an object doesn't match the expected one", "object", objectName, "sha256", sum, "expected", object.SHA256)
+		objectsCorrupted.Inc()
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: fmt.Sprintf("SHA-256 mismatch: got %s, expected %s", sum, object.SHA256)}
+	}
 
 	err = w.Close()
 	if err != nil {
-		fmt.Printf("failed to close/flush writing to the bucket for: %s\n%s\n", objectName, err.Error())
+		logger.Error("Failed to close/flush writing to the bucket", "object", objectName, "err", err)
+		uploadFailures.WithLabelValues(failureClose).Inc()
 		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
 	}
+	if stored := w.Attrs().CRC32C; stored != crc {
+		// no CRC was sent along with the object, so it's the only way to find out it was stored intact
+		logger.Error("CRC of an uploaded object doesn't match its content", "object", objectName, "crc", stored, "expected", crc)
+		objectsCorrupted.Inc()
+		if err := obj.Delete(u.ctx); err != nil {
+			logger.Warn("Failed to delete a corrupted object", "object", objectName, "err", err)
+		}
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: fmt.Sprintf("CRC mismatch: got %d, expected %d", stored, crc)}
+	}
 
-	fmt.Printf("Successfully uploaded %d to GCS bucket\n", size)
+	if u.index != nil {
+		u.index.add(objectName, crc)
+	}
+	uploadLatency.Observe(time.Since(start).Seconds())
+	bytesUploaded.Add(float64(written))
+	logger.Info("Successfully uploaded an object to GCS bucket", "object", objectName, "bytes", written)
 	return &uploadStatus{Object: &object.Path, Exist: false}
 }
+
+// copyObject makes an object by copying another one within GCS bucket, e.g. an object of the same content
+func (u *Uploader) copyObject(ctx context.Context, srcName string, objectName string, object *RepoFile) *uploadStatus {
+	obj := u.bucket.Object(objectName)
+	if status := u.existing(ctx, obj, objectName, object); status != nil {
+		return status
+	}
+	ctx, cancel := u.opContext(ctx)
+	defer cancel()
+	c := obj.CopierFrom(u.bucket.Object(srcName))
+	u.setMetadata(&c.ObjectAttrs, object.Path)
+	if object.SHA256 != "" {
+		c.Metadata = map[string]string{shaMetadataKey: object.SHA256}
+	}
+	attrs, err := c.Run(ctx)
+	if err != nil {
+		logger.Error("Failed to copy an object within GCS bucket", "object", objectName, "src", srcName, "err", err)
+		uploadFailures.WithLabelValues(failureCopy).Inc()
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: err.Error()}
+	}
+	if object.CRC32 != 0 && attrs.CRC32C != object.CRC32 {
+		logger.Error("CRC of a copied object doesn't match the expected one", "object", objectName, "crc", attrs.CRC32C, "expected", object.CRC32)
+		objectsCorrupted.Inc()
+		if err := obj.Delete(u.ctx); err != nil {
+			logger.Warn("Failed to delete a corrupted object", "object", objectName, "err", err)
+		}
+		return &uploadStatus{Object: &object.Path, Exist: false, Err: fmt.Sprintf("CRC mismatch: got %d, expected %d", attrs.CRC32C, object.CRC32)}
+	}
+	if u.index != nil {
+		u.index.add(objectName, attrs.CRC32C)
+	}
+	logger.Info("Successfully copied an object within GCS bucket", "object", objectName, "src", srcName)
+	return &uploadStatus{Object: &object.Path, Exist: false}
+}
+
+func observeUpload(status *uploadStatus) {
+	switch {
+	case status.Err != "":
+		objectsUploaded.WithLabelValues("failed").Inc()
+	case status.Exist:
+		objectsUploaded.WithLabelValues("exists").Inc()
+	default:
+		objectsUploaded.WithLabelValues("uploaded").Inc()
+	}
+}
+
+// tracedUpload runs an upload within a span being a child of a span the object has been received within
+func tracedUpload(objectName string, object *RepoFile, upload func() *uploadStatus) *uploadStatus {
+	ctx := object.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer.Start(ctx, "oshub.upload", trace.WithAttributes(attribute.String("object", objectName)))
+	defer span.End()
+	status := upload()
+	span.SetAttributes(attribute.Bool("exist", status.Exist))
+	if status.Err != "" {
+		span.SetStatus(codes.Error, status.Err)
+	}
+	return status
+}
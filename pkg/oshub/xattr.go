@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package oshub
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns extended attributes of a given file, symlinks are not followed, a map key is an attribute name
+func readXattrs(p string) (map[string]string, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil || size == 0 {
+		if err == unix.ENOTSUP {
+			err = nil
+		}
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(p, buf)
+	if err != nil {
+		return nil, err
+	}
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		vSize, err := unix.Lgetxattr(p, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, vSize)
+		vSize, err = unix.Lgetxattr(p, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value[:vSize])
+	}
+	return xattrs, nil
+}
+
+func writeXattr(p string, name string, value string) error {
+	return unix.Lsetxattr(p, name, []byte(value), 0)
+}
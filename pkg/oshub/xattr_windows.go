@@ -0,0 +1,14 @@
+package oshub
+
+import (
+	"fmt"
+)
+
+// readXattrs returns extended attributes of a given file, Windows files have none of them
+func readXattrs(p string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattr(p string, name string, value string) error {
+	return fmt.Errorf("extended attributes are not supported")
+}
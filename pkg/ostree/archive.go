@@ -0,0 +1,139 @@
+package ostree
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type (
+	// FileMeta is metadata of a content object checksummed along with its content, i.e. its ownership,
+	// a unix mode including the file type, a symlink target and extended attributes
+	FileMeta struct {
+		Uid    uint32
+		Gid    uint32
+		Mode   uint32
+		Rdev   uint32
+		Target string
+		Xattrs []Xattr
+	}
+
+	// Xattr is an extended attribute of a content object
+	Xattr struct {
+		Name  string
+		Value []byte
+	}
+)
+
+const (
+	// an extended attribute of files of bare-user repos keeping their real ownership, mode and xattrs
+	BareUserMetaXattr = "user.ostreemeta"
+
+	modeSymlink uint32 = 0120000
+
+	// ostree compresses content of archived objects by zlib level 6 unless the repo config says otherwise
+	archiveCompressionLevel = 6
+)
+
+// IsSymlink returns true if the metadata describes a symlink, i.e. an object without content
+func (m *FileMeta) IsSymlink() bool {
+	return m.Mode&modeTypeMask == modeSymlink
+}
+
+// ParseBareUserMeta parses the user.ostreemeta extended attribute (uuua(ayay)) of a file of a bare-user repo,
+// the symlink target of a symlink object is the file content rather than a part of the metadata
+func ParseBareUserMeta(data []byte) (*FileMeta, error) {
+	const fixed = 3 * 4
+	if len(data) < fixed {
+		return nil, fmt.Errorf("invalid %s attribute", BareUserMetaXattr)
+	}
+	// ostree stores integers of file metadata in big endian
+	meta := &FileMeta{
+		Uid:  binary.BigEndian.Uint32(data[0:4]),
+		Gid:  binary.BigEndian.Uint32(data[4:8]),
+		Mode: binary.BigEndian.Uint32(data[8:12]),
+	}
+	elements, err := arrayElements(data[fixed:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s attribute: %s", BareUserMetaXattr, err.Error())
+	}
+	for _, e := range elements {
+		frames, err := tupleFrames(e, 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s attribute: %s", BareUserMetaXattr, err.Error())
+		}
+		name, err := readString(e[:frames[0]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s attribute: %s", BareUserMetaXattr, err.Error())
+		}
+		meta.Xattrs = append(meta.Xattrs, Xattr{Name: name, Value: e[frames[0] : len(e)-offsetSize(len(e))]})
+	}
+	return meta, nil
+}
+
+// WriteArchivedContent writes a content object the way archive-z2 repos store it, i.e. a .filez object, given
+// its metadata and content of a given size. The object keeps the checksum of the bare object it's made of.
+// The same metadata and content always make the same object.
+func WriteArchivedContent(w io.Writer, meta *FileMeta, content io.Reader, size int64) error {
+	if meta.IsSymlink() || meta.Mode&modeTypeMask != modeRegular {
+		size = 0
+	}
+	header := archiveHeader(meta, uint64(size))
+	prefix := make([]byte, archiveHeaderPrefix)
+	binary.BigEndian.PutUint32(prefix, uint32(len(header)))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	zw, err := flate.NewWriter(w, archiveCompressionLevel)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(zw, io.LimitReader(content, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("the object content is truncated: %d of %d bytes", n, size)
+	}
+	return zw.Close()
+}
+
+// archiveHeader serializes a header of an archived content object (tuuuusa(ayay)), integers are big endian
+func archiveHeader(meta *FileMeta, size uint64) []byte {
+	data := make([]byte, 8+4*4)
+	binary.BigEndian.PutUint64(data[0:8], size)
+	binary.BigEndian.PutUint32(data[8:12], meta.Uid)
+	binary.BigEndian.PutUint32(data[12:16], meta.Gid)
+	binary.BigEndian.PutUint32(data[16:20], meta.Mode)
+	binary.BigEndian.PutUint32(data[20:24], meta.Rdev)
+	data = append(data, meta.Target...)
+	data = append(data, 0)
+	targetEnd := len(data)
+	data = append(data, serializeXattrs(meta.Xattrs)...)
+	return frameContainer(data, []int{targetEnd})
+}
+
+// serializeXattrs serializes extended attributes as a(ayay) sorted by name the way ostree does,
+// names are stored as byte strings along with their terminating zero
+func serializeXattrs(xattrs []Xattr) []byte {
+	sorted := append([]Xattr{}, xattrs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var data []byte
+	var ends []int
+	for _, x := range sorted {
+		e := append([]byte(x.Name), 0)
+		nameEnd := len(e)
+		e = append(e, x.Value...)
+		data = append(data, frameContainer(e, []int{nameEnd})...)
+		ends = append(ends, len(data))
+	}
+	return frameContainer(data, ends)
+}
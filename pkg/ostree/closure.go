@@ -0,0 +1,148 @@
+package ostree
+
+import (
+	"sort"
+	"sync"
+)
+
+type (
+	// RefClosure reports a number of objects reachable from a ref and how many of them
+	// are not reachable from any other ref of a closure
+	RefClosure struct {
+		Ref     string
+		Commit  string
+		Objects int
+		Unique  int
+	}
+
+	// Closure is a union of objects reachable from a set of refs, paths are relative to the repo root,
+	// e.g. ./objects/ab/cdef.dirtree
+	Closure struct {
+		Objects map[string]bool
+		Refs    []RefClosure
+	}
+
+	closureWalker struct {
+		repo  *Repo
+		sem   chan struct{}
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		trees map[string]*DirTree
+		err   error
+	}
+)
+
+// Closure computes objects reachable from given refs (a map of ref names to commit checksums).
+// Dirtrees shared by refs are read and parsed only once by a pool of workers, then the union closure
+// and per-ref object counts are computed from the parsed trees kept in memory.
+// Only the commits refs point to are taken into account, i.e. parent commits are not traversed.
+func (r *Repo) Closure(refs map[string]string, workers int) (*Closure, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	w := &closureWalker{repo: r, sem: make(chan struct{}, workers), trees: make(map[string]*DirTree)}
+
+	commits := make(map[string]*Commit)
+	for _, commit := range refs {
+		if _, ok := commits[commit]; ok {
+			continue
+		}
+		c, err := r.ReadCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+		commits[commit] = c
+		w.walk(c.RootTree)
+	}
+	w.wg.Wait()
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+
+	closure := &Closure{Objects: make(map[string]bool)}
+	owners := make(map[string]int)
+	for ii, ref := range names {
+		objects := make(map[string]bool)
+		commit := refs[ref]
+		objects[ObjectRelPath(commit, ObjectCommit)] = true
+		if r.HasObject(commit, ObjectCommitMeta) {
+			objects[ObjectRelPath(commit, ObjectCommitMeta)] = true
+		}
+		objects[ObjectRelPath(commits[commit].RootMeta, ObjectDirMeta)] = true
+		w.collect(commits[commit].RootTree, objects)
+
+		for obj := range objects {
+			if _, ok := owners[obj]; ok {
+				owners[obj] = -1
+			} else {
+				owners[obj] = ii
+			}
+			closure.Objects[obj] = true
+		}
+		closure.Refs = append(closure.Refs, RefClosure{Ref: ref, Commit: commit, Objects: len(objects)})
+	}
+	for _, owner := range owners {
+		if owner >= 0 {
+			closure.Refs[owner].Unique += 1
+		}
+	}
+	return closure, nil
+}
+
+// walk reads and parses a dirtree and all its subtrees unless they have been parsed already
+func (w *closureWalker) walk(tree string) {
+	w.mu.Lock()
+	if _, ok := w.trees[tree]; ok || w.err != nil {
+		w.mu.Unlock()
+		return
+	}
+	// reserve the tree so concurrent walkers don't parse it twice
+	w.trees[tree] = nil
+	w.mu.Unlock()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.sem <- struct{}{}
+		t, err := w.repo.ReadDirTree(tree)
+		<-w.sem
+
+		w.mu.Lock()
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+			return
+		}
+		w.trees[tree] = t
+		w.mu.Unlock()
+
+		for _, d := range t.Dirs {
+			w.walk(d.TreeChecksum)
+		}
+	}()
+}
+
+// collect adds objects reachable from a parsed dirtree to a given set
+func (w *closureWalker) collect(tree string, objects map[string]bool) {
+	treePath := ObjectRelPath(tree, ObjectDirTree)
+	if objects[treePath] {
+		return
+	}
+	objects[treePath] = true
+	t := w.trees[tree]
+	for _, f := range t.Files {
+		objects[ObjectRelPath(f.Checksum, w.repo.ContentType())] = true
+	}
+	for _, d := range t.Dirs {
+		objects[ObjectRelPath(d.MetaChecksum, ObjectDirMeta)] = true
+		w.collect(d.TreeChecksum, objects)
+	}
+}
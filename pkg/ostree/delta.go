@@ -0,0 +1,40 @@
+package ostree
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// a file of a static delta directory describing the delta and its parts
+	DeltaSuperblock string = "superblock"
+)
+
+// DeltaDir returns a path of a static delta directory relative to the repo root, e.g. deltas/ab/cdef-ghij,
+// the delta is named by modified base64 of checksums of its commits, from is empty for a delta from scratch
+func DeltaDir(from string, to string) (string, error) {
+	toB64, err := checksumB64(to)
+	if err != nil {
+		return "", err
+	}
+	name := toB64
+	if from != "" {
+		fromB64, err := checksumB64(from)
+		if err != nil {
+			return "", err
+		}
+		name = fromB64 + "-" + toB64
+	}
+	return "deltas/" + name[:2] + "/" + name[2:], nil
+}
+
+// checksumB64 returns base64 of a checksum as ostree names deltas by, without padding and with / replaced by _
+func checksumB64(csum string) (string, error) {
+	data, err := hex.DecodeString(csum)
+	if err != nil || len(data) != checksumLen {
+		return "", fmt.Errorf("invalid commit checksum: %s", csum)
+	}
+	return strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(data), "/", "_"), nil
+}
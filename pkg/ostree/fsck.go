@@ -0,0 +1,251 @@
+package ostree
+
+import (
+	"bufio"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// FsckError is an object or a ref found broken by Fsck, a path is relative to the repo root,
+	// e.g. ./objects/ab/cdef.filez or refs/heads/main
+	FsckError struct {
+		Path string
+		Err  string
+	}
+
+	// FsckReport is a result of a repo check
+	FsckReport struct {
+		// a number of objects whose checksum has been verified
+		Objects int
+		// a number of objects whose checksum hasn't been verified, e.g. content objects of bare repos
+		Unverified int
+		Corrupted  []FsckError
+		BrokenRefs []FsckError
+	}
+)
+
+const (
+	// a file type mask and a regular file type of a unix mode
+	modeTypeMask uint32 = 0170000
+	modeRegular  uint32 = 0100000
+
+	// a header of an archived content object is prefixed by its size and padded to 8 bytes
+	archiveHeaderPrefix int = 8
+	// a sanity limit of a file header size, it's dominated by xattrs which are small
+	maxFileHeaderSize uint32 = 1 << 20
+)
+
+// OK returns true if neither corrupted objects nor broken refs have been found
+func (r *FsckReport) OK() bool {
+	return len(r.Corrupted) == 0 && len(r.BrokenRefs) == 0
+}
+
+// Fsck verifies that a checksum of each object matches its name and that refs point to existing commits,
+// objects are verified by a given number of workers. Content objects are verified only in archive repos,
+// as checksums of content objects of bare repos depend on ownership and xattrs of files.
+func (r *Repo) Fsck(workers int) (*FsckReport, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	report := &FsckReport{}
+	var mu sync.Mutex
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for ii := 0; ii < workers; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				verified, err := r.verifyObjectFile(p)
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Corrupted = append(report.Corrupted, FsckError{Path: "./" + filepath.ToSlash(p), Err: err.Error()})
+				case verified:
+					report.Objects += 1
+				default:
+					report.Unverified += 1
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	objectsDir := filepath.Join(r.Dir, "objects")
+	err := filepath.Walk(objectsDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(r.Dir, p)
+		if err != nil {
+			return err
+		}
+		paths <- rel
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk through the repo objects: %s", err.Error())
+	}
+	sort.Slice(report.Corrupted, func(i, j int) bool { return report.Corrupted[i].Path < report.Corrupted[j].Path })
+
+	refs, err := r.Refs()
+	if err != nil {
+		return nil, err
+	}
+	for ref, commit := range refs {
+		if _, err := r.ReadCommit(commit); os.IsNotExist(err) {
+			report.BrokenRefs = append(report.BrokenRefs, FsckError{Path: "refs/" + ref, Err: "no such commit: " + commit})
+		} else if err != nil {
+			report.BrokenRefs = append(report.BrokenRefs, FsckError{Path: "refs/" + ref, Err: err.Error()})
+		}
+	}
+	sort.Slice(report.BrokenRefs, func(i, j int) bool { return report.BrokenRefs[i].Path < report.BrokenRefs[j].Path })
+	return report, nil
+}
+
+// VerifyObject checks that a checksum of an object matches a given one, it returns false without an error
+// if the object can't be verified, e.g. a content object of a bare repo or a detached commit metadata
+func (r *Repo) VerifyObject(csum string, t ObjectType) (bool, error) {
+	if len(csum) != 2*checksumLen {
+		return false, fmt.Errorf("invalid object checksum: %s", csum)
+	}
+	rel, err := filepath.Rel(r.Dir, r.ObjectPath(csum, t))
+	if err != nil {
+		return false, err
+	}
+	return r.verifyObjectFile(rel)
+}
+
+// VerifyObjectFile checks that a checksum of an object at a given path relative to the repo root,
+// e.g. ./objects/ab/cdef.filez, matches its name, see VerifyObject
+func (r *Repo) VerifyObjectFile(rel string) (bool, error) {
+	return r.verifyObjectFile(filepath.FromSlash(strings.TrimPrefix(rel, "./")))
+}
+
+// verifyObjectFile verifies an object file at a given path relative to the repo root, e.g. objects/ab/cdef.filez
+func (r *Repo) verifyObjectFile(rel string) (bool, error) {
+	dir, name := filepath.Split(rel)
+	ext := filepath.Ext(name)
+	csum := filepath.Base(dir) + strings.TrimSuffix(name, ext)
+	if _, err := hex.DecodeString(csum); err != nil || len(csum) != 2*checksumLen {
+		return false, fmt.Errorf("the object name is not a checksum")
+	}
+
+	var h hash.Hash
+	var err error
+	switch ObjectType(strings.TrimPrefix(ext, ".")) {
+	case ObjectCommit, ObjectDirTree, ObjectDirMeta:
+		h, err = r.metaChecksum(rel)
+	case ObjectFileZ:
+		h, err = r.archivedContentChecksum(rel)
+	default:
+		// detached metadata is named by the commit it belongs to, bare content objects need their owner
+		// and xattrs to be checksummed
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != csum {
+		return false, fmt.Errorf("checksum mismatch, actual: %s", actual)
+	}
+	return true, nil
+}
+
+// metaChecksum checksums a metadata object which is a serialized GVariant
+func (r *Repo) metaChecksum(rel string) (hash.Hash, error) {
+	f, err := os.Open(filepath.Join(r.Dir, rel))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// archivedContentChecksum checksums a content object of an archive repo, i.e. a file header
+// (uuuusa(ayay)) of uid, gid, mode, rdev, a symlink target and xattrs prefixed by its size followed
+// by the file content. The archived object starts with a header (tuuuusa(ayay)) prefixed the same way
+// which differs only by the leading uncompressed size, it's followed by the raw deflated content.
+func (r *Repo) archivedContentChecksum(rel string) (hash.Hash, error) {
+	f, err := os.Open(filepath.Join(r.Dir, rel))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in := bufio.NewReader(f)
+
+	prefix := make([]byte, archiveHeaderPrefix)
+	if _, err := io.ReadFull(in, prefix); err != nil {
+		return nil, fmt.Errorf("truncated object header")
+	}
+	headerSize := binary.BigEndian.Uint32(prefix)
+	if headerSize > maxFileHeaderSize {
+		return nil, fmt.Errorf("invalid object header size: %d", headerSize)
+	}
+	archived := make([]byte, headerSize)
+	if _, err := io.ReadFull(in, archived); err != nil {
+		return nil, fmt.Errorf("truncated object header")
+	}
+	header, mode, err := fileHeader(archived)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	binary.BigEndian.PutUint32(prefix, uint32(len(header)))
+	h.Write(prefix)
+	h.Write(header)
+	if mode&modeTypeMask == modeRegular {
+		content := flate.NewReader(in)
+		defer content.Close()
+		if _, err := io.Copy(h, content); err != nil {
+			return nil, fmt.Errorf("failed to inflate the object content: %s", err.Error())
+		}
+	}
+	return h, nil
+}
+
+// fileHeader converts a header of an archived content object (tuuuusa(ayay)) to a file header (uuuusa(ayay))
+// ostree checksums, it returns the file mode along with it
+func fileHeader(archived []byte) ([]byte, uint32, error) {
+	// t and four u members precede the symlink target, the only framing offset is the end of the target
+	const fixed = 8 + 4*4
+	size := offsetSize(len(archived))
+	if len(archived) < fixed+size {
+		return nil, 0, fmt.Errorf("invalid object header")
+	}
+	targetEnd := readOffset(archived[len(archived)-size:], size)
+	if targetEnd <= fixed || targetEnd > len(archived)-size {
+		return nil, 0, fmt.Errorf("invalid object header")
+	}
+	// ostree stores integers of file headers in big endian
+	mode := binary.BigEndian.Uint32(archived[16:20])
+
+	body := make([]byte, 0, len(archived))
+	body = append(body, archived[8:len(archived)-size]...)
+	offset := targetEnd - 8
+	size = 1
+	for offsetSize(len(body)+size) > size {
+		size *= 2
+	}
+	frame := make([]byte, 8)
+	binary.LittleEndian.PutUint64(frame, uint64(offset))
+	return append(body, frame[:size]...), mode, nil
+}
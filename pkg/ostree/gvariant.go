@@ -0,0 +1,120 @@
+package ostree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Minimal decoding and encoding of GVariant serialized data sufficient to parse ostree metadata objects
+// and to make headers of archived content objects,
+// see https://developer.gnome.org/glib/stable/gvariant-format-strings.html and the GVariant
+// serialization specification for details
+
+// offsetSize returns a size of framing offsets of a container of a given serialized size
+func offsetSize(containerSize int) int {
+	switch {
+	case containerSize == 0:
+		return 0
+	case containerSize <= 0xff:
+		return 1
+	case containerSize <= 0xffff:
+		return 2
+	case containerSize <= 0xffffffff:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func readOffset(data []byte, size int) int {
+	switch size {
+	case 1:
+		return int(data[0])
+	case 2:
+		return int(binary.LittleEndian.Uint16(data))
+	case 4:
+		return int(binary.LittleEndian.Uint32(data))
+	default:
+		return int(binary.LittleEndian.Uint64(data))
+	}
+}
+
+// writeOffset appends a framing offset of a given size to serialized data
+func writeOffset(data []byte, offset int, size int) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(offset))
+	return append(data, buf[:size]...)
+}
+
+// frameContainer appends framing offsets to a serialized container, their size is the smallest one
+// that can address the whole container including the offsets themselves
+func frameContainer(data []byte, offsets []int) []byte {
+	if len(offsets) == 0 {
+		return data
+	}
+	size := 1
+	for offsetSize(len(data)+len(offsets)*size) > size {
+		size *= 2
+	}
+	for _, offset := range offsets {
+		data = writeOffset(data, offset, size)
+	}
+	return data
+}
+
+func align(offset int, alignment int) int {
+	return (offset + alignment - 1) &^ (alignment - 1)
+}
+
+// tupleFrames returns n framing offsets of a tuple, i.e. ends of its variable-sized non-last members,
+// in the order of the members. Offsets never decrease and never point past the members, so members
+// can be sliced by them safely.
+func tupleFrames(data []byte, n int) ([]int, error) {
+	size := offsetSize(len(data))
+	if len(data) == 0 || len(data) < n*size {
+		return nil, fmt.Errorf("truncated GVariant tuple")
+	}
+	frames := make([]int, n)
+	prev := 0
+	for ii := 0; ii < n; ii++ {
+		end := len(data) - ii*size
+		frames[ii] = readOffset(data[end-size:end], size)
+		if frames[ii] < prev || frames[ii] > len(data)-n*size {
+			return nil, fmt.Errorf("invalid GVariant framing offset")
+		}
+		prev = frames[ii]
+	}
+	return frames, nil
+}
+
+// arrayElements splits a serialized array of variable-sized elements aligned to 1 byte
+func arrayElements(data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	size := offsetSize(len(data))
+	tableStart := readOffset(data[len(data)-size:], size)
+	if tableStart > len(data) || (len(data)-tableStart)%size != 0 {
+		return nil, fmt.Errorf("invalid GVariant array framing")
+	}
+	n := (len(data) - tableStart) / size
+	elements := make([][]byte, n)
+	start := 0
+	for ii := 0; ii < n; ii++ {
+		pos := tableStart + ii*size
+		end := readOffset(data[pos:pos+size], size)
+		if end < start || end > tableStart {
+			return nil, fmt.Errorf("invalid GVariant array element offset")
+		}
+		elements[ii] = data[start:end]
+		start = end
+	}
+	return elements, nil
+}
+
+func readString(data []byte) (string, error) {
+	if len(data) == 0 || data[len(data)-1] != 0 {
+		return "", fmt.Errorf("invalid GVariant string")
+	}
+	return string(data[:len(data)-1]), nil
+}
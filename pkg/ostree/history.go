@@ -0,0 +1,35 @@
+package ostree
+
+import (
+	"fmt"
+)
+
+const (
+	// a bound of a commit history walked by IsAncestor, it guards against parent cycles of corrupted repos
+	maxHistoryDepth int = 100000
+)
+
+// IsAncestor tells whether a commit is an ancestor of another one, or the same commit, by following parents
+// of the latter, commits are read by a given function. An error is returned if the history of the commit
+// is incomplete before the ancestor is found, e.g. a parent commit is absent.
+func IsAncestor(ancestor string, commit string, read func(csum string) (*Commit, error)) (bool, error) {
+	for depth := 0; commit != ""; depth++ {
+		if commit == ancestor {
+			return true, nil
+		}
+		if depth >= maxHistoryDepth {
+			return false, fmt.Errorf("the commit history is deeper than %d commits", maxHistoryDepth)
+		}
+		c, err := read(commit)
+		if err != nil {
+			return false, fmt.Errorf("failed to read commit %s: %s", commit, err.Error())
+		}
+		commit = c.Parent
+	}
+	return false, nil
+}
+
+// IsAncestor tells whether a commit of the repo is an ancestor of another one, see IsAncestor
+func (r *Repo) IsAncestor(ancestor string, commit string) (bool, error) {
+	return IsAncestor(ancestor, commit, r.ReadCommit)
+}
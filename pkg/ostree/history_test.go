@@ -0,0 +1,56 @@
+package ostree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIsAncestor(t *testing.T) {
+	// c descends from b which descends from a, e's parent is absent, f and g make a cycle
+	commits := map[string]*Commit{
+		"a": {},
+		"b": {Parent: "a"},
+		"c": {Parent: "b"},
+		"d": {},
+		"e": {Parent: "x"},
+		"f": {Parent: "g"},
+		"g": {Parent: "f"},
+	}
+	read := func(csum string) (*Commit, error) {
+		if c, ok := commits[csum]; ok {
+			return c, nil
+		}
+		return nil, fmt.Errorf("no such commit")
+	}
+	tests := []struct {
+		ancestor string
+		commit   string
+		ok       bool
+		// a part of the expected error, if any
+		err string
+	}{
+		{"a", "a", true, ""},
+		{"b", "c", true, ""},
+		{"a", "c", true, ""},
+		{"c", "a", false, ""},
+		{"d", "c", false, ""},
+		{"a", "e", false, "failed to read commit x"},
+		{"a", "f", false, "deeper than"},
+	}
+	for _, tc := range tests {
+		ok, err := IsAncestor(tc.ancestor, tc.commit, read)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("unexpected error of %s and %s: %v, expected %q", tc.ancestor, tc.commit, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to walk the history of %s: %s", tc.commit, err)
+		}
+		if ok != tc.ok {
+			t.Errorf("%s is an ancestor of %s: %v, expected %v", tc.ancestor, tc.commit, ok, tc.ok)
+		}
+	}
+}
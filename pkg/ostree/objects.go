@@ -0,0 +1,145 @@
+package ostree
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type (
+	ObjectType string
+
+	// Commit is a parsed ostree commit object, (a{sv}aya(say)sstayay)
+	Commit struct {
+		Parent    string
+		Subject   string
+		Body      string
+		Timestamp time.Time
+		RootTree  string
+		RootMeta  string
+	}
+
+	// DirTree is a parsed ostree dirtree object, (a(say)a(sayay))
+	DirTree struct {
+		Files []DirTreeFile
+		Dirs  []DirTreeDir
+	}
+
+	DirTreeFile struct {
+		Name     string
+		Checksum string
+	}
+
+	DirTreeDir struct {
+		Name         string
+		TreeChecksum string
+		MetaChecksum string
+	}
+)
+
+const (
+	ObjectCommit     ObjectType = "commit"
+	ObjectCommitMeta ObjectType = "commitmeta"
+	ObjectDirTree    ObjectType = "dirtree"
+	ObjectDirMeta    ObjectType = "dirmeta"
+	ObjectFile       ObjectType = "file"
+	ObjectFileZ      ObjectType = "filez"
+
+	checksumLen int = 32
+)
+
+func checksum(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", nil
+	}
+	if len(data) != checksumLen {
+		return "", fmt.Errorf("invalid checksum length: %d", len(data))
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func ParseCommit(data []byte) (*Commit, error) {
+	// variable-sized non-last members: a{sv}, ay, a(say), s, s, ay
+	frames, err := tupleFrames(data, 6)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit object: %s", err.Error())
+	}
+	var c Commit
+	if c.Parent, err = checksum(data[frames[0]:frames[1]]); err != nil {
+		return nil, fmt.Errorf("invalid commit parent: %s", err.Error())
+	}
+	if c.Subject, err = readString(data[frames[2]:frames[3]]); err != nil {
+		return nil, fmt.Errorf("invalid commit subject: %s", err.Error())
+	}
+	if c.Body, err = readString(data[frames[3]:frames[4]]); err != nil {
+		return nil, fmt.Errorf("invalid commit body: %s", err.Error())
+	}
+	tsStart := align(frames[4], 8)
+	if tsStart+8 > frames[5] {
+		return nil, fmt.Errorf("invalid commit object: truncated timestamp")
+	}
+	// ostree stores the timestamp in big endian
+	c.Timestamp = time.Unix(int64(binary.BigEndian.Uint64(data[tsStart:tsStart+8])), 0).UTC()
+	if c.RootTree, err = checksum(data[tsStart+8 : frames[5]]); err != nil {
+		return nil, fmt.Errorf("invalid commit root dirtree: %s", err.Error())
+	}
+	if c.RootMeta, err = checksum(data[frames[5] : len(data)-6*offsetSize(len(data))]); err != nil {
+		return nil, fmt.Errorf("invalid commit root dirmeta: %s", err.Error())
+	}
+	return &c, nil
+}
+
+func ParseDirTree(data []byte) (*DirTree, error) {
+	frames, err := tupleFrames(data, 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dirtree object: %s", err.Error())
+	}
+	files, err := arrayElements(data[:frames[0]])
+	if err != nil {
+		return nil, fmt.Errorf("invalid dirtree files: %s", err.Error())
+	}
+	dirs, err := arrayElements(data[frames[0] : len(data)-offsetSize(len(data))])
+	if err != nil {
+		return nil, fmt.Errorf("invalid dirtree dirs: %s", err.Error())
+	}
+
+	var t DirTree
+	for _, f := range files {
+		// (say)
+		fFrames, err := tupleFrames(f, 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree file entry: %s", err.Error())
+		}
+		name, err := readString(f[:fFrames[0]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree file name: %s", err.Error())
+		}
+		csum, err := checksum(f[fFrames[0] : len(f)-offsetSize(len(f))])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree file checksum: %s", err.Error())
+		}
+		t.Files = append(t.Files, DirTreeFile{Name: name, Checksum: csum})
+	}
+	for _, d := range dirs {
+		// (sayay)
+		dFrames, err := tupleFrames(d, 2)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree dir entry: %s", err.Error())
+		}
+		name, err := readString(d[:dFrames[0]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree dir name: %s", err.Error())
+		}
+		tree, err := checksum(d[dFrames[0]:dFrames[1]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree dir checksum: %s", err.Error())
+		}
+		meta, err := checksum(d[dFrames[1] : len(d)-2*offsetSize(len(d))])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dirtree dirmeta checksum: %s", err.Error())
+		}
+		t.Dirs = append(t.Dirs, DirTreeDir{Name: name, TreeChecksum: tree, MetaChecksum: meta})
+	}
+	return &t, nil
+}
@@ -0,0 +1,190 @@
+package ostree
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testParent   = strings.Repeat("11", checksumLen)
+	testRootTree = strings.Repeat("22", checksumLen)
+	testRootMeta = strings.Repeat("33", checksumLen)
+	testFileSum  = strings.Repeat("44", checksumLen)
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// serializeCommit makes a commit object, (a{sv}aya(say)sstayay), with no metadata and related objects
+func serializeCommit(t *testing.T, subject string, body string, ts time.Time) []byte {
+	var data []byte
+	var offsets []int
+	// a{sv}
+	offsets = append(offsets, len(data))
+	data = append(data, mustDecode(t, testParent)...)
+	offsets = append(offsets, len(data))
+	// a(say)
+	offsets = append(offsets, len(data))
+	data = append(append(data, subject...), 0)
+	offsets = append(offsets, len(data))
+	data = append(append(data, body...), 0)
+	offsets = append(offsets, len(data))
+	for len(data) != align(len(data), 8) {
+		data = append(data, 0)
+	}
+	tsBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(tsBytes, uint64(ts.Unix()))
+	data = append(data, tsBytes...)
+	data = append(data, mustDecode(t, testRootTree)...)
+	offsets = append(offsets, len(data))
+	data = append(data, mustDecode(t, testRootMeta)...)
+	return frameContainer(data, reversed(offsets))
+}
+
+// serializeDirTree makes a dirtree object, (a(say)a(sayay)), of a file and a subdirectory
+func serializeDirTree(t *testing.T, file string, dir string) []byte {
+	fileEntry := append([]byte(file), 0)
+	nameEnd := len(fileEntry)
+	fileEntry = frameContainer(append(fileEntry, mustDecode(t, testFileSum)...), []int{nameEnd})
+	files := frameContainer(fileEntry, []int{len(fileEntry)})
+
+	dirEntry := append([]byte(dir), 0)
+	nameEnd = len(dirEntry)
+	dirEntry = append(dirEntry, mustDecode(t, testRootTree)...)
+	treeEnd := len(dirEntry)
+	dirEntry = frameContainer(append(dirEntry, mustDecode(t, testRootMeta)...), []int{treeEnd, nameEnd})
+	dirs := frameContainer(dirEntry, []int{len(dirEntry)})
+
+	return frameContainer(append(append([]byte{}, files...), dirs...), []int{len(files)})
+}
+
+func reversed(offsets []int) []int {
+	res := make([]int, len(offsets))
+	for ii, o := range offsets {
+		res[len(offsets)-1-ii] = o
+	}
+	return res
+}
+
+// garble returns a copy of data with a byte at a given position, counted from the end if it's negative, replaced
+func garble(data []byte, pos int, b byte) []byte {
+	res := append([]byte{}, data...)
+	if pos < 0 {
+		pos += len(res)
+	}
+	res[pos] = b
+	return res
+}
+
+func TestParseCommit(t *testing.T) {
+	ts := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	data := serializeCommit(t, "subject", "body", ts)
+	c, err := ParseCommit(data)
+	if err != nil {
+		t.Fatalf("failed to parse a commit: %s", err)
+	}
+	want := Commit{Parent: testParent, Subject: "subject", Body: "body", Timestamp: ts, RootTree: testRootTree, RootMeta: testRootMeta}
+	if *c != want {
+		t.Fatalf("unexpected commit: %+v, want %+v", *c, want)
+	}
+}
+
+func TestParseCommitInvalid(t *testing.T) {
+	data := serializeCommit(t, "subject", "body", time.Unix(0, 0))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"single byte", []byte{0}},
+		{"offsets only", data[len(data)-6:]},
+		{"truncated root meta", data[:len(data)-10]},
+		{"truncated in half", data[:len(data)/2]},
+		// the last byte is the end of a{sv}, the one before it is the end of the parent
+		{"decreasing offsets", garble(garble(data, -1, 10), -2, 2)},
+		{"offset past the buffer", garble(data, -3, 0xff)},
+		{"offset into the framing", garble(data, -6, byte(len(data)-2))},
+		{"unterminated subject", garble(data, 32+len("subject"), 'x')},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if c, err := ParseCommit(tc.data); err == nil {
+				t.Fatalf("parsed an invalid commit: %+v", *c)
+			}
+		})
+	}
+}
+
+func TestParseCommitTruncated(t *testing.T) {
+	data := serializeCommit(t, "subject", "body", time.Unix(0, 0))
+	// none of the prefixes may crash the parser
+	for n := 0; n < len(data); n++ {
+		ParseCommit(data[:n])
+	}
+}
+
+func TestParseDirTree(t *testing.T) {
+	tree, err := ParseDirTree(serializeDirTree(t, "file", "dir"))
+	if err != nil {
+		t.Fatalf("failed to parse a dirtree: %s", err)
+	}
+	if len(tree.Files) != 1 || tree.Files[0] != (DirTreeFile{Name: "file", Checksum: testFileSum}) {
+		t.Fatalf("unexpected dirtree files: %+v", tree.Files)
+	}
+	if len(tree.Dirs) != 1 || tree.Dirs[0] != (DirTreeDir{Name: "dir", TreeChecksum: testRootTree, MetaChecksum: testRootMeta}) {
+		t.Fatalf("unexpected dirtree dirs: %+v", tree.Dirs)
+	}
+}
+
+func TestParseDirTreeInvalid(t *testing.T) {
+	data := serializeDirTree(t, "file", "dir")
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"single byte", []byte{0xff}},
+		{"truncated", data[:len(data)-1]},
+		{"truncated in half", data[:len(data)/2]},
+		{"offset past the buffer", garble(data, -1, 0xff)},
+		{"files past the framing", garble(data, -1, byte(len(data)))},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tree, err := ParseDirTree(tc.data); err == nil {
+				t.Fatalf("parsed an invalid dirtree: %+v", *tree)
+			}
+		})
+	}
+}
+
+func TestParseDirTreeGarbled(t *testing.T) {
+	data := serializeDirTree(t, "file", "dir")
+	// every byte replaced by every value must either parse or fail, never crash the parser
+	for pos := range data {
+		for b := 0; b < 256; b++ {
+			ParseDirTree(garble(data, pos, byte(b)))
+		}
+	}
+	for n := 0; n < len(data); n++ {
+		ParseDirTree(data[:n])
+	}
+}
+
+func TestParseCommitGarbled(t *testing.T) {
+	data := serializeCommit(t, "subject", "body", time.Unix(0, 0))
+	// every byte replaced by every value must either parse or fail, never crash the parser
+	for pos := range data {
+		for b := 0; b < 256; b++ {
+			ParseCommit(garble(data, pos, byte(b)))
+		}
+	}
+}
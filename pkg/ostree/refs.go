@@ -0,0 +1,20 @@
+package ostree
+
+import (
+	"strings"
+)
+
+const (
+	// refs of local branches, e.g. heads/main
+	RefNamespaceHeads string = "heads"
+	// refs of branches pulled from remotes, e.g. remotes/origin/main
+	RefNamespaceRemotes string = "remotes"
+	// refs of branches mirrored from remotes by pull --mirror, e.g. mirrors/origin/main
+	RefNamespaceMirrors string = "mirrors"
+)
+
+// RefNamespace returns a namespace of a ref path relative to refs/, i.e. its first segment,
+// e.g. heads for heads/main and remotes for remotes/origin/main
+func RefNamespace(ref string) string {
+	return strings.SplitN(strings.TrimPrefix(ref, "refs/"), "/", 2)[0]
+}
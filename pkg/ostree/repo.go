@@ -0,0 +1,170 @@
+package ostree
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	Repo struct {
+		Dir  string
+		Mode string
+	}
+)
+
+const (
+	ModeArchive   string = "archive"
+	ModeArchiveZ2 string = "archive-z2"
+	ModeBare      string = "bare"
+	ModeBareUser  string = "bare-user"
+)
+
+func OpenRepo(dir string) (*Repo, error) {
+	mode, err := ReadMode(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !IsKnownMode(mode) {
+		return nil, fmt.Errorf("unsupported repo mode: %s", mode)
+	}
+	return &Repo{Dir: dir, Mode: mode}, nil
+}
+
+// ReadMode returns a mode of an ostree repo specified in its config, e.g. archive-z2, bare, bare-user
+func ReadMode(repoDir string) (string, error) {
+	f, err := os.Open(filepath.Join(repoDir, "config"))
+	if err != nil {
+		return "", fmt.Errorf("failed to open the repo config: %s", err.Error())
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if section == "core" && len(kv) == 2 && strings.TrimSpace(kv[0]) == "mode" {
+			return strings.TrimSpace(kv[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read the repo config: %s", err.Error())
+	}
+	return "", fmt.Errorf("the repo config doesn't specify a repo mode")
+}
+
+// SetConfigMode returns a repo config whose mode is replaced by a given one, the rest of the config is kept as is
+func SetConfigMode(config []byte, mode string) []byte {
+	lines := strings.SplitAfter(string(config), "\n")
+	section := ""
+	for ii, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = trimmed[1 : len(trimmed)-1]
+			continue
+		}
+		kv := strings.SplitN(trimmed, "=", 2)
+		if section == "core" && len(kv) == 2 && strings.TrimSpace(kv[0]) == "mode" {
+			lines[ii] = "mode=" + mode + line[len(strings.TrimRight(line, "\r\n")):]
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// IsKnownMode returns true if a repo mode is one of archive, archive-z2, bare and bare-user
+func IsKnownMode(mode string) bool {
+	switch mode {
+	case ModeArchive, ModeArchiveZ2, ModeBare, ModeBareUser:
+		return true
+	}
+	return false
+}
+
+// IsArchiveMode returns true if content objects of repos of a given mode are stored compressed
+func IsArchiveMode(mode string) bool {
+	return mode == ModeArchive || mode == ModeArchiveZ2
+}
+
+// IsArchive returns true if content objects of the repo are stored compressed, i.e. as .filez files
+func (r *Repo) IsArchive() bool {
+	return IsArchiveMode(r.Mode)
+}
+
+// ContentType returns a type of content objects stored in the repo
+func (r *Repo) ContentType() ObjectType {
+	if r.IsArchive() {
+		return ObjectFileZ
+	}
+	return ObjectFile
+}
+
+// ObjectRelPath returns a path of an object relative to the repo root, e.g. ./objects/ab/cdef.commit
+func ObjectRelPath(csum string, t ObjectType) string {
+	return "./objects/" + csum[:2] + "/" + csum[2:] + "." + string(t)
+}
+
+func (r *Repo) ObjectPath(csum string, t ObjectType) string {
+	return filepath.Join(r.Dir, "objects", csum[:2], csum[2:]+"."+string(t))
+}
+
+func (r *Repo) ReadObject(csum string, t ObjectType) ([]byte, error) {
+	if len(csum) != 2*checksumLen {
+		return nil, fmt.Errorf("invalid object checksum: %s", csum)
+	}
+	return ioutil.ReadFile(r.ObjectPath(csum, t))
+}
+
+func (r *Repo) HasObject(csum string, t ObjectType) bool {
+	_, err := os.Stat(r.ObjectPath(csum, t))
+	return err == nil
+}
+
+func (r *Repo) ReadCommit(csum string) (*Commit, error) {
+	data, err := r.ReadObject(csum, ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCommit(data)
+}
+
+func (r *Repo) ReadDirTree(csum string) (*DirTree, error) {
+	data, err := r.ReadObject(csum, ObjectDirTree)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDirTree(data)
+}
+
+// Refs returns refs of the repo, a map key is a ref path relative to refs/, e.g. heads/main,
+// a value is a commit checksum
+func (r *Repo) Refs() (map[string]string, error) {
+	refs := make(map[string]string)
+	refsDir := filepath.Join(r.Dir, "refs")
+	err := filepath.Walk(refsDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		commit, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		ref, err := filepath.Rel(refsDir, p)
+		if err != nil {
+			return err
+		}
+		refs[filepath.ToSlash(ref)] = strings.TrimSpace(string(commit))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read refs: %s", err.Error())
+	}
+	return refs, nil
+}
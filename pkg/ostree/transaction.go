@@ -0,0 +1,79 @@
+package ostree
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type (
+	// Transaction is an ostree transaction found in a repo, e.g. an in-progress commit or pull
+	Transaction struct {
+		// a process running the transaction, zero if it's unknown
+		PID int
+		// false if the process has exited without finishing the transaction, e.g. it has crashed
+		Alive bool
+	}
+)
+
+const (
+	// ostree creates a symlink to pid=<pid> at the repo root while a transaction is in progress
+	transactionLink = "transaction"
+)
+
+// Transaction returns an in-progress transaction of the repo, it returns nil if there is none
+func (r *Repo) Transaction() (*Transaction, error) {
+	target, err := os.Readlink(filepath.Join(r.Dir, transactionLink))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transaction link: %s", err.Error())
+	}
+	txn := &Transaction{Alive: true}
+	if pid, err := strconv.Atoi(strings.TrimPrefix(target, "pid=")); err == nil && pid > 0 {
+		txn.PID = pid
+		txn.Alive = processAlive(pid)
+	}
+	return txn, nil
+}
+
+// PartialCommits returns commits whose objects haven't been all written yet, e.g. by an interrupted pull
+func (r *Repo) PartialCommits() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(r.Dir, "state"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the repo state: %s", err.Error())
+	}
+	var commits []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".commitpartial") {
+			commits = append(commits, strings.TrimSuffix(e.Name(), ".commitpartial"))
+		}
+	}
+	sort.Strings(commits)
+	return commits, nil
+}
+
+// processAlive returns true if a local process exists, a transaction may run in another container
+// or on another host sharing the repo though, so it's a best effort check
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess fails on Windows if there is no such process
+		return true
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}